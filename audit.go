@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/appendblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+)
+
+const (
+	auditActionDeleteResourceGroup = "delete_resource_group"
+	// An attempt record is written before a deletion starts, and a result
+	// record once the deletion request has returned.
+	auditPhaseAttempt = "attempt"
+	auditPhaseResult  = "result"
+
+	auditBlobTimeLayout = "20060102T150405Z"
+)
+
+// appendBlobClient is the subset of *appendblob.Client used by rg-cleanup.
+type appendBlobClient interface {
+	Create(ctx context.Context, options *appendblob.CreateOptions) (appendblob.CreateResponse, error)
+	AppendBlock(ctx context.Context, body io.ReadSeekCloser, options *appendblob.AppendBlockOptions) (appendblob.AppendBlockResponse, error)
+}
+
+// getAuditBlobClient returns a client for a new append blob named after start
+// in the container at containerURL. The container URL may carry a SAS token;
+// otherwise cred is used.
+func getAuditBlobClient(cred azcore.TokenCredential, containerURL string, start time.Time) (*appendblob.Client, error) {
+	parts, err := blob.ParseURL(containerURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid audit blob URL: %v", err)
+	}
+	parts.BlobName = fmt.Sprintf("rg-cleanup-%s.ndjson", start.UTC().Format(auditBlobTimeLayout))
+	options := &appendblob.ClientOptions{ClientOptions: getClientOptions().ClientOptions}
+	if parts.SAS.Signature() != "" {
+		return appendblob.NewClientWithNoCredential(parts.String(), options)
+	}
+	return appendblob.NewClient(parts.String(), cred, options)
+}
+
+// auditRecord is a line of the audit trail.
+type auditRecord struct {
+	Time           time.Time `json:"time"`
+	Principal      string    `json:"principal"`
+	Action         string    `json:"action"`
+	Phase          string    `json:"phase"`
+	SubscriptionID string    `json:"subscriptionId"`
+	ResourceGroup  string    `json:"resourceGroup"`
+	Outcome        string    `json:"outcome,omitempty"`
+	RequestID      string    `json:"requestId,omitempty"`
+	Error          string    `json:"error,omitempty"`
+}
+
+// auditLog appends NDJSON records to an append blob, which is only created
+// once the first record is written. A nil *auditLog writes nothing.
+type auditLog struct {
+	client    appendBlobClient
+	principal string
+	required  bool
+
+	// mu serializes the writes of subscriptions cleaned up concurrently.
+	mu      sync.Mutex
+	created bool
+	// failed is the first error when required is set. The run must stop once
+	// it is set.
+	failed error
+}
+
+func newAuditLog(client appendBlobClient, principal string, required bool) *auditLog {
+	return &auditLog{client: client, principal: principal, required: required}
+}
+
+// write appends a record about the deletion of record's resource group.
+func (a *auditLog) write(ctx context.Context, phase string, record resourceGroupRecord, requestID string) error {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	err := a.append(ctx, auditRecord{
+		Time:           time.Now().UTC(),
+		Principal:      a.principal,
+		Action:         auditActionDeleteResourceGroup,
+		Phase:          phase,
+		SubscriptionID: record.SubscriptionID,
+		ResourceGroup:  record.Name,
+		Outcome:        record.Outcome,
+		RequestID:      requestID,
+		Error:          record.Error,
+	})
+	if err != nil && a.required && a.failed == nil {
+		a.failed = err
+	}
+	return err
+}
+
+func (a *auditLog) append(ctx context.Context, record auditRecord) error {
+	if !a.created {
+		if _, err := a.client.Create(ctx, nil); err != nil {
+			return fmt.Errorf("failed to create audit blob: %v", err)
+		}
+		a.created = true
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit record: %v", err)
+	}
+	if _, err := a.client.AppendBlock(ctx, streaming.NopCloser(bytes.NewReader(append(data, '\n'))), nil); err != nil {
+		return fmt.Errorf("failed to append audit record: %v", err)
+	}
+	return nil
+}
+
+// err returns the error that must stop the run, if any.
+func (a *auditLog) err() error {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.failed == nil {
+		return nil
+	}
+	return fmt.Errorf("stopping because the audit trail could not be written and --audit-required is set: %v", a.failed)
+}
+
+type auditLogKey struct{}
+
+// withAuditLog returns a copy of ctx carrying a.
+func withAuditLog(ctx context.Context, a *auditLog) context.Context {
+	return context.WithValue(ctx, auditLogKey{}, a)
+}
+
+// auditLogFrom returns the audit log stored in ctx by withAuditLog, or nil.
+func auditLogFrom(ctx context.Context) *auditLog {
+	a, _ := ctx.Value(auditLogKey{}).(*auditLog)
+	return a
+}
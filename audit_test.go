@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/appendblob"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+// fakeAppendBlobClient records the blocks appended to it.
+type fakeAppendBlobClient struct {
+	created   int
+	blocks    []string
+	appendErr error
+}
+
+func (c *fakeAppendBlobClient) Create(context.Context, *appendblob.CreateOptions) (appendblob.CreateResponse, error) {
+	c.created++
+	return appendblob.CreateResponse{}, nil
+}
+
+func (c *fakeAppendBlobClient) AppendBlock(_ context.Context, body io.ReadSeekCloser, _ *appendblob.AppendBlockOptions) (appendblob.AppendBlockResponse, error) {
+	if c.appendErr != nil {
+		return appendblob.AppendBlockResponse{}, c.appendErr
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return appendblob.AppendBlockResponse{}, err
+	}
+	c.blocks = append(c.blocks, string(data))
+	return appendblob.AppendBlockResponse{}, nil
+}
+
+func TestAuditTrail(t *testing.T) {
+	fourDaysAgo := to.StringPtr(reportFourDaysAgo)
+	newResourceGroupsClient := func() *fakeResourceGroupsClient {
+		return &fakeResourceGroupsClient{pages: [][]*armresources.ResourceGroup{{
+			{Name: to.StringPtr("old-1"), Tags: map[string]*string{creationTimestampTag: fourDaysAgo}},
+			{Name: to.StringPtr("old-2"), Tags: map[string]*string{creationTimestampTag: fourDaysAgo}},
+		}}}
+	}
+	testCases := []struct {
+		desc            string
+		appendErr       error
+		required        bool
+		expectedDeleted []string
+		expectedErr     bool
+	}{
+		{
+			desc:            "audit records are written",
+			expectedDeleted: []string{"old-1", "old-2"},
+		},
+		{
+			desc:            "audit failures are logged",
+			appendErr:       errors.New("forbidden"),
+			expectedDeleted: []string{"old-1", "old-2"},
+		},
+		{
+			desc:        "audit failures stop the run with --audit-required",
+			appendErr:   errors.New("forbidden"),
+			required:    true,
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			blob := &fakeAppendBlobClient{appendErr: tc.appendErr}
+			ctx := withAuditLog(context.Background(), newAuditLog(blob, "client-id", tc.required))
+			c := newResourceGroupsClient()
+			o := &options{ttl: defaultTTL, auditBlobURL: "https://account.blob.core.windows.net/audit", auditRequired: tc.required}
+			result, err := runResourceGroupCleanup(ctx, "sub", c, c, fakeResourcesClient{}, o)
+			if tc.expectedErr != (err != nil) {
+				t.Fatalf("expected error to be %v, but got %v", tc.expectedErr, err)
+			}
+			if fmt.Sprint(c.deleted) != fmt.Sprint(tc.expectedDeleted) {
+				t.Fatalf("expected %v to be deleted, but got %v", tc.expectedDeleted, c.deleted)
+			}
+			if tc.required && result.skipped[reasonAuditError] != 1 {
+				t.Fatalf("expected 1 resource group to be skipped because of the audit error, but got %v", result.skipped)
+			}
+			if tc.appendErr != nil {
+				return
+			}
+
+			if blob.created != 1 {
+				t.Fatalf("expected the audit blob to be created once, but got %d", blob.created)
+			}
+			var phases []string
+			for _, block := range blob.blocks {
+				if !strings.HasSuffix(block, "\n") {
+					t.Fatalf("expected an NDJSON line, but got %q", block)
+				}
+				var record auditRecord
+				if err := json.Unmarshal([]byte(block), &record); err != nil {
+					t.Fatal(err)
+				}
+				if record.Principal != "client-id" || record.SubscriptionID != "sub" || record.Action != auditActionDeleteResourceGroup || record.Time.IsZero() {
+					t.Fatalf("unexpected audit record %+v", record)
+				}
+				phases = append(phases, fmt.Sprintf("%s:%s:%s", record.ResourceGroup, record.Phase, record.Outcome))
+			}
+			expected := []string{"old-1:attempt:", "old-1:result:deletion_started", "old-2:attempt:", "old-2:result:deletion_started"}
+			if fmt.Sprint(phases) != fmt.Sprint(expected) {
+				t.Fatalf("expected audit records %v, but got %v", expected, phases)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"strings"
+)
+
+const azCLISubscriptionEnabled = "Enabled"
+
+// azureSubscription is an entry of `az account list --output json`.
+type azureSubscription struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	State string `json:"state"`
+}
+
+// listAzCLISubscriptionIDs returns the IDs of the enabled subscriptions the
+// account logged in to the Azure CLI can access.
+func listAzCLISubscriptionIDs(ctx context.Context) ([]string, error) {
+	az, err := exec.LookPath("az")
+	if err != nil {
+		return nil, errors.New("--all-az-cli-subscriptions requires the Azure CLI, but az was not found in PATH")
+	}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, az, "account", "list", "--output", "json")
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run 'az account list': %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	subscriptions, err := parseAzCLISubscriptions(out)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, sub := range subscriptions {
+		if sub.State != azCLISubscriptionEnabled {
+			slog.Info(fmt.Sprintf("Skipping subscription %s ('%s') from the Azure CLI because it is %s", sub.ID, sub.Name, sub.State), "subscription_id", sub.ID)
+			continue
+		}
+		ids = append(ids, sub.ID)
+	}
+	return ids, nil
+}
+
+func parseAzCLISubscriptions(data []byte) ([]azureSubscription, error) {
+	var subscriptions []azureSubscription
+	if err := json.Unmarshal(data, &subscriptions); err != nil {
+		return nil, fmt.Errorf("failed to parse the output of 'az account list': %v", err)
+	}
+	for _, sub := range subscriptions {
+		if sub.ID == "" {
+			return nil, fmt.Errorf("subscription '%s' listed by 'az account list' has no ID", sub.Name)
+		}
+	}
+	return subscriptions, nil
+}
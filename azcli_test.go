@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+const azAccountListOutput = `[
+  {"cloudName": "AzureCloud", "id": "11111111-1111-1111-1111-111111111111", "isDefault": true, "name": "dev", "state": "Enabled", "tenantId": "tenant"},
+  {"cloudName": "AzureCloud", "id": "22222222-2222-2222-2222-222222222222", "isDefault": false, "name": "old", "state": "Disabled", "tenantId": "tenant"},
+  {"cloudName": "AzureCloud", "id": "33333333-3333-3333-3333-333333333333", "isDefault": false, "name": "test", "state": "Enabled", "tenantId": "tenant"}
+]`
+
+func TestParseAzCLISubscriptions(t *testing.T) {
+	subscriptions, err := parseAzCLISubscriptions([]byte(azAccountListOutput))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []azureSubscription{
+		{ID: "11111111-1111-1111-1111-111111111111", Name: "dev", State: "Enabled"},
+		{ID: "22222222-2222-2222-2222-222222222222", Name: "old", State: "Disabled"},
+		{ID: "33333333-3333-3333-3333-333333333333", Name: "test", State: "Enabled"},
+	}
+	if !reflect.DeepEqual(subscriptions, expected) {
+		t.Fatalf("expected %v, but got %v", expected, subscriptions)
+	}
+
+	for _, invalid := range []string{"Please run 'az login' to setup account.", `[{"name": "no-id"}]`} {
+		if _, err := parseAzCLISubscriptions([]byte(invalid)); err == nil {
+			t.Fatalf("expected an error for %q, but got nil", invalid)
+		}
+	}
+}
+
+func TestListAzCLISubscriptionIDs(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake az is a shell script")
+	}
+	dir := t.TempDir()
+	// PATH only holds the fake az, so the script sticks to shell builtins.
+	script := "#!/bin/sh\nprintf '%s\\n' '" + azAccountListOutput + "'\n"
+	if err := os.WriteFile(filepath.Join(dir, "az"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+
+	ids, err := listAzCLISubscriptionIDs(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"11111111-1111-1111-1111-111111111111", "33333333-3333-3333-3333-333333333333"}
+	if !reflect.DeepEqual(ids, expected) {
+		t.Fatalf("expected the enabled subscriptions %v, but got %v", expected, ids)
+	}
+}
+
+func TestListAzCLISubscriptionIDsWithoutAz(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	_, err := listAzCLISubscriptionIDs(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "az was not found in PATH") {
+		t.Fatalf("expected an error about az not being in PATH, but got %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// deletionBudgetInterval is how often the deletion budget is refilled.
+const deletionBudgetInterval = time.Hour
+
+// deletionBudget limits the number of deletions started per hour with
+// --deletion-budget-per-hour. It is a token bucket holding up to perHour
+// tokens, refilled every hour, that is shared by all the subscriptions of a
+// run. A nil *deletionBudget never limits deletions.
+type deletionBudget struct {
+	perHour int
+
+	mu         sync.Mutex
+	tokens     int
+	refilledAt time.Time
+	now        func() time.Time
+	sleep      func(ctx context.Context, d time.Duration) error
+}
+
+func newDeletionBudget(perHour int) *deletionBudget {
+	return &deletionBudget{
+		perHour: perHour,
+		tokens:  perHour,
+		now:     time.Now,
+		sleep:   sleepContext,
+	}
+}
+
+// take waits until a deletion may be started and uses up a token for it. It
+// returns an error if ctx is done first.
+func (b *deletionBudget) take(ctx context.Context) error {
+	if b == nil {
+		return nil
+	}
+	// Holding the lock while sleeping makes concurrent deletions queue up
+	// behind the one waiting for the refill.
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for {
+		now := b.now()
+		if b.refilledAt.IsZero() {
+			b.refilledAt = now
+		}
+		if elapsed := now.Sub(b.refilledAt); elapsed >= deletionBudgetInterval {
+			b.tokens = b.perHour
+			b.refilledAt = b.refilledAt.Add(elapsed.Truncate(deletionBudgetInterval))
+		}
+		if b.tokens > 0 {
+			b.tokens--
+			return nil
+		}
+		wait := b.refilledAt.Add(deletionBudgetInterval).Sub(now)
+		loggerFrom(ctx).Info(fmt.Sprintf("The deletion budget of %d per hour is used up, waiting %s", b.perHour, wait.Round(time.Second)))
+		if err := b.sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// sleepContext sleeps for d, or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+type deletionBudgetKey struct{}
+
+func withDeletionBudget(ctx context.Context, b *deletionBudget) context.Context {
+	return context.WithValue(ctx, deletionBudgetKey{}, b)
+}
+
+// deletionBudgetFrom returns the deletion budget of ctx, or nil if there is
+// none.
+func deletionBudgetFrom(ctx context.Context) *deletionBudget {
+	b, _ := ctx.Value(deletionBudgetKey{}).(*deletionBudget)
+	return b
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDeletionBudget(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	now := start
+	var waits []time.Duration
+	b := newDeletionBudget(2)
+	b.now = func() time.Time { return now }
+	b.sleep = func(_ context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		now = now.Add(d)
+		return nil
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := b.take(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if len(waits) != 0 {
+		t.Fatalf("expected no wait within the budget, but got %v", waits)
+	}
+
+	now = now.Add(20 * time.Minute)
+	if err := b.take(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := []time.Duration{40 * time.Minute}; len(waits) != 1 || waits[0] != expected[0] {
+		t.Fatalf("expected to wait %v, but got %v", expected, waits)
+	}
+	if expected := start.Add(time.Hour); !now.Equal(expected) {
+		t.Fatalf("expected the deletion to start at %s, but got %s", expected, now)
+	}
+
+	// A second token is left in the refilled budget, and the budget is
+	// refilled again after idling for several hours.
+	if err := b.take(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	now = now.Add(3*time.Hour + 10*time.Minute)
+	for i := 0; i < 2; i++ {
+		if err := b.take(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if len(waits) != 1 {
+		t.Fatalf("expected no further wait, but got %v", waits)
+	}
+}
+
+func TestDeletionBudgetCanceled(t *testing.T) {
+	b := newDeletionBudget(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := b.take(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cancel()
+	if err := b.take(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected %v, but got %v", context.Canceled, err)
+	}
+
+	var nilBudget *deletionBudget
+	if err := nilBudget.take(ctx); err != nil {
+		t.Fatalf("expected a nil budget to never wait, but got %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	armruntime "github.com/Azure/azure-sdk-for-go/sdk/azcore/arm/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/authorization/armauthorization/v2"
+)
+
+// classicAdministratorsAPIVersion is the API version used to delete classic
+// administrators, which the SDK client does not support.
+const classicAdministratorsAPIVersion = "2015-07-01"
+
+// classicAdministratorsClient is the subset of
+// *armauthorization.ClassicAdministratorsClient used by rg-cleanup, plus the
+// ability to delete a classic administrator.
+type classicAdministratorsClient interface {
+	NewListPager(options *armauthorization.ClassicAdministratorsClientListOptions) *runtime.Pager[armauthorization.ClassicAdministratorsClientListResponse]
+	Delete(ctx context.Context, id string) error
+}
+
+// principalResolver looks up whether a user account still exists.
+type principalResolver interface {
+	userExists(ctx context.Context, email string) (bool, error)
+}
+
+type armClassicAdministratorsClient struct {
+	*armauthorization.ClassicAdministratorsClient
+	endpoint string
+	pipeline runtime.Pipeline
+}
+
+func getClassicAdministratorsClient(cred azcore.TokenCredential, subscriptionID string) (*armClassicAdministratorsClient, error) {
+	client, err := armauthorization.NewClassicAdministratorsClient(subscriptionID, cred, getClientOptions())
+	if err != nil {
+		return nil, err
+	}
+	pipeline, err := armruntime.NewPipeline(moduleName, moduleVersion, cred, runtime.PipelineOptions{}, getClientOptions())
+	if err != nil {
+		return nil, err
+	}
+	return &armClassicAdministratorsClient{
+		ClassicAdministratorsClient: client,
+		endpoint:                    cloud.AzurePublic.Services[cloud.ResourceManager].Endpoint,
+		pipeline:                    pipeline,
+	}, nil
+}
+
+// Delete removes the classic administrator with the given resource ID.
+func (c *armClassicAdministratorsClient) Delete(ctx context.Context, id string) error {
+	req, err := runtime.NewRequest(ctx, http.MethodDelete, runtime.JoinPaths(c.endpoint, id))
+	if err != nil {
+		return err
+	}
+	query := req.Raw().URL.Query()
+	query.Set("api-version", classicAdministratorsAPIVersion)
+	req.Raw().URL.RawQuery = query.Encode()
+	resp, err := c.pipeline.Do(req)
+	if err != nil {
+		return err
+	}
+	if !runtime.HasStatusCode(resp, http.StatusOK, http.StatusNoContent) {
+		return runtime.NewResponseError(resp)
+	}
+	return nil
+}
+
+// runClassicAdministratorCleanup removes co-administrators whose account no
+// longer exists in the tenant. Service and account administrators cannot be
+// removed this way and are never touched, and neither is any address listed
+// in o.classicAdministratorExcludes. Co-administrators whose account could not
+// be looked up are unresolved and kept.
+func runClassicAdministratorCleanup(ctx context.Context, c classicAdministratorsClient, users principalResolver, o *options) error {
+	slog.Info("Scanning for classic administrators whose account no longer exists")
+
+	removed, unresolved := 0, 0
+	pager := c.NewListPager(nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("error when iterating classic administrators: %v", err)
+		}
+		for _, admin := range page.Value {
+			if admin.Properties == nil || admin.Properties.EmailAddress == nil || admin.ID == nil {
+				continue
+			}
+			email := *admin.Properties.EmailAddress
+			role := ""
+			if admin.Properties.Role != nil {
+				role = *admin.Properties.Role
+			}
+
+			if !strings.EqualFold(role, "CoAdministrator") {
+				continue
+			}
+			if isClassicAdministratorExcluded(email, o.classicAdministratorExcludes) {
+				slog.Info(fmt.Sprintf("Skip classic administrator '%s' because it is excluded", email))
+				continue
+			}
+
+			exists, err := users.userExists(ctx, email)
+			if err != nil {
+				slog.Error(fmt.Sprintf("Error when looking up classic administrator '%s', keeping it as unresolved", email), "error", err)
+				unresolved++
+				continue
+			}
+			if exists {
+				continue
+			}
+
+			if o.dryRun {
+				slog.Info(fmt.Sprintf("Dry-run: skip removal of classic administrator '%s' whose account no longer exists", email), "dry_run", true)
+				continue
+			}
+
+			slog.Info(fmt.Sprintf("Removing classic administrator '%s' whose account no longer exists", email))
+			if err := c.Delete(ctx, *admin.ID); err != nil {
+				slog.Error(fmt.Sprintf("Error when removing classic administrator %s", email), "error", err)
+				continue
+			}
+			removed++
+		}
+	}
+
+	slog.Info(fmt.Sprintf("Removed %d classic administrators whose account no longer exists, %d could not be resolved", removed, unresolved), "removed", removed, "unresolved", unresolved)
+	return nil
+}
+
+func isClassicAdministratorExcluded(email string, excludes []string) bool {
+	for _, exclude := range excludes {
+		if strings.EqualFold(email, exclude) {
+			return true
+		}
+	}
+	return false
+}
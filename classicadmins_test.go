@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/authorization/armauthorization/v2"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+type fakeClassicAdministratorsClient struct {
+	admins  []*armauthorization.ClassicAdministrator
+	deleted []string
+}
+
+func (c *fakeClassicAdministratorsClient) NewListPager(*armauthorization.ClassicAdministratorsClientListOptions) *runtime.Pager[armauthorization.ClassicAdministratorsClientListResponse] {
+	return newStaticPager(armauthorization.ClassicAdministratorsClientListResponse{
+		ClassicAdministratorListResult: armauthorization.ClassicAdministratorListResult{Value: c.admins},
+	})
+}
+
+func (c *fakeClassicAdministratorsClient) Delete(_ context.Context, id string) error {
+	c.deleted = append(c.deleted, id)
+	return nil
+}
+
+// fakeUserResolver maps email addresses to whether the user exists. Unknown
+// addresses return an error.
+type fakeUserResolver map[string]bool
+
+func (r fakeUserResolver) userExists(_ context.Context, email string) (bool, error) {
+	exists, ok := r[email]
+	if !ok {
+		return false, errors.New("graph unavailable")
+	}
+	return exists, nil
+}
+
+func getClassicAdministrator(email, role string) *armauthorization.ClassicAdministrator {
+	return &armauthorization.ClassicAdministrator{
+		ID: to.StringPtr("/subscriptions/sub/providers/Microsoft.Authorization/classicAdministrators/" + email),
+		Properties: &armauthorization.ClassicAdministratorProperties{
+			EmailAddress: to.StringPtr(email),
+			Role:         to.StringPtr(role),
+		},
+	}
+}
+
+func TestRunClassicAdministratorCleanup(t *testing.T) {
+	users := fakeUserResolver{
+		"active@contoso.com":   true,
+		"deleted@contoso.com":  false,
+		"excluded@contoso.com": false,
+		"owner@contoso.com":    false,
+	}
+	testCases := []struct {
+		desc            string
+		dryRun          bool
+		expectedDeleted []string
+	}{
+		{
+			desc:            "co-administrators whose account no longer exists are removed",
+			expectedDeleted: []string{"/subscriptions/sub/providers/Microsoft.Authorization/classicAdministrators/deleted@contoso.com"},
+		},
+		{
+			desc:   "dry-run",
+			dryRun: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			c := &fakeClassicAdministratorsClient{
+				admins: []*armauthorization.ClassicAdministrator{
+					getClassicAdministrator("active@contoso.com", "CoAdministrator"),
+					getClassicAdministrator("deleted@contoso.com", "CoAdministrator"),
+					getClassicAdministrator("excluded@contoso.com", "CoAdministrator"),
+					getClassicAdministrator("owner@contoso.com", "ServiceAdministrator;AccountAdministrator"),
+					getClassicAdministrator("unknown@contoso.com", "CoAdministrator"),
+				},
+			}
+			o := &options{dryRun: tc.dryRun, classicAdministratorExcludes: []string{"Excluded@contoso.com"}}
+			if err := runClassicAdministratorCleanup(context.Background(), c, users, o); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if fmt.Sprint(c.deleted) != fmt.Sprint(tc.expectedDeleted) {
+				t.Fatalf("expected %v to be deleted, but got %v", tc.expectedDeleted, c.deleted)
+			}
+		})
+	}
+}
+
+func TestClassicAdministratorsClientDelete(t *testing.T) {
+	id := "/subscriptions/sub/providers/Microsoft.Authorization/classicAdministrators/admin"
+	var gotMethod, gotPath, gotAPIVersion string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod, gotPath, gotAPIVersion = r.Method, r.URL.Path, r.URL.Query().Get("api-version")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	c := &armClassicAdministratorsClient{
+		endpoint: srv.URL,
+		pipeline: runtime.NewPipeline(moduleName, moduleVersion, runtime.PipelineOptions{}, nil),
+	}
+	if err := c.Delete(context.Background(), id); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gotMethod != http.MethodDelete || gotPath != id || gotAPIVersion != classicAdministratorsAPIVersion {
+		t.Fatalf("unexpected request: %s %s?api-version=%s", gotMethod, gotPath, gotAPIVersion)
+	}
+}
+
+func TestRunClassicAdministratorCleanupGraph(t *testing.T) {
+	// The guest is only found by its #EXT# user principal name, and the
+	// Microsoft account by its other mails.
+	found := map[string]bool{
+		"userPrincipalName eq 'member@contoso.com' or mail eq 'member@contoso.com'": true,
+		"startswith(userPrincipalName, 'guest_fabrikam.com#EXT#@')":                 true,
+		"otherMails/any(m:m eq 'msa@outlook.com')":                                  true,
+	}
+	failing := map[string]bool{
+		"startswith(userPrincipalName, 'flaky_contoso.com#EXT#@')": true,
+	}
+	var filters []string
+	users := newTestGraphClient(newFakeGraphServer(t, found, failing, &filters).URL)
+	c := &fakeClassicAdministratorsClient{
+		admins: []*armauthorization.ClassicAdministrator{
+			getClassicAdministrator("member@contoso.com", "CoAdministrator"),
+			getClassicAdministrator("guest@fabrikam.com", "CoAdministrator"),
+			getClassicAdministrator("msa@outlook.com", "CoAdministrator"),
+			getClassicAdministrator("flaky@contoso.com", "CoAdministrator"),
+			getClassicAdministrator("gone@contoso.com", "CoAdministrator"),
+		},
+	}
+	if err := runClassicAdministratorCleanup(context.Background(), c, users, &options{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{"/subscriptions/sub/providers/Microsoft.Authorization/classicAdministrators/gone@contoso.com"}
+	if fmt.Sprint(c.deleted) != fmt.Sprint(expected) {
+		t.Fatalf("expected %v to be deleted, but got %v", expected, c.deleted)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Subcommands of rg-cleanup. Running it without one is the same as
+// commandAll.
+const (
+	// commandResourceGroups only cleans up resource groups.
+	commandResourceGroups = "resource-groups"
+	// commandAll cleans up resource groups and, with their flags, managed
+	// identities, snapshots and classic administrators.
+	commandAll = "all"
+)
+
+// parseCommand splits the subcommand from the flags in args, the command-line
+// arguments without the program name.
+func parseCommand(args []string) (string, []string, error) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return commandAll, args, nil
+	}
+	switch args[0] {
+	case commandResourceGroups, commandAll:
+		return args[0], args[1:], nil
+	case "role-assignments":
+		return "", nil, fmt.Errorf("rg-cleanup does not clean up role assignments")
+	default:
+		return "", nil, fmt.Errorf("unknown command '%s', expected '%s' or '%s'", args[0], commandResourceGroups, commandAll)
+	}
+}
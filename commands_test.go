@@ -0,0 +1,72 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCommand(t *testing.T) {
+	testCases := []struct {
+		desc            string
+		args            []string
+		expectedCommand string
+		expectedArgs    []string
+		expectedErr     bool
+	}{
+		{
+			desc:            "no arguments",
+			expectedCommand: commandAll,
+		},
+		{
+			desc:            "flags without a command",
+			args:            []string{"--dry-run", "--ttl=24h"},
+			expectedCommand: commandAll,
+			expectedArgs:    []string{"--dry-run", "--ttl=24h"},
+		},
+		{
+			desc:            "resource-groups",
+			args:            []string{"resource-groups", "--dry-run"},
+			expectedCommand: commandResourceGroups,
+			expectedArgs:    []string{"--dry-run"},
+		},
+		{
+			desc:            "all",
+			args:            []string{"all", "--managed-identities"},
+			expectedCommand: commandAll,
+			expectedArgs:    []string{"--managed-identities"},
+		},
+		{
+			desc:        "role-assignments",
+			args:        []string{"role-assignments"},
+			expectedErr: true,
+		},
+		{
+			desc:        "unknown command",
+			args:        []string{"identities", "--dry-run"},
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			command, args, err := parseCommand(tc.args)
+			if tc.expectedErr != (err != nil) {
+				t.Fatalf("expected error to be %v, but got %v", tc.expectedErr, err)
+			}
+			if command != tc.expectedCommand || len(args) != len(tc.expectedArgs) || (len(args) > 0 && !reflect.DeepEqual(args, tc.expectedArgs)) {
+				t.Fatalf("expected %s %v, but got %s %v", tc.expectedCommand, tc.expectedArgs, command, args)
+			}
+		})
+	}
+}
+
+func TestValidateSettingsResourceGroupsCommand(t *testing.T) {
+	o := &options{command: commandResourceGroups, scopeLevel: scopeLevelSubscription, subscriptionIDs: []string{"sub"}, managedIdentities: true, policyEffect: policyEffectAudit, concurrentSubscriptions: 1, stuckAfter: 1}
+	if err := o.validateSettings(); err == nil {
+		t.Fatal("expected an error for --managed-identities with the resource-groups command, but got nil")
+	}
+	o.command = commandAll
+	if err := o.validateSettings(); err != nil {
+		t.Fatalf("expected no error with the all command, but got %v", err)
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// config is the content of --config. Its keys are the names of the
+// corresponding flags, and a key that is absent leaves the option as is.
+// Secrets such as webhook URLs and passwords are deliberately not part of it:
+// they are only read from the environment.
+type config struct {
+	DryRun                       *bool                `yaml:"dry-run,omitempty"`
+	TTL                          *configDuration      `yaml:"ttl,omitempty"`
+	Regex                        *string              `yaml:"regex,omitempty"`
+	DisableRegexFullMatch        *bool                `yaml:"disable-regex-full-match,omitempty"`
+	SubscriptionIDs              []string             `yaml:"subscription-id,omitempty"`
+	SubscriptionNameFilter       *string              `yaml:"subscription-name-filter,omitempty"`
+	ScopeLevel                   *string              `yaml:"scope-level,omitempty"`
+	ProtectTagValues             []string             `yaml:"protect-tag-values,omitempty"`
+	CreatedBySPs                 []string             `yaml:"created-by-sp,omitempty"`
+	CreatedByTag                 *string              `yaml:"created-by-tag,omitempty"`
+	TagKeyFilter                 *string              `yaml:"tag-key-filter,omitempty"`
+	HasTags                      []string             `yaml:"has-tag,omitempty"`
+	MissingTags                  []string             `yaml:"missing-tag,omitempty"`
+	SkipIfCostTagExceeds         *configCostThreshold `yaml:"skip-if-cost-tag-exceeds,omitempty"`
+	MinResourceCount             *int                 `yaml:"min-resource-count,omitempty"`
+	SkipResourceTypes            []string             `yaml:"skip-rg-with-resource-type,omitempty"`
+	MinimumRGsToKeep             *int                 `yaml:"minimum-rgs-to-keep,omitempty"`
+	ManagedIdentities            *bool                `yaml:"managed-identities,omitempty"`
+	ManagedIdentityResourceGroup *string              `yaml:"managed-identity-resource-group,omitempty"`
+	DeleteOrphanedSnapshots      *bool                `yaml:"delete-orphaned-snapshots,omitempty"`
+	IncludeIncrementalSnapshots  *bool                `yaml:"include-incremental-snapshots,omitempty"`
+	ClassicAdministrators        *bool                `yaml:"classic-administrators,omitempty"`
+	ClassicAdministratorExcludes []string             `yaml:"classic-administrator-exclude,omitempty"`
+	NotifyBeforeDeletion         *string              `yaml:"notify-before-deletion,omitempty"`
+	NotifyDaysBefore             *int                 `yaml:"notify-days-before,omitempty"`
+	SMTPServer                   *string              `yaml:"smtp-server,omitempty"`
+	SMTPFrom                     *string              `yaml:"smtp-from,omitempty"`
+
+	// Subscriptions overrides options for single subscriptions, by
+	// subscription ID.
+	Subscriptions map[string]subscriptionConfig `yaml:"subscriptions,omitempty"`
+}
+
+// subscriptionConfig holds the options that can be overridden for a single
+// subscription.
+type subscriptionConfig struct {
+	TTL   *configDuration `yaml:"ttl,omitempty"`
+	Regex *string         `yaml:"regex,omitempty"`
+}
+
+// configDuration is a time.Duration written as a string such as "72h",
+// like the duration flags.
+type configDuration time.Duration
+
+func (d configDuration) MarshalYAML() (any, error) {
+	return time.Duration(d).String(), nil
+}
+
+func (d *configDuration) UnmarshalYAML(value *yaml.Node) error {
+	parsed, err := time.ParseDuration(value.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: invalid duration '%s': %v", value.Line, value.Value, err)
+	}
+	*d = configDuration(parsed)
+	return nil
+}
+
+// configCostThreshold is the value of --skip-if-cost-tag-exceeds, written as
+// TAG=AMOUNT like the flag.
+type configCostThreshold struct {
+	tag       string
+	threshold float64
+}
+
+func (c configCostThreshold) MarshalYAML() (any, error) {
+	return formatCostThreshold(c.tag, c.threshold), nil
+}
+
+func (c *configCostThreshold) UnmarshalYAML(value *yaml.Node) error {
+	tag, threshold, err := parseCostThreshold(value.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %v", value.Line, err)
+	}
+	c.tag, c.threshold = tag, threshold
+	return nil
+}
+
+// parseCostThreshold parses a --skip-if-cost-tag-exceeds value, TAG=AMOUNT.
+func parseCostThreshold(value string) (string, float64, error) {
+	tag, amount, ok := strings.Cut(value, "=")
+	if !ok || tag == "" {
+		return "", 0, fmt.Errorf("expected TAG=AMOUNT, got '%s'", value)
+	}
+	threshold, err := strconv.ParseFloat(amount, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid amount '%s': %v", amount, err)
+	}
+	return tag, threshold, nil
+}
+
+// formatCostThreshold formats tag and threshold as a
+// --skip-if-cost-tag-exceeds value, or returns "" when tag is empty.
+func formatCostThreshold(tag string, threshold float64) string {
+	if tag == "" {
+		return ""
+	}
+	return tag + "=" + strconv.FormatFloat(threshold, 'f', -1, 64)
+}
+
+// readConfig reads the config file at path. Unknown keys are an error, so
+// that a typo does not silently leave an option unset.
+func readConfig(path string) (*config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %v", err)
+	}
+	defer f.Close()
+	var c config
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&c); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to decode config file '%s': %v", path, err)
+	}
+	return &c, nil
+}
+
+// applyConfig sets the options of c that were not given on the command line
+// or, for the subscription IDs, in the environment.
+func (o *options) applyConfig(c *config) {
+	applyConfigValue(o, "dry-run", &o.dryRun, c.DryRun)
+	if c.TTL != nil && !o.explicit["ttl"] {
+		o.ttl = time.Duration(*c.TTL)
+	}
+	applyConfigValue(o, "regex", &o.regex, c.Regex)
+	applyConfigValue(o, "disable-regex-full-match", &o.disableRegexFullMatch, c.DisableRegexFullMatch)
+	if len(o.subscriptionIDs) == 0 {
+		o.subscriptionIDs = c.SubscriptionIDs
+	}
+	applyConfigValue(o, "subscription-name-filter", &o.subscriptionNameFilter, c.SubscriptionNameFilter)
+	applyConfigValue(o, "scope-level", &o.scopeLevel, c.ScopeLevel)
+	applyConfigList(o, "protect-tag-values", &o.protectTagValues, c.ProtectTagValues)
+	applyConfigList(o, "created-by-sp", &o.createdBySPs, c.CreatedBySPs)
+	applyConfigValue(o, "created-by-tag", &o.createdByTag, c.CreatedByTag)
+	applyConfigValue(o, "tag-key-filter", &o.tagKeyFilter, c.TagKeyFilter)
+	applyConfigList(o, "has-tag", &o.hasTags, c.HasTags)
+	applyConfigList(o, "missing-tag", &o.missingTags, c.MissingTags)
+	if c.SkipIfCostTagExceeds != nil && !o.explicit["skip-if-cost-tag-exceeds"] {
+		o.costTag, o.costThreshold = c.SkipIfCostTagExceeds.tag, c.SkipIfCostTagExceeds.threshold
+	}
+	applyConfigValue(o, "min-resource-count", &o.minResourceCount, c.MinResourceCount)
+	applyConfigList(o, "skip-rg-with-resource-type", &o.skipResourceTypes, c.SkipResourceTypes)
+	applyConfigValue(o, "minimum-rgs-to-keep", &o.minimumRGsToKeep, c.MinimumRGsToKeep)
+	applyConfigValue(o, "managed-identities", &o.managedIdentities, c.ManagedIdentities)
+	applyConfigValue(o, "managed-identity-resource-group", &o.managedIdentityResourceGroup, c.ManagedIdentityResourceGroup)
+	applyConfigValue(o, "delete-orphaned-snapshots", &o.deleteOrphanedSnapshots, c.DeleteOrphanedSnapshots)
+	applyConfigValue(o, "include-incremental-snapshots", &o.includeIncrementalSnapshots, c.IncludeIncrementalSnapshots)
+	applyConfigValue(o, "classic-administrators", &o.classicAdministrators, c.ClassicAdministrators)
+	applyConfigList(o, "classic-administrator-exclude", &o.classicAdministratorExcludes, c.ClassicAdministratorExcludes)
+	applyConfigValue(o, "notify-before-deletion", &o.notifyBeforeDeletion, c.NotifyBeforeDeletion)
+	applyConfigValue(o, "notify-days-before", &o.notifyDaysBefore, c.NotifyDaysBefore)
+	applyConfigValue(o, "smtp-server", &o.smtpAddress, c.SMTPServer)
+	applyConfigValue(o, "smtp-from", &o.smtpFrom, c.SMTPFrom)
+
+	o.subscriptionOverrides = map[string]subscriptionConfig{}
+	for subscriptionID, override := range c.Subscriptions {
+		o.subscriptionOverrides[strings.ToLower(subscriptionID)] = override
+	}
+}
+
+func applyConfigValue[T any](o *options, flagName string, option *T, value *T) {
+	if value != nil && !o.explicit[flagName] {
+		*option = *value
+	}
+}
+
+func applyConfigList(o *options, flagName string, option *[]string, values []string) {
+	if values != nil && !o.explicit[flagName] {
+		*option = values
+	}
+}
+
+// forSubscription returns the options to clean up subscriptionID with, i.e.
+// o with the overrides of the config file for that subscription. Flags still
+// take precedence.
+func (o *options) forSubscription(subscriptionID string) *options {
+	override, ok := o.subscriptionOverrides[strings.ToLower(subscriptionID)]
+	if !ok {
+		return o
+	}
+	overridden := *o
+	if override.TTL != nil && !o.explicit["ttl"] {
+		overridden.ttl = time.Duration(*override.TTL)
+	}
+	if override.Regex != nil && !o.explicit["regex"] {
+		overridden.regex = *override.Regex
+	}
+	return &overridden
+}
+
+// newEffectiveConfig returns the config equivalent to o, as printed by
+// --validate-config.
+func newEffectiveConfig(o options) *config {
+	ttl := configDuration(o.ttl)
+	c := &config{
+		DryRun:                       &o.dryRun,
+		TTL:                          &ttl,
+		Regex:                        &o.regex,
+		DisableRegexFullMatch:        &o.disableRegexFullMatch,
+		SubscriptionIDs:              o.subscriptionIDs,
+		SubscriptionNameFilter:       &o.subscriptionNameFilter,
+		ScopeLevel:                   &o.scopeLevel,
+		ProtectTagValues:             o.protectTagValues,
+		CreatedBySPs:                 o.createdBySPs,
+		CreatedByTag:                 &o.createdByTag,
+		TagKeyFilter:                 &o.tagKeyFilter,
+		HasTags:                      o.hasTags,
+		MissingTags:                  o.missingTags,
+		MinResourceCount:             &o.minResourceCount,
+		SkipResourceTypes:            o.skipResourceTypes,
+		MinimumRGsToKeep:             &o.minimumRGsToKeep,
+		ManagedIdentities:            &o.managedIdentities,
+		ManagedIdentityResourceGroup: &o.managedIdentityResourceGroup,
+		DeleteOrphanedSnapshots:      &o.deleteOrphanedSnapshots,
+		IncludeIncrementalSnapshots:  &o.includeIncrementalSnapshots,
+		ClassicAdministrators:        &o.classicAdministrators,
+		ClassicAdministratorExcludes: o.classicAdministratorExcludes,
+		NotifyBeforeDeletion:         &o.notifyBeforeDeletion,
+		NotifyDaysBefore:             &o.notifyDaysBefore,
+		SMTPServer:                   &o.smtpAddress,
+		SMTPFrom:                     &o.smtpFrom,
+	}
+	if o.costTag != "" {
+		c.SkipIfCostTagExceeds = &configCostThreshold{tag: o.costTag, threshold: o.costThreshold}
+	}
+	if len(o.subscriptionOverrides) > 0 {
+		c.Subscriptions = map[string]subscriptionConfig{}
+		for subscriptionID := range o.subscriptionOverrides {
+			effective := o.forSubscription(subscriptionID)
+			ttl := configDuration(effective.ttl)
+			c.Subscriptions[subscriptionID] = subscriptionConfig{TTL: &ttl, Regex: &effective.regex}
+		}
+	}
+	return c
+}
+
+// printEffectiveConfig writes the effective configuration of o as YAML to w.
+func printEffectiveConfig(w io.Writer, o *options) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(newEffectiveConfig(*o)); err != nil {
+		return fmt.Errorf("failed to encode the effective configuration: %v", err)
+	}
+	return encoder.Close()
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadConfig(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		content     string
+		expectedErr string
+	}{
+		{
+			desc: "valid",
+			content: `ttl: 48h
+regex: ^ci-
+has-tag: [owner]
+subscriptions:
+  SUB-1:
+    ttl: 12h
+`,
+		},
+		{
+			desc:    "empty",
+			content: "",
+		},
+		{
+			desc:        "unknown key",
+			content:     "tll: 48h\n",
+			expectedErr: "field tll not found",
+		},
+		{
+			desc:        "unknown per-subscription key",
+			content:     "subscriptions:\n  sub-1:\n    dry-run: true\n",
+			expectedErr: "field dry-run not found",
+		},
+		{
+			desc:        "secrets are not part of the config",
+			content:     "slack-webhook-url: https://hooks.slack.com/services/x\n",
+			expectedErr: "field slack-webhook-url not found",
+		},
+		{
+			desc:        "invalid duration",
+			content:     "ttl: 3d\n",
+			expectedErr: "invalid duration '3d'",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			_, err := readConfig(writeConfig(t, tc.content))
+			if tc.expectedErr == "" && err != nil {
+				t.Fatalf("expected no error, but got %v", err)
+			}
+			if tc.expectedErr != "" && (err == nil || !strings.Contains(err.Error(), tc.expectedErr)) {
+				t.Fatalf("expected an error containing %q, but got %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	c, err := readConfig(writeConfig(t, `ttl: 48h
+regex: ^ci-
+subscription-id: [sub-1, sub-2]
+protect-tag-values: [prod]
+notify-days-before: 2
+subscriptions:
+  SUB-1:
+    ttl: 12h
+    regex: ^pr-
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		desc     string
+		o        options
+		expected options
+		sub1     options
+	}{
+		{
+			desc: "the config sets the options",
+			o:    options{ttl: defaultTTL, notifyDaysBefore: defaultNotifyDaysBefore},
+			expected: options{
+				ttl:              48 * time.Hour,
+				regex:            "^ci-",
+				subscriptionIDs:  []string{"sub-1", "sub-2"},
+				protectTagValues: []string{"prod"},
+				notifyDaysBefore: 2,
+			},
+			sub1: options{ttl: 12 * time.Hour, regex: "^pr-"},
+		},
+		{
+			desc: "flags and environment variables take precedence",
+			o: options{
+				ttl:              time.Hour,
+				subscriptionIDs:  []string{"sub-3"},
+				notifyDaysBefore: defaultNotifyDaysBefore,
+				explicit:         map[string]bool{"ttl": true, "notify-days-before": true},
+			},
+			expected: options{
+				ttl:              time.Hour,
+				regex:            "^ci-",
+				subscriptionIDs:  []string{"sub-3"},
+				protectTagValues: []string{"prod"},
+				notifyDaysBefore: defaultNotifyDaysBefore,
+			},
+			sub1: options{ttl: time.Hour, regex: "^pr-"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			o := tc.o
+			o.applyConfig(c)
+			sub1 := o.forSubscription("sub-1")
+			if sub1.ttl != tc.sub1.ttl || sub1.regex != tc.sub1.regex {
+				t.Fatalf("expected ttl %s and regex %q for sub-1, but got %s and %q", tc.sub1.ttl, tc.sub1.regex, sub1.ttl, sub1.regex)
+			}
+			if sub2 := o.forSubscription("sub-2"); sub2 != &o {
+				t.Fatalf("expected no override for sub-2, but got %+v", sub2)
+			}
+			o.explicit, o.subscriptionOverrides = nil, nil
+			if !reflect.DeepEqual(o, tc.expected) {
+				t.Fatalf("expected %+v, but got %+v", tc.expected, o)
+			}
+		})
+	}
+}
+
+func TestPrintEffectiveConfig(t *testing.T) {
+	o := &options{ttl: 48 * time.Hour, regex: "^ci-", subscriptionIDs: []string{"sub-1"}, scopeLevel: scopeLevelSubscription, smtpPassword: "secret"}
+	o.applyConfig(&config{Subscriptions: map[string]subscriptionConfig{"sub-1": {Regex: to.StringPtr("^pr-")}}})
+	var b bytes.Buffer
+	if err := printEffectiveConfig(&b, o); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"ttl: 48h0m0s\n", "regex: ^ci-\n", "subscription-id:\n  - sub-1\n", "subscriptions:\n  sub-1:\n    ttl: 48h0m0s\n    regex: ^pr-\n"} {
+		if !strings.Contains(b.String(), s) {
+			t.Fatalf("expected the effective configuration to contain %q, but got:\n%s", s, b.String())
+		}
+	}
+	if strings.Contains(b.String(), "secret") {
+		t.Fatalf("expected the effective configuration to leave out secrets, but got:\n%s", b.String())
+	}
+
+	// The effective configuration is a valid config file.
+	c, err := readConfig(writeConfig(t, b.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reread := &options{}
+	reread.applyConfig(c)
+	if reread.ttl != o.ttl || reread.forSubscription("sub-1").regex != "^pr-" {
+		t.Fatalf("expected the effective configuration to round-trip, but got %+v", reread)
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+)
+
+const (
+	costManagementAPIVersion = "2023-03-01"
+	// costLookback is the period whose cost is reported for each resource
+	// group, an estimate of what deleting it saves per month.
+	costLookback = 30 * 24 * time.Hour
+	// costBatchSize caps the resource groups filtered on in a single query.
+	costBatchSize = 100
+	// costCacheTTL is how long the costs are reused in --watch mode. Cost
+	// Management only refreshes its data a few times a day.
+	costCacheTTL = 12 * time.Hour
+)
+
+// costEstimator looks up the cost of resource groups in the Cost Management
+// query API. The API allows only a few queries per minute, so resource groups
+// are queried in batches, costs are cached and throttled requests are retried
+// for longer than other ARM requests.
+type costEstimator struct {
+	endpoint string
+	pipeline runtime.Pipeline
+
+	mu    sync.Mutex
+	cache map[string]resourceGroupCost
+	// disabled is set once the identity turns out not to be allowed to read
+	// costs, so that it is reported only once.
+	disabled bool
+}
+
+// resourceGroupCost is the cost of a resource group over costLookback.
+type resourceGroupCost struct {
+	amount   float64
+	currency string
+	expires  time.Time
+}
+
+func newCostEstimator(cred azcore.TokenCredential) *costEstimator {
+	endpoint := cloud.AzurePublic.Services[cloud.ResourceManager].Endpoint
+	return &costEstimator{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		pipeline: runtime.NewPipeline(moduleName, moduleVersion, runtime.PipelineOptions{
+			PerRetry: []policy.Policy{
+				runtime.NewBearerTokenPolicy(cred, []string{cloud.AzurePublic.Services[cloud.ResourceManager].Audience + "/.default"}, nil),
+				tracingPolicy{},
+			},
+		}, &policy.ClientOptions{
+			Retry: policy.RetryOptions{MaxRetries: 6, RetryDelay: 10 * time.Second, MaxRetryDelay: 2 * time.Minute},
+		}),
+		cache: map[string]resourceGroupCost{},
+	}
+}
+
+func costCacheKey(subscriptionID, rgName string) string {
+	return strings.ToLower(subscriptionID + "/" + rgName)
+}
+
+// annotate sets the estimated cost of every resource group of records that
+// was deleted, or would have been in a dry run. Errors are logged and leave
+// the cost of the affected resource groups unset.
+func (e *costEstimator) annotate(ctx context.Context, records []resourceGroupRecord, now time.Time) {
+	missing := map[string][]string{}
+	for _, record := range records {
+		if record.Decision != decisionDelete {
+			continue
+		}
+		if _, ok := e.cached(record.SubscriptionID, record.Name, now); !ok {
+			missing[record.SubscriptionID] = append(missing[record.SubscriptionID], record.Name)
+		}
+	}
+	for subscriptionID, names := range missing {
+		for start := 0; start < len(names); start += costBatchSize {
+			end := min(start+costBatchSize, len(names))
+			if err := e.query(ctx, subscriptionID, names[start:end], now); err != nil {
+				if !e.disable(err) {
+					slog.Error("Error when querying the cost of resource groups", "subscription_id", subscriptionID, "error", err)
+				}
+			}
+		}
+	}
+	for i, record := range records {
+		if record.Decision != decisionDelete {
+			continue
+		}
+		if cost, ok := e.cached(record.SubscriptionID, record.Name, now); ok {
+			amount := cost.amount
+			records[i].EstimatedCost, records[i].CostCurrency = &amount, cost.currency
+		}
+	}
+}
+
+func (e *costEstimator) cached(subscriptionID, rgName string, now time.Time) (resourceGroupCost, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	cost, ok := e.cache[costCacheKey(subscriptionID, rgName)]
+	return cost, ok && now.Before(cost.expires)
+}
+
+// disable stops querying costs if err shows that the identity cannot read
+// them, and reports whether it did.
+func (e *costEstimator) disable(err error) bool {
+	var respErr *azcore.ResponseError
+	if !errors.As(err, &respErr) || (respErr.StatusCode != http.StatusForbidden && respErr.StatusCode != http.StatusUnauthorized) {
+		return false
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.disabled {
+		slog.Warn("Not estimating costs: the identity needs the Cost Management Reader role to read them", "error", err)
+	}
+	e.disabled = true
+	return true
+}
+
+type costQuery struct {
+	Type       string          `json:"type"`
+	Timeframe  string          `json:"timeframe"`
+	TimePeriod costQueryPeriod `json:"timePeriod"`
+	Dataset    costDataset     `json:"dataset"`
+}
+
+type costQueryPeriod struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+type costDataset struct {
+	Granularity string                        `json:"granularity"`
+	Aggregation map[string]costAggregation    `json:"aggregation"`
+	Grouping    []costGrouping                `json:"grouping"`
+	Filter      map[string]costDimensionValue `json:"filter"`
+}
+
+type costAggregation struct {
+	Name     string `json:"name"`
+	Function string `json:"function"`
+}
+
+type costGrouping struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+type costDimensionValue struct {
+	Name     string   `json:"name"`
+	Operator string   `json:"operator"`
+	Values   []string `json:"values"`
+}
+
+type costQueryResult struct {
+	Properties struct {
+		NextLink string `json:"nextLink"`
+		Columns  []struct {
+			Name string `json:"name"`
+		} `json:"columns"`
+		Rows [][]any `json:"rows"`
+	} `json:"properties"`
+}
+
+// query caches the cost of the resource groups names of subscriptionID over
+// the costLookback period before now. Resource groups without any cost are
+// cached with a cost of 0.
+func (e *costEstimator) query(ctx context.Context, subscriptionID string, names []string, now time.Time) error {
+	e.mu.Lock()
+	disabled := e.disabled
+	e.mu.Unlock()
+	if disabled {
+		return nil
+	}
+
+	body := costQuery{
+		Type:       "ActualCost",
+		Timeframe:  "Custom",
+		TimePeriod: costQueryPeriod{From: now.Add(-costLookback).UTC(), To: now.UTC()},
+		Dataset: costDataset{
+			Granularity: "None",
+			Aggregation: map[string]costAggregation{"totalCost": {Name: "Cost", Function: "Sum"}},
+			Grouping:    []costGrouping{{Type: "Dimension", Name: "ResourceGroupName"}},
+			Filter:      map[string]costDimensionValue{"dimensions": {Name: "ResourceGroupName", Operator: "In", Values: names}},
+		},
+	}
+	costs := map[string]resourceGroupCost{}
+	endpoint := fmt.Sprintf("%s/subscriptions/%s/providers/Microsoft.CostManagement/query?api-version=%s", e.endpoint, url.PathEscape(subscriptionID), costManagementAPIVersion)
+	for endpoint != "" {
+		req, err := runtime.NewRequest(ctx, http.MethodPost, endpoint)
+		if err != nil {
+			return err
+		}
+		if err := runtime.MarshalAsJSON(req, body); err != nil {
+			return err
+		}
+		resp, err := e.pipeline.Do(req)
+		if err != nil {
+			return err
+		}
+		if !runtime.HasStatusCode(resp, http.StatusOK) {
+			return runtime.NewResponseError(resp)
+		}
+		var result costQueryResult
+		if err := runtime.UnmarshalAsJSON(resp, &result); err != nil {
+			return err
+		}
+		if err := addCostRows(costs, result); err != nil {
+			return err
+		}
+		endpoint = result.Properties.NextLink
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	expires := now.Add(costCacheTTL)
+	for _, name := range names {
+		cost := costs[strings.ToLower(name)]
+		cost.expires = expires
+		e.cache[costCacheKey(subscriptionID, name)] = cost
+	}
+	return nil
+}
+
+// addCostRows adds the cost of each resource group in result to costs, keyed
+// by lowercase name since Cost Management does not keep the case of names.
+func addCostRows(costs map[string]resourceGroupCost, result costQueryResult) error {
+	costColumn, nameColumn, currencyColumn := -1, -1, -1
+	for i, column := range result.Properties.Columns {
+		switch strings.ToLower(column.Name) {
+		case "cost":
+			costColumn = i
+		case "resourcegroupname":
+			nameColumn = i
+		case "currency":
+			currencyColumn = i
+		}
+	}
+	if costColumn < 0 || nameColumn < 0 {
+		return fmt.Errorf("unexpected columns in the cost query result: %v", result.Properties.Columns)
+	}
+	for _, row := range result.Properties.Rows {
+		if len(row) != len(result.Properties.Columns) {
+			return fmt.Errorf("unexpected row in the cost query result: %v", row)
+		}
+		amount, ok := row[costColumn].(float64)
+		name, _ := row[nameColumn].(string)
+		if !ok || name == "" {
+			return fmt.Errorf("unexpected row in the cost query result: %v", row)
+		}
+		cost := costs[strings.ToLower(name)]
+		cost.amount += amount
+		if currencyColumn >= 0 {
+			cost.currency, _ = row[currencyColumn].(string)
+		}
+		costs[strings.ToLower(name)] = cost
+	}
+	return nil
+}
+
+type costEstimatorKey struct{}
+
+// withCostEstimator returns a copy of ctx carrying e.
+func withCostEstimator(ctx context.Context, e *costEstimator) context.Context {
+	return context.WithValue(ctx, costEstimatorKey{}, e)
+}
+
+// costEstimatorFrom returns the estimator stored in ctx by withCostEstimator,
+// or nil.
+func costEstimatorFrom(ctx context.Context) *costEstimator {
+	e, _ := ctx.Value(costEstimatorKey{}).(*costEstimator)
+	return e
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+)
+
+// newTestCostEstimator returns an estimator querying a server that answers
+// with status, and with a cost of 1.5 USD for each resource group whose name
+// starts with old-, in any case. It also returns the number of queries made.
+func newTestCostEstimator(t *testing.T, status int) (*costEstimator, *int) {
+	queries := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries++
+		if r.Method != http.MethodPost || r.URL.Path != "/subscriptions/sub/providers/Microsoft.CostManagement/query" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		var query costQuery
+		if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+			t.Errorf("failed to decode the query: %v", err)
+		}
+		rows := [][]any{}
+		for _, name := range query.Dataset.Filter["dimensions"].Values {
+			if strings.HasPrefix(strings.ToLower(name), "old-") {
+				// Cost Management returns names in lowercase.
+				rows = append(rows, []any{1.5, strings.ToLower(name), "USD"})
+			}
+		}
+		if len(query.Dataset.Filter["dimensions"].Values) > costBatchSize {
+			t.Errorf("expected at most %d resource groups per query, but got %d", costBatchSize, len(query.Dataset.Filter["dimensions"].Values))
+		}
+		fmt.Fprintf(w, `{"properties":{"columns":[{"name":"Cost"},{"name":"ResourceGroupName"},{"name":"Currency"}],"rows":%s}}`, toJSON(t, rows))
+	}))
+	t.Cleanup(server.Close)
+	return &costEstimator{
+		endpoint: server.URL,
+		pipeline: runtime.NewPipeline(moduleName, moduleVersion, runtime.PipelineOptions{}, &policy.ClientOptions{Retry: policy.RetryOptions{MaxRetries: -1}}),
+		cache:    map[string]resourceGroupCost{},
+	}, &queries
+}
+
+func toJSON(t *testing.T, v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestCostEstimator(t *testing.T) {
+	now := time.Now()
+	var records []resourceGroupRecord
+	for i := 0; i < 150; i++ {
+		records = append(records, resourceGroupRecord{SubscriptionID: "sub", Name: fmt.Sprintf("Old-%d", i), Decision: decisionDelete})
+	}
+	records = append(records,
+		resourceGroupRecord{SubscriptionID: "sub", Name: "unused", Decision: decisionDelete},
+		resourceGroupRecord{SubscriptionID: "sub", Name: "old-kept", Decision: decisionSkip},
+	)
+
+	e, queries := newTestCostEstimator(t, http.StatusOK)
+	e.annotate(context.Background(), records, now)
+	if *queries != 2 {
+		t.Fatalf("expected 2 queries, but got %d", *queries)
+	}
+	if cost := records[0].EstimatedCost; cost == nil || *cost != 1.5 || records[0].CostCurrency != "USD" {
+		t.Fatalf("expected a cost of 1.5 USD, but got %+v", records[0])
+	}
+	if cost := records[150].EstimatedCost; cost == nil || *cost != 0 {
+		t.Fatalf("expected a cost of 0 for a resource group without costs, but got %+v", records[150])
+	}
+	if records[151].EstimatedCost != nil {
+		t.Fatalf("expected no cost for a skipped resource group, but got %v", *records[151].EstimatedCost)
+	}
+	result := &runResult{resourceGroups: records}
+	if summary := newRunSummary(result, []string{"sub"}, true); summary.EstimatedCost["USD"] != 225 || len(summary.EstimatedCost) != 1 {
+		t.Fatalf("expected a total of 225 USD, but got %v", summary.EstimatedCost)
+	}
+
+	// The costs are cached until they expire.
+	e.annotate(context.Background(), records, now.Add(time.Hour))
+	if *queries != 2 {
+		t.Fatalf("expected the costs to be cached, but got %d queries", *queries)
+	}
+	e.annotate(context.Background(), records, now.Add(costCacheTTL))
+	if *queries != 4 {
+		t.Fatalf("expected the expired costs to be queried again, but got %d queries", *queries)
+	}
+}
+
+func TestCostEstimatorForbidden(t *testing.T) {
+	records := []resourceGroupRecord{
+		{SubscriptionID: "sub", Name: "old-1", Decision: decisionDelete},
+	}
+	e, queries := newTestCostEstimator(t, http.StatusForbidden)
+	e.annotate(context.Background(), records, time.Now())
+	e.annotate(context.Background(), records, time.Now())
+	if *queries != 1 {
+		t.Fatalf("expected the estimator to stop querying after a 403, but got %d queries", *queries)
+	}
+	if records[0].EstimatedCost != nil {
+		t.Fatalf("expected no cost, but got %v", *records[0].EstimatedCost)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+const (
+	defaultCredentialCheckInterval = 15 * time.Minute
+	// credentialCheckTimeout bounds a token request, so that an unreachable
+	// identity endpoint fails the check instead of blocking it.
+	credentialCheckTimeout = time.Minute
+)
+
+// checkCredentialHealth returns an error if cred cannot get an ARM access
+// token. Building a credential does not authenticate, so without this check
+// an invalid secret or an expired federated token only shows up as errors
+// from the first API calls.
+func checkCredentialHealth(ctx context.Context, cred azcore.TokenCredential) error {
+	ctx, cancel := context.WithTimeout(ctx, credentialCheckTimeout)
+	defer cancel()
+	_, err := cred.GetToken(ctx, policy.TokenRequestOptions{
+		Scopes: []string{cloud.AzurePublic.Services[cloud.ResourceManager].Audience + "/.default"},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get an access token: %v", err)
+	}
+	return nil
+}
+
+// watchCredentialHealth checks cred every interval until ctx is done. The
+// first failure is sent on the returned channel, after which the checks
+// stop.
+func watchCredentialHealth(ctx context.Context, cred azcore.TokenCredential, interval time.Duration) <-chan error {
+	errs := make(chan error, 1)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := checkCredentialHealth(ctx, cred); err != nil && ctx.Err() == nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+	return errs
+}
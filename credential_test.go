@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// expiringCredential returns tokens for the first valid requests and fails
+// afterwards, like a credential whose secret expired during a run.
+type expiringCredential struct {
+	valid int32
+	calls atomic.Int32
+}
+
+func (c *expiringCredential) GetToken(context.Context, policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	if c.calls.Add(1) > c.valid {
+		return azcore.AccessToken{}, errors.New("AADSTS7000222: the provided client secret keys are expired")
+	}
+	return azcore.AccessToken{Token: "token"}, nil
+}
+
+func TestCheckCredentialHealth(t *testing.T) {
+	if err := checkCredentialHealth(context.Background(), &expiringCredential{valid: 1}); err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	if err := checkCredentialHealth(context.Background(), &expiringCredential{}); err == nil {
+		t.Fatal("expected an error, but got nil")
+	}
+}
+
+func TestWatchCredentialHealth(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cred := &expiringCredential{valid: 2}
+	select {
+	case err := <-watchCredentialHealth(ctx, cred, time.Millisecond):
+		if err == nil {
+			t.Fatal("expected an error, but got nil")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected the expired credential to be reported")
+	}
+	if calls := cred.calls.Load(); calls != 3 {
+		t.Fatalf("expected the checks to stop after the first failure, but got %d calls", calls)
+	}
+}
+
+func TestWatchCredentialHealthCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := watchCredentialHealth(ctx, &expiringCredential{valid: 1 << 30}, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-errs:
+		t.Fatalf("expected no error, but got %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
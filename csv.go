@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/chewong/rg-cleanup/pkg/cleanup"
+)
+
+var csvHeader = []string{"subscription", "name", "location", "age_days", "creation_timestamp", "owner", "outcome"}
+
+// writeCSV writes a row for each resource group that was deleted, or would
+// have been in a dry run, to path. When appendRows is set, rows are appended
+// to an existing file and the header is only written if the file is empty.
+func writeCSV(path string, resourceGroups []resourceGroupRecord, appendRows bool, now time.Time) error {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if appendRows {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	f, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open CSV file: %v", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to open CSV file: %v", err)
+	}
+
+	w := csv.NewWriter(f)
+	if info.Size() == 0 {
+		if err := w.Write(csvHeader); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to write CSV file: %v", err)
+		}
+	}
+	for _, rg := range resourceGroups {
+		if rg.Decision != decisionDelete {
+			continue
+		}
+		creationTimestamp := rg.Tags[creationTimestampTag]
+		ageDays := ""
+		if t, err := cleanup.ParseCreationTimestamp(creationTimestamp); err == nil {
+			ageDays = strconv.Itoa(int(now.Sub(t).Hours() / 24))
+		}
+		if err := w.Write([]string{rg.SubscriptionID, rg.Name, rg.Location, ageDays, creationTimestamp, rg.Tags[ownerTag], rg.Outcome}); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to write CSV file: %v", err)
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write CSV file: %v", err)
+	}
+	return f.Close()
+}
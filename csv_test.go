@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteCSV(t *testing.T) {
+	now := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+	resourceGroups := []resourceGroupRecord{
+		{
+			SubscriptionID: "sub",
+			Name:           "old-rg",
+			Location:       "westus2",
+			Tags:           map[string]string{creationTimestampTag: "2024-01-05T12:00:00Z", ownerTag: "Doe, Jane"},
+			Decision:       decisionDelete,
+			Outcome:        outcomeDeletionStarted,
+		},
+		{
+			SubscriptionID: "sub",
+			Name:           "untagged-rg",
+			Decision:       decisionDelete,
+			Outcome:        outcomeFailed,
+		},
+		{
+			SubscriptionID: "sub",
+			Name:           "young-rg",
+			Decision:       decisionSkip,
+			Outcome:        outcomeSkipped,
+		},
+	}
+	header := "subscription,name,location,age_days,creation_timestamp,owner,outcome\n"
+	rows := "sub,old-rg,westus2,4,2024-01-05T12:00:00Z,\"Doe, Jane\",deletion_started\n" +
+		"sub,untagged-rg,,,,,failed\n"
+
+	testCases := []struct {
+		desc       string
+		appendRows bool
+		expected   string
+	}{
+		{
+			desc:     "truncate",
+			expected: header + rows,
+		},
+		{
+			desc:       "append",
+			appendRows: true,
+			expected:   header + rows + rows,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "deletions.csv")
+			for i := 0; i < 2; i++ {
+				if err := writeCSV(path, resourceGroups, tc.appendRows, now); err != nil {
+					t.Fatal(err)
+				}
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tc.expected {
+				t.Fatalf("expected:\n%s\nbut got:\n%s", tc.expected, data)
+			}
+		})
+	}
+}
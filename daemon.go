@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// intervalJitter is the fraction by which --interval is randomly shortened
+// or lengthened each cycle, so that instances started together drift apart.
+const intervalJitter = 0.1
+
+// jitter returns d shortened or lengthened by up to fraction of d. random
+// returns a number in [0, 1), like rand.Float64.
+func jitter(d time.Duration, fraction float64, random func() float64) time.Duration {
+	return d + time.Duration((2*random()-1)*fraction*float64(d))
+}
+
+// nextCycleDelay returns how long --watch waits before the next cycle.
+func (o *options) nextCycleDelay() time.Duration {
+	if o.interval > 0 {
+		return jitter(o.interval, intervalJitter, rand.Float64)
+	}
+	return o.pollInterval
+}
+
+// reloadOptions returns the options of the next --interval cycle: base, the
+// options given by flags and the environment, completed with the current
+// content of --config and resolved with client like at startup.
+func reloadOptions(ctx context.Context, base options, client subscriptionsClient) (*options, error) {
+	o := base
+	if err := o.complete(); err != nil {
+		return nil, err
+	}
+	if err := o.validateSettings(); err != nil {
+		return nil, err
+	}
+	if err := o.resolve(ctx, client); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJitter(t *testing.T) {
+	testCases := []struct {
+		random   float64
+		expected time.Duration
+	}{
+		{random: 0, expected: 54 * time.Minute},
+		{random: 0.5, expected: time.Hour},
+		{random: 0.75, expected: 63 * time.Minute},
+	}
+
+	for _, tc := range testCases {
+		if d := jitter(time.Hour, intervalJitter, func() float64 { return tc.random }); d != tc.expected {
+			t.Fatalf("expected %s for %g, but got %s", tc.expected, tc.random, d)
+		}
+	}
+
+	o := &options{interval: 6 * time.Hour}
+	for i := 0; i < 100; i++ {
+		if d := o.nextCycleDelay(); d < 324*time.Minute || d > 396*time.Minute {
+			t.Fatalf("expected a delay within 10%% of %s, but got %s", o.interval, d)
+		}
+	}
+}
+
+func TestReloadOptions(t *testing.T) {
+	path := writeConfig(t, "ttl: 48h\nregex: ^ci-\n")
+	base := options{
+		configFile:              path,
+		subscriptionIDs:         []string{"sub"},
+		interval:                time.Hour,
+		ttl:                     defaultTTL,
+		regex:                   "^pr-",
+		explicit:                map[string]bool{"regex": true},
+		scopeLevel:              scopeLevelSubscription,
+		policyEffect:            policyEffectAudit,
+		concurrentSubscriptions: 1,
+		stuckAfter:              1,
+	}
+
+	o, err := reloadOptions(context.Background(), base, &fakeSubscriptionsClient{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if o.ttl != 48*time.Hour || o.regex != "^pr-" || !o.watch || o.pollInterval != time.Hour {
+		t.Fatalf("expected the config TTL, the flag regex and watch mode, but got %+v", o)
+	}
+
+	if err := os.WriteFile(path, []byte("ttl: 24h\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if o, err = reloadOptions(context.Background(), base, &fakeSubscriptionsClient{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if o.ttl != 24*time.Hour {
+		t.Fatalf("expected the reloaded TTL of 24h, but got %s", o.ttl)
+	}
+
+	if err := os.WriteFile(path, []byte("tll: 24h\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reloadOptions(context.Background(), base, &fakeSubscriptionsClient{}); err == nil {
+		t.Fatal("expected an error for an invalid config file")
+	}
+	if base.ttl != defaultTTL || base.watch {
+		t.Fatalf("expected the base options to be left as is, but got %+v", base)
+	}
+}
+
+func TestReloadOptionsResolvesSubscriptions(t *testing.T) {
+	c := &fakeSubscriptionsClient{names: map[string]string{
+		"sub-1": "dev-aks",
+		"sub-2": "prod-aks",
+		"sub-3": "dev-capz",
+	}}
+	testCases := []struct {
+		desc            string
+		subscriptionIDs []string
+		config          string
+		expected        []string
+	}{
+		{
+			desc:            "subscription name filter",
+			subscriptionIDs: []string{"sub-1", "sub-2", "sub-3"},
+			config:          "subscription-name-filter: dev-.*\n",
+			expected:        []string{"sub-1", "sub-3"},
+		},
+		{
+			desc:     "management group scope with a name filter",
+			config:   "scope-level: management-group\nsubscription-name-filter: prod-.*\n",
+			expected: []string{"sub-2"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			base := options{
+				configFile:              writeConfig(t, tc.config),
+				subscriptionIDs:         tc.subscriptionIDs,
+				interval:                time.Hour,
+				ttl:                     defaultTTL,
+				scopeLevel:              scopeLevelSubscription,
+				policyEffect:            policyEffectAudit,
+				concurrentSubscriptions: 1,
+				stuckAfter:              1,
+			}
+			o, err := reloadOptions(context.Background(), base, c)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			ids := append([]string(nil), o.subscriptionIDs...)
+			sort.Strings(ids)
+			if !reflect.DeepEqual(ids, tc.expected) {
+				t.Fatalf("expected the subscriptions %v, but got %v", tc.expected, ids)
+			}
+		})
+	}
+}
+
+func TestReloadOptionsChecksConfirmation(t *testing.T) {
+	t.Setenv("RG_CLEANUP_ENVIRONMENT", "production")
+	base := options{
+		configFile:              writeConfig(t, "dry-run: false\n"),
+		subscriptionIDs:         []string{"sub"},
+		interval:                time.Hour,
+		ttl:                     defaultTTL,
+		dryRun:                  true,
+		confirmationEnvName:     "RG_CLEANUP_ENVIRONMENT",
+		confirmationEnvValue:    "staging",
+		scopeLevel:              scopeLevelSubscription,
+		policyEffect:            policyEffectAudit,
+		concurrentSubscriptions: 1,
+		stuckAfter:              1,
+	}
+	if _, err := reloadOptions(context.Background(), base, &fakeSubscriptionsClient{}); err == nil || !strings.Contains(err.Error(), "--require-confirmation-env") {
+		t.Fatalf("expected turning --dry-run off to need confirmation, but got %v", err)
+	}
+}
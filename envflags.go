@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// flagEnvPrefix is the prefix of the environment variables that set flags,
+// e.g. $RG_CLEANUP_DRY_RUN for --dry-run.
+const flagEnvPrefix = "RG_CLEANUP_"
+
+// flagEnvVar returns the environment variable that sets the flag name.
+func flagEnvVar(name string) string {
+	return flagEnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// setFlagsFromEnv sets each flag of fs that is not in explicit from its
+// environment variable, as returned by getenv, and adds it to explicit so
+// that --config does not override it. Flags on the command line take
+// precedence over the environment.
+func setFlagsFromEnv(fs *flag.FlagSet, explicit map[string]bool, getenv func(string) string) error {
+	var errs []string
+	fs.VisitAll(func(f *flag.Flag) {
+		if explicit[f.Name] {
+			return
+		}
+		name := flagEnvVar(f.Name)
+		value := getenv(name)
+		if value == "" {
+			return
+		}
+		if err := fs.Set(f.Name, value); err != nil {
+			errs = append(errs, fmt.Sprintf("invalid value '%s' for $%s: %s", value, name, flagValueError(f, err)))
+			return
+		}
+		explicit[f.Name] = true
+	})
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// flagValueError explains err, returned when setting f. The flag package only
+// returns "parse error" for booleans, numbers and durations.
+func flagValueError(f *flag.Flag, err error) string {
+	getter, ok := f.Value.(flag.Getter)
+	if !ok {
+		return err.Error()
+	}
+	switch getter.Get().(type) {
+	case bool:
+		return "expected true or false"
+	case time.Duration:
+		return "expected a duration such as 72h or 30m"
+	case int, int64, uint, uint64:
+		return "expected an integer"
+	case float64:
+		return "expected a number"
+	default:
+		return err.Error()
+	}
+}
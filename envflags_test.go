@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFlagEnvVar(t *testing.T) {
+	for name, expected := range map[string]string{
+		"ttl":                      "RG_CLEANUP_TTL",
+		"dry-run":                  "RG_CLEANUP_DRY_RUN",
+		"disable-regex-full-match": "RG_CLEANUP_DISABLE_REGEX_FULL_MATCH",
+	} {
+		if actual := flagEnvVar(name); actual != expected {
+			t.Fatalf("expected %s for --%s, but got %s", expected, name, actual)
+		}
+	}
+}
+
+func TestSetFlagsFromEnv(t *testing.T) {
+	testCases := []struct {
+		desc             string
+		args             []string
+		env              map[string]string
+		expectedTTL      time.Duration
+		expectedDryRun   bool
+		expectedRegex    string
+		expectedExplicit []string
+		expectedErr      string
+	}{
+		{
+			desc:             "environment variables set the flags",
+			env:              map[string]string{"RG_CLEANUP_TTL": "24h", "RG_CLEANUP_DRY_RUN": "true", "RG_CLEANUP_REGEX": "^ci-"},
+			expectedTTL:      24 * time.Hour,
+			expectedDryRun:   true,
+			expectedRegex:    "^ci-",
+			expectedExplicit: []string{"dry-run", "regex", "ttl"},
+		},
+		{
+			desc:             "flags take precedence",
+			args:             []string{"--ttl=1h", "--dry-run=false"},
+			env:              map[string]string{"RG_CLEANUP_TTL": "24h", "RG_CLEANUP_DRY_RUN": "true"},
+			expectedTTL:      time.Hour,
+			expectedExplicit: []string{"dry-run", "ttl"},
+		},
+		{
+			desc:        "unset and empty variables are ignored",
+			env:         map[string]string{"RG_CLEANUP_REGEX": "", "TTL": "24h"},
+			expectedTTL: defaultTTL,
+		},
+		{
+			desc:        "invalid boolean",
+			env:         map[string]string{"RG_CLEANUP_DRY_RUN": "yes"},
+			expectedErr: "invalid value 'yes' for $RG_CLEANUP_DRY_RUN: expected true or false",
+		},
+		{
+			desc:        "invalid duration",
+			env:         map[string]string{"RG_CLEANUP_TTL": "3d"},
+			expectedErr: "invalid value '3d' for $RG_CLEANUP_TTL: expected a duration such as 72h or 30m",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			var ttl time.Duration
+			var dryRun bool
+			var regex string
+			fs := flag.NewFlagSet("rg-cleanup", flag.ContinueOnError)
+			fs.SetOutput(io.Discard)
+			fs.DurationVar(&ttl, "ttl", defaultTTL, "")
+			fs.BoolVar(&dryRun, "dry-run", false, "")
+			fs.StringVar(&regex, "regex", "", "")
+			if err := fs.Parse(tc.args); err != nil {
+				t.Fatal(err)
+			}
+			explicit := map[string]bool{}
+			fs.Visit(func(f *flag.Flag) {
+				explicit[f.Name] = true
+			})
+
+			err := setFlagsFromEnv(fs, explicit, func(name string) string { return tc.env[name] })
+			if tc.expectedErr != "" {
+				if err == nil || err.Error() != tc.expectedErr {
+					t.Fatalf("expected error %q, but got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ttl != tc.expectedTTL || dryRun != tc.expectedDryRun || regex != tc.expectedRegex {
+				t.Fatalf("expected ttl=%s dry-run=%t regex=%q, but got ttl=%s dry-run=%t regex=%q", tc.expectedTTL, tc.expectedDryRun, tc.expectedRegex, ttl, dryRun, regex)
+			}
+			var names []string
+			fs.VisitAll(func(f *flag.Flag) {
+				if explicit[f.Name] {
+					names = append(names, f.Name)
+				}
+			})
+			if strings.Join(names, ",") != strings.Join(tc.expectedExplicit, ",") {
+				t.Fatalf("expected %v to be explicit, but got %v", tc.expectedExplicit, names)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	eventDeletionStarted = "deletion_started"
+	eventDeletionFailed  = "deletion_failed"
+
+	// eventQueueSize is how many events may wait to be posted before sending
+	// an event blocks the cleanup.
+	eventQueueSize         = 1024
+	defaultEventAttempts   = 3
+	defaultEventRetryDelay = 2 * time.Second
+)
+
+// deletionEvent is the payload posted to --event-webhook-url.
+type deletionEvent struct {
+	Type      string    `json:"type"`
+	Timestamp time.Time `json:"timestamp"`
+	// Version is the version of rg-cleanup that sent the event.
+	Version string `json:"version"`
+	resourceGroupRecord
+}
+
+// eventSender posts events to a webhook from a background goroutine, so that
+// a slow webhook does not slow down the cleanup. Each event is retried up to
+// attempts times. A nil *eventSender discards events.
+type eventSender struct {
+	url        string
+	header     http.Header
+	attempts   int
+	retryDelay time.Duration
+
+	queue chan deletionEvent
+	done  chan struct{}
+}
+
+func newEventSender(ctx context.Context, url string, header http.Header) *eventSender {
+	s := &eventSender{
+		url:        url,
+		header:     header,
+		attempts:   defaultEventAttempts,
+		retryDelay: defaultEventRetryDelay,
+		queue:      make(chan deletionEvent, eventQueueSize),
+		done:       make(chan struct{}),
+	}
+	go s.run(ctx)
+	return s
+}
+
+// send queues event to be posted.
+func (s *eventSender) send(event deletionEvent) {
+	if s == nil {
+		return
+	}
+	event.Version = moduleVersion
+	s.queue <- event
+}
+
+// close waits for the queued events to be posted.
+func (s *eventSender) close() {
+	if s == nil {
+		return
+	}
+	close(s.queue)
+	<-s.done
+}
+
+func (s *eventSender) run(ctx context.Context) {
+	defer close(s.done)
+	for event := range s.queue {
+		if err := s.post(ctx, event); err != nil {
+			slog.Error(fmt.Sprintf("Error when posting the %s event of %s", event.Type, event.Name), "subscription_id", event.SubscriptionID, "rg_name", event.Name, "error", err)
+		}
+	}
+}
+
+// post posts event, retrying with an exponential backoff.
+func (s *eventSender) post(ctx context.Context, event deletionEvent) error {
+	delay := s.retryDelay
+	var err error
+	for attempt := 1; attempt <= s.attempts; attempt++ {
+		if err = postJSON(ctx, s.url, s.header, event); err == nil {
+			return nil
+		}
+		if attempt == s.attempts {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+	return fmt.Errorf("giving up after %d attempts: %v", s.attempts, err)
+}
+
+type eventSenderKey struct{}
+
+// withEventSender returns a copy of ctx carrying s.
+func withEventSender(ctx context.Context, s *eventSender) context.Context {
+	return context.WithValue(ctx, eventSenderKey{}, s)
+}
+
+// eventSenderFrom returns the event sender stored in ctx by withEventSender,
+// or nil.
+func eventSenderFrom(ctx context.Context) *eventSender {
+	s, _ := ctx.Value(eventSenderKey{}).(*eventSender)
+	return s
+}
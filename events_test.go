@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+// eventRecorder is a webhook that fails the first failures requests and
+// records the events it accepts.
+type eventRecorder struct {
+	mu       sync.Mutex
+	failures int
+	requests int
+	events   []map[string]any
+}
+
+func (e *eventRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.requests++
+	if e.requests <= e.failures || r.Header.Get("X-Api-Key") != "secret" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	var event map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	e.events = append(e.events, event)
+}
+
+func TestEventSenderRetries(t *testing.T) {
+	testCases := []struct {
+		desc             string
+		failures         int
+		expectedRequests int
+		expectedEvents   int
+	}{
+		{desc: "first attempt", expectedRequests: 1, expectedEvents: 1},
+		{desc: "retried", failures: 2, expectedRequests: 3, expectedEvents: 1},
+		{desc: "gives up", failures: 5, expectedRequests: 3},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			recorder := &eventRecorder{failures: tc.failures}
+			server := httptest.NewServer(recorder)
+			defer server.Close()
+
+			s := newEventSender(context.Background(), server.URL, http.Header{"X-Api-Key": {"secret"}})
+			s.retryDelay = 0
+			s.send(deletionEvent{Type: eventDeletionStarted, resourceGroupRecord: resourceGroupRecord{Name: "old-rg"}})
+			s.close()
+
+			if recorder.requests != tc.expectedRequests {
+				t.Fatalf("expected %d requests, but got %d", tc.expectedRequests, recorder.requests)
+			}
+			if len(recorder.events) != tc.expectedEvents {
+				t.Fatalf("expected %d events, but got %v", tc.expectedEvents, recorder.events)
+			}
+		})
+	}
+}
+
+func TestRunResourceGroupCleanupEvents(t *testing.T) {
+	recorder := &eventRecorder{}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	fourDaysAgo := to.StringPtr(reportFourDaysAgo)
+	c := &fakeResourceGroupsClient{pages: [][]*armresources.ResourceGroup{{
+		{Name: to.StringPtr("old-rg"), Location: to.StringPtr("westus2"), Tags: map[string]*string{creationTimestampTag: fourDaysAgo, "owner": to.StringPtr("alice")}},
+		{Name: to.StringPtr("kept-rg"), Tags: map[string]*string{creationTimestampTag: fourDaysAgo, doNotDeleteTag: to.StringPtr("")}},
+	}}}
+
+	for _, deleteErr := range []error{nil, errors.New("authorization failed")} {
+		c.deleteErr = deleteErr
+		events := newEventSender(context.Background(), server.URL, http.Header{"X-Api-Key": {"secret"}})
+		ctx := withEventSender(context.Background(), events)
+		if _, err := runResourceGroupCleanup(ctx, "sub", c, c, fakeResourcesClient{}, &options{ttl: defaultTTL, ignoreDeletionErrors: true}); err != nil {
+			t.Fatal(err)
+		}
+		events.close()
+	}
+
+	if len(recorder.events) != 2 {
+		t.Fatalf("expected an event for each deletion of old-rg, but got %v", recorder.events)
+	}
+	for i, expectedType := range []string{eventDeletionStarted, eventDeletionFailed} {
+		event := recorder.events[i]
+		if event["type"] != expectedType || event["subscriptionId"] != "sub" || event["name"] != "old-rg" || event["decision"] != decisionDelete {
+			t.Fatalf("expected a %s event for old-rg, but got %v", expectedType, event)
+		}
+		if tags, _ := event["tags"].(map[string]any); tags["owner"] != "alice" {
+			t.Fatalf("expected the event to carry the tags of old-rg, but got %v", event)
+		}
+		if _, ok := event["timestamp"]; !ok {
+			t.Fatalf("expected the event to have a timestamp, but got %v", event)
+		}
+	}
+	if recorder.events[1]["error"] != "authorization failed" {
+		t.Fatalf("expected the failure event to carry the error, but got %v", recorder.events[1])
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	// eventStreamSchemaVersion is the version of the streamEvent schema. It
+	// must be bumped whenever a field is removed or changes meaning; new
+	// fields may be added without bumping it.
+	eventStreamSchemaVersion = 1
+
+	streamEventScanStarted     = "scan_started"
+	streamEventRGEvaluated     = "rg_evaluated"
+	streamEventRGDeleteStarted = "rg_delete_started"
+	streamEventRGDeleteFailed  = "rg_delete_failed"
+	streamEventRunCompleted    = "run_completed"
+)
+
+// streamEvent is a line written by --events-stdout.
+type streamEvent struct {
+	SchemaVersion  int       `json:"schemaVersion"`
+	Type           string    `json:"type"`
+	Timestamp      time.Time `json:"timestamp"`
+	SubscriptionID string    `json:"subscriptionId,omitempty"`
+	// ResourceGroup is set for the rg_* events.
+	ResourceGroup *resourceGroupRecord `json:"resourceGroup,omitempty"`
+	// Summary and Error are set for run_completed.
+	Summary *runSummary `json:"summary,omitempty"`
+	Error   string      `json:"error,omitempty"`
+}
+
+func newStreamEvent(eventType, subscriptionID string) streamEvent {
+	return streamEvent{
+		SchemaVersion:  eventStreamSchemaVersion,
+		Type:           eventType,
+		Timestamp:      time.Now().UTC(),
+		SubscriptionID: subscriptionID,
+	}
+}
+
+// withResourceGroup returns a copy of e about the resource group of record.
+func (e streamEvent) withResourceGroup(record resourceGroupRecord) streamEvent {
+	e.ResourceGroup = &record
+	return e
+}
+
+// eventStream writes events as JSON lines as they happen. A nil *eventStream
+// writes nothing.
+type eventStream struct {
+	// mu keeps the lines of subscriptions cleaned up concurrently from being
+	// interleaved.
+	mu     sync.Mutex
+	w      io.Writer
+	failed bool
+}
+
+func newEventStream(w io.Writer) *eventStream {
+	return &eventStream{w: w}
+}
+
+// send writes event. Write errors are logged once, since a closed pipe would
+// otherwise fail every event.
+func (s *eventStream) send(event streamEvent) {
+	if s == nil {
+		return
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error when encoding the %s event", event.Type), "error", err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// A single write per line keeps lines whole even if w is shared.
+	if _, err := s.w.Write(append(data, '\n')); err != nil && !s.failed {
+		s.failed = true
+		slog.Error("Error when writing to the event stream", "error", err)
+	}
+}
+
+type eventStreamKey struct{}
+
+// withEventStream returns a copy of ctx carrying s.
+func withEventStream(ctx context.Context, s *eventStream) context.Context {
+	return context.WithValue(ctx, eventStreamKey{}, s)
+}
+
+// eventStreamFrom returns the event stream stored in ctx by withEventStream,
+// or nil.
+func eventStreamFrom(ctx context.Context) *eventStream {
+	s, _ := ctx.Value(eventStreamKey{}).(*eventStream)
+	return s
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	goruntime "runtime"
+	"sort"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+// byteWriter writes one byte at a time, yielding in between, so that lines
+// written concurrently without locking would be interleaved.
+type byteWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *byteWriter) Write(p []byte) (int, error) {
+	for _, b := range p {
+		w.buf.WriteByte(b)
+		goruntime.Gosched()
+	}
+	return len(p), nil
+}
+
+func TestEventStreamConcurrentSubscriptions(t *testing.T) {
+	fourDaysAgo := to.StringPtr(reportFourDaysAgo)
+	oneDayAgo := to.StringPtr(reportOneDayAgo)
+	subscriptionIDs := []string{"sub-1", "sub-2", "sub-3", "sub-4"}
+	w := &byteWriter{}
+	ctx := withEventStream(context.Background(), newEventStream(w))
+	errs := forEachSubscription(ctx, subscriptionIDs, len(subscriptionIDs), func(ctx context.Context, subscriptionID string) error {
+		c := &fakeResourceGroupsClient{pages: [][]*armresources.ResourceGroup{{
+			{Name: to.StringPtr("old"), Tags: map[string]*string{creationTimestampTag: fourDaysAgo}},
+			{Name: to.StringPtr("new"), Tags: map[string]*string{creationTimestampTag: oneDayAgo}},
+		}}}
+		if subscriptionID == "sub-4" {
+			c.deleteErr = errors.New("conflict")
+		}
+		_, err := runResourceGroupCleanup(ctx, subscriptionID, c, c, fakeResourcesClient{}, &options{ttl: defaultTTL, ignoreDeletionErrors: true})
+		return err
+	})
+	if len(errs) > 0 {
+		t.Fatalf("expected no error, but got %v", errs)
+	}
+
+	var events []string
+	scanner := bufio.NewScanner(&w.buf)
+	for scanner.Scan() {
+		var event streamEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("expected a JSON object per line, but got %q: %v", scanner.Text(), err)
+		}
+		if event.SchemaVersion != eventStreamSchemaVersion || event.Timestamp.IsZero() {
+			t.Fatalf("unexpected event %+v", event)
+		}
+		name := ""
+		if event.ResourceGroup != nil {
+			name = ":" + event.ResourceGroup.Name
+		}
+		events = append(events, fmt.Sprintf("%s:%s%s", event.SubscriptionID, event.Type, name))
+	}
+	sort.Strings(events)
+	var expected []string
+	for _, subscriptionID := range subscriptionIDs {
+		deletion := streamEventRGDeleteStarted
+		if subscriptionID == "sub-4" {
+			deletion = streamEventRGDeleteFailed
+		}
+		expected = append(expected,
+			subscriptionID+":"+deletion+":old",
+			subscriptionID+":"+streamEventRGEvaluated+":new",
+			subscriptionID+":"+streamEventRGEvaluated+":old",
+			subscriptionID+":"+streamEventScanStarted,
+		)
+	}
+	sort.Strings(expected)
+	if fmt.Sprint(events) != fmt.Sprint(expected) {
+		t.Fatalf("expected events %v, but got %v", expected, events)
+	}
+}
+
+func TestEventStreamNil(t *testing.T) {
+	var s *eventStream
+	s.send(newStreamEvent(streamEventScanStarted, "sub"))
+	if eventStreamFrom(context.Background()) != nil {
+		t.Fatal("expected no event stream in an empty context")
+	}
+}
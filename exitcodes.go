@@ -0,0 +1,54 @@
+package main
+
+// Exit codes of rg-cleanup, for wrapper scripts to tell the outcomes of a run
+// apart. They are documented in the README and must not change. 2 is left
+// out because the flag package and the Go runtime use it for invalid flags
+// and crashes.
+const (
+	// exitCodeSuccess is returned when --watch or --create-cleanup-tag-policy
+	// complete successfully.
+	exitCodeSuccess = 0
+	// exitCodeNothingEligible means that no resource group was eligible for
+	// deletion.
+	exitCodeNothingEligible = exitCodeSuccess
+	// exitCodeFatal means that the run stopped because of an error, e.g. an
+	// invalid option, a credential error or a failure to list resource
+	// groups.
+	exitCodeFatal = 1
+	// exitCodeDeleted means that the deletion of every eligible resource
+	// group was started.
+	exitCodeDeleted = 3
+	// exitCodeDeletionsFailed means that at least one deletion failed.
+	exitCodeDeletionsFailed = 4
+	// exitCodeDryRunEligible means that a dry run found resource groups
+	// eligible for deletion.
+	exitCodeDryRunEligible = 5
+
+	// exitCodeCountFound is returned instead of exitCodeDryRunEligible with
+	// --dry-run-count, which exits like grep.
+	exitCodeCountFound = 1
+)
+
+// computeExitCode returns the exit code of a run that completed without a
+// fatal error.
+func computeExitCode(summary *runSummary) int {
+	switch {
+	case summary.Failed > 0:
+		return exitCodeDeletionsFailed
+	case summary.DryRun && summary.Eligible > 0:
+		return exitCodeDryRunEligible
+	case summary.Deleted > 0:
+		return exitCodeDeleted
+	default:
+		return exitCodeNothingEligible
+	}
+}
+
+// dryRunCountExitCode returns the exit code of a --dry-run-count run that
+// found count resource groups eligible for deletion.
+func dryRunCountExitCode(count int) int {
+	if count > 0 {
+		return exitCodeCountFound
+	}
+	return exitCodeNothingEligible
+}
@@ -0,0 +1,64 @@
+package main
+
+import "testing"
+
+func TestComputeExitCode(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		summary  runSummary
+		expected int
+	}{
+		{
+			desc:     "nothing eligible",
+			summary:  runSummary{Scanned: 3, Skipped: 3},
+			expected: exitCodeNothingEligible,
+		},
+		{
+			desc:     "no resource groups",
+			summary:  runSummary{},
+			expected: exitCodeNothingEligible,
+		},
+		{
+			desc:     "deleted",
+			summary:  runSummary{Scanned: 3, Eligible: 2, Deleted: 2, Skipped: 1},
+			expected: exitCodeDeleted,
+		},
+		{
+			desc:     "some deletions failed",
+			summary:  runSummary{Scanned: 3, Eligible: 2, Deleted: 1, Failed: 1, Skipped: 1},
+			expected: exitCodeDeletionsFailed,
+		},
+		{
+			desc:     "every deletion failed",
+			summary:  runSummary{Scanned: 2, Eligible: 2, Failed: 2},
+			expected: exitCodeDeletionsFailed,
+		},
+		{
+			desc:     "dry run found eligible resource groups",
+			summary:  runSummary{DryRun: true, Scanned: 3, Eligible: 2, Skipped: 1},
+			expected: exitCodeDryRunEligible,
+		},
+		{
+			desc:     "dry run found nothing eligible",
+			summary:  runSummary{DryRun: true, Scanned: 3, Skipped: 3},
+			expected: exitCodeNothingEligible,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if code := computeExitCode(&tc.summary); code != tc.expected {
+				t.Fatalf("expected exit code %d, but got %d", tc.expected, code)
+			}
+		})
+	}
+}
+
+func TestDryRunCountExitCode(t *testing.T) {
+	if code := dryRunCountExitCode(0); code != 0 {
+		t.Fatalf("expected exit code 0 without eligible resource groups, but got %d", code)
+	}
+	if code := dryRunCountExitCode(12); code != 1 {
+		t.Fatalf("expected exit code 1 with eligible resource groups, but got %d", code)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+
+	"github.com/chewong/rg-cleanup/pkg/cleanup"
+)
+
+// maxExpectedDeletionsNames is how many eligible resource groups are listed
+// when --expect-deletions does not match.
+const maxExpectedDeletionsNames = 10
+
+// checkExpectedDeletions lists the resource groups eligible for deletion in
+// every subscription of o, and returns an error if their number is not
+// within --expect-tolerance of --expect-deletions. It lists them on its own
+// so that nothing is deleted when the check fails.
+func checkExpectedDeletions(ctx context.Context, cred azcore.TokenCredential, o *options) error {
+	var eligible []string
+	for _, subscriptionID := range o.subscriptionIDs {
+		r, resources, err := getSubscriptionClients(cred, subscriptionID, o.useResourceGraph)
+		if err != nil {
+			return err
+		}
+		names, err := listEligibleResourceGroups(ctx, subscriptionID, r, resources, o.forSubscription(subscriptionID))
+		if err != nil {
+			return fmt.Errorf("error when counting the resource groups eligible for deletion in subscription %s: %v", subscriptionID, err)
+		}
+		for _, name := range names {
+			eligible = append(eligible, subscriptionID+"/"+name)
+		}
+	}
+	return compareExpectedDeletions(*o.expectDeletions, o.expectTolerance, eligible)
+}
+
+// listEligibleResourceGroups returns the names of the resource groups of r
+// that this run would delete. They are decided like in
+// runResourceGroupCleanup: a resource group must be part of the plan with
+// --apply, eligible for deletion, and past its --grace-period.
+func listEligibleResourceGroups(ctx context.Context, subscriptionID string, r resourceGroupLister, resources resourcesClient, o *options) ([]string, error) {
+	// The decisions are logged again when the resource groups are cleaned up.
+	quiet := slog.New(slog.NewTextHandler(io.Discard, nil))
+	quietCtx := withLogger(ctx, quiet)
+	now := time.Now()
+	var names []string
+	pager := r.NewListPager(nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error when iterating resource groups: %v", err)
+		}
+		for _, rg := range page.Value {
+			if o.applyPlan != nil {
+				if _, ok := o.applyPlan.check(quiet, subscriptionID, rg); !ok {
+					continue
+				}
+			}
+			if evaluateResourceGroup(quietCtx, quiet, subscriptionID, resources, rg, o).Decision != decisionDelete {
+				continue
+			}
+			if o.gracePeriod > 0 && cleanup.WaitsForGracePeriod(rg, o.gracePeriod, now) {
+				continue
+			}
+			names = append(names, *rg.Name)
+		}
+	}
+	return names, nil
+}
+
+// compareExpectedDeletions returns an error listing the first eligible
+// resource groups if there are not expected of them, give or take tolerance.
+func compareExpectedDeletions(expected, tolerance int, eligible []string) error {
+	diff := len(eligible) - expected
+	if diff >= -tolerance && diff <= tolerance {
+		return nil
+	}
+	names := eligible
+	if len(names) > maxExpectedDeletionsNames {
+		names = append(names[:maxExpectedDeletionsNames:maxExpectedDeletionsNames], fmt.Sprintf("and %d more", len(eligible)-maxExpectedDeletionsNames))
+	}
+	listed := ""
+	if len(names) > 0 {
+		listed = ": " + strings.Join(names, ", ")
+	}
+	return fmt.Errorf("refusing to delete anything: --expect-deletions expects %d resource groups (tolerance %d), but %d are eligible%s", expected, tolerance, len(eligible), listed)
+}
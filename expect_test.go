@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+func TestListEligibleResourceGroups(t *testing.T) {
+	fourDaysAgo := time.Now().Add(-defaultTTL - 24*time.Hour).Format(time.RFC3339)
+	oneDayAgo := time.Now().Add(-24 * time.Hour).Format(time.RFC3339)
+	newResourceGroup := func(name, creationTimestamp string) *armresources.ResourceGroup {
+		rg := getResourceGroup(name, map[string]*string{creationTimestampTag: to.StringPtr(creationTimestamp)})
+		return &rg
+	}
+	r := &fakeResourceGroupsClient{
+		pages: [][]*armresources.ResourceGroup{
+			{newResourceGroup("old-1", fourDaysAgo), newResourceGroup("new", oneDayAgo)},
+			{newResourceGroup("old-2", fourDaysAgo), newResourceGroup("busy", fourDaysAgo)},
+		},
+	}
+	o := &options{ttl: defaultTTL, minResourceCount: 3}
+	names, err := listEligibleResourceGroups(context.Background(), "sub", r, fakeResourcesClient{"busy": 3}, o)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := []string{"old-1", "old-2"}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected %v to be eligible, but got %v", expected, names)
+	}
+	if len(r.deleted) != 0 {
+		t.Fatalf("expected nothing to be deleted, but got %v", r.deleted)
+	}
+}
+
+func TestExpectDeletionsAfterDryRun(t *testing.T) {
+	fourDaysAgo := time.Now().Add(-defaultTTL - 24*time.Hour).Format(time.RFC3339)
+	twoDaysAgo := time.Now().Add(-48 * time.Hour).Format(time.RFC3339)
+	oneHourAgo := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	newResourceGroup := func(name, scheduled string) *armresources.ResourceGroup {
+		rg := getResourceGroup(name, map[string]*string{creationTimestampTag: to.StringPtr(fourDaysAgo), scheduledDeletionTag: to.StringPtr(scheduled)})
+		return &rg
+	}
+	scheduled, waiting, vault := newResourceGroup("scheduled", twoDaysAgo), newResourceGroup("waiting", oneHourAgo), newResourceGroup("vault", twoDaysAgo)
+	resources := fakeTypedResourcesClient{"vault": {"Microsoft.KeyVault/vaults"}}
+
+	dryRun := &fakeResourceGroupsClient{pages: [][]*armresources.ResourceGroup{{scheduled, waiting, vault}}}
+	o := &options{ttl: defaultTTL, gracePeriod: 24 * time.Hour, skipResourceTypes: []string{"Microsoft.KeyVault/vaults"}, dryRun: true}
+	result, err := runResourceGroupCleanup(context.Background(), "sub", dryRun, dryRun, resources, o)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	p := newPlan(result.resourceGroups, time.Now())
+	expected := len(p.ResourceGroups)
+	if expected != 1 {
+		t.Fatalf("expected the dry run to plan 1 deletion, but got %+v", p.ResourceGroups)
+	}
+
+	// "unplanned" became eligible after the dry run, so --apply does not
+	// delete it and it must not be counted either.
+	live := &fakeResourceGroupsClient{pages: [][]*armresources.ResourceGroup{{scheduled, waiting, vault, newResourceGroup("unplanned", twoDaysAgo)}}}
+	o.dryRun, o.applyPlan, o.expectDeletions = false, p, &expected
+	names, err := listEligibleResourceGroups(context.Background(), "sub", live, resources, o)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := compareExpectedDeletions(*o.expectDeletions, o.expectTolerance, names); err != nil {
+		t.Fatalf("expected the dry run and the live run to agree, but got %v", err)
+	}
+	if _, err := runResourceGroupCleanup(context.Background(), "sub", live, live, resources, o); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(live.deleted, names) {
+		t.Fatalf("expected %v to be deleted, but got %v", names, live.deleted)
+	}
+}
+
+func TestCompareExpectedDeletions(t *testing.T) {
+	var many []string
+	for i := 0; i < 12; i++ {
+		many = append(many, fmt.Sprintf("sub/rg-%d", i))
+	}
+	testCases := []struct {
+		desc        string
+		expected    int
+		tolerance   int
+		eligible    []string
+		expectedErr string
+	}{
+		{
+			desc:     "exact",
+			expected: 2,
+			eligible: []string{"sub/a", "sub/b"},
+		},
+		{
+			desc:      "within tolerance",
+			expected:  3,
+			tolerance: 1,
+			eligible:  []string{"sub/a", "sub/b"},
+		},
+		{
+			desc:        "fewer than expected",
+			expected:    3,
+			eligible:    []string{"sub/a", "sub/b"},
+			expectedErr: "expects 3 resource groups (tolerance 0), but 2 are eligible: sub/a, sub/b",
+		},
+		{
+			desc:        "none eligible",
+			expected:    1,
+			expectedErr: "but 0 are eligible",
+		},
+		{
+			desc:        "more than expected",
+			expected:    10,
+			tolerance:   1,
+			eligible:    many,
+			expectedErr: "but 12 are eligible: sub/rg-0, sub/rg-1, sub/rg-2, sub/rg-3, sub/rg-4, sub/rg-5, sub/rg-6, sub/rg-7, sub/rg-8, sub/rg-9, and 2 more",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := compareExpectedDeletions(tc.expected, tc.tolerance, tc.eligible)
+			if tc.expectedErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.HasSuffix(err.Error(), tc.expectedErr) {
+				t.Fatalf("expected an error ending with '%s', but got %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	githubAPIURL = "https://api.github.com"
+	// githubIssueLabel is added to the issues opened by rg-cleanup, which
+	// only looks at issues with this label.
+	githubIssueLabel       = "rg-cleanup-stuck"
+	defaultGitHubTokenEnv  = "GITHUB_TOKEN"
+	githubIssuesPerPage    = 100
+	githubAPIVersionHeader = "2022-11-28"
+)
+
+// githubIssuesClient is a minimal GitHub REST API client for the issues of a
+// repository.
+type githubIssuesClient struct {
+	apiURL string
+	repo   string
+	token  string
+}
+
+func newGitHubIssuesClient(repo, token string) *githubIssuesClient {
+	return &githubIssuesClient{apiURL: githubAPIURL, repo: repo, token: token}
+}
+
+type githubIssue struct {
+	Number int    `json:"number,omitempty"`
+	Title  string `json:"title,omitempty"`
+	Body   string `json:"body,omitempty"`
+	// State is "open" or "closed".
+	State       string   `json:"state,omitempty"`
+	StateReason string   `json:"state_reason,omitempty"`
+	Labels      []string `json:"labels,omitempty"`
+}
+
+// do sends payload, if not nil, as JSON to the path of the API and decodes
+// the response into result, if not nil.
+func (c *githubIssuesClient) do(ctx context.Context, method, path string, payload, result any) error {
+	var body bytes.Buffer
+	if payload != nil {
+		if err := json.NewEncoder(&body).Encode(payload); err != nil {
+			return fmt.Errorf("failed to encode payload: %v", err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, c.apiURL+path, &body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("X-GitHub-Api-Version", githubAPIVersionHeader)
+	req.Header.Set("User-Agent", userAgent())
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s from %s %s", resp.Status, method, path)
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("failed to decode response: %v", err)
+	}
+	return nil
+}
+
+// listOpenIssues returns the numbers of the open issues labeled
+// githubIssueLabel, by title.
+func (c *githubIssuesClient) listOpenIssues(ctx context.Context) (map[string]int, error) {
+	issues := map[string]int{}
+	for page := 1; ; page++ {
+		var result []githubIssue
+		path := fmt.Sprintf("/repos/%s/issues?state=open&labels=%s&per_page=%d&page=%d", c.repo, githubIssueLabel, githubIssuesPerPage, page)
+		if err := c.do(ctx, http.MethodGet, path, nil, &result); err != nil {
+			return nil, err
+		}
+		for _, issue := range result {
+			issues[issue.Title] = issue.Number
+		}
+		if len(result) < githubIssuesPerPage {
+			return issues, nil
+		}
+	}
+}
+
+func (c *githubIssuesClient) createIssue(ctx context.Context, title, body string) error {
+	return c.do(ctx, http.MethodPost, fmt.Sprintf("/repos/%s/issues", c.repo), githubIssue{Title: title, Body: body, Labels: []string{githubIssueLabel}}, nil)
+}
+
+func (c *githubIssuesClient) updateIssue(ctx context.Context, number int, body string) error {
+	return c.do(ctx, http.MethodPatch, fmt.Sprintf("/repos/%s/issues/%d", c.repo, number), githubIssue{Body: body}, nil)
+}
+
+// closeIssue comments on the issue and closes it as completed.
+func (c *githubIssuesClient) closeIssue(ctx context.Context, number int, comment string) error {
+	if err := c.do(ctx, http.MethodPost, fmt.Sprintf("/repos/%s/issues/%d/comments", c.repo, number), map[string]string{"body": comment}, nil); err != nil {
+		return err
+	}
+	return c.do(ctx, http.MethodPatch, fmt.Sprintf("/repos/%s/issues/%d", c.repo, number), githubIssue{State: "closed", StateReason: "completed"}, nil)
+}
+
+// syncGitHubIssues opens an issue for each stuck resource group, or updates
+// the one already open, and closes the issues of the resource groups that are
+// gone. Issues are matched by title. Failures to update a single issue are
+// logged and do not stop the others.
+func syncGitHubIssues(ctx context.Context, c *githubIssuesClient, stuck, gone []*stuckResourceGroup) error {
+	if len(stuck) == 0 && len(gone) == 0 {
+		return nil
+	}
+	open, err := c.listOpenIssues(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list issues: %v", err)
+	}
+	for _, rg := range stuck {
+		logger := slog.With("subscription_id", rg.SubscriptionID, "rg_name", rg.Name)
+		title := stuckIssueTitle(rg)
+		if number, ok := open[title]; ok {
+			logger.Info(fmt.Sprintf("Updating issue #%d of stuck resource group '%s'", number, rg.Name))
+			if err := c.updateIssue(ctx, number, newStuckIssueBody(rg)); err != nil {
+				logger.Error(fmt.Sprintf("Error when updating issue #%d", number), "error", err)
+			}
+			continue
+		}
+		logger.Info(fmt.Sprintf("Opening an issue for stuck resource group '%s' in %s", rg.Name, c.repo))
+		if err := c.createIssue(ctx, title, newStuckIssueBody(rg)); err != nil {
+			logger.Error(fmt.Sprintf("Error when opening an issue for %s", rg.Name), "error", err)
+		}
+	}
+	for _, rg := range gone {
+		number, ok := open[stuckIssueTitle(rg)]
+		if !ok {
+			continue
+		}
+		logger := slog.With("subscription_id", rg.SubscriptionID, "rg_name", rg.Name)
+		logger.Info(fmt.Sprintf("Closing issue #%d: resource group '%s' is gone", number, rg.Name))
+		if err := c.closeIssue(ctx, number, fmt.Sprintf("Resource group `%s` no longer exists. Closed by rg-cleanup.", rg.Name)); err != nil {
+			logger.Error(fmt.Sprintf("Error when closing issue #%d", number), "error", err)
+		}
+	}
+	return nil
+}
+
+func stuckIssueTitle(rg *stuckResourceGroup) string {
+	return fmt.Sprintf("rg-cleanup cannot delete resource group %s in subscription %s", rg.Name, rg.SubscriptionID)
+}
+
+// newStuckIssueBody returns the Markdown body of the issue of rg.
+func newStuckIssueBody(rg *stuckResourceGroup) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "rg-cleanup failed to delete this resource group in %d runs.\n\n", rg.Failures)
+	fmt.Fprintf(&b, "| | |\n| --- | --- |\n")
+	fmt.Fprintf(&b, "| Resource group | `%s` |\n", rg.Name)
+	fmt.Fprintf(&b, "| Subscription | `%s` |\n", rg.SubscriptionID)
+	fmt.Fprintf(&b, "| Last failure | %s |\n", rg.LastFailure.UTC().Format(time.RFC3339))
+	if rg.RequestID != "" {
+		fmt.Fprintf(&b, "| Request ID | `%s` |\n", rg.RequestID)
+	}
+	if rg.CorrelationRequestID != "" {
+		fmt.Fprintf(&b, "| Correlation request ID | `%s` |\n", rg.CorrelationRequestID)
+	}
+	if len(rg.Tags) > 0 {
+		keys := make([]string, 0, len(rg.Tags))
+		for k := range rg.Tags {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		fmt.Fprintf(&b, "\n**Tags**\n\n")
+		for _, k := range keys {
+			fmt.Fprintf(&b, "- `%s`: `%s`\n", k, rg.Tags[k])
+		}
+	}
+	if rg.LastError != "" {
+		fmt.Fprintf(&b, "\n**Last error**\n\n```\n%s\n```\n", strings.ReplaceAll(rg.LastError, "```", "'''"))
+	}
+	fmt.Fprintf(&b, "\nThis issue is closed automatically once the resource group is gone.\n")
+	return b.String()
+}
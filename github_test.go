@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyncGitHubIssues(t *testing.T) {
+	var requests []string
+	var created githubIssue
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/repos/org/infra/issues":
+			if r.URL.Query().Get("labels") != githubIssueLabel || r.URL.Query().Get("state") != "open" {
+				t.Errorf("unexpected query %s", r.URL.RawQuery)
+			}
+			json.NewEncoder(w).Encode([]githubIssue{
+				{Number: 7, Title: stuckIssueTitle(&stuckResourceGroup{SubscriptionID: "sub", Name: "still-stuck"})},
+				{Number: 8, Title: stuckIssueTitle(&stuckResourceGroup{SubscriptionID: "sub", Name: "deleted"})},
+			})
+		case r.Method == http.MethodPost && r.URL.Path == "/repos/org/infra/issues":
+			if err := json.NewDecoder(r.Body).Decode(&created); err != nil {
+				t.Error(err)
+			}
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodPatch && r.URL.Path == "/repos/org/infra/issues/8":
+			var issue githubIssue
+			if err := json.NewDecoder(r.Body).Decode(&issue); err != nil {
+				t.Error(err)
+			}
+			if issue.State != "closed" {
+				t.Errorf("expected issue #8 to be closed, but got %+v", issue)
+			}
+		}
+	}))
+	defer server.Close()
+
+	c := newGitHubIssuesClient("org/infra", "token")
+	c.apiURL = server.URL
+	lastFailure := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	stuck := []*stuckResourceGroup{
+		{SubscriptionID: "sub", Name: "new-stuck", Tags: map[string]string{"owner": "alice"}, Failures: 3, LastFailure: lastFailure, LastError: "ScopeLocked", RequestID: "req-1", CorrelationRequestID: "corr-1"},
+		{SubscriptionID: "sub", Name: "still-stuck", Failures: 4, LastFailure: lastFailure},
+	}
+	gone := []*stuckResourceGroup{
+		{SubscriptionID: "sub", Name: "deleted"},
+		{SubscriptionID: "sub", Name: "never-had-an-issue"},
+	}
+	if err := syncGitHubIssues(context.Background(), c, stuck, gone); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"GET /repos/org/infra/issues",
+		"POST /repos/org/infra/issues",
+		"PATCH /repos/org/infra/issues/7",
+		"POST /repos/org/infra/issues/8/comments",
+		"PATCH /repos/org/infra/issues/8",
+	}
+	if fmt.Sprint(requests) != fmt.Sprint(expected) {
+		t.Fatalf("expected requests %v, but got %v", expected, requests)
+	}
+	if created.Title != stuckIssueTitle(stuck[0]) || fmt.Sprint(created.Labels) != "["+githubIssueLabel+"]" {
+		t.Fatalf("unexpected issue %+v", created)
+	}
+	for _, s := range []string{"`new-stuck`", "3 runs", "`owner`: `alice`", "ScopeLocked", "`req-1`", "`corr-1`"} {
+		if !strings.Contains(created.Body, s) {
+			t.Fatalf("expected the issue body to contain %q, but got:\n%s", s, created.Body)
+		}
+	}
+}
+
+func TestSyncGitHubIssuesListError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	c := newGitHubIssuesClient("org/infra", "token")
+	c.apiURL = server.URL
+	if err := syncGitHubIssues(context.Background(), c, []*stuckResourceGroup{{SubscriptionID: "sub", Name: "rg"}}, nil); err == nil {
+		t.Fatal("expected an error, but got nil")
+	}
+}
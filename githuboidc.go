@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+const (
+	// Set by GitHub Actions in jobs with the 'id-token: write' permission.
+	actionsIDTokenRequestURLEnvVar   = "ACTIONS_ID_TOKEN_REQUEST_URL"
+	actionsIDTokenRequestTokenEnvVar = "ACTIONS_ID_TOKEN_REQUEST_TOKEN"
+
+	// githubOIDCAudience is the audience Azure AD expects in federated
+	// credentials.
+	githubOIDCAudience = "api://AzureADTokenExchange"
+)
+
+// githubOIDCTokenSource requests ID tokens from the GitHub Actions OIDC
+// provider, to be exchanged for Azure AD tokens with --github-actions-oidc.
+type githubOIDCTokenSource struct {
+	requestURL   string
+	requestToken string
+}
+
+// getAssertion returns a new GitHub Actions ID token. GitHub tokens expire
+// after a few minutes, so a new one is requested each time Azure AD needs an
+// assertion.
+func (s *githubOIDCTokenSource) getAssertion(ctx context.Context) (string, error) {
+	u, err := url.Parse(s.requestURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid $%s: %v", actionsIDTokenRequestURLEnvVar, err)
+	}
+	query := u.Query()
+	query.Set("audience", githubOIDCAudience)
+	u.RawQuery = query.Encode()
+
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+s.requestToken)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", userAgent())
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to request a GitHub Actions ID token: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to request a GitHub Actions ID token: unexpected status %s", resp.Status)
+	}
+	var token struct {
+		Value string `json:"value"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("failed to decode the GitHub Actions ID token: %v", err)
+	}
+	if token.Value == "" {
+		return "", fmt.Errorf("GitHub Actions returned an empty ID token")
+	}
+	return token.Value, nil
+}
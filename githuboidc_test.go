@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGitHubOIDCGetAssertion(t *testing.T) {
+	testCases := []struct {
+		desc          string
+		status        int
+		body          string
+		expectedToken string
+		expectedErr   string
+	}{
+		{
+			desc:          "token",
+			status:        http.StatusOK,
+			body:          `{"count":1,"value":"github-id-token"}`,
+			expectedToken: "github-id-token",
+		},
+		{
+			desc:        "request token rejected",
+			status:      http.StatusUnauthorized,
+			expectedErr: "unexpected status 401 Unauthorized",
+		},
+		{
+			desc:        "empty token",
+			status:      http.StatusOK,
+			body:        `{"value":""}`,
+			expectedErr: "empty ID token",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if auth := r.Header.Get("Authorization"); auth != "Bearer request-token" {
+					t.Errorf("expected the request token, but got '%s'", auth)
+				}
+				if audience := r.URL.Query().Get("audience"); audience != githubOIDCAudience {
+					t.Errorf("expected audience '%s', but got '%s'", githubOIDCAudience, audience)
+				}
+				if version := r.URL.Query().Get("api-version"); version != "2.0" {
+					t.Errorf("expected the query of the request URL to be kept, but got api-version '%s'", version)
+				}
+				w.WriteHeader(tc.status)
+				w.Write([]byte(tc.body))
+			}))
+			defer server.Close()
+
+			s := &githubOIDCTokenSource{requestURL: server.URL + "/token?api-version=2.0", requestToken: "request-token"}
+			token, err := s.getAssertion(context.Background())
+			if tc.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+					t.Fatalf("expected an error containing '%s', but got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if token != tc.expectedToken {
+				t.Fatalf("expected '%s', but got '%s'", tc.expectedToken, token)
+			}
+		})
+	}
+}
+
+func TestValidateCredentialsGitHubActionsOIDC(t *testing.T) {
+	source := &githubOIDCTokenSource{requestURL: "https://token.actions.githubusercontent.com", requestToken: "request-token"}
+	testCases := []struct {
+		desc        string
+		o           options
+		expectedErr bool
+	}{
+		{
+			desc: "no client secret needed",
+			o:    options{clientID: "client", tenantID: "tenant", githubActionsOIDC: true, githubOIDC: source},
+		},
+		{
+			desc:        "missing request variables",
+			o:           options{clientID: "client", tenantID: "tenant", githubActionsOIDC: true, githubOIDC: &githubOIDCTokenSource{}},
+			expectedErr: true,
+		},
+		{
+			desc:        "missing tenant",
+			o:           options{clientID: "client", githubActionsOIDC: true, githubOIDC: source},
+			expectedErr: true,
+		},
+		{
+			desc:        "with --identity",
+			o:           options{clientID: "client", tenantID: "tenant", identity: true, githubActionsOIDC: true, githubOIDC: source},
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if err := tc.o.validateCredentials(); (err != nil) != tc.expectedErr {
+				t.Fatalf("expected error to be %t, but got %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
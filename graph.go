@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+)
+
+const graphEndpoint = "https://graph.microsoft.com"
+
+// graphClient is a minimal Microsoft Graph client for the few lookups
+// rg-cleanup needs.
+type graphClient struct {
+	endpoint string
+	pipeline runtime.Pipeline
+}
+
+func newGraphClient(cred azcore.TokenCredential) *graphClient {
+	return &graphClient{
+		endpoint: graphEndpoint,
+		pipeline: runtime.NewPipeline(moduleName, moduleVersion, runtime.PipelineOptions{
+			PerRetry: []policy.Policy{
+				runtime.NewBearerTokenPolicy(cred, []string{graphEndpoint + "/.default"}, nil),
+				tracingPolicy{},
+			},
+		}, nil),
+	}
+}
+
+// userLookup is a $filter that can find the user account of a classic
+// administrator's email address. Advanced lookups filter on properties that
+// Graph only supports with the eventual consistency level.
+type userLookup struct {
+	filter   string
+	advanced bool
+}
+
+// userLookups returns the lookups of the user account of email. Members are
+// found by user principal name or mail address. Guests, including Microsoft
+// accounts, often have neither: their user principal name is derived from
+// the address, e.g. alice_fabrikam.com#EXT#@contoso.onmicrosoft.com, and the
+// address may only be in their other mails or sign-in identities.
+func userLookups(email string) []userLookup {
+	escaped := strings.ReplaceAll(email, "'", "''")
+	guestPrefix := strings.ReplaceAll(escaped, "@", "_") + "#EXT#@"
+	return []userLookup{
+		{filter: fmt.Sprintf("userPrincipalName eq '%s' or mail eq '%s'", escaped, escaped)},
+		{filter: fmt.Sprintf("startswith(userPrincipalName, '%s')", guestPrefix)},
+		{filter: fmt.Sprintf("otherMails/any(m:m eq '%s')", escaped), advanced: true},
+		{filter: fmt.Sprintf("identities/any(i:i/issuerAssignedId eq '%s')", escaped), advanced: true},
+	}
+}
+
+// userExists reports whether a user account of email exists in the tenant. It
+// only returns false when none of the lookups of userLookups finds one, and
+// returns an error if any of the lookups it needed failed.
+func (g *graphClient) userExists(ctx context.Context, email string) (_ bool, err error) {
+	ctx, span := tracer().Start(ctx, "graph user lookup")
+	defer func() { endSpan(span, err) }()
+
+	for _, lookup := range userLookups(email) {
+		found, err := g.findUser(ctx, lookup)
+		if err != nil {
+			return false, fmt.Errorf("failed to look up users with '%s': %v", lookup.filter, err)
+		}
+		if found {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// findUser reports whether lookup finds at least one user.
+func (g *graphClient) findUser(ctx context.Context, lookup userLookup) (bool, error) {
+	query := url.Values{}
+	query.Set("$filter", lookup.filter)
+	query.Set("$select", "id")
+	if lookup.advanced {
+		query.Set("$count", "true")
+	}
+
+	req, err := runtime.NewRequest(ctx, http.MethodGet, g.endpoint+"/v1.0/users?"+query.Encode())
+	if err != nil {
+		return false, err
+	}
+	if lookup.advanced {
+		req.Raw().Header.Set("ConsistencyLevel", "eventual")
+	}
+	resp, err := g.pipeline.Do(req)
+	if err != nil {
+		return false, err
+	}
+	if !runtime.HasStatusCode(resp, http.StatusOK) {
+		return false, runtime.NewResponseError(resp)
+	}
+
+	var result struct {
+		Value []struct {
+			ID string `json:"id"`
+		} `json:"value"`
+	}
+	if err := runtime.UnmarshalAsJSON(resp, &result); err != nil {
+		return false, err
+	}
+	return len(result.Value) > 0, nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+)
+
+// newFakeGraphServer returns a Graph server whose users are found by the
+// filters in found. Filters in failing return an error, and the filters
+// received are appended to filters.
+func newFakeGraphServer(t *testing.T, found, failing map[string]bool, filters *[]string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filter := r.URL.Query().Get("$filter")
+		*filters = append(*filters, filter)
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path != "/v1.0/users" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		// Graph rejects filters on collections without an advanced query.
+		advanced := r.Header.Get("ConsistencyLevel") == "eventual" && r.URL.Query().Get("$count") == "true"
+		if strings.Contains(filter, "/any(") && !advanced {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if failing[filter] {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if found[filter] {
+			fmt.Fprint(w, `{"value":[{"id":"00000000-0000-0000-0000-000000000001"}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"value":[]}`)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newTestGraphClient(endpoint string) *graphClient {
+	return &graphClient{
+		endpoint: endpoint,
+		pipeline: runtime.NewPipeline(moduleName, moduleVersion, runtime.PipelineOptions{}, nil),
+	}
+}
+
+func TestGraphUserExists(t *testing.T) {
+	found := map[string]bool{
+		"userPrincipalName eq 'alice@contoso.com' or mail eq 'alice@contoso.com'": true,
+		"startswith(userPrincipalName, 'bob_fabrikam.com#EXT#@')":                 true,
+		"otherMails/any(m:m eq 'carol@outlook.com')":                              true,
+		"identities/any(i:i/issuerAssignedId eq 'dave@gmail.com')":                true,
+	}
+	failing := map[string]bool{
+		"otherMails/any(m:m eq 'erin@contoso.com')": true,
+	}
+	testCases := []struct {
+		email           string
+		expectedExists  bool
+		expectedErr     bool
+		expectedFilters []string
+	}{
+		{
+			email:           "alice@contoso.com",
+			expectedExists:  true,
+			expectedFilters: []string{"userPrincipalName eq 'alice@contoso.com' or mail eq 'alice@contoso.com'"},
+		},
+		{
+			email:          "bob@fabrikam.com",
+			expectedExists: true,
+			expectedFilters: []string{
+				"userPrincipalName eq 'bob@fabrikam.com' or mail eq 'bob@fabrikam.com'",
+				"startswith(userPrincipalName, 'bob_fabrikam.com#EXT#@')",
+			},
+		},
+		{
+			email:          "carol@outlook.com",
+			expectedExists: true,
+			expectedFilters: []string{
+				"userPrincipalName eq 'carol@outlook.com' or mail eq 'carol@outlook.com'",
+				"startswith(userPrincipalName, 'carol_outlook.com#EXT#@')",
+				"otherMails/any(m:m eq 'carol@outlook.com')",
+			},
+		},
+		{
+			email:          "dave@gmail.com",
+			expectedExists: true,
+			expectedFilters: []string{
+				"userPrincipalName eq 'dave@gmail.com' or mail eq 'dave@gmail.com'",
+				"startswith(userPrincipalName, 'dave_gmail.com#EXT#@')",
+				"otherMails/any(m:m eq 'dave@gmail.com')",
+				"identities/any(i:i/issuerAssignedId eq 'dave@gmail.com')",
+			},
+		},
+		{
+			email:       "erin@contoso.com",
+			expectedErr: true,
+			expectedFilters: []string{
+				"userPrincipalName eq 'erin@contoso.com' or mail eq 'erin@contoso.com'",
+				"startswith(userPrincipalName, 'erin_contoso.com#EXT#@')",
+				"otherMails/any(m:m eq 'erin@contoso.com')",
+			},
+		},
+		{
+			email: "o'brien@contoso.com",
+			expectedFilters: []string{
+				"userPrincipalName eq 'o''brien@contoso.com' or mail eq 'o''brien@contoso.com'",
+				"startswith(userPrincipalName, 'o''brien_contoso.com#EXT#@')",
+				"otherMails/any(m:m eq 'o''brien@contoso.com')",
+				"identities/any(i:i/issuerAssignedId eq 'o''brien@contoso.com')",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.email, func(t *testing.T) {
+			var filters []string
+			g := newTestGraphClient(newFakeGraphServer(t, found, failing, &filters).URL)
+			exists, err := g.userExists(context.Background(), tc.email)
+			if (err != nil) != tc.expectedErr {
+				t.Fatalf("expected error %t, but got %v", tc.expectedErr, err)
+			}
+			if exists != tc.expectedExists {
+				t.Fatalf("expected %t, but got %t", tc.expectedExists, exists)
+			}
+			if !reflect.DeepEqual(filters, tc.expectedFilters) {
+				t.Fatalf("expected filters %q, but got %q", tc.expectedFilters, filters)
+			}
+		})
+	}
+}
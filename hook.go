@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+
+	"github.com/chewong/rg-cleanup/pkg/cleanup"
+)
+
+const (
+	preDeleteHook  = "pre-delete hook"
+	postDeleteHook = "post-delete hook"
+
+	defaultDeleteHookTimeout = time.Minute
+	// maxHookOutput bounds how much of the output of a failed hook is
+	// logged.
+	maxHookOutput = 1024
+)
+
+// validateDeleteHook checks the --<flag> hook script and its timeout. The
+// script is run directly rather than through a shell, so it must be the path
+// of an executable, without arguments.
+func validateDeleteHook(flag, script string, timeout time.Duration) error {
+	if script == "" {
+		return nil
+	}
+	if strings.ContainsAny(script, " \t\n") {
+		return fmt.Errorf("--%s must be the path of an executable without arguments, got '%s'; put the command in a script instead", flag, script)
+	}
+	if timeout <= 0 {
+		return fmt.Errorf("--%s-timeout must be positive, got %s", flag, timeout)
+	}
+	return nil
+}
+
+// runDeleteHook runs hook, the --pre-delete-hook or --post-delete-hook
+// script, for the deletion of rg with $RG_NAME, $SUBSCRIPTION_ID and
+// $RG_AGE_HOURS set, and returns an error if it fails or does not exit within
+// timeout. $RG_AGE_HOURS is empty when the resource group has no valid
+// creation timestamp.
+func runDeleteHook(ctx context.Context, hook, script string, timeout time.Duration, subscriptionID string, rg *armresources.ResourceGroup, now time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ageHours := ""
+	if creationTimestamp, ok := rg.Tags[creationTimestampTag]; ok && creationTimestamp != nil {
+		if created, err := cleanup.ParseCreationTimestamp(*creationTimestamp); err == nil {
+			ageHours = strconv.Itoa(int(now.Sub(created).Hours()))
+		}
+	}
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, script)
+	cmd.Env = append(os.Environ(),
+		"RG_NAME="+*rg.Name,
+		"SUBSCRIPTION_ID="+subscriptionID,
+		"RG_AGE_HOURS="+ageHours,
+	)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	// Do not wait for children of the script that keep the output open once
+	// it has been killed.
+	cmd.WaitDelay = time.Second
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("timed out after %s", timeout)
+		}
+		out := strings.TrimSpace(output.String())
+		if len(out) > maxHookOutput {
+			out = out[:maxHookOutput] + "..."
+		}
+		return fmt.Errorf("%s '%s' failed: %v: %s", hook, script, err, out)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+func writeHook(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "hook.sh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+content), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRunDeleteHook(t *testing.T) {
+	now := time.Date(2024, 1, 5, 12, 0, 0, 0, time.UTC)
+	rg := &armresources.ResourceGroup{
+		Name: to.StringPtr("old-rg"),
+		Tags: map[string]*string{creationTimestampTag: to.StringPtr("2024-01-02T12:00:00Z")},
+	}
+	testCases := []struct {
+		desc        string
+		script      string
+		rg          *armresources.ResourceGroup
+		timeout     time.Duration
+		expectedErr string
+	}{
+		{
+			desc:   "approved",
+			script: `[ "$RG_NAME" = old-rg ] && [ "$SUBSCRIPTION_ID" = sub ] && [ "$RG_AGE_HOURS" = 72 ]`,
+			rg:     rg,
+		},
+		{
+			desc:        "rejected",
+			script:      "echo 'no ticket for this resource group'\nexit 1",
+			rg:          rg,
+			expectedErr: "no ticket for this resource group",
+		},
+		{
+			desc:   "no creation timestamp",
+			script: `[ -z "$RG_AGE_HOURS" ]`,
+			rg:     &armresources.ResourceGroup{Name: to.StringPtr("untagged")},
+		},
+		{
+			desc:        "timeout",
+			script:      "exec sleep 10",
+			rg:          rg,
+			timeout:     100 * time.Millisecond,
+			expectedErr: "timed out after 100ms",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			timeout := tc.timeout
+			if timeout == 0 {
+				timeout = defaultDeleteHookTimeout
+			}
+			err := runDeleteHook(context.Background(), preDeleteHook, writeHook(t, tc.script), timeout, "sub", tc.rg, now)
+			if tc.expectedErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+				t.Fatalf("expected an error containing '%s', but got %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestPostDeleteHook(t *testing.T) {
+	fourDaysAgo := to.StringPtr(time.Now().Add(-defaultTTL - 24*time.Hour).Format(time.RFC3339))
+	newResourceGroup := func(name string) *armresources.ResourceGroup {
+		rg := getResourceGroup(name, map[string]*string{creationTimestampTag: fourDaysAgo})
+		return &rg
+	}
+	r := &fakeResourceGroupsClient{
+		pages:      [][]*armresources.ResourceGroup{{newResourceGroup("old-1"), newResourceGroup("old-2"), newResourceGroup("old-3")}},
+		deleteErrs: map[string]error{"old-2": errors.New("conflict")},
+	}
+	deletedFile := filepath.Join(t.TempDir(), "deleted")
+	// The hook fails for old-3, which must not fail its deletion.
+	hook := writeHook(t, `echo "$SUBSCRIPTION_ID/$RG_NAME" >> `+deletedFile+`
+[ "$RG_NAME" != old-3 ]`)
+	o := &options{ttl: defaultTTL, postDeleteHook: hook, postDeleteHookTimeout: defaultDeleteHookTimeout, ignoreDeletionErrors: true}
+	result, err := runResourceGroupCleanup(context.Background(), "sub", r, r, fakeResourcesClient{}, o)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.deleted != 2 || result.failed != 1 {
+		t.Fatalf("expected 2 deleted and 1 failed, but got %d and %d", result.deleted, result.failed)
+	}
+	b, err := os.ReadFile(deletedFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "sub/old-1\nsub/old-3\n"; string(b) != expected {
+		t.Fatalf("expected the hook to run for the started deletions only, but got %q", b)
+	}
+}
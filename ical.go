@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	ics "github.com/arran4/golang-ical"
+	"github.com/chewong/rg-cleanup/pkg/cleanup"
+)
+
+const (
+	defaultICalLookahead = 7 * 24 * time.Hour
+	ownerTag             = "owner"
+	ownerEmailTag        = "owner-email"
+	contactTag           = "contact"
+)
+
+// upcomingDeletion describes the deletion of a resource group by a later
+// run.
+type upcomingDeletion struct {
+	subscriptionID string
+	name           string
+	// created is zero when the resource group has no creation timestamp.
+	created time.Time
+	owner   string
+	// contact is the email address in the owner-email tag, or else the
+	// contact tag.
+	contact  string
+	deletion time.Time
+}
+
+// getUpcomingDeletion returns the deletion of rg if it will be deleted by
+// now+lookahead. record is the decision of this run on rg, which is reused
+// unless it depends on time: only a resource group whose TTL has not elapsed
+// yet is evaluated again, with evaluateResourceGroup at now+lookahead, so
+// every rule of the cleanup applies and its resources are listed at most once
+// more. The deletion date is when the TTL of rg elapses, or now if it already
+// has or rg has no creation timestamp, delayed by --grace-period.
+func getUpcomingDeletion(ctx context.Context, subscriptionID string, resources resourcesClient, rg *armresources.ResourceGroup, record resourceGroupRecord, o *options, now time.Time, lookahead time.Duration) (upcomingDeletion, bool) {
+	// The decisions are logged when the resource group is cleaned up.
+	quiet := slog.New(slog.NewTextHandler(io.Discard, nil))
+	switch {
+	case record.Decision == decisionDelete:
+	case record.Reason == reasonTTLNotElapsed:
+		later := *o
+		later.evaluateAt = now.Add(lookahead)
+		if evaluateResourceGroup(ctx, quiet, subscriptionID, resources, rg, &later).Decision != decisionDelete {
+			return upcomingDeletion{}, false
+		}
+	default:
+		// The other reasons to skip rg do not change with time.
+		return upcomingDeletion{}, false
+	}
+
+	d := upcomingDeletion{
+		subscriptionID: subscriptionID,
+		name:           *rg.Name,
+		deletion:       now,
+	}
+	if creationTimestamp, ok := rg.Tags[creationTimestampTag]; ok && creationTimestamp != nil {
+		// The timestamp is valid, or rg would not be eligible.
+		d.created, _ = cleanup.ParseCreationTimestamp(*creationTimestamp)
+		if eligible := d.created.Add(cleanup.TTL(quiet, rg, o.ttl)); eligible.After(now) {
+			d.deletion = eligible
+		}
+	}
+	if o.gracePeriod > 0 {
+		// The grace period starts when rg is scheduled for deletion, on the
+		// first run after it became eligible.
+		scheduled := d.deletion
+		if value, ok := rg.Tags[scheduledDeletionTag]; ok && value != nil {
+			if t, err := cleanup.ParseCreationTimestamp(*value); err == nil {
+				scheduled = t
+			}
+		}
+		if deleteAt := scheduled.Add(o.gracePeriod); deleteAt.After(now) {
+			d.deletion = deleteAt
+		}
+	}
+	if d.deletion.After(now.Add(lookahead)) {
+		return upcomingDeletion{}, false
+	}
+
+	if owner, ok := rg.Tags[ownerTag]; ok && owner != nil {
+		d.owner = *owner
+	}
+	for _, tag := range []string{ownerEmailTag, contactTag} {
+		if contact, ok := rg.Tags[tag]; ok && contact != nil && *contact != "" {
+			d.contact = *contact
+			break
+		}
+	}
+	return d, true
+}
+
+// formatCreated formats the creation time of d, or "unknown" if it has no
+// creation timestamp.
+func (d upcomingDeletion) formatCreated() string {
+	if d.created.IsZero() {
+		return "unknown"
+	}
+	return d.created.UTC().Format(time.RFC3339)
+}
+
+// writeICal writes an iCalendar file with one event per upcoming deletion.
+func writeICal(path string, deletions []upcomingDeletion, now time.Time) error {
+	cal := ics.NewCalendar()
+	cal.SetMethod(ics.MethodPublish)
+	cal.SetProductId("-//Azure//rg-cleanup//EN")
+	cal.SetName("rg-cleanup upcoming deletions")
+
+	for _, d := range deletions {
+		owner := d.owner
+		if owner == "" {
+			owner = "unknown"
+		}
+		event := cal.AddEvent(fmt.Sprintf("%s/%s@rg-cleanup", d.subscriptionID, d.name))
+		event.SetDtStampTime(now)
+		event.SetStartAt(d.deletion)
+		event.SetEndAt(d.deletion.Add(30 * time.Minute))
+		event.SetSummary(fmt.Sprintf("rg-cleanup: resource group '%s' becomes eligible for deletion", d.name))
+		event.SetDescription(fmt.Sprintf("Resource group: %s\nSubscription: %s\nCreation timestamp: %s\nOwner: %s\nExpected deletion: %s\nAdd a '%s' tag to keep it.",
+			d.name, d.subscriptionID, d.formatCreated(), owner, d.deletion.Format(time.RFC3339), doNotDeleteTag))
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create iCal file: %v", err)
+	}
+	if err := cal.SerializeTo(f); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write iCal file: %v", err)
+	}
+	return f.Close()
+}
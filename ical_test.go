@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/Azure/go-autorest/autorest/to"
+	ics "github.com/arran4/golang-ical"
+)
+
+func TestGetUpcomingDeletion(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	soon := now.Add(-defaultTTL + time.Hour)
+	testCases := []struct {
+		desc             string
+		tags             map[string]*string
+		state            string
+		o                *options
+		expectedUpcoming bool
+		expectedDeletion time.Time
+	}{
+		{
+			desc:             "becomes eligible within the lookahead",
+			tags:             map[string]*string{creationTimestampTag: to.StringPtr(soon.Format(time.RFC3339)), ownerTag: to.StringPtr("alice")},
+			expectedUpcoming: true,
+			expectedDeletion: now.Add(time.Hour),
+		},
+		{
+			desc:             "becomes eligible after the lookahead",
+			tags:             map[string]*string{creationTimestampTag: to.StringPtr(now.Format(time.RFC3339))},
+			expectedUpcoming: false,
+		},
+		{
+			desc:             "already eligible",
+			tags:             map[string]*string{creationTimestampTag: to.StringPtr(now.Add(-defaultTTL - time.Hour).Format(time.RFC3339))},
+			expectedUpcoming: true,
+			expectedDeletion: now,
+		},
+		{
+			desc:             "no creation timestamp",
+			tags:             map[string]*string{},
+			expectedUpcoming: true,
+			expectedDeletion: now,
+		},
+		{
+			desc:             "has a DO-NOT-DELETE tag",
+			tags:             map[string]*string{creationTimestampTag: to.StringPtr(soon.Format(time.RFC3339)), doNotDeleteTag: to.StringPtr("")},
+			expectedUpcoming: false,
+		},
+		{
+			desc:             "misses a --has-tag tag",
+			tags:             map[string]*string{creationTimestampTag: to.StringPtr(soon.Format(time.RFC3339))},
+			o:                &options{ttl: defaultTTL, hasTags: []string{"ci"}},
+			expectedUpcoming: false,
+		},
+		{
+			desc:             "not created by --created-by-sp",
+			tags:             map[string]*string{creationTimestampTag: to.StringPtr(soon.Format(time.RFC3339))},
+			o:                &options{ttl: defaultTTL, createdBySPs: []string{"sp"}, createdByTag: defaultCreatedByTag},
+			expectedUpcoming: false,
+		},
+		{
+			desc:             "provisioning state not allowed",
+			tags:             map[string]*string{creationTimestampTag: to.StringPtr(soon.Format(time.RFC3339))},
+			state:            "Deleting",
+			o:                &options{ttl: defaultTTL, provisioningStates: defaultProvisioningStates},
+			expectedUpcoming: false,
+		},
+		{
+			desc:             "grace period pushes the deletion past the lookahead",
+			tags:             map[string]*string{creationTimestampTag: to.StringPtr(soon.Format(time.RFC3339))},
+			o:                &options{ttl: defaultTTL, gracePeriod: 48 * time.Hour},
+			expectedUpcoming: false,
+		},
+		{
+			desc:             "grace period of a scheduled resource group",
+			tags:             map[string]*string{creationTimestampTag: to.StringPtr(now.Add(-defaultTTL - 48*time.Hour).Format(time.RFC3339)), scheduledDeletionTag: to.StringPtr(now.Add(-12 * time.Hour).Format(time.RFC3339))},
+			o:                &options{ttl: defaultTTL, gracePeriod: 24 * time.Hour},
+			expectedUpcoming: true,
+			expectedDeletion: now.Add(12 * time.Hour),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			o := tc.o
+			if o == nil {
+				o = &options{ttl: defaultTTL}
+			}
+			rg := getResourceGroup("kubetest-123", tc.tags)
+			if tc.state != "" {
+				rg.Properties = &armresources.ResourceGroupProperties{ProvisioningState: to.StringPtr(tc.state)}
+			}
+			quiet := slog.New(slog.NewTextHandler(io.Discard, nil))
+			current := *o
+			current.evaluateAt = now
+			record := evaluateResourceGroup(context.Background(), quiet, "sub", fakeResourcesClient{}, &rg, &current)
+			d, ok := getUpcomingDeletion(context.Background(), "sub", fakeResourcesClient{}, &rg, record, o, now, 24*time.Hour)
+			if ok != tc.expectedUpcoming {
+				t.Fatalf("expected %t, but got %t", tc.expectedUpcoming, ok)
+			}
+			if ok && !d.deletion.Equal(tc.expectedDeletion) {
+				t.Fatalf("expected deletion at %s, but got %s", tc.expectedDeletion, d.deletion)
+			}
+		})
+	}
+}
+
+// countingResourcesClient counts the listings of the resources of each
+// resource group, which contain no resource.
+type countingResourcesClient map[string]int
+
+func (c countingResourcesClient) NewListByResourceGroupPager(resourceGroupName string, options *armresources.ClientListByResourceGroupOptions) *runtime.Pager[armresources.ClientListByResourceGroupResponse] {
+	c[resourceGroupName]++
+	return fakeResourcesClient{}.NewListByResourceGroupPager(resourceGroupName, options)
+}
+
+func TestUpcomingDeletionsReuseDecisions(t *testing.T) {
+	now := time.Now()
+	newResourceGroup := func(name string, created time.Time) *armresources.ResourceGroup {
+		rg := getResourceGroup(name, map[string]*string{
+			creationTimestampTag: to.StringPtr(created.Format(time.RFC3339)),
+			ownerEmailTag:        to.StringPtr("alice@example.com"),
+		})
+		return &rg
+	}
+	c := &fakeResourceGroupsClient{pages: [][]*armresources.ResourceGroup{{
+		newResourceGroup("stale", now.Add(-defaultTTL-time.Hour)),
+		newResourceGroup("soon", now.Add(-defaultTTL+24*time.Hour)),
+		newResourceGroup("later", now),
+	}}}
+	resources := countingResourcesClient{}
+	o := &options{
+		ttl:                  defaultTTL,
+		dryRun:               true,
+		minResourceCount:     1,
+		exportICal:           "upcoming.ics",
+		icalLookahead:        defaultICalLookahead,
+		notifyBeforeDeletion: notifyEmail,
+		notifyDaysBefore:     2,
+	}
+	result, err := runResourceGroupCleanup(context.Background(), "sub", c, c, resources, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The resources of the stale resource group are listed by the run, and
+	// those of the others once more to decide whether they will be
+	// eligible.
+	expected := map[string]int{"stale": 1, "soon": 1, "later": 1}
+	if !reflect.DeepEqual(map[string]int(resources), expected) {
+		t.Fatalf("expected the resources to be listed %v times, but got %v", expected, resources)
+	}
+	if len(result.upcoming) != 2 || len(result.notifications) != 2 {
+		t.Fatalf("expected 2 upcoming deletions and 2 notifications, but got %d and %d", len(result.upcoming), len(result.notifications))
+	}
+}
+
+func TestWriteICal(t *testing.T) {
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), "upcoming.ics")
+	deletions := []upcomingDeletion{
+		{
+			subscriptionID: "sub",
+			name:           "kubetest-123",
+			created:        now.Add(-48 * time.Hour),
+			owner:          "alice",
+			deletion:       now.Add(24 * time.Hour),
+		},
+	}
+	if err := writeICal(path, deletions, now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer f.Close()
+	cal, err := ics.ParseCalendar(f)
+	if err != nil {
+		t.Fatalf("failed to parse iCal file: %v", err)
+	}
+	events := cal.Events()
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event, but got %d", len(events))
+	}
+	start, err := events[0].GetStartAt()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !start.Equal(deletions[0].deletion) {
+		t.Fatalf("expected the event to start at %s, but got %s", deletions[0].deletion, start)
+	}
+	description := events[0].GetProperty(ics.ComponentPropertyDescription).Value
+	for _, s := range []string{"kubetest-123", "sub", "alice", "2023-05-30T12:00:00Z", "2023-06-02T12:00:00Z"} {
+		if !strings.Contains(description, s) {
+			t.Fatalf("expected the event description to contain '%s', but got '%s'", s, description)
+		}
+	}
+}
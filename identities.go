@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/msi/armmsi"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resourcegraph/armresourcegraph"
+	"github.com/chewong/rg-cleanup/pkg/cleanup"
+)
+
+// userAssignedIdentitiesClient is the subset of
+// *armmsi.UserAssignedIdentitiesClient used by rg-cleanup.
+type userAssignedIdentitiesClient interface {
+	NewListBySubscriptionPager(options *armmsi.UserAssignedIdentitiesClientListBySubscriptionOptions) *runtime.Pager[armmsi.UserAssignedIdentitiesClientListBySubscriptionResponse]
+	NewListByResourceGroupPager(resourceGroupName string, options *armmsi.UserAssignedIdentitiesClientListByResourceGroupOptions) *runtime.Pager[armmsi.UserAssignedIdentitiesClientListByResourceGroupResponse]
+	Delete(ctx context.Context, resourceGroupName string, resourceName string, options *armmsi.UserAssignedIdentitiesClientDeleteOptions) (armmsi.UserAssignedIdentitiesClientDeleteResponse, error)
+}
+
+// federatedIdentityCredentialsClient is the subset of
+// *armmsi.FederatedIdentityCredentialsClient used by rg-cleanup.
+type federatedIdentityCredentialsClient interface {
+	NewListPager(resourceGroupName string, resourceName string, options *armmsi.FederatedIdentityCredentialsClientListOptions) *runtime.Pager[armmsi.FederatedIdentityCredentialsClientListResponse]
+}
+
+// roleAssignmentsQuery returns one role assignment of a principal, whatever
+// its scope. The principal ID is a GUID, which needs no escaping.
+const roleAssignmentsQuery = "authorizationresources | where type =~ 'microsoft.authorization/roleassignments' | where properties.principalId =~ '%s' | project id | take 1"
+
+type managedIdentityClients struct {
+	identities           userAssignedIdentitiesClient
+	federatedCredentials federatedIdentityCredentialsClient
+	// roleAssignments is queried for the role assignments of the identities
+	// in every scope, not only in the subscription: an identity may be
+	// granted roles on a management group or in other subscriptions.
+	roleAssignments resourceGraphClient
+}
+
+func getManagedIdentityClients(cred azcore.TokenCredential, subscriptionID string) (*managedIdentityClients, error) {
+	identities, err := armmsi.NewUserAssignedIdentitiesClient(subscriptionID, cred, getClientOptions())
+	if err != nil {
+		return nil, err
+	}
+	federatedCredentials, err := armmsi.NewFederatedIdentityCredentialsClient(subscriptionID, cred, getClientOptions())
+	if err != nil {
+		return nil, err
+	}
+	roleAssignments, err := getResourceGraphClient(cred)
+	if err != nil {
+		return nil, err
+	}
+	return &managedIdentityClients{
+		identities:           identities,
+		federatedCredentials: federatedCredentials,
+		roleAssignments:      roleAssignments,
+	}, nil
+}
+
+// runManagedIdentityCleanup deletes stale user-assigned managed identities in
+// the subscription, or only in o.managedIdentityResourceGroup when it is set.
+// An identity is only deleted when it has no role assignments and no
+// federated identity credentials. It returns the errors of the identities
+// that failed to be deleted, which do not stop the cleanup.
+func runManagedIdentityCleanup(ctx context.Context, c *managedIdentityClients, o *options) ([]error, error) {
+	slog.Info("Scanning for orphaned managed identities")
+
+	identities, err := listManagedIdentities(ctx, c.identities, o.managedIdentityResourceGroup)
+	if err != nil {
+		return nil, fmt.Errorf("error when iterating managed identities: %v", err)
+	}
+
+	var failed []error
+	for _, identity := range identities {
+		name := *identity.Name
+		age, ok := shouldDeleteManagedIdentity(identity, o)
+		if !ok {
+			continue
+		}
+
+		id, err := arm.ParseResourceID(*identity.ID)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Error when parsing the ID of managed identity '%s'", name), "error", err)
+			continue
+		}
+
+		orphaned, err := isManagedIdentityOrphaned(ctx, c, id.ResourceGroupName, identity)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Error when checking whether managed identity '%s' is in use", name), "error", err)
+			continue
+		}
+		if !orphaned {
+			continue
+		}
+
+		if o.dryRun {
+			slog.Info(fmt.Sprintf("Dry-run: skip deletion of orphaned managed identity '%s' in resource group '%s' (age: %s)", name, id.ResourceGroupName, age), "dry_run", true)
+			continue
+		}
+
+		slog.Info(fmt.Sprintf("Deleting managed identity '%s' in resource group '%s' (age: %s)", name, id.ResourceGroupName, age))
+		if _, err := c.identities.Delete(ctx, id.ResourceGroupName, name, nil); err != nil {
+			slog.Error(fmt.Sprintf("Error when deleting managed identity %s", name), "error", err)
+			failed = append(failed, fmt.Errorf("%s: %v", name, err))
+		}
+	}
+
+	return failed, nil
+}
+
+func listManagedIdentities(ctx context.Context, c userAssignedIdentitiesClient, resourceGroup string) ([]*armmsi.Identity, error) {
+	var identities []*armmsi.Identity
+	if resourceGroup != "" {
+		pager := c.NewListByResourceGroupPager(resourceGroup, nil)
+		for pager.More() {
+			page, err := pager.NextPage(ctx)
+			if err != nil {
+				return nil, err
+			}
+			identities = append(identities, page.Value...)
+		}
+		return identities, nil
+	}
+
+	pager := c.NewListBySubscriptionPager(nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, page.Value...)
+	}
+	return identities, nil
+}
+
+// shouldDeleteManagedIdentity applies the same tag, regex and TTL rules as
+// shouldDeleteResourceGroup. The age comes from the creationTimestamp tag,
+// falling back to the creation time recorded by ARM.
+func shouldDeleteManagedIdentity(identity *armmsi.Identity, o *options) (string, bool) {
+	if cleanup.IsProtected(identity.Tags, o.protectTagValues) {
+		return "", false
+	}
+
+	if o.regex != "" {
+		match, err := cleanup.RegexMatchesResourceGroupName(o.regex, *identity.Name, !o.disableRegexFullMatch)
+		if err != nil {
+			slog.Error("failed to regex managed identity name", "error", err)
+			return "", false
+		}
+		if !match {
+			return "", false
+		}
+	}
+
+	var t time.Time
+	if creationTimestamp, ok := identity.Tags[creationTimestampTag]; ok {
+		if creationTimestamp == nil {
+			slog.Error(fmt.Sprintf("failed to parse timestamp: the '%s' tag has no value", creationTimestampTag))
+			return "", false
+		}
+		var err error
+		t, err = cleanup.ParseCreationTimestamp(*creationTimestamp)
+		if err != nil {
+			slog.Error("failed to parse timestamp", "error", err)
+			return "", false
+		}
+	} else if identity.SystemData != nil && identity.SystemData.CreatedAt != nil {
+		t = *identity.SystemData.CreatedAt
+	} else {
+		return fmt.Sprintf("probably a long time because it does not have a '%s' tag or a creation time", creationTimestampTag), true
+	}
+
+	return cleanup.FormatAge(t), time.Since(t) >= o.ttl
+}
+
+// isManagedIdentityOrphaned reports whether the identity's service principal
+// has no role assignments in any scope and the identity has no federated
+// identity credentials. Role assignments are looked up with Azure Resource
+// Graph across the management groups and subscriptions rg-cleanup can read.
+func isManagedIdentityOrphaned(ctx context.Context, c *managedIdentityClients, resourceGroup string, identity *armmsi.Identity) (bool, error) {
+	if identity.Properties != nil && identity.Properties.PrincipalID != nil {
+		resp, err := c.roleAssignments.Resources(ctx, armresourcegraph.QueryRequest{
+			Query: to.Ptr(fmt.Sprintf(roleAssignmentsQuery, *identity.Properties.PrincipalID)),
+			Options: &armresourcegraph.QueryRequestOptions{
+				AuthorizationScopeFilter: to.Ptr(armresourcegraph.AuthorizationScopeFilterAtScopeAboveAndBelow),
+				ResultFormat:             to.Ptr(armresourcegraph.ResultFormatObjectArray),
+			},
+		}, nil)
+		if err != nil {
+			return false, fmt.Errorf("failed to query role assignments: %v", err)
+		}
+		if resp.TotalRecords == nil || *resp.TotalRecords > 0 {
+			slog.Debug(fmt.Sprintf("Managed identity '%s' still has role assignments", *identity.Name))
+			return false, nil
+		}
+	}
+
+	pager := c.federatedCredentials.NewListPager(resourceGroup, *identity.Name, nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return false, fmt.Errorf("failed to list federated identity credentials: %v", err)
+		}
+		if len(page.Value) > 0 {
+			slog.Debug(fmt.Sprintf("Managed identity '%s' still has federated identity credentials", *identity.Name))
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/msi/armmsi"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resourcegraph/armresourcegraph"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+type fakeIdentitiesClient struct {
+	identities []*armmsi.Identity
+	deleted    []string
+	deleteErr  error
+}
+
+func (c *fakeIdentitiesClient) NewListBySubscriptionPager(*armmsi.UserAssignedIdentitiesClientListBySubscriptionOptions) *runtime.Pager[armmsi.UserAssignedIdentitiesClientListBySubscriptionResponse] {
+	return newStaticPager(armmsi.UserAssignedIdentitiesClientListBySubscriptionResponse{
+		UserAssignedIdentitiesListResult: armmsi.UserAssignedIdentitiesListResult{Value: c.identities},
+	})
+}
+
+func (c *fakeIdentitiesClient) NewListByResourceGroupPager(resourceGroupName string, _ *armmsi.UserAssignedIdentitiesClientListByResourceGroupOptions) *runtime.Pager[armmsi.UserAssignedIdentitiesClientListByResourceGroupResponse] {
+	var identities []*armmsi.Identity
+	for _, identity := range c.identities {
+		if id, _ := arm.ParseResourceID(*identity.ID); id.ResourceGroupName == resourceGroupName {
+			identities = append(identities, identity)
+		}
+	}
+	return newStaticPager(armmsi.UserAssignedIdentitiesClientListByResourceGroupResponse{
+		UserAssignedIdentitiesListResult: armmsi.UserAssignedIdentitiesListResult{Value: identities},
+	})
+}
+
+func (c *fakeIdentitiesClient) Delete(_ context.Context, resourceGroupName string, resourceName string, _ *armmsi.UserAssignedIdentitiesClientDeleteOptions) (armmsi.UserAssignedIdentitiesClientDeleteResponse, error) {
+	if c.deleteErr != nil {
+		return armmsi.UserAssignedIdentitiesClientDeleteResponse{}, c.deleteErr
+	}
+	c.deleted = append(c.deleted, resourceGroupName+"/"+resourceName)
+	return armmsi.UserAssignedIdentitiesClientDeleteResponse{}, nil
+}
+
+// fakeFederatedCredentialsClient maps identity names to their number of
+// federated identity credentials.
+type fakeFederatedCredentialsClient map[string]int
+
+func (c fakeFederatedCredentialsClient) NewListPager(_ string, resourceName string, _ *armmsi.FederatedIdentityCredentialsClientListOptions) *runtime.Pager[armmsi.FederatedIdentityCredentialsClientListResponse] {
+	var credentials []*armmsi.FederatedIdentityCredential
+	for i := 0; i < c[resourceName]; i++ {
+		credentials = append(credentials, &armmsi.FederatedIdentityCredential{Name: to.StringPtr(fmt.Sprintf("fic-%d", i))})
+	}
+	return newStaticPager(armmsi.FederatedIdentityCredentialsClientListResponse{
+		FederatedIdentityCredentialsListResult: armmsi.FederatedIdentityCredentialsListResult{Value: credentials},
+	})
+}
+
+// fakeRoleAssignmentsClient holds the principal IDs that have role
+// assignments, in any scope, and answers Azure Resource Graph queries for
+// them.
+type fakeRoleAssignmentsClient struct {
+	principals map[string]bool
+	err        error
+}
+
+func (c *fakeRoleAssignmentsClient) Resources(_ context.Context, query armresourcegraph.QueryRequest, _ *armresourcegraph.ClientResourcesOptions) (armresourcegraph.ClientResourcesResponse, error) {
+	if c.err != nil {
+		return armresourcegraph.ClientResourcesResponse{}, c.err
+	}
+	if *query.Options.AuthorizationScopeFilter != armresourcegraph.AuthorizationScopeFilterAtScopeAboveAndBelow {
+		return armresourcegraph.ClientResourcesResponse{}, fmt.Errorf("unexpected authorization scope filter %s", *query.Options.AuthorizationScopeFilter)
+	}
+	var rows []any
+	for principalID := range c.principals {
+		if *query.Query == fmt.Sprintf(roleAssignmentsQuery, principalID) {
+			rows = append(rows, map[string]any{"id": "/providers/Microsoft.Management/managementGroups/mg/providers/Microsoft.Authorization/roleAssignments/assignment"})
+		}
+	}
+	total := int64(len(rows))
+	return armresourcegraph.ClientResourcesResponse{QueryResponse: armresourcegraph.QueryResponse{Data: rows, TotalRecords: &total}}, nil
+}
+
+func getIdentity(resourceGroup, name, principalID string, createdAt time.Time, tags map[string]*string) *armmsi.Identity {
+	return &armmsi.Identity{
+		ID:         to.StringPtr(fmt.Sprintf("/subscriptions/sub/resourceGroups/%s/providers/Microsoft.ManagedIdentity/userAssignedIdentities/%s", resourceGroup, name)),
+		Name:       to.StringPtr(name),
+		Tags:       tags,
+		Properties: &armmsi.UserAssignedIdentityProperties{PrincipalID: to.StringPtr(principalID)},
+		SystemData: &armmsi.SystemData{CreatedAt: &createdAt},
+	}
+}
+
+func TestShouldDeleteManagedIdentity(t *testing.T) {
+	oneDayAgo := time.Now().Add(-24 * time.Hour)
+	fourDaysAgo := time.Now().Add(-defaultTTL - 24*time.Hour)
+	testCases := []struct {
+		desc                string
+		identity            *armmsi.Identity
+		regex               string
+		expectedToBeDeleted bool
+		expectedAge         string
+	}{
+		{
+			desc:                "identity created less than 3 days ago",
+			identity:            getIdentity("rg", "id-1", "p-1", oneDayAgo, nil),
+			expectedToBeDeleted: false,
+			expectedAge:         "1 days (24 hours)",
+		},
+		{
+			desc:                "identity created more than 3 days ago",
+			identity:            getIdentity("rg", "id-1", "p-1", fourDaysAgo, nil),
+			expectedToBeDeleted: true,
+			expectedAge:         "4 days (96 hours)",
+		},
+		{
+			desc:                "creationTimestamp tag takes precedence over the ARM creation time",
+			identity:            getIdentity("rg", "id-1", "p-1", fourDaysAgo, map[string]*string{creationTimestampTag: to.StringPtr(oneDayAgo.Format(time.RFC3339))}),
+			expectedToBeDeleted: false,
+			expectedAge:         "1 days (24 hours)",
+		},
+		{
+			desc:                "creationTimestamp tag without a value",
+			identity:            getIdentity("rg", "id-1", "p-1", fourDaysAgo, map[string]*string{creationTimestampTag: nil}),
+			expectedToBeDeleted: false,
+		},
+		{
+			desc:                "identity with a DO-NOT-DELETE tag",
+			identity:            getIdentity("rg", "id-1", "p-1", fourDaysAgo, map[string]*string{doNotDeleteTag: to.StringPtr("")}),
+			expectedToBeDeleted: false,
+		},
+		{
+			desc:                "identity that does not match the regex",
+			identity:            getIdentity("rg", "prod-identity", "p-1", fourDaysAgo, nil),
+			regex:               "^ci-.+$",
+			expectedToBeDeleted: false,
+		},
+		{
+			desc:                "identity that matches the regex",
+			identity:            getIdentity("rg", "ci-identity", "p-1", fourDaysAgo, nil),
+			regex:               "^ci-.+$",
+			expectedToBeDeleted: true,
+			expectedAge:         "4 days (96 hours)",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			age, ok := shouldDeleteManagedIdentity(tc.identity, &options{ttl: defaultTTL, regex: tc.regex})
+			if ok != tc.expectedToBeDeleted {
+				t.Fatalf("expected %t, but got %t", tc.expectedToBeDeleted, ok)
+			}
+			if age != tc.expectedAge {
+				t.Fatalf("expected the identity age to be '%s', but got '%s'", tc.expectedAge, age)
+			}
+		})
+	}
+}
+
+func TestRunManagedIdentityCleanup(t *testing.T) {
+	fourDaysAgo := time.Now().Add(-defaultTTL - 24*time.Hour)
+	newClients := func() *managedIdentityClients {
+		return &managedIdentityClients{
+			identities: &fakeIdentitiesClient{
+				identities: []*armmsi.Identity{
+					getIdentity("identities", "orphaned", "p-1", fourDaysAgo, nil),
+					getIdentity("identities", "has-role-assignment", "p-2", fourDaysAgo, nil),
+					getIdentity("identities", "has-federated-credential", "p-3", fourDaysAgo, nil),
+					getIdentity("identities", "too-young", "p-4", time.Now(), nil),
+					getIdentity("other", "orphaned-elsewhere", "p-5", fourDaysAgo, nil),
+				},
+			},
+			federatedCredentials: fakeFederatedCredentialsClient{"has-federated-credential": 1},
+			roleAssignments:      &fakeRoleAssignmentsClient{principals: map[string]bool{"p-2": true}},
+		}
+	}
+
+	testCases := []struct {
+		desc            string
+		resourceGroup   string
+		dryRun          bool
+		expectedDeleted []string
+	}{
+		{
+			desc:            "subscription-wide cleanup",
+			expectedDeleted: []string{"identities/orphaned", "other/orphaned-elsewhere"},
+		},
+		{
+			desc:            "cleanup scoped to a resource group",
+			resourceGroup:   "identities",
+			expectedDeleted: []string{"identities/orphaned"},
+		},
+		{
+			desc:   "dry-run",
+			dryRun: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			c := newClients()
+			failed, err := runManagedIdentityCleanup(context.Background(), c, &options{ttl: defaultTTL, dryRun: tc.dryRun, managedIdentityResourceGroup: tc.resourceGroup})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(failed) != 0 {
+				t.Fatalf("expected no failed deletions, but got %v", failed)
+			}
+			deleted := c.identities.(*fakeIdentitiesClient).deleted
+			sort.Strings(deleted)
+			if fmt.Sprint(deleted) != fmt.Sprint(tc.expectedDeleted) {
+				t.Fatalf("expected %v to be deleted, but got %v", tc.expectedDeleted, deleted)
+			}
+		})
+	}
+}
+
+func TestRunManagedIdentityCleanupRoleAssignmentError(t *testing.T) {
+	fourDaysAgo := time.Now().Add(-defaultTTL - 24*time.Hour)
+	identities := &fakeIdentitiesClient{
+		identities: []*armmsi.Identity{getIdentity("identities", "orphaned", "p-1", fourDaysAgo, nil)},
+	}
+	c := &managedIdentityClients{
+		identities:           identities,
+		federatedCredentials: fakeFederatedCredentialsClient{},
+		roleAssignments:      &fakeRoleAssignmentsClient{err: errors.New("forbidden")},
+	}
+	if _, err := runManagedIdentityCleanup(context.Background(), c, &options{ttl: defaultTTL}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(identities.deleted) != 0 {
+		t.Fatalf("expected no identities to be deleted when role assignments cannot be listed, but got %v", identities.deleted)
+	}
+}
+
+func TestRunManagedIdentityCleanupDeleteError(t *testing.T) {
+	fourDaysAgo := time.Now().Add(-defaultTTL - 24*time.Hour)
+	c := &managedIdentityClients{
+		identities: &fakeIdentitiesClient{
+			identities: []*armmsi.Identity{
+				getIdentity("identities", "orphaned-1", "p-1", fourDaysAgo, nil),
+				getIdentity("identities", "orphaned-2", "p-2", fourDaysAgo, nil),
+			},
+			deleteErr: errors.New("conflict"),
+		},
+		federatedCredentials: fakeFederatedCredentialsClient{},
+		roleAssignments:      &fakeRoleAssignmentsClient{},
+	}
+	failed, err := runManagedIdentityCleanup(context.Background(), c, &options{ttl: defaultTTL})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "[orphaned-1: conflict orphaned-2: conflict]"
+	if fmt.Sprint(failed) != expected {
+		t.Fatalf("expected failed deletions %s, but got %v", expected, failed)
+	}
+	identitiesErr := &deletionsError{resources: "managed identities", errs: failed}
+	if expected := "failed to delete 2 managed identities: orphaned-1: conflict; orphaned-2: conflict"; identitiesErr.Error() != expected {
+		t.Fatalf("expected '%s', but got '%s'", expected, identitiesErr.Error())
+	}
+}
@@ -0,0 +1,115 @@
+//go:build test
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+)
+
+const simulateLatencyFlag = "simulate-latency"
+
+var simulatedLatency latency
+
+func init() {
+	flag.Var(&simulatedLatency, simulateLatencyFlag, "Add artificial latency to each ARM API call, formatted as 'ms,jitter-ms'.")
+	hiddenFlags[simulateLatencyFlag] = true
+	clientDecorators = append(clientDecorators, func(c resourceGroupsClient) resourceGroupsClient {
+		if simulatedLatency.base == 0 && simulatedLatency.jitter == 0 {
+			return c
+		}
+		return &latencyClient{client: c, latency: simulatedLatency}
+	})
+}
+
+// latency is a flag.Value holding a base delay and a random jitter that is
+// added on top of it.
+type latency struct {
+	base   time.Duration
+	jitter time.Duration
+}
+
+func (l *latency) String() string {
+	return fmt.Sprintf("%d,%d", l.base.Milliseconds(), l.jitter.Milliseconds())
+}
+
+func (l *latency) Set(value string) error {
+	base, jitter, _ := strings.Cut(value, ",")
+	baseMs, err := strconv.Atoi(strings.TrimSpace(base))
+	if err != nil || baseMs < 0 {
+		return fmt.Errorf("invalid latency %q: expected 'ms,jitter-ms'", value)
+	}
+	jitterMs := 0
+	if jitter != "" {
+		jitterMs, err = strconv.Atoi(strings.TrimSpace(jitter))
+		if err != nil || jitterMs < 0 {
+			return fmt.Errorf("invalid latency jitter %q: expected 'ms,jitter-ms'", value)
+		}
+	}
+	l.base = time.Duration(baseMs) * time.Millisecond
+	l.jitter = time.Duration(jitterMs) * time.Millisecond
+	return nil
+}
+
+// delay returns the base latency plus a random amount of jitter.
+func (l latency) delay() time.Duration {
+	if l.jitter <= 0 {
+		return l.base
+	}
+	return l.base + time.Duration(rand.Int63n(int64(l.jitter)+1))
+}
+
+// latencyClient wraps a resourceGroupsClient and sleeps before every call it
+// forwards, including each page fetched through NewListPager.
+type latencyClient struct {
+	client  resourceGroupsClient
+	latency latency
+}
+
+func (c *latencyClient) sleep(ctx context.Context) error {
+	timer := time.NewTimer(c.latency.delay())
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (c *latencyClient) NewListPager(options *armresources.ResourceGroupsClientListOptions) *runtime.Pager[armresources.ResourceGroupsClientListResponse] {
+	pager := c.client.NewListPager(options)
+	return runtime.NewPager(runtime.PagingHandler[armresources.ResourceGroupsClientListResponse]{
+		More: func(armresources.ResourceGroupsClientListResponse) bool {
+			return pager.More()
+		},
+		Fetcher: func(ctx context.Context, _ *armresources.ResourceGroupsClientListResponse) (armresources.ResourceGroupsClientListResponse, error) {
+			if err := c.sleep(ctx); err != nil {
+				return armresources.ResourceGroupsClientListResponse{}, err
+			}
+			return pager.NextPage(ctx)
+		},
+	})
+}
+
+func (c *latencyClient) BeginDelete(ctx context.Context, resourceGroupName string, options *armresources.ResourceGroupsClientBeginDeleteOptions) (*runtime.Poller[armresources.ResourceGroupsClientDeleteResponse], error) {
+	if err := c.sleep(ctx); err != nil {
+		return nil, err
+	}
+	return c.client.BeginDelete(ctx, resourceGroupName, options)
+}
+
+func (c *latencyClient) CreateOrUpdate(ctx context.Context, resourceGroupName string, parameters armresources.ResourceGroup, options *armresources.ResourceGroupsClientCreateOrUpdateOptions) (armresources.ResourceGroupsClientCreateOrUpdateResponse, error) {
+	if err := c.sleep(ctx); err != nil {
+		return armresources.ResourceGroupsClientCreateOrUpdateResponse{}, err
+	}
+	return c.client.CreateOrUpdate(ctx, resourceGroupName, parameters, options)
+}
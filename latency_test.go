@@ -0,0 +1,86 @@
+//go:build test
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+func TestLatencySet(t *testing.T) {
+	testCases := []struct {
+		value          string
+		expectedBase   time.Duration
+		expectedJitter time.Duration
+		expectErr      bool
+	}{
+		{value: "100,20", expectedBase: 100 * time.Millisecond, expectedJitter: 20 * time.Millisecond},
+		{value: "50", expectedBase: 50 * time.Millisecond},
+		{value: "abc", expectErr: true},
+		{value: "10,-1", expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.value, func(t *testing.T) {
+			var l latency
+			err := l.Set(tc.value)
+			if tc.expectErr {
+				if err == nil {
+					t.Fatalf("expected an error for %q", tc.value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if l.base != tc.expectedBase || l.jitter != tc.expectedJitter {
+				t.Fatalf("expected %s,%s, but got %s,%s", tc.expectedBase, tc.expectedJitter, l.base, l.jitter)
+			}
+		})
+	}
+}
+
+func TestLatencyClient(t *testing.T) {
+	inner := &fakeResourceGroupsClient{
+		pages: [][]*armresources.ResourceGroup{
+			{{Name: to.StringPtr("rg-1")}},
+			{{Name: to.StringPtr("rg-2")}},
+		},
+	}
+	c := &latencyClient{client: inner, latency: latency{base: 10 * time.Millisecond}}
+
+	start := time.Now()
+	pager := c.NewListPager(nil)
+	pages := 0
+	for pager.More() {
+		if _, err := pager.NextPage(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		pages++
+	}
+	if _, err := c.BeginDelete(context.Background(), "rg-1", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pages != 2 {
+		t.Fatalf("expected 2 pages, but got %d", pages)
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Fatalf("expected at least 30ms of simulated latency, but took %s", elapsed)
+	}
+	if len(inner.deleted) != 1 || inner.deleted[0] != "rg-1" {
+		t.Fatalf("expected rg-1 to be deleted, but got %v", inner.deleted)
+	}
+}
+
+func TestLatencyClientContextCancelled(t *testing.T) {
+	c := &latencyClient{client: &fakeResourceGroupsClient{}, latency: latency{base: time.Hour}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.BeginDelete(ctx, "rg-1", nil); err == nil {
+		t.Fatal("expected an error when the context is cancelled")
+	}
+}
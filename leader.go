@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/lease"
+)
+
+const (
+	defaultLeaseDuration      = 30 * time.Second
+	defaultLeaseRenewInterval = 10 * time.Second
+	// Azure Storage only accepts lease durations between 15 and 60 seconds,
+	// apart from infinite leases, which would never expire if the leader
+	// died.
+	minLeaseDuration = 15 * time.Second
+	maxLeaseDuration = 60 * time.Second
+	// leaseReleaseTimeout bounds how long releasing the lease may delay
+	// shutting down.
+	leaseReleaseTimeout = 5 * time.Second
+)
+
+// leaseClient is the subset of *lease.BlobClient used by rg-cleanup.
+type leaseClient interface {
+	AcquireLease(ctx context.Context, duration int32, options *lease.BlobAcquireOptions) (lease.BlobAcquireResponse, error)
+	RenewLease(ctx context.Context, options *lease.BlobRenewOptions) (lease.BlobRenewResponse, error)
+	ReleaseLease(ctx context.Context, options *lease.BlobReleaseOptions) (lease.BlobReleaseResponse, error)
+}
+
+// getLeaseClient returns a lease client for the blob at blobURL, and a
+// function that creates the blob, as only existing blobs can be leased. The
+// URL may carry a SAS token; otherwise cred is used.
+func getLeaseClient(cred azcore.TokenCredential, blobURL string) (*lease.BlobClient, func(ctx context.Context) error, error) {
+	options := &blockblob.ClientOptions{ClientOptions: getClientOptions().ClientOptions}
+	var client *blockblob.Client
+	var err error
+	if parts, parseErr := blob.ParseURL(blobURL); parseErr != nil {
+		return nil, nil, fmt.Errorf("invalid lease blob URL: %v", parseErr)
+	} else if parts.SAS.Signature() != "" {
+		client, err = blockblob.NewClientWithNoCredential(blobURL, options)
+	} else {
+		client, err = blockblob.NewClient(blobURL, cred, options)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create lease blob client: %v", err)
+	}
+	leases, err := lease.NewBlobClient(client, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create lease client: %v", err)
+	}
+	createBlob := func(ctx context.Context) error {
+		_, err := client.UploadBuffer(ctx, nil, nil)
+		return err
+	}
+	return leases, createBlob, nil
+}
+
+// leaderElector elects a leader among the replicas of rg-cleanup running
+// with the same --lease-blob-url: the replica holding the lease on the blob
+// leads and runs the cleanup cycles, while the others stand by. A nil
+// *leaderElector always leads.
+type leaderElector struct {
+	client        leaseClient
+	createBlob    func(ctx context.Context) error
+	duration      time.Duration
+	renewInterval time.Duration
+
+	mu      sync.Mutex
+	leading bool
+	// cancelCycle stops the cycle in progress when the lease is lost.
+	cancelCycle context.CancelFunc
+}
+
+func newLeaderElector(client leaseClient, createBlob func(ctx context.Context) error, duration, renewInterval time.Duration) *leaderElector {
+	return &leaderElector{client: client, createBlob: createBlob, duration: duration, renewInterval: renewInterval}
+}
+
+// campaign tries to acquire the lease, then keeps renewing it, every
+// renewInterval until ctx is done.
+func (e *leaderElector) campaign(ctx context.Context) {
+	for {
+		e.tryLead(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(e.renewInterval):
+		}
+	}
+}
+
+// tryLead renews the lease when leading, or tries to acquire it otherwise.
+func (e *leaderElector) tryLead(ctx context.Context) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	// A hung request must not keep the lease past its expiry unnoticed.
+	ctx, cancel := context.WithTimeout(ctx, e.renewInterval)
+	defer cancel()
+	if e.leading {
+		if _, err := e.client.RenewLease(ctx, nil); err != nil {
+			e.leading = false
+			if e.cancelCycle != nil {
+				e.cancelCycle()
+			}
+			slog.Error("Error when renewing the lease, standing by and stopping the cycle in progress", "error", err)
+		}
+		return
+	}
+	_, err := e.client.AcquireLease(ctx, int32(e.duration/time.Second), nil)
+	if bloberror.HasCode(err, bloberror.BlobNotFound) {
+		if err := e.createBlob(ctx); err != nil && !bloberror.HasCode(err, bloberror.BlobAlreadyExists, bloberror.LeaseIDMissing) {
+			slog.Error("Error when creating the lease blob", "error", err)
+			return
+		}
+		_, err = e.client.AcquireLease(ctx, int32(e.duration/time.Second), nil)
+	}
+	switch {
+	case err == nil:
+		e.leading = true
+		slog.Info("Acquired the lease, leading")
+	case bloberror.HasCode(err, bloberror.LeaseAlreadyPresent):
+		slog.Debug("Another instance holds the lease")
+	default:
+		slog.Error("Error when acquiring the lease", "error", err)
+	}
+}
+
+// cycleContext returns a context for a cleanup cycle, which is canceled when
+// the lease is lost, and whether this instance leads. There is no cycle to
+// run when it does not lead.
+func (e *leaderElector) cycleContext(ctx context.Context) (context.Context, context.CancelFunc, bool) {
+	if e == nil {
+		return ctx, func() {}, true
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.leading {
+		return ctx, func() {}, false
+	}
+	cycleCtx, cancel := context.WithCancel(ctx)
+	e.cancelCycle = cancel
+	return cycleCtx, cancel, true
+}
+
+// release gives up the lease, if held, so that a standby replica can take
+// over without waiting for it to expire.
+func (e *leaderElector) release() {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.leading {
+		return
+	}
+	e.leading = false
+	ctx, cancel := context.WithTimeout(context.Background(), leaseReleaseTimeout)
+	defer cancel()
+	if _, err := e.client.ReleaseLease(ctx, nil); err != nil {
+		slog.Error("Error when releasing the lease", "error", err)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/lease"
+)
+
+// fakeLeaseClient fails the calls with the next error of errs, if any.
+type fakeLeaseClient struct {
+	errs  []error
+	calls []string
+}
+
+func (c *fakeLeaseClient) next(call string) error {
+	c.calls = append(c.calls, call)
+	if len(c.errs) == 0 {
+		return nil
+	}
+	err := c.errs[0]
+	c.errs = c.errs[1:]
+	return err
+}
+
+func (c *fakeLeaseClient) AcquireLease(_ context.Context, _ int32, _ *lease.BlobAcquireOptions) (lease.BlobAcquireResponse, error) {
+	return lease.BlobAcquireResponse{}, c.next("AcquireLease")
+}
+
+func (c *fakeLeaseClient) RenewLease(context.Context, *lease.BlobRenewOptions) (lease.BlobRenewResponse, error) {
+	return lease.BlobRenewResponse{}, c.next("RenewLease")
+}
+
+func (c *fakeLeaseClient) ReleaseLease(context.Context, *lease.BlobReleaseOptions) (lease.BlobReleaseResponse, error) {
+	return lease.BlobReleaseResponse{}, c.next("ReleaseLease")
+}
+
+func newStorageError(code bloberror.Code) error {
+	return &azcore.ResponseError{ErrorCode: string(code), StatusCode: http.StatusConflict}
+}
+
+func TestLeaderElector(t *testing.T) {
+	client := &fakeLeaseClient{errs: []error{newStorageError(bloberror.LeaseAlreadyPresent)}}
+	e := newLeaderElector(client, nil, defaultLeaseDuration, defaultLeaseRenewInterval)
+
+	// Another instance holds the lease.
+	e.tryLead(context.Background())
+	if _, _, leading := e.cycleContext(context.Background()); leading {
+		t.Fatal("expected to stand by while another instance holds the lease")
+	}
+
+	// The lease expired and is acquired.
+	e.tryLead(context.Background())
+	cycleCtx, cancel, leading := e.cycleContext(context.Background())
+	defer cancel()
+	if !leading {
+		t.Fatal("expected to lead once the lease is acquired")
+	}
+
+	// The lease is renewed, then lost in the middle of the cycle.
+	e.tryLead(context.Background())
+	if cycleCtx.Err() != nil {
+		t.Fatalf("expected the cycle to go on while the lease is renewed, but got %v", cycleCtx.Err())
+	}
+	client.errs = []error{errors.New("lease lost")}
+	e.tryLead(context.Background())
+	if !errors.Is(cycleCtx.Err(), context.Canceled) {
+		t.Fatalf("expected the cycle to be canceled when the lease is lost, but got %v", cycleCtx.Err())
+	}
+	if _, _, leading := e.cycleContext(context.Background()); leading {
+		t.Fatal("expected to stand by once the lease is lost")
+	}
+
+	// Releasing without the lease does nothing.
+	e.release()
+	expected := []string{"AcquireLease", "AcquireLease", "RenewLease", "RenewLease"}
+	if !reflect.DeepEqual(client.calls, expected) {
+		t.Fatalf("expected calls %v, but got %v", expected, client.calls)
+	}
+}
+
+func TestLeaderElectorCreatesBlob(t *testing.T) {
+	client := &fakeLeaseClient{errs: []error{newStorageError(bloberror.BlobNotFound)}}
+	created := false
+	e := newLeaderElector(client, func(context.Context) error {
+		created = true
+		return nil
+	}, defaultLeaseDuration, defaultLeaseRenewInterval)
+
+	e.tryLead(context.Background())
+	if _, _, leading := e.cycleContext(context.Background()); !created || !leading {
+		t.Fatalf("expected the blob to be created and the lease acquired, but got created %t and leading %t", created, leading)
+	}
+	e.release()
+	expected := []string{"AcquireLease", "AcquireLease", "ReleaseLease"}
+	if !reflect.DeepEqual(client.calls, expected) {
+		t.Fatalf("expected calls %v, but got %v", expected, client.calls)
+	}
+}
+
+func TestNilLeaderElector(t *testing.T) {
+	var e *leaderElector
+	ctx := context.Background()
+	cycleCtx, cancel, leading := e.cycleContext(ctx)
+	defer cancel()
+	if !leading || cycleCtx != ctx {
+		t.Fatal("expected to always lead without --lease-blob-url")
+	}
+	e.release()
+}
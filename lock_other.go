@@ -0,0 +1,12 @@
+//go:build !unix
+
+package main
+
+import "log/slog"
+
+// acquireLock always succeeds: flock(2) is not available on this platform, so
+// overlapping runs are not prevented.
+func acquireLock(path string) (release func(), acquired bool, err error) {
+	slog.Warn("Lock files are not supported on this platform, overlapping runs are not prevented")
+	return func() {}, true, nil
+}
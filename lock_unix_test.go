@@ -0,0 +1,35 @@
+//go:build unix
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestAcquireLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rg-cleanup.lock")
+
+	release, acquired, err := acquireLock(path)
+	if err != nil || !acquired {
+		t.Fatalf("expected to acquire the lock, but got %t, %v", acquired, err)
+	}
+	// flock(2) locks belong to the open file, so a second open in the same
+	// process behaves like another instance.
+	if _, acquired, err := acquireLock(path); err != nil || acquired {
+		t.Fatalf("expected the lock to be held, but got %t, %v", acquired, err)
+	}
+
+	release()
+	release, acquired, err = acquireLock(path)
+	if err != nil || !acquired {
+		t.Fatalf("expected to acquire the released lock, but got %t, %v", acquired, err)
+	}
+	release()
+}
+
+func TestAcquireLockError(t *testing.T) {
+	if _, _, err := acquireLock(filepath.Join(t.TempDir(), "missing", "rg-cleanup.lock")); err == nil {
+		t.Fatalf("expected an error, but got nil")
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+
+	defaultLogLevel = "info"
+)
+
+// newLogger returns a logger writing to w in the given format, which is
+// either "text" or "json", that drops records below level. level is one of
+// "debug", "info", "warn" or "error".
+func newLogger(w io.Writer, format, level string) (*slog.Logger, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("unknown log level '%s', must be 'debug', 'info', 'warn' or 'error'", level)
+	}
+	handlerOptions := &slog.HandlerOptions{Level: l}
+	switch format {
+	case logFormatText:
+		return slog.New(slog.NewTextHandler(w, handlerOptions)), nil
+	case logFormatJSON:
+		return slog.New(slog.NewJSONHandler(w, handlerOptions)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format '%s', must be '%s' or '%s'", format, logFormatText, logFormatJSON)
+	}
+}
+
+type loggerKey struct{}
+
+// withLogger returns a copy of ctx carrying logger, so that helpers deep in a
+// run log with the fields of the resource they are looking at.
+func withLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// loggerFrom returns the logger stored in ctx by withLogger, or the default
+// logger.
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// summaryLogKey is the group holding the counts of the end-of-run summary
+// log line, which --quiet keeps.
+const summaryLogKey = "summary"
+
+// quietHandler drops the records below warn level, except the deletion
+// decisions and the end-of-run summary.
+type quietHandler struct {
+	slog.Handler
+}
+
+func (h quietHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level >= slog.LevelWarn {
+		return h.Handler.Handle(ctx, r)
+	}
+	keep := false
+	r.Attrs(func(a slog.Attr) bool {
+		if (a.Key == "decision" && a.Value.String() == decisionDelete) || a.Key == summaryLogKey {
+			keep = true
+			return false
+		}
+		return true
+	})
+	if !keep {
+		return nil
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h quietHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return quietHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h quietHandler) WithGroup(name string) slog.Handler {
+	return quietHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+func TestNewLogger(t *testing.T) {
+	for _, tc := range []struct {
+		format      string
+		level       string
+		expectedErr bool
+	}{
+		{format: logFormatText},
+		{format: logFormatJSON},
+		{format: "xml", expectedErr: true},
+		{format: logFormatText, level: "debug"},
+		{format: logFormatText, level: "WARN"},
+		{format: logFormatText, level: "verbose", expectedErr: true},
+	} {
+		t.Run(tc.format+"/"+tc.level, func(t *testing.T) {
+			level := tc.level
+			if level == "" {
+				level = defaultLogLevel
+			}
+			_, err := newLogger(&bytes.Buffer{}, tc.format, level)
+			if tc.expectedErr != (err != nil) {
+				t.Fatalf("expected error to be %v, but got %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestRunResourceGroupCleanupStructuredLogs(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, logFormatJSON, "debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := getResourceGroup("old-rg", map[string]*string{
+		creationTimestampTag: to.StringPtr(time.Now().Add(-48 * time.Hour).Format(time.RFC3339)),
+	})
+	old.Location = to.StringPtr("westus2")
+	kept := getResourceGroup("kept-rg", map[string]*string{
+		doNotDeleteTag: to.StringPtr(""),
+	})
+	c := &fakeResourceGroupsClient{pages: [][]*armresources.ResourceGroup{{&old, &kept}}}
+	o := &options{ttl: 24 * time.Hour, dryRun: true}
+
+	if _, err := runResourceGroupCleanup(withLogger(context.Background(), logger), "sub", c, c, fakeResourcesClient{}, o); err != nil {
+		t.Fatal(err)
+	}
+
+	decisions := map[string]map[string]any{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("expected a JSON log line, but got %q: %v", line, err)
+		}
+		if name, ok := entry["rg_name"].(string); ok {
+			if _, ok := entry["decision"]; ok {
+				decisions[name] = entry
+			}
+		}
+	}
+
+	for _, tc := range []struct {
+		name             string
+		expectedDecision string
+		expectedReason   string
+	}{
+		{name: "old-rg", expectedDecision: "delete", expectedReason: "ttl_elapsed"},
+		{name: "kept-rg", expectedDecision: "skip", expectedReason: "protected"},
+	} {
+		entry, ok := decisions[tc.name]
+		if !ok {
+			t.Fatalf("expected a decision to be logged for %s, but got none", tc.name)
+		}
+		if entry["decision"] != tc.expectedDecision || entry["reason"] != tc.expectedReason {
+			t.Fatalf("expected %s to be logged with decision %s and reason %s, but got %v", tc.name, tc.expectedDecision, tc.expectedReason, entry)
+		}
+		if entry["subscription_id"] != "sub" || entry["dry_run"] != true {
+			t.Fatalf("expected subscription_id and dry_run fields on %s, but got %v", tc.name, entry)
+		}
+	}
+	if location := decisions["old-rg"]["location"]; location != "westus2" {
+		t.Fatalf("expected location westus2, but got %v", location)
+	}
+	if age, ok := decisions["old-rg"]["age_hours"].(float64); !ok || age != 48 {
+		t.Fatalf("expected age_hours of 48, but got %v", decisions["old-rg"]["age_hours"])
+	}
+}
+
+func TestRunResourceGroupCleanupLogLevel(t *testing.T) {
+	for _, tc := range []struct {
+		level         string
+		quiet         bool
+		expectedLines int
+	}{
+		// Only the scan and deletion lines; the regex mismatch is debug.
+		{level: "info", expectedLines: 2},
+		{level: "debug", expectedLines: 4},
+		{level: "error", expectedLines: 0},
+		// Only the deletion line.
+		{level: "debug", quiet: true, expectedLines: 1},
+	} {
+		t.Run(fmt.Sprintf("%s/quiet=%t", tc.level, tc.quiet), func(t *testing.T) {
+			var buf bytes.Buffer
+			logger, err := newLogger(&buf, logFormatText, tc.level)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.quiet {
+				logger = slog.New(quietHandler{logger.Handler()})
+			}
+			match := getResourceGroup("kube-1", nil)
+			other := getResourceGroup("other", nil)
+			c := &fakeResourceGroupsClient{pages: [][]*armresources.ResourceGroup{{&match, &other}}}
+			o := &options{ttl: defaultTTL, regex: "kube.+"}
+
+			if _, err := runResourceGroupCleanup(withLogger(context.Background(), logger), "sub", c, c, fakeResourcesClient{}, o); err != nil {
+				t.Fatal(err)
+			}
+			if len(c.deleted) != 1 || c.deleted[0] != "kube-1" {
+				t.Fatalf("expected only kube-1 to be deleted, but got %v", c.deleted)
+			}
+			lines := 0
+			if out := strings.TrimSpace(buf.String()); out != "" {
+				lines = len(strings.Split(out, "\n"))
+			}
+			if lines != tc.expectedLines {
+				t.Fatalf("expected %d log lines, but got %d:\n%s", tc.expectedLines, lines, buf.String())
+			}
+		})
+	}
+}
+
+func TestQuietHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, logFormatText, "info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger = slog.New(quietHandler{logger.Handler()}).With("subscription_id", "sub")
+
+	logger.Info("Scanning for stale resource groups")
+	logger.Info("Skip deletion", "decision", decisionSkip)
+	logger.Info("Beginning to delete", "decision", decisionDelete)
+	logger.Error("Error when deleting", "error", errors.New("forbidden"))
+	logger.Info("rg-cleanup deleted 1 RGs", slog.Group(summaryLogKey, "deleted", 1))
+
+	var messages []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if !strings.Contains(line, "subscription_id=sub") {
+			t.Fatalf("expected the attributes of the logger to be kept, but got %q", line)
+		}
+		messages = append(messages, line[strings.Index(line, "msg="):strings.Index(line, " subscription_id")])
+	}
+	expected := []string{`msg="Beginning to delete"`, `msg="Error when deleting"`, `msg="rg-cleanup deleted 1 RGs"`}
+	if fmt.Sprint(messages) != fmt.Sprint(expected) {
+		t.Fatalf("expected %v to be logged, but got %v", expected, messages)
+	}
+}
@@ -2,64 +2,540 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/http"
 	"os"
-	"regexp"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/chewong/rg-cleanup/pkg/cleanup"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
-	defaultTTL            = 3 * 24 * time.Hour
-	defaultRegex          = ""
-	creationTimestampTag  = "creationTimestamp"
-	doNotDeleteTag        = "DO-NOT-DELETE"
-	aadClientIDEnvVar     = "AAD_CLIENT_ID"
-	aadClientSecretEnvVar = "AAD_CLIENT_SECRET"
-	tenantIDEnvVar        = "TENANT_ID"
-	subscriptionIDEnvVar  = "SUBSCRIPTION_ID"
+	defaultTTL             = 3 * 24 * time.Hour
+	defaultRegex           = ""
+	defaultMaxTagLogLength = 1024
+	creationTimestampTag   = cleanup.CreationTimestampTag
+	doNotDeleteTag         = cleanup.DoNotDeleteTag
+	deletionInProgressTag  = cleanup.DeletionInProgressTag
+	scheduledDeletionTag   = cleanup.ScheduledDeletionTag
+	ttlOverrideTag         = cleanup.TTLOverrideTag
+	defaultCreatedByTag    = cleanup.DefaultCreatedByTag
+	aadClientIDEnvVar      = "AAD_CLIENT_ID"
+	aadClientSecretEnvVar  = "AAD_CLIENT_SECRET"
+	tenantIDEnvVar         = "TENANT_ID"
+	subscriptionIDEnvVar   = "SUBSCRIPTION_ID"
+	defaultPollInterval    = 5 * time.Minute
+	defaultLockFile        = "/tmp/rg-cleanup.lock"
+
+	// Values of --scope-level.
+	scopeLevelResourceGroup   = "resource-group"
+	scopeLevelSubscription    = "subscription"
+	scopeLevelManagementGroup = "management-group"
+
+	// Standard Azure SDK environment variables. They are set by Azure
+	// Resource Manager service connections in Azure Pipelines, used with
+	// --azure-pipelines, and by Azure Workload Identity on AKS, which also
+	// sets AZURE_FEDERATED_TOKEN_FILE.
+	azureClientIDEnvVar             = "AZURE_CLIENT_ID"
+	azureClientSecretEnvVar         = "AZURE_CLIENT_SECRET"
+	azureTenantIDEnvVar             = "AZURE_TENANT_ID"
+	azureSubscriptionIDEnvVar       = "AZURE_SUBSCRIPTION_ID"
+	workloadIdentityTokenFileEnvVar = "AZURE_FEDERATED_TOKEN_FILE"
 )
 
-var rfc3339Layouts = []string{
-	time.RFC3339,
-	time.RFC3339Nano,
-	// The following two layouts are also acceptable
-	// RFC3339 layouts. See:
-	// https://github.com/golang/go/issues/20555#issuecomment-440348440
-	"2006-01-02T15:04:05+0000",
-	"2006-01-02T15:04:05-0000",
-	"2006-01-02T15:04:05-00:00",
-	"2006-01-02T15:04:05+00:00",
+// resourceGroupLister lists the resource groups of a subscription.
+type resourceGroupLister = cleanup.ResourceGroupLister
+
+// resourceGroupDeleter deletes resource groups and updates the tags that
+// rg-cleanup uses to track their deletion.
+type resourceGroupDeleter = cleanup.ResourceGroupDeleter
+
+// resourceGroupsClient is the subset of *armresources.ResourceGroupsClient
+// used by rg-cleanup.
+type resourceGroupsClient interface {
+	resourceGroupLister
+	resourceGroupDeleter
 }
 
+// clientDecorators wrap the resource group client before it is used. Files
+// compiled behind build tags register decorators here from init().
+var clientDecorators []func(resourceGroupsClient) resourceGroupsClient
+
+// hiddenFlags holds the names of flags that are omitted from --help.
+var hiddenFlags = map[string]bool{}
+
 type options struct {
-	clientID       string
-	clientSecret   string
-	tenantID       string
-	subscriptionID string
-	dryRun         bool
-	ttl            time.Duration
-	identity       bool
-	regex          string
+	// command is the subcommand rg-cleanup runs, e.g. commandAll.
+	command string
+
+	clientID     string
+	clientSecret string
+	tenantID     string
+	dryRun       bool
+	ttl          time.Duration
+	identity     bool
+	regex        string
+
+	// federatedTokenFile is the service account token projected by Azure
+	// Workload Identity, exchanged for an Azure AD token.
+	federatedTokenFile string
+	// githubActionsOIDC exchanges a GitHub Actions ID token, requested from
+	// githubOIDC, for an Azure AD token.
+	githubActionsOIDC bool
+	githubOIDC        *githubOIDCTokenSource
+
+	disableRegexFullMatch bool
+
+	subscriptionIDs        []string
+	subscriptionIDsFile    string
+	allAzCLISubscriptions  bool
+	subscriptionNameFilter string
+	scopeLevel             string
+
+	protectTagValues []string
+	minResourceCount int
+	minimumRGsToKeep int
+	maxTagLogLength  int
+	createdBySPs     []string
+	createdByTag     string
+	tagKeyFilter     string
+	hasTags          []string
+	missingTags      []string
+
+	// skipResourceTypes are the resource types that keep a resource group
+	// that contains one.
+	skipResourceTypes []string
+
+	managedIdentities            bool
+	managedIdentityResourceGroup string
+
+	deleteOrphanedSnapshots     bool
+	includeIncrementalSnapshots bool
+
+	classicAdministrators        bool
+	classicAdministratorExcludes []string
+
+	exportICal    string
+	icalLookahead time.Duration
+
+	notifyBeforeDeletion string
+	notifyDaysBefore     int
+	smtpAddress          string
+	smtpFrom             string
+	smtpUsername         string
+	smtpPassword         string
+
+	// evaluateAt, when set, is the time at which resource groups are
+	// evaluated instead of the current time, to find those that will become
+	// eligible for deletion.
+	evaluateAt time.Time
+
+	logFormat string
+	logLevel  string
+	quiet     bool
+	// dryRunCount runs a dry run that only prints the number of resource
+	// groups eligible for deletion to stdout.
+	dryRunCount bool
+
+	pushgatewayURL string
+	statsdAddr     string
+
+	watch        bool
+	pollInterval time.Duration
+	// interval runs rg-cleanup as a daemon, like --watch, repeating the
+	// cleanup every interval with some jitter and re-reading --config before
+	// each cycle. Disabled when 0.
+	interval time.Duration
+	// credentialCheckInterval is how often --watch checks that the
+	// credential can still get a token. Disabled when 0.
+	credentialCheckInterval time.Duration
+
+	serveMetrics   bool
+	metricsAddress string
+	serveAddr      string
+
+	// leaseBlobURL elects a leader among the replicas running with --watch:
+	// only the replica holding a lease on this blob runs cleanup cycles.
+	leaseBlobURL       string
+	leaseDuration      time.Duration
+	leaseRenewInterval time.Duration
+
+	// ignoreDeletionErrors leaves failed deletions out of the errors of the
+	// run. They are still logged, reported and reflected in the exit code.
+	ignoreDeletionErrors bool
+	// useResourceGraph lists resource groups with Azure Resource Graph
+	// instead of ARM.
+	useResourceGraph bool
+	// provisioningStates are the provisioning states of the resource groups
+	// that may be deleted. Any state may be deleted when empty.
+	provisioningStates []string
+
+	sarifOutput string
+	reportFile  string
+
+	// planOut is where a dry run writes the plan of the deletions, which
+	// --apply executes later.
+	planOut   string
+	applyFile string
+	applyPlan *plan
+
+	confirmationEnvName  string
+	confirmationEnvValue string
+	// expectDeletions is the number of resource groups a live run expects
+	// to be eligible for deletion, give or take expectTolerance. Disabled
+	// when nil.
+	expectDeletions *int
+	expectTolerance int
+
+	csvFile   string
+	csvAppend bool
+
+	tagReportOutput string
+
+	githubSummary string
+
+	costTag       string
+	costThreshold float64
+
+	slackWebhookURL string
+	teamsWebhookURL string
+
+	eventWebhookURL    string
+	eventWebhookHeader http.Header
+
+	auditBlobURL  string
+	auditRequired bool
+
+	resultsQueueURL string
+
+	serviceBusConnectionString string
+	serviceBusTopic            string
+
+	enableTelemetry   bool
+	telemetryEndpoint string
+
+	stuckStateFile string
+	stuckAfter     int
+	githubRepo     string
+	githubTokenEnv string
+	githubToken    string
+
+	lockFile string
+
+	progressPages int
+
+	concurrentSubscriptions int
+
+	estimateCost bool
+
+	tagOnDelete bool
+	// gracePeriod is how long a resource group stays tagged as scheduled for
+	// deletion before it is deleted. Disabled when 0.
+	gracePeriod time.Duration
+	// deletionBudgetPerHour is the maximum number of deletions started per
+	// hour across the run. Unlimited when 0.
+	deletionBudgetPerHour int
+	// preDeleteHook is a script run before each deletion, which skips the
+	// resource group when it fails.
+	preDeleteHook        string
+	preDeleteHookTimeout time.Duration
+	// postDeleteHook is a script run once the deletion of a resource group
+	// has started. Its failures are only logged.
+	postDeleteHook        string
+	postDeleteHookTimeout time.Duration
+
+	output       string
+	eventsStdout bool
+
+	monitorDCREndpoint string
+	monitorDCRID       string
+	monitorStream      string
+	// monitorMetricsEndpoint is where the StaleResourceGroupCount custom
+	// metric is written: a regional Azure Monitor endpoint followed by the
+	// ID of the resource the metric belongs to.
+	monitorMetricsEndpoint string
+
+	azurePipelines bool
+
+	createCleanupTagPolicy bool
+	policyEffect           string
+
+	configFile     string
+	validateConfig bool
+
+	version bool
+	// explicit holds the names of the flags given on the command line or
+	// in $RG_CLEANUP_* variables, which take precedence over --config.
+	explicit map[string]bool
+	// subscriptionOverrides holds the options of --config overridden for
+	// single subscriptions, by lowercase subscription ID.
+	subscriptionOverrides map[string]subscriptionConfig
+}
+
+// complete fills in the options that are derived from other options.
+func (o *options) complete() error {
+	if o.configFile != "" {
+		c, err := readConfig(o.configFile)
+		if err != nil {
+			return err
+		}
+		o.applyConfig(c)
+	}
+	if o.interval > 0 {
+		o.watch, o.pollInterval = true, o.interval
+	}
+	if o.dryRunCount {
+		o.dryRun = true
+	}
+	if o.subscriptionIDsFile != "" {
+		ids, err := readSubscriptionIDsFile(o.subscriptionIDsFile)
+		if err != nil {
+			return err
+		}
+		o.subscriptionIDs = append(o.subscriptionIDs, ids...)
+	}
+	if o.allAzCLISubscriptions {
+		ids, err := listAzCLISubscriptionIDs(context.Background())
+		if err != nil {
+			return err
+		}
+		o.subscriptionIDs = append(o.subscriptionIDs, ids...)
+	}
+	if o.applyFile != "" {
+		p, err := readPlan(o.applyFile)
+		if err != nil {
+			return err
+		}
+		o.applyPlan = p
+		if len(o.subscriptionIDs) == 0 {
+			o.subscriptionIDs = p.subscriptionIDs()
+		}
+	}
+	o.subscriptionIDs = dedupe(o.subscriptionIDs)
+	return nil
 }
 
 func (o *options) validate() error {
+	if err := o.validateCredentials(); err != nil {
+		return err
+	}
+	return o.validateSettings()
+}
+
+// validateSettings checks the options that decide what is cleaned up and how,
+// i.e. everything but the credentials. It is all --validate-config checks.
+func (o *options) validateSettings() error {
+	if o.command == commandResourceGroups && (o.managedIdentities || o.classicAdministrators || o.deleteOrphanedSnapshots) {
+		return fmt.Errorf("the %s command only cleans up resource groups, use the %s command to also clean up managed identities, snapshots or classic administrators", commandResourceGroups, commandAll)
+	}
+	if err := o.validateScopeLevel(); err != nil {
+		return err
+	}
+	if err := o.validatePlan(); err != nil {
+		return err
+	}
+	if len(o.subscriptionIDs) == 0 && o.subscriptionNameFilter == "" && o.scopeLevel != scopeLevelManagementGroup {
+		return fmt.Errorf("no subscription IDs: $%s, --subscription-id and --subscription-ids-file are empty", subscriptionIDEnvVar)
+	}
+	effect, err := parsePolicyEffect(o.policyEffect)
+	if err != nil {
+		return err
+	}
+	o.policyEffect = effect
+	switch o.notifyBeforeDeletion {
+	case "":
+	case notifyEmail:
+		if o.smtpAddress == "" || o.smtpFrom == "" {
+			return fmt.Errorf("--notify-before-deletion=%s requires --smtp-server and --smtp-from", notifyEmail)
+		}
+		if o.notifyDaysBefore <= 0 {
+			return fmt.Errorf("--notify-days-before must be positive, got %d", o.notifyDaysBefore)
+		}
+	default:
+		return fmt.Errorf("unsupported --notify-before-deletion '%s', expected '%s'", o.notifyBeforeDeletion, notifyEmail)
+	}
+	if o.monitorDCREndpoint != "" && (o.monitorDCRID == "" || o.monitorStream == "") {
+		return fmt.Errorf("--monitor-dcr-endpoint requires --monitor-dcr-id and --monitor-stream")
+	}
+	for _, missing := range o.missingTags {
+		for _, key := range o.hasTags {
+			if strings.EqualFold(key, missing) {
+				return fmt.Errorf("--has-tag and --missing-tag both name the '%s' tag, so no resource group can be deleted", key)
+			}
+		}
+	}
+	if o.includeIncrementalSnapshots && !o.deleteOrphanedSnapshots {
+		return fmt.Errorf("--include-incremental-snapshots requires --delete-orphaned-snapshots")
+	}
+	if o.stuckAfter < 1 {
+		return fmt.Errorf("--stuck-after must be at least 1, got %d", o.stuckAfter)
+	}
+	if o.githubRepo != "" {
+		if o.stuckStateFile == "" {
+			return fmt.Errorf("--github-repo requires --stuck-state-file")
+		}
+		if owner, name, ok := strings.Cut(o.githubRepo, "/"); !ok || owner == "" || name == "" || strings.Contains(name, "/") {
+			return fmt.Errorf("--github-repo must be owner/name, got '%s'", o.githubRepo)
+		}
+		if o.githubToken == "" {
+			return fmt.Errorf("--github-repo requires a GitHub token in $%s", o.githubTokenEnv)
+		}
+	}
+	if (o.serviceBusConnectionString == "") != (o.serviceBusTopic == "") {
+		return fmt.Errorf("--servicebus-connection-string and --servicebus-topic must be given together")
+	}
+	if o.enableTelemetry && o.telemetryEndpoint == "" {
+		return fmt.Errorf("--enable-telemetry requires --telemetry-endpoint")
+	}
+	if o.auditRequired && o.auditBlobURL == "" {
+		return fmt.Errorf("--audit-required requires --audit-blob-url")
+	}
+	if o.output != "" && o.output != outputTable && o.output != outputJSON {
+		return fmt.Errorf("--output must be '%s' or '%s', got '%s'", outputTable, outputJSON, o.output)
+	}
+	if o.output != "" && o.eventsStdout {
+		return fmt.Errorf("--output and --events-stdout both write to stdout and cannot be combined")
+	}
+	if o.dryRunCount && (o.output != "" || o.eventsStdout) {
+		return fmt.Errorf("--dry-run-count cannot be combined with --output or --events-stdout, which also write to stdout")
+	}
+	if o.dryRunCount && o.watch {
+		return fmt.Errorf("--dry-run-count cannot be combined with --watch or --interval")
+	}
+	if o.concurrentSubscriptions < 1 {
+		return fmt.Errorf("--concurrent-subscriptions must be at least 1, got %d", o.concurrentSubscriptions)
+	}
+	if o.progressPages < 0 {
+		return fmt.Errorf("--progress-pages must not be negative, got %d", o.progressPages)
+	}
+	if o.minimumRGsToKeep < 0 {
+		return fmt.Errorf("--minimum-rgs-to-keep must not be negative, got %d", o.minimumRGsToKeep)
+	}
+	if o.serveMetrics && !o.watch {
+		return fmt.Errorf("--serve-metrics requires --watch")
+	}
+	if o.serveMetrics && o.serveAddr != "" {
+		return fmt.Errorf("--serve-metrics and --serve-addr are mutually exclusive")
+	}
+	if o.interval < 0 {
+		return fmt.Errorf("--interval must not be negative, got %s", o.interval)
+	}
+	if o.interval > 0 && o.explicit["poll-interval"] {
+		return fmt.Errorf("--interval and --poll-interval are mutually exclusive")
+	}
+	if o.watch && o.pollInterval <= 0 {
+		return fmt.Errorf("--poll-interval must be positive, got %s", o.pollInterval)
+	}
+	if err := o.validateLease(); err != nil {
+		return err
+	}
+	if o.gracePeriod < 0 {
+		return fmt.Errorf("--grace-period must not be negative, got %s", o.gracePeriod)
+	}
+	if o.expectTolerance < 0 {
+		return fmt.Errorf("--expect-tolerance must not be negative, got %d", o.expectTolerance)
+	}
+	if o.expectDeletions != nil && o.watch {
+		return fmt.Errorf("--expect-deletions cannot be combined with --watch or --interval, since the number of eligible resource groups changes between cycles")
+	}
+	if err := validateDeleteHook("pre-delete-hook", o.preDeleteHook, o.preDeleteHookTimeout); err != nil {
+		return err
+	}
+	if err := validateDeleteHook("post-delete-hook", o.postDeleteHook, o.postDeleteHookTimeout); err != nil {
+		return err
+	}
+	if o.deletionBudgetPerHour < 0 {
+		return fmt.Errorf("--deletion-budget-per-hour must not be negative, got %d", o.deletionBudgetPerHour)
+	}
+	if o.credentialCheckInterval < 0 {
+		return fmt.Errorf("--credential-check-interval must not be negative, got %s", o.credentialCheckInterval)
+	}
+	return nil
+}
+
+// validatePlan checks the options of --plan-out and --apply.
+func (o *options) validatePlan() error {
+	if o.planOut != "" && !o.dryRun {
+		return fmt.Errorf("--plan-out requires --dry-run")
+	}
+	if o.applyFile == "" {
+		return nil
+	}
+	if o.dryRun {
+		return fmt.Errorf("--apply cannot be combined with --dry-run")
+	}
+	if o.watch {
+		return fmt.Errorf("--apply cannot be combined with --watch or --interval")
+	}
+	if o.applyPlan != nil && len(o.applyPlan.ResourceGroups) == 0 {
+		return fmt.Errorf("the plan '%s' has no resource groups to delete", o.applyFile)
+	}
+	return nil
+}
+
+// validateLease checks the options of --lease-blob-url.
+func (o *options) validateLease() error {
+	if o.leaseBlobURL == "" {
+		return nil
+	}
+	if !o.watch {
+		return fmt.Errorf("--lease-blob-url requires --watch or --interval")
+	}
+	if o.leaseDuration < minLeaseDuration || o.leaseDuration > maxLeaseDuration {
+		return fmt.Errorf("--lease-duration must be between %s and %s, got %s", minLeaseDuration, maxLeaseDuration, o.leaseDuration)
+	}
+	// A renewal starts up to one interval after the last successful one and
+	// may take one more before it fails and the cycle in progress is
+	// stopped, which must happen before the lease expires and a standby
+	// replica takes over.
+	if o.leaseRenewInterval <= 0 || 2*o.leaseRenewInterval >= o.leaseDuration {
+		return fmt.Errorf("--lease-renew-interval must be positive and shorter than half of --lease-duration, got %s", o.leaseRenewInterval)
+	}
+	return nil
+}
+
+func (o *options) validateCredentials() error {
 	if o.clientID == "" {
 		return fmt.Errorf("$%s is empty", aadClientIDEnvVar)
 	}
-	if o.subscriptionID == "" {
-		return fmt.Errorf("$%s is empty", subscriptionIDEnvVar)
+	if o.identity && o.githubActionsOIDC {
+		return fmt.Errorf("--identity and --github-actions-oidc are mutually exclusive")
 	}
 	if o.identity {
 		return nil
 	}
+	if o.githubActionsOIDC {
+		if o.githubOIDC.requestURL == "" || o.githubOIDC.requestToken == "" {
+			return fmt.Errorf("$%s and $%s must be set with --github-actions-oidc, give the job the 'id-token: write' permission", actionsIDTokenRequestURLEnvVar, actionsIDTokenRequestTokenEnvVar)
+		}
+		if o.tenantID == "" {
+			return fmt.Errorf("$%s and $%s are empty", azureTenantIDEnvVar, tenantIDEnvVar)
+		}
+		return nil
+	}
+	if o.federatedTokenFile != "" {
+		if o.tenantID == "" {
+			return fmt.Errorf("$%s and $%s are empty", azureTenantIDEnvVar, tenantIDEnvVar)
+		}
+		return nil
+	}
 	if o.clientSecret == "" {
 		return fmt.Errorf("$%s is empty", aadClientSecretEnvVar)
 	}
@@ -69,136 +545,1312 @@ func (o *options) validate() error {
 	return nil
 }
 
+// validateScopeLevel checks --scope-level and the options it restricts.
+func (o *options) validateScopeLevel() error {
+	switch o.scopeLevel {
+	case scopeLevelSubscription, scopeLevelManagementGroup:
+		return nil
+	case scopeLevelResourceGroup:
+		if o.regex == "" {
+			return fmt.Errorf("--scope-level=%s requires --regex", scopeLevelResourceGroup)
+		}
+		if o.managedIdentities || o.classicAdministrators || o.deleteOrphanedSnapshots {
+			return fmt.Errorf("--scope-level=%s only deletes resource groups and cannot be combined with --managed-identities, --classic-administrators or --delete-orphaned-snapshots", scopeLevelResourceGroup)
+		}
+		for subscriptionID := range o.subscriptionOverrides {
+			if o.forSubscription(subscriptionID).regex == "" {
+				return fmt.Errorf("--scope-level=%s requires --regex, but the config file clears it for subscription %s", scopeLevelResourceGroup, subscriptionID)
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported --scope-level '%s', expected '%s', '%s' or '%s'", o.scopeLevel, scopeLevelResourceGroup, scopeLevelSubscription, scopeLevelManagementGroup)
+	}
+}
+
+// checkConfirmation returns an error if --require-confirmation-env is set and
+// the environment variable does not hold the expected value. Dry runs never
+// need confirmation.
+func (o *options) checkConfirmation() error {
+	if o.confirmationEnvName == "" || o.dryRun {
+		return nil
+	}
+	if value := os.Getenv(o.confirmationEnvName); value != o.confirmationEnvValue {
+		return fmt.Errorf("refusing to delete anything: --require-confirmation-env expects $%s to be '%s', but it is '%s'", o.confirmationEnvName, o.confirmationEnvValue, value)
+	}
+	return nil
+}
+
 func defineOptions() *options {
 	o := options{}
+	command, args, err := parseCommand(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(flag.CommandLine.Output(), err)
+		os.Exit(2)
+	}
+	o.command = command
 	o.clientID = os.Getenv(aadClientIDEnvVar)
 	o.clientSecret = os.Getenv(aadClientSecretEnvVar)
 	o.tenantID = os.Getenv(tenantIDEnvVar)
-	o.subscriptionID = os.Getenv(subscriptionIDEnvVar)
 	flag.BoolVar(&o.dryRun, "dry-run", false, "Set to true if we should run the cleanup tool without deleting the resource groups.")
 	flag.BoolVar(&o.identity, "identity", false, "Set to true if we should user-assigned identity for AUTH")
+	flag.BoolVar(&o.githubActionsOIDC, "github-actions-oidc", false, fmt.Sprintf("Set to true to authenticate in GitHub Actions by exchanging an ID token requested with $%s and $%s for an Azure AD token, without a client secret. The job needs the 'id-token: write' permission.", actionsIDTokenRequestURLEnvVar, actionsIDTokenRequestTokenEnvVar))
 	flag.DurationVar(&o.ttl, "ttl", defaultTTL, "The duration we allow resource groups to live before we consider them to be stale.")
 	flag.StringVar(&o.regex, "regex", defaultRegex, "Only delete resource groups matching regex")
-	flag.Parse()
+	flag.BoolVar(&o.disableRegexFullMatch, "disable-regex-full-match", false, "Set to true to let --regex match any part of a name instead of the whole name. Use anchors to avoid matching more than intended.")
+	flag.Func("subscription-id", fmt.Sprintf("Subscription ID to clean up. Can be repeated. Defaults to $%s.", subscriptionIDEnvVar), func(value string) error {
+		o.subscriptionIDs = append(o.subscriptionIDs, splitCommaList(value)...)
+		return nil
+	})
+	flag.StringVar(&o.subscriptionIDsFile, "subscription-ids-file", "", "Path to a file with one subscription ID per line to clean up, in addition to --subscription-id. Blank lines and lines starting with '#' are ignored.")
+	flag.BoolVar(&o.allAzCLISubscriptions, "all-az-cli-subscriptions", false, "Set to true to also clean up every enabled subscription listed by 'az account list', i.e. those the account logged in to the Azure CLI can access. Requires az in PATH.")
+	flag.StringVar(&o.scopeLevel, "scope-level", scopeLevelSubscription, fmt.Sprintf("What rg-cleanup may touch: '%s' to clean up the given subscriptions, '%s' to clean up every subscription the credential can access, or '%s' to only delete resource groups matching --regex.", scopeLevelSubscription, scopeLevelManagementGroup, scopeLevelResourceGroup))
+	flag.StringVar(&o.subscriptionNameFilter, "subscription-name-filter", "", "Only clean up subscriptions whose display name fully matches this regex. When no subscription IDs are given, all subscriptions the credential can access are considered.")
+	flag.Func("protect-tag-values", fmt.Sprintf("Comma-separated list of values. When set, a '%s' tag only protects a resource group if its comma-separated value contains at least one of them.", doNotDeleteTag), func(value string) error {
+		o.protectTagValues = splitCommaList(value)
+		return nil
+	})
+	flag.Func("created-by-sp", "Only delete resource groups whose --created-by-tag tag matches this service principal object ID. Can be repeated.", func(value string) error {
+		o.createdBySPs = append(o.createdBySPs, splitCommaList(value)...)
+		return nil
+	})
+	flag.StringVar(&o.createdByTag, "created-by-tag", defaultCreatedByTag, "The tag holding the object ID of the principal that created a resource group, used by --created-by-sp.")
+	flag.StringVar(&o.tagKeyFilter, "tag-key-filter", "", "Only delete resource groups that have at least one tag key matching this regex, e.g. '^ci-run-'.")
+	flag.Func("has-tag", "Only delete resource groups that have a tag with this key, whatever its value. Can be repeated to require several tags.", func(value string) error {
+		o.hasTags = append(o.hasTags, value)
+		return nil
+	})
+	flag.Func("missing-tag", "Only delete resource groups that do not have a tag with this key. Can be repeated to require several tags to be missing.", func(value string) error {
+		o.missingTags = append(o.missingTags, value)
+		return nil
+	})
+	flag.IntVar(&o.minResourceCount, "min-resource-count", 0, "Skip deletion of resource groups that contain at least this many resources, regardless of their age. Disabled when 0.")
+	flag.Func("skip-rg-with-resource-type", "Skip deletion of resource groups that contain a resource of this type, e.g. 'Microsoft.KeyVault/vaults', regardless of their age. Can be repeated.", func(value string) error {
+		o.skipResourceTypes = append(o.skipResourceTypes, splitCommaList(value)...)
+		return nil
+	})
+	flag.IntVar(&o.minimumRGsToKeep, "minimum-rgs-to-keep", 0, "Refuse to delete anything in a subscription if the cleanup would leave fewer than this many resource groups in it. Disabled when 0.")
+	flag.IntVar(&o.maxTagLogLength, "max-tag-log-length", defaultMaxTagLogLength, "Truncate the tags logged for each deleted resource group to this many characters. No limit when 0.")
+	if o.command == commandAll {
+		o.defineOtherCleanupFlags()
+	}
+	flag.StringVar(&o.exportICal, "export-ical", "", "Write an iCalendar file to this path with an event for each resource group that becomes eligible for deletion within --ical-lookahead.")
+	flag.DurationVar(&o.icalLookahead, "ical-lookahead", defaultICalLookahead, "How far ahead --export-ical looks for upcoming deletions.")
+	flag.StringVar(&o.notifyBeforeDeletion, "notify-before-deletion", "", fmt.Sprintf("Set to '%s' to warn the contact in the '%s' or '%s' tag of each resource group that becomes eligible for deletion within --notify-days-before.", notifyEmail, ownerEmailTag, contactTag))
+	flag.IntVar(&o.notifyDaysBefore, "notify-days-before", defaultNotifyDaysBefore, "How many days before its deletion --notify-before-deletion warns about a resource group.")
+	flag.StringVar(&o.smtpAddress, "smtp-server", "", "The host:port of the SMTP server used by --notify-before-deletion=email.")
+	flag.StringVar(&o.smtpFrom, "smtp-from", "", "The sender address of the emails sent by --notify-before-deletion=email.")
+	flag.StringVar(&o.logFormat, "log-format", logFormatText, "The log output format, either 'text' or 'json'.")
+	flag.StringVar(&o.logLevel, "log-level", defaultLogLevel, "The minimum level of logs to print: 'debug', 'info', 'warn' or 'error'. Per-resource-group skip decisions are logged at debug.")
+	flag.IntVar(&o.concurrentSubscriptions, "concurrent-subscriptions", 1, "Clean up this many subscriptions at a time. A subscription that fails does not stop the others.")
+	flag.BoolVar(&o.estimateCost, "estimate-cost", false, "Set to true to look up the cost over the last 30 days of each resource group that is deleted, or would be with --dry-run, in Cost Management. Requires the Cost Management Reader role.")
+	flag.BoolVar(&o.tagOnDelete, "tag-on-delete", false, fmt.Sprintf("Set to true to tag resource groups with '%s=<timestamp>' before deleting them, so that other tools can tell they are going away. The tag is removed if the deletion cannot be started.", deletionInProgressTag))
+	flag.DurationVar(&o.gracePeriod, "grace-period", 0, fmt.Sprintf("When set, tag eligible resource groups with '%s=<timestamp>' instead of deleting them, and only delete them in a later run once this duration has passed since, e.g. 24h. Disabled when 0.", scheduledDeletionTag))
+	flag.IntVar(&o.deletionBudgetPerHour, "deletion-budget-per-hour", 0, "Start at most this many resource group deletions per hour across the run, waiting for the budget to be refilled when it is used up. Unlimited when 0.")
+	flag.StringVar(&o.preDeleteHook, "pre-delete-hook", "", "Run this executable, a path without arguments, before deleting each resource group, with $RG_NAME, $SUBSCRIPTION_ID and $RG_AGE_HOURS set. The resource group is skipped if it exits with a non-zero status.")
+	flag.DurationVar(&o.preDeleteHookTimeout, "pre-delete-hook-timeout", defaultDeleteHookTimeout, "How long --pre-delete-hook may run before it is killed and the resource group skipped.")
+	flag.StringVar(&o.postDeleteHook, "post-delete-hook", "", "Run this executable, a path without arguments, once the deletion of each resource group has started, with the same environment variables as --pre-delete-hook. Its failures are logged but do not fail the run.")
+	flag.DurationVar(&o.postDeleteHookTimeout, "post-delete-hook-timeout", defaultDeleteHookTimeout, "How long --post-delete-hook may run before it is killed.")
+	flag.StringVar(&o.output, "output", "", fmt.Sprintf("Print the resource groups that are deleted, or would be with --dry-run, to stdout at the end of the run, either as a '%s' or as a '%s' array. Logs are written to stderr.", outputTable, outputJSON))
+	flag.BoolVar(&o.eventsStdout, "events-stdout", false, fmt.Sprintf("Write a JSON object per line to stdout as the run progresses: %s, %s, %s, %s and %s events. Logs are written to stderr.", streamEventScanStarted, streamEventRGEvaluated, streamEventRGDeleteStarted, streamEventRGDeleteFailed, streamEventRunCompleted))
+	flag.IntVar(&o.progressPages, "progress-pages", defaultProgressPages, "Log the progress of the scan of a subscription every this many pages of resource groups, and at least every 30 seconds. Set to 0 to disable.")
+	flag.BoolVar(&o.dryRunCount, "dry-run-count", false, "Set to true to run a dry run that only prints the number of resource groups eligible for deletion to stdout and logs nothing but errors. Exits with 0 if there are none and 1 otherwise, like grep.")
+	flag.BoolVar(&o.quiet, "quiet", false, "Set to true to only log deletions, errors and the end-of-run summary. Skipped resource groups are still listed in --report-file.")
+	flag.StringVar(&o.pushgatewayURL, "pushgateway-url", "", "Push run metrics for each subscription to the Prometheus Pushgateway at this URL, e.g. http://pushgateway:9091.")
+	flag.StringVar(&o.statsdAddr, "statsd-addr", "", "Send counters and timings in the DogStatsD format over UDP to this address as the run progresses, e.g. localhost:8125.")
+	flag.BoolVar(&o.watch, "watch", false, "Keep running and repeat the cleanup every --poll-interval until SIGTERM, printing the number of stale resource groups after each cycle.")
+	flag.DurationVar(&o.pollInterval, "poll-interval", defaultPollInterval, "How often --watch repeats the cleanup.")
+	flag.DurationVar(&o.interval, "interval", 0, "Keep running like --watch and repeat the cleanup every this long, e.g. 6h, give or take 10% at random, re-reading --config before each cycle. Disabled when 0.")
+	flag.DurationVar(&o.credentialCheckInterval, "credential-check-interval", defaultCredentialCheckInterval, "How often --watch checks that the credential can still get a token, exiting with an error if it cannot. Disabled when 0.")
+	flag.BoolVar(&o.serveMetrics, "serve-metrics", false, "Set to true to serve Prometheus metrics on /metrics, plus /healthz and /readyz, while running with --watch.")
+	flag.StringVar(&o.metricsAddress, "metrics-address", defaultMetricsAddress, "The address --serve-metrics listens on.")
+	flag.StringVar(&o.serveAddr, "serve-addr", "", "Serve /healthz, /readyz, /status and /metrics on this address, e.g. ':8080', with or without --watch.")
+	flag.StringVar(&o.leaseBlobURL, "lease-blob-url", "", "Elect a leader among the replicas running with --watch or --interval by leasing this blob, e.g. 'https://myaccount.blob.core.windows.net/rg-cleanup/leader'. Only the leader runs cleanup cycles. The blob is created if it does not exist, and the URL may carry a SAS token.")
+	flag.DurationVar(&o.leaseDuration, "lease-duration", defaultLeaseDuration, "How long the lease of --lease-blob-url lasts unless renewed, between 15s and 60s. A standby replica takes over at most this long after the leader dies.")
+	flag.DurationVar(&o.leaseRenewInterval, "lease-renew-interval", defaultLeaseRenewInterval, "How often the leader renews the lease of --lease-blob-url, and standby replicas try to acquire it. Must be shorter than half of --lease-duration.")
+	flag.StringVar(&o.sarifOutput, "sarif-output", "", "Write a SARIF 2.1.0 file to this path with a result for each stale resource group, e.g. for GitHub code scanning.")
+	flag.BoolVar(&o.ignoreDeletionErrors, "ignore-deletion-errors", false, "Set to true to not count failed deletions as errors of the subscription and the run. They are still logged, reported and reflected in the exit code.")
+	flag.BoolVar(&o.useResourceGraph, "use-resource-graph", false, "Set to true to list resource groups with their tags through Azure Resource Graph, which is faster for large subscriptions. Its data can lag behind ARM by a few minutes; deletions still go through ARM.")
+	flag.Func("filter-provisioning-state", fmt.Sprintf("Only delete resource groups in this provisioning state. Can be repeated or comma-separated. Defaults to %s.", strings.Join(defaultProvisioningStates, ",")), func(value string) error {
+		o.provisioningStates = append(o.provisioningStates, splitCommaList(value)...)
+		return nil
+	})
+	flag.StringVar(&o.planOut, "plan-out", "", "With --dry-run, write the resource groups that would be deleted to this JSON plan, which --apply executes later.")
+	flag.StringVar(&o.applyFile, "apply", "", "Only delete the resource groups of this plan written by --plan-out, skipping those whose tags changed, that no longer exist or that are no longer eligible. Defaults the subscriptions to those of the plan.")
+	flag.StringVar(&o.reportFile, "report-file", "", "Write a JSON report of the run to this path, with an entry for each scanned resource group. The report is also written when the run fails or is interrupted.")
+	flag.Func("require-confirmation-env", "NAME=VALUE. Refuse to delete anything unless the environment variable NAME is set to VALUE, e.g. 'ENVIRONMENT=staging'. Not checked with --dry-run.", func(value string) error {
+		name, expected, ok := strings.Cut(value, "=")
+		if !ok || name == "" {
+			return fmt.Errorf("expected NAME=VALUE, got '%s'", value)
+		}
+		o.confirmationEnvName, o.confirmationEnvValue = name, expected
+		return nil
+	})
+	flag.Func("expect-deletions", "Refuse to delete anything unless this many resource groups are eligible for deletion, give or take --expect-tolerance, e.g. the count of a reviewed dry run. The actual count and the first eligible resource groups are printed otherwise. Not checked with --dry-run.", func(value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("expected a non-negative number, got '%s'", value)
+		}
+		o.expectDeletions = &n
+		return nil
+	})
+	flag.IntVar(&o.expectTolerance, "expect-tolerance", 0, "How many resource groups the number eligible for deletion may differ from --expect-deletions by.")
+	flag.StringVar(&o.csvFile, "csv-file", "", "Write a CSV file to this path with a row for each deleted resource group, or each resource group that would be deleted with --dry-run.")
+	flag.BoolVar(&o.csvAppend, "csv-append", false, "Set to true to append rows to an existing --csv-file instead of overwriting it. The header is only written to an empty file.")
+	flag.StringVar(&o.tagReportOutput, "tag-report-output", "", "Before cleaning up, write a tab-separated file to this path with a row for each resource group, its tags and whether it would be deleted.")
+	flag.StringVar(&o.githubSummary, "github-summary", os.Getenv(githubStepSummaryEnvVar), fmt.Sprintf("Append a Markdown summary of the run to this file. Defaults to $%s, so it is written automatically in GitHub Actions.", githubStepSummaryEnvVar))
+	flag.Func("skip-if-cost-tag-exceeds", "TAG=AMOUNT. Skip deletion of resource groups whose TAG tag holds a cost in USD greater than AMOUNT, e.g. 'estimated-monthly-cost=500'.", func(value string) error {
+		tag, threshold, err := parseCostThreshold(value)
+		if err != nil {
+			return err
+		}
+		o.costTag, o.costThreshold = tag, threshold
+		return nil
+	})
+	flag.StringVar(&o.slackWebhookURL, "slack-webhook-url", "", fmt.Sprintf("Post a summary of the run to this Slack incoming webhook. Defaults to $%s.", slackWebhookURLEnvVar))
+	flag.BoolVar(&o.azurePipelines, "azure-pipelines", false, fmt.Sprintf("Set to true to read credentials from $%s, $%s, $%s and $%s as set by Azure Pipelines service connections, falling back to the usual variables when they are absent.", azureClientIDEnvVar, azureClientSecretEnvVar, azureTenantIDEnvVar, azureSubscriptionIDEnvVar))
+	flag.BoolVar(&o.createCleanupTagPolicy, "create-cleanup-tag-policy", false, fmt.Sprintf("Instead of cleaning up, create an Azure Policy definition and assignment in each subscription that requires a '%s' tag on new resource groups.", creationTimestampTag))
+	flag.StringVar(&o.policyEffect, "policy-effect", policyEffectAudit, fmt.Sprintf("The effect of the policy created by --create-cleanup-tag-policy, either '%s' or '%s'.", policyEffectAudit, policyEffectDeny))
+	flag.StringVar(&o.teamsWebhookURL, "teams-webhook-url", "", fmt.Sprintf("Post a summary of the run as an Adaptive Card to this Microsoft Teams incoming webhook. Defaults to $%s.", teamsWebhookURLEnvVar))
+	flag.StringVar(&o.eventWebhookURL, "event-webhook-url", "", "POST a JSON event to this URL each time the deletion of a resource group starts or fails.")
+	flag.Func("event-webhook-header", "NAME=VALUE. An HTTP header to send with each --event-webhook-url request, e.g. 'Authorization=Bearer <token>'. Can be repeated.", func(value string) error {
+		name, headerValue, ok := strings.Cut(value, "=")
+		if !ok || name == "" {
+			return fmt.Errorf("expected NAME=VALUE, got '%s'", value)
+		}
+		if o.eventWebhookHeader == nil {
+			o.eventWebhookHeader = http.Header{}
+		}
+		o.eventWebhookHeader.Add(name, headerValue)
+		return nil
+	})
+	flag.StringVar(&o.auditBlobURL, "audit-blob-url", "", "Append an NDJSON audit record for each deletion attempt to a new append blob per run in the Azure Blob Storage container at this URL. The URL may include a SAS token; otherwise the rg-cleanup credential is used.")
+	flag.BoolVar(&o.auditRequired, "audit-required", false, "Set to true to not delete a resource group, and stop the run, when its audit record cannot be written to --audit-blob-url.")
+	flag.StringVar(&o.resultsQueueURL, "results-queue-url", "", "Enqueue JSON messages with the deletions of each run, followed by a message with its summary, to the Azure Storage queue at this URL. The URL may include a SAS token; otherwise the rg-cleanup credential is used.")
+	flag.StringVar(&o.serviceBusConnectionString, "servicebus-connection-string", "", fmt.Sprintf("Publish a JSON message for each deleted resource group to --servicebus-topic in the Azure Service Bus namespace of this connection string. Prefer setting $%s to keep it out of the process list.", flagEnvVar("servicebus-connection-string")))
+	flag.StringVar(&o.serviceBusTopic, "servicebus-topic", "", "The Service Bus topic --servicebus-connection-string publishes to.")
+	flag.BoolVar(&o.enableTelemetry, "enable-telemetry", false, "Set to true to send anonymous usage data to --telemetry-endpoint after each run: the numbers of scanned, deleted and failed resource groups and the scanned resource groups by region, without any name or ID.")
+	flag.StringVar(&o.telemetryEndpoint, "telemetry-endpoint", "", "The URL --enable-telemetry POSTs its JSON payload to.")
+	flag.StringVar(&o.stuckStateFile, "stuck-state-file", "", "Keep track of the resource groups whose deletion failed across runs in this JSON file.")
+	flag.IntVar(&o.stuckAfter, "stuck-after", defaultStuckAfter, "Consider a resource group in --stuck-state-file stuck once its deletion failed in this many runs.")
+	flag.StringVar(&o.githubRepo, "github-repo", "", "Open an issue in this GitHub repository, as owner/name, for each stuck resource group, and close it once the resource group is gone. Requires --stuck-state-file.")
+	flag.StringVar(&o.githubTokenEnv, "github-token-env", defaultGitHubTokenEnv, "The environment variable holding the GitHub token used by --github-repo.")
+	flag.StringVar(&o.lockFile, "lock-file", defaultLockFile, "Take an exclusive lock on this file for the duration of the run, and exit if another instance holds it. Set to '' to disable.")
+	flag.StringVar(&o.monitorDCREndpoint, "monitor-dcr-endpoint", "", "Send the decision for each resource group and the run summary to Azure Monitor through the Logs Ingestion API at this data collection endpoint, e.g. 'https://my-dce.westus2-1.ingest.monitor.azure.com'. Requires --monitor-dcr-id and --monitor-stream.")
+	flag.StringVar(&o.monitorDCRID, "monitor-dcr-id", "", "The immutable ID of the data collection rule used by --monitor-dcr-endpoint, e.g. 'dcr-00000000000000000000000000000000'.")
+	flag.StringVar(&o.monitorStream, "monitor-stream", "", "The name of the data collection rule stream used by --monitor-dcr-endpoint, e.g. 'Custom-RgCleanup_CL'.")
+	flag.StringVar(&o.monitorMetricsEndpoint, "azure-monitor-workspace-endpoint", "", "After each scan, write the number of stale resource groups of each subscription as the StaleResourceGroupCount custom metric to Azure Monitor at this endpoint: the regional metrics endpoint followed by the ID of the resource to attach the metric to, e.g. 'https://westus2.monitoring.azure.com/subscriptions/<id>/resourceGroups/<rg>/providers/Microsoft.OperationalInsights/workspaces/<name>'.")
+	flag.StringVar(&o.configFile, "config", "", "Read options from this YAML file, whose keys are the names of the flags, plus per-subscription overrides under 'subscriptions'. Environment variables and flags take precedence over it.")
+	flag.BoolVar(&o.version, "version", false, "Print the version, git commit, build date and Go version of rg-cleanup and exit.")
+	flag.BoolVar(&o.validateConfig, "validate-config", false, "Set to true to check the options, including --config, and print the effective configuration as YAML, or as JSON with --output json, without contacting Azure.")
+	flag.Usage = usage
+	flag.CommandLine.Parse(args)
+	o.explicit = map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		o.explicit[f.Name] = true
+	})
+	if err := setFlagsFromEnv(flag.CommandLine, o.explicit, os.Getenv); err != nil {
+		// Exit like flag.Parse does for an invalid flag.
+		fmt.Fprintln(flag.CommandLine.Output(), err)
+		os.Exit(2)
+	}
+	if !o.explicit["filter-provisioning-state"] {
+		o.provisioningStates = defaultProvisioningStates
+	}
+	if o.azurePipelines {
+		o.loadAzurePipelinesEnv()
+	}
+	if o.githubActionsOIDC {
+		o.loadGitHubActionsOIDCEnv()
+	} else if !o.identity {
+		o.loadWorkloadIdentityEnv()
+	}
+	if len(o.subscriptionIDs) == 0 {
+		o.subscriptionIDs = splitCommaList(os.Getenv(subscriptionIDEnvVar))
+	}
+	// Read here rather than as the flag defaults so that --help does not
+	// print the secret webhook URLs.
+	if o.slackWebhookURL == "" {
+		o.slackWebhookURL = os.Getenv(slackWebhookURLEnvVar)
+	}
+	if o.teamsWebhookURL == "" {
+		o.teamsWebhookURL = os.Getenv(teamsWebhookURLEnvVar)
+	}
+	o.smtpUsername = os.Getenv(smtpUsernameEnvVar)
+	o.smtpPassword = os.Getenv(smtpPasswordEnvVar)
+	if o.githubRepo != "" {
+		o.githubToken = os.Getenv(o.githubTokenEnv)
+	}
 	return &o
 }
 
+// defineOtherCleanupFlags defines the flags of the cleanups of other resources
+// than resource groups, which the resource-groups command does not have.
+func (o *options) defineOtherCleanupFlags() {
+	flag.BoolVar(&o.managedIdentities, "managed-identities", false, "Set to true if we should also delete stale user-assigned managed identities that have no role assignments or federated credentials.")
+	flag.StringVar(&o.managedIdentityResourceGroup, "managed-identity-resource-group", "", "Only clean up managed identities in this resource group. Defaults to the whole subscription.")
+	flag.BoolVar(&o.deleteOrphanedSnapshots, "delete-orphaned-snapshots", false, "Set to true if we should also delete full disk snapshots older than the TTL.")
+	flag.BoolVar(&o.includeIncrementalSnapshots, "include-incremental-snapshots", false, "Also delete incremental snapshots with --delete-orphaned-snapshots.")
+	flag.BoolVar(&o.classicAdministrators, "classic-administrators", false, "Set to true if we should also remove classic co-administrators whose account no longer exists.")
+	flag.Func("classic-administrator-exclude", "Email address of a classic administrator that should never be removed. Can be repeated or comma-separated.", func(value string) error {
+		o.classicAdministratorExcludes = append(o.classicAdministratorExcludes, splitCommaList(value)...)
+		return nil
+	})
+}
+
+// loadAzurePipelinesEnv overrides the credentials read from the usual
+// environment variables with the ones set by an Azure Pipelines service
+// connection, when they are present.
+func (o *options) loadAzurePipelinesEnv() {
+	if v := os.Getenv(azureClientIDEnvVar); v != "" {
+		o.clientID = v
+	}
+	if v := os.Getenv(azureClientSecretEnvVar); v != "" {
+		o.clientSecret = v
+	}
+	if v := os.Getenv(azureTenantIDEnvVar); v != "" {
+		o.tenantID = v
+	}
+	if len(o.subscriptionIDs) == 0 {
+		o.subscriptionIDs = splitCommaList(os.Getenv(azureSubscriptionIDEnvVar))
+	}
+}
+
+// loadGitHubActionsOIDCEnv reads the ID token request URL and token set by
+// GitHub Actions, and the client and tenant IDs from $AZURE_CLIENT_ID and
+// $AZURE_TENANT_ID when they are present, like the azure/login action.
+func (o *options) loadGitHubActionsOIDCEnv() {
+	o.githubOIDC = &githubOIDCTokenSource{
+		requestURL:   os.Getenv(actionsIDTokenRequestURLEnvVar),
+		requestToken: os.Getenv(actionsIDTokenRequestTokenEnvVar),
+	}
+	if v := os.Getenv(azureClientIDEnvVar); v != "" {
+		o.clientID = v
+	}
+	if v := os.Getenv(azureTenantIDEnvVar); v != "" {
+		o.tenantID = v
+	}
+}
+
+// loadWorkloadIdentityEnv switches to the environment variables injected by
+// Azure Workload Identity when $AZURE_FEDERATED_TOKEN_FILE is set, so that
+// rg-cleanup works in a pod using workload identity without extra flags.
+func (o *options) loadWorkloadIdentityEnv() {
+	tokenFile := os.Getenv(workloadIdentityTokenFileEnvVar)
+	if tokenFile == "" {
+		return
+	}
+	o.federatedTokenFile = tokenFile
+	if v := os.Getenv(azureClientIDEnvVar); v != "" {
+		o.clientID = v
+	}
+	if v := os.Getenv(azureTenantIDEnvVar); v != "" {
+		o.tenantID = v
+	}
+}
+
+// usage prints the default help text, minus any flags listed in hiddenFlags.
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [%s|%s] [flags]\n\n", os.Args[0], commandResourceGroups, commandAll)
+	fmt.Fprintf(out, "'%s' only cleans up resource groups. '%s', the default, can also clean up managed identities, snapshots and classic administrators.\n\nFlags:\n", commandResourceGroups, commandAll)
+	visible := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	visible.SetOutput(out)
+	flag.VisitAll(func(f *flag.Flag) {
+		if !hiddenFlags[f.Name] {
+			visible.Var(f.Value, f.Name, f.Usage)
+			visible.Lookup(f.Name).DefValue = f.DefValue
+		}
+	})
+	visible.PrintDefaults()
+}
+
 func main() {
-	log.Println("Initializing rg-cleanup")
+	os.Exit(run())
+}
 
+// run runs rg-cleanup and returns its exit code.
+func run() int {
 	o := defineOptions()
+	if o.version {
+		fmt.Print(currentBuildInfo())
+		return exitCodeSuccess
+	}
+	logLevel := o.logLevel
+	if o.dryRunCount {
+		// Only errors are logged, so that scripts can capture the count.
+		logLevel = "error"
+	}
+	logger, err := newLogger(os.Stderr, o.logFormat, logLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCodeFatal
+	}
+	if o.quiet {
+		logger = slog.New(quietHandler{logger.Handler()})
+	}
+	slog.SetDefault(logger)
+
+	build := currentBuildInfo()
+	slog.Info(fmt.Sprintf("Initializing rg-cleanup %s", build.Version), "version", build.Version, "git_commit", build.GitCommit, "build_date", build.BuildDate, "go_version", build.GoVersion)
+	// base is reloaded with the config file before each --interval cycle.
+	base := *o
+	if err := o.complete(); err != nil {
+		slog.Error("Error when completing options", "error", err)
+		return exitCodeFatal
+	}
+	if o.validateConfig {
+		if err := o.validateSettings(); err != nil {
+			slog.Error("Error when validating options", "error", err)
+			return exitCodeFatal
+		}
+		if o.output == outputJSON {
+			err = printRunConfig(os.Stdout, o)
+		} else {
+			err = printEffectiveConfig(os.Stdout, o)
+		}
+		if err != nil {
+			slog.Error("Error when printing the effective configuration", "error", err)
+			return exitCodeFatal
+		}
+		return exitCodeSuccess
+	}
 	if err := o.validate(); err != nil {
-		log.Printf("Error when validating options: %v", err)
-		panic(err)
+		slog.Error("Error when validating options", "error", err)
+		return exitCodeFatal
+	}
+
+	if o.lockFile != "" {
+		release, acquired, err := acquireLock(o.lockFile)
+		if err != nil {
+			slog.Error("Error when acquiring the lock file", "error", err)
+			return exitCodeFatal
+		}
+		if !acquired {
+			slog.Info(fmt.Sprintf("Lock file '%s' is held: another instance is running", o.lockFile))
+			return exitCodeSuccess
+		}
+		defer release()
 	}
 
 	if o.dryRun {
-		log.Println("Dry-run enabled - printing logs but not actually deleting resource groups")
+		slog.Info("Dry-run enabled - printing logs but not actually deleting resource groups")
 	}
 
-	r, err := getResourceGroupClient(o.clientID, o.clientSecret, o.tenantID, o.subscriptionID, o.identity)
+	cred, err := getCredential(o.clientID, o.clientSecret, o.tenantID, o.identity, o.federatedTokenFile, o.githubOIDC)
 	if err != nil {
-		log.Printf("Error when obtaining resource group client: %v", err)
-		panic(err)
+		slog.Error("Error when obtaining credential", "error", err)
+		return exitCodeFatal
+	}
+	if err := checkCredentialHealth(context.Background(), cred); err != nil {
+		slog.Error("Error when checking the credential", "error", err)
+		return exitCodeFatal
 	}
 
-	if err := run(context.Background(), r, o.ttl, o.dryRun, o.regex); err != nil {
-		log.Printf("Error when running rg-cleanup: %v", err)
-		panic(err)
+	subscriptions, err := getSubscriptionsClient(cred)
+	if err != nil {
+		slog.Error("Error when obtaining subscriptions client", "error", err)
+		return exitCodeFatal
 	}
-}
+	if err := o.resolve(context.Background(), subscriptions); err != nil {
+		slog.Error("Error when resolving options", "error", err)
+		return exitCodeFatal
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := setupTracing(ctx)
+	if err != nil {
+		slog.Error("Error when setting up tracing", "error", err)
+		return exitCodeFatal
+	}
+	defer func() {
+		// Flush the spans even when ctx was cancelled by a signal.
+		if err := shutdownTracing(context.Background()); err != nil {
+			slog.Error("Error when flushing traces", "error", err)
+		}
+	}()
 
-func run(ctx context.Context, r *armresources.ResourceGroupsClient, ttl time.Duration, dryRun bool, regex string) error {
-	log.Println("Scanning for stale resource groups")
+	if o.createCleanupTagPolicy {
+		for _, subscriptionID := range o.subscriptionIDs {
+			definitions, assignments, err := getPolicyClients(cred, subscriptionID)
+			if err != nil {
+				slog.Error("Error when obtaining policy clients", "error", err)
+				return exitCodeFatal
+			}
+			slog.Info(fmt.Sprintf("Creating the '%s' policy with effect %s in subscription %s", cleanupTagPolicyName, o.policyEffect, subscriptionID), "subscription_id", subscriptionID)
+			if err := createCleanupTagPolicy(ctx, definitions, assignments, subscriptionID, o.policyEffect); err != nil {
+				slog.Error("Error when creating the cleanup tag policy", "subscription_id", subscriptionID, "error", err)
+				return exitCodeFatal
+			}
+		}
+		return exitCodeSuccess
+	}
 
-	pager := r.NewListPager(nil)
-	for pager.More() {
-		nextResult, err := pager.NextPage(ctx)
+	if o.eventsStdout {
+		ctx = withEventStream(ctx, newEventStream(os.Stdout))
+	}
+	statsd := newStatsdClient(o.statsdAddr)
+	defer statsd.close()
+	ctx = withStatsdClient(ctx, statsd)
+	if o.serviceBusConnectionString != "" {
+		publisher, closePublisher, err := newServiceBusPublisher(o.serviceBusConnectionString, o.serviceBusTopic)
 		if err != nil {
-			return fmt.Errorf("error when iterating resource groups: %v", err)
+			slog.Error("Error when connecting to Service Bus", "error", err)
+			return exitCodeFatal
 		}
-		for _, rg := range nextResult.Value {
-			rgName := *rg.Name
-			if age, ok := shouldDeleteResourceGroup(rg, ttl, regex); ok {
-				if dryRun {
-					log.Printf("Dry-run: skip deletion of eligible resource group '%s' (age: %s)", rgName, age)
-					continue
+		defer closePublisher()
+		ctx = withServiceBusPublisher(ctx, publisher)
+	}
+	if o.notifyBeforeDeletion == notifyEmail {
+		ctx = withMailer(ctx, newMailer(o))
+	}
+	if o.deletionBudgetPerHour > 0 {
+		// The budget outlives a cycle in --watch mode.
+		ctx = withDeletionBudget(ctx, newDeletionBudget(o.deletionBudgetPerHour))
+	}
+	if o.estimateCost {
+		// The estimator outlives a cycle in --watch mode to cache the costs.
+		ctx = withCostEstimator(ctx, newCostEstimator(cred))
+	}
+
+	var server *metricsServer
+	if o.serveMetrics || o.serveAddr != "" {
+		address := o.metricsAddress
+		if o.serveAddr != "" {
+			address = o.serveAddr
+		}
+		server = newMetricsServer()
+		if o.watch {
+			server.maxCycleAge = maxCycleAgeFactor * o.pollInterval
+		} else {
+			// The credential has been checked, and there is no cycle to
+			// wait for.
+			server.ready.Store(true)
+		}
+		var stopServing func()
+		ctx, stopServing = server.serveInBackground(ctx, address)
+		defer stopServing()
+	}
+
+	if !o.watch {
+		result, err := runCleanup(ctx, cred, o, server)
+		if err != nil {
+			slog.Error("Error when running rg-cleanup", "error", err)
+			// A run whose only errors are failed deletions still has a
+			// result, and exits with exitCodeDeletionsFailed.
+			if result == nil {
+				return exitCodeFatal
+			}
+		}
+		summary := newRunSummary(result, o.subscriptionIDs, o.dryRun)
+		if o.dryRunCount {
+			fmt.Println(summary.Eligible)
+			return dryRunCountExitCode(summary.Eligible)
+		}
+		return computeExitCode(summary)
+	}
+
+	var elector *leaderElector
+	if o.leaseBlobURL != "" {
+		client, createBlob, err := getLeaseClient(cred, o.leaseBlobURL)
+		if err != nil {
+			slog.Error("Error when obtaining lease client", "error", err)
+			return exitCodeFatal
+		}
+		elector = newLeaderElector(client, createBlob, o.leaseDuration, o.leaseRenewInterval)
+		go elector.campaign(ctx)
+		defer elector.release()
+	}
+
+	var credentialErrs <-chan error
+	if o.credentialCheckInterval > 0 {
+		credentialErrs = watchCredentialHealth(ctx, cred, o.credentialCheckInterval)
+	}
+
+	if o.interval > 0 {
+		slog.Info(fmt.Sprintf("Watching for stale resource groups every %s, give or take %d%%", o.interval, int(intervalJitter*100)))
+	} else {
+		slog.Info(fmt.Sprintf("Watching for stale resource groups every %s", o.pollInterval))
+	}
+	standby := false
+	for cycle := 0; ; cycle++ {
+		cycleCtx, cancelCycle, leading := elector.cycleContext(ctx)
+		server.setStandby(!leading)
+		delay := o.nextCycleDelay()
+		if !leading {
+			if !standby {
+				slog.Info("Standing by while another instance holds the lease")
+			}
+			// Check again soon, to take over as soon as the lease expires.
+			delay = o.leaseRenewInterval
+		} else {
+			if cycle > 0 && o.interval > 0 && o.configFile != "" {
+				reloaded, err := reloadOptions(cycleCtx, base, subscriptions)
+				if err != nil {
+					slog.Error("Error when reloading the config file, keeping the previous options", "error", err)
+				} else {
+					o = reloaded
 				}
+			}
+			result, err := runCleanup(cycleCtx, cred, o, server)
+			if err != nil {
+				slog.Error("Error when running rg-cleanup", "error", err)
+			} else {
+				slog.Info(fmt.Sprintf("Watch: %d of %d resource groups are stale", result.eligible, result.scanned), "stale", result.eligible, "total", result.scanned)
+			}
+		}
+		cancelCycle()
+		standby = !leading
+		select {
+		case <-ctx.Done():
+			if errors.Is(context.Cause(ctx), errServerFailed) {
+				slog.Error("Stopping watch because the metrics server failed")
+				return exitCodeFatal
+			}
+			slog.Info("Received a termination signal, stopping watch")
+			return exitCodeSuccess
+		case err := <-credentialErrs:
+			slog.Error("Error when checking the credential, stopping watch", "error", err)
+			return exitCodeFatal
+		case <-time.After(delay):
+		}
+	}
+}
 
-				// Start the delete without waiting for it to complete.
-				log.Printf("Beginning to delete resource group '%s' (age: %s)", rgName, age)
-				_, err = r.BeginDelete(ctx, rgName, nil)
+// runCleanup runs one cleanup pass over every subscription in o and returns
+// the combined result. The metrics of server are updated when it is not nil.
+func runCleanup(ctx context.Context, cred azcore.TokenCredential, o *options, server *metricsServer) (_ *runResult, err error) {
+	ctx, span := tracer().Start(ctx, "cleanup run", trace.WithAttributes(
+		attribute.Bool("dry_run", o.dryRun),
+		attribute.Int("subscriptions", len(o.subscriptionIDs)),
+	))
+	total := &runResult{skipped: map[string]int{}}
+	if statsd := statsdClientFrom(ctx); statsd != nil {
+		start := time.Now()
+		defer func() {
+			statsd.timing("run.duration", time.Since(start), "dry_run:"+strconv.FormatBool(o.dryRun), "success:"+strconv.FormatBool(err == nil))
+		}()
+	}
+	if stream := eventStreamFrom(ctx); stream != nil {
+		defer func() {
+			event := newStreamEvent(streamEventRunCompleted, "")
+			event.Summary = newRunSummary(total, o.subscriptionIDs, o.dryRun)
+			if err != nil {
+				event.Error = err.Error()
+			}
+			stream.send(event)
+		}()
+	}
+	defer func() {
+		span.SetAttributes(
+			attribute.Int("rgs_scanned", total.scanned),
+			attribute.Int("rgs_deleted", total.deleted),
+			attribute.Int("rgs_failed", total.failed),
+		)
+		endSpan(span, err)
+	}()
+	if o.reportFile != "" {
+		// Write the report even when the run fails or is interrupted.
+		start := time.Now()
+		defer func() {
+			slog.Info(fmt.Sprintf("Writing the run report to '%s'", o.reportFile))
+			if reportErr := writeReport(o.reportFile, newReport(o, total, start, time.Now(), err)); reportErr != nil {
+				slog.Error("Error when writing the run report", "error", reportErr)
+				if err == nil {
+					err = reportErr
+				}
+			}
+		}()
+	}
+	if o.auditBlobURL != "" {
+		c, err := getAuditBlobClient(cred, o.auditBlobURL, time.Now())
+		if err != nil {
+			return nil, fmt.Errorf("error when obtaining audit blob client: %v", err)
+		}
+		ctx = withAuditLog(ctx, newAuditLog(c, o.clientID, o.auditRequired))
+	}
+	if o.eventWebhookURL != "" {
+		events := newEventSender(ctx, o.eventWebhookURL, o.eventWebhookHeader)
+		defer events.close()
+		ctx = withEventSender(ctx, events)
+	}
+	if !o.dryRun {
+		// The object ID is only needed to explain permission errors, so a
+		// failure is not fatal.
+		if objectID, err := principalObjectID(ctx, cred); err != nil {
+			slog.Debug("Error when reading the principal object ID", "error", err)
+		} else {
+			ctx = withPrincipalObjectID(ctx, objectID)
+		}
+	}
+	if o.tagReportOutput != "" {
+		if err := writeTagReport(ctx, cred, o); err != nil {
+			return nil, fmt.Errorf("error when writing tag report: %v", err)
+		}
+	}
+	if o.expectDeletions != nil && !o.dryRun {
+		if err := checkExpectedDeletions(ctx, cred, o); err != nil {
+			return nil, err
+		}
+	}
+	var mu sync.Mutex
+	errs := forEachSubscription(ctx, o.subscriptionIDs, o.concurrentSubscriptions, func(ctx context.Context, subscriptionID string) error {
+		result := &runResult{skipped: map[string]int{}}
+		err := cleanupSubscription(ctx, cred, subscriptionID, o.forSubscription(subscriptionID), server, result)
+		mu.Lock()
+		defer mu.Unlock()
+		total.add(result)
+		return err
+	})
+	// deletionsErr is returned once the run is summarized, since failed
+	// deletions do not stop it.
+	var deletionsErr error
+	if len(errs) > 0 {
+		total.subscriptionErrors = map[string]string{}
+		for subscriptionID, err := range errs {
+			slog.Error("Error when cleaning up subscription", "subscription_id", subscriptionID, "error", err)
+			total.subscriptionErrors[subscriptionID] = err.Error()
+		}
+		if !onlyDeletionsErrors(errs) {
+			return nil, subscriptionsError(errs)
+		}
+		deletionsErr = subscriptionsError(errs)
+	}
+
+	if e := costEstimatorFrom(ctx); e != nil {
+		e.annotate(ctx, total.resourceGroups, time.Now())
+	}
+	if o.planOut != "" {
+		p := newPlan(total.resourceGroups, time.Now())
+		slog.Info(fmt.Sprintf("Writing the plan to delete %d resource groups to '%s'", len(p.ResourceGroups), o.planOut))
+		if err := writePlan(o.planOut, p); err != nil {
+			return nil, fmt.Errorf("error when writing the plan: %v", err)
+		}
+	}
+	if o.exportICal != "" {
+		slog.Info(fmt.Sprintf("Writing %d upcoming deletions to '%s'", len(total.upcoming), o.exportICal))
+		if err := writeICal(o.exportICal, total.upcoming, time.Now()); err != nil {
+			return nil, fmt.Errorf("error when writing iCal file: %v", err)
+		}
+	}
+	if o.sarifOutput != "" {
+		if err := writeSARIF(o.sarifOutput, total.resourceGroups); err != nil {
+			return nil, fmt.Errorf("error when writing SARIF file: %v", err)
+		}
+	}
+	if o.csvFile != "" {
+		if err := writeCSV(o.csvFile, total.resourceGroups, o.csvAppend, time.Now()); err != nil {
+			return nil, fmt.Errorf("error when writing CSV file: %v", err)
+		}
+	}
+	if o.githubSummary != "" {
+		if err := writeGitHubSummary(o.githubSummary, total, o.dryRun); err != nil {
+			return nil, fmt.Errorf("error when writing GitHub step summary: %v", err)
+		}
+	}
+	if o.output != "" {
+		if err := writeOutput(os.Stdout, o.output, total.resourceGroups); err != nil {
+			return nil, fmt.Errorf("error when writing the output: %v", err)
+		}
+	}
+	summary := newRunSummary(total, o.subscriptionIDs, o.dryRun)
+	slog.Info(summary.headline(), slog.Group(summaryLogKey, "scanned", summary.Scanned, "eligible", summary.Eligible, "deleted", summary.Deleted, "failed", summary.Failed, "skipped", summary.Skipped, "skipped_by_reason", summary.SkippedByReason))
+	if o.logFormat == logFormatText && summary.Skipped > 0 {
+		// The table would break up the log lines of the other formats.
+		fmt.Fprint(os.Stderr, formatSkipBreakdown(summary.SkippedByReason))
+	}
+	if o.slackWebhookURL != "" {
+		if err := postJSON(ctx, o.slackWebhookURL, nil, newSlackMessage(summary)); err != nil {
+			slog.Error("Error when posting the Slack notification", "error", err)
+		}
+	}
+	if o.teamsWebhookURL != "" {
+		if err := postJSON(ctx, o.teamsWebhookURL, nil, newTeamsMessage(summary)); err != nil {
+			slog.Error("Error when posting the Teams notification", "error", err)
+		}
+	}
+	if o.enableTelemetry {
+		if err := sendTelemetry(ctx, o.telemetryEndpoint, newTelemetryPayload(summary, total.resourceGroups)); err != nil {
+			slog.Debug("Error when sending telemetry", "error", err)
+		}
+	}
+	if o.monitorDCREndpoint != "" {
+		c := newLogsIngestionClient(cred, o.monitorDCREndpoint, o.monitorDCRID, o.monitorStream)
+		if err := c.upload(ctx, newMonitorRecords(summary, total.resourceGroups, time.Now())); err != nil {
+			slog.Error("Error when sending the run results to Azure Monitor", "error", err)
+		}
+	}
+	if o.monitorMetricsEndpoint != "" {
+		c := newCustomMetricsClient(cred, o.monitorMetricsEndpoint)
+		if err := c.write(ctx, newStaleResourceGroupCountMetric(o.subscriptionIDs, total.resourceGroups, time.Now())); err != nil {
+			slog.Error("Error when writing the stale resource group count to Azure Monitor", "error", err)
+		}
+	}
+	if o.stuckStateFile != "" && !o.dryRun {
+		if err := trackStuckResourceGroups(ctx, o, total.resourceGroups, time.Now()); err != nil {
+			slog.Error("Error when tracking stuck resource groups", "error", err)
+		}
+	}
+	if o.resultsQueueURL != "" {
+		if c, err := getResultsQueueClient(cred, o.resultsQueueURL); err != nil {
+			slog.Error("Error when obtaining results queue client", "error", err)
+		} else if err := enqueueResults(ctx, c, summary, total.resourceGroups); err != nil {
+			slog.Error("Error when enqueuing the run results", "error", err)
+		}
+	}
+	if server != nil {
+		server.recordRun(summary, time.Now())
+	}
+	return total, deletionsErr
+}
+
+// forEachSubscription calls f for each of subscriptionIDs, running up to
+// workers calls at a time, and returns the errors by subscription. Once ctx is
+// done, the subscriptions that were not started fail with its error.
+func forEachSubscription(ctx context.Context, subscriptionIDs []string, workers int, f func(ctx context.Context, subscriptionID string) error) map[string]error {
+	jobs := make(chan string)
+	var mu sync.Mutex
+	errs := map[string]error{}
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for subscriptionID := range jobs {
+				err := ctx.Err()
+				if err == nil {
+					err = f(ctx, subscriptionID)
+				}
 				if err != nil {
-					log.Printf("Error when deleting %s: %v", rgName, err)
+					mu.Lock()
+					errs[subscriptionID] = err
+					mu.Unlock()
 				}
 			}
+		}()
+	}
+	for _, subscriptionID := range subscriptionIDs {
+		jobs <- subscriptionID
+	}
+	close(jobs)
+	wg.Wait()
+	return errs
+}
+
+// subscriptionsError returns a single error for the errors of the
+// subscriptions that failed.
+func subscriptionsError(errs map[string]error) error {
+	if len(errs) == 1 {
+		for _, err := range errs {
+			return err
 		}
 	}
+	subscriptionIDs := make([]string, 0, len(errs))
+	for subscriptionID := range errs {
+		subscriptionIDs = append(subscriptionIDs, subscriptionID)
+	}
+	sort.Strings(subscriptionIDs)
+	messages := make([]string, len(subscriptionIDs))
+	for i, subscriptionID := range subscriptionIDs {
+		messages[i] = fmt.Sprintf("%s: %v", subscriptionID, errs[subscriptionID])
+	}
+	return fmt.Errorf("%d subscriptions failed: %s", len(errs), strings.Join(messages, "; "))
+}
 
-	return nil
+// getSubscriptionClients returns the resource group client, wrapped by
+// clientDecorators, and the resources client of subscriptionID.
+func getSubscriptionClients(cred azcore.TokenCredential, subscriptionID string, useResourceGraph bool) (resourceGroupsClient, resourcesClient, error) {
+	r, err := getResourceGroupClient(cred, subscriptionID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error when obtaining resource group client: %v", err)
+	}
+
+	var client resourceGroupsClient = r
+	if useResourceGraph {
+		graph, err := getResourceGraphClient(cred)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error when obtaining Azure Resource Graph client: %v", err)
+		}
+		client = &resourceGraphResourceGroups{resourceGroupsClient: r, graph: graph, subscriptionID: subscriptionID}
+	}
+	for _, decorate := range clientDecorators {
+		client = decorate(client)
+	}
+
+	resources, err := getResourcesClient(cred, subscriptionID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error when obtaining resources client: %v", err)
+	}
+	return client, resources, nil
 }
 
-func shouldDeleteResourceGroup(rg *armresources.ResourceGroup, ttl time.Duration, regex string) (string, bool) {
-	if _, ok := rg.Tags[doNotDeleteTag]; ok {
-		return "", false
+// cleanupSubscription cleans up subscriptionID and adds the result to total.
+// The metrics of server are updated when it is not nil.
+func cleanupSubscription(ctx context.Context, cred azcore.TokenCredential, subscriptionID string, o *options, server *metricsServer, total *runResult) (err error) {
+	ctx, span := tracer().Start(ctx, "cleanup subscription", trace.WithAttributes(attribute.String("subscription_id", subscriptionID)))
+	defer func() { endSpan(span, err) }()
+
+	client, resources, err := getSubscriptionClients(cred, subscriptionID, o.useResourceGraph)
+	if err != nil {
+		return err
+	}
+
+	if server != nil {
+		ctx = withProgressMetrics(ctx, server.progress)
+	}
+	start := time.Now()
+	result, err := runResourceGroupCleanup(ctx, subscriptionID, client, client, resources, o)
+	if result != nil {
+		total.add(result)
+	}
+	// Failed deletions do not stop the cleanup of the subscription; they are
+	// returned once it is done.
+	var deletionsErr, identitiesErr *deletionsError
+	if errors.As(err, &deletionsErr) {
+		err = nil
+	}
+	observed := result
+	if err != nil {
+		observed = nil
+	}
+	if o.pushgatewayURL != "" {
+		if err := pushMetrics(o.pushgatewayURL, subscriptionID, observed, time.Since(start), time.Now()); err != nil {
+			slog.Error("Error when pushing metrics", "subscription_id", subscriptionID, "error", err)
+		}
+	}
+	if server != nil {
+		server.metrics.observe(observed, time.Since(start), time.Now(), subscriptionID)
+	}
+	if err != nil {
+		return err
+	}
+
+	if o.managedIdentities {
+		c, err := getManagedIdentityClients(cred, subscriptionID)
+		if err != nil {
+			return fmt.Errorf("error when obtaining managed identity clients: %v", err)
+		}
+		failed, err := runManagedIdentityCleanup(ctx, c, o)
+		if err != nil {
+			return fmt.Errorf("error when cleaning up managed identities: %v", err)
+		}
+		// Failed identity deletions fail the run like failed resource group
+		// deletions.
+		total.failed += len(failed)
+		if len(failed) > 0 && !o.ignoreDeletionErrors {
+			identitiesErr = &deletionsError{resources: "managed identities", errs: failed}
+		}
 	}
 
-	if regex != "" {
-		match, err := regexMatchesResourceGroupName(regex, *rg.Name)
+	if o.deleteOrphanedSnapshots {
+		c, err := getSnapshotsClient(cred, subscriptionID)
 		if err != nil {
-			log.Printf("failed to regex Resource Group Name: %s", err)
-			return "", false
+			return fmt.Errorf("error when obtaining snapshots client: %v", err)
+		}
+		if err := runSnapshotCleanup(ctx, c, o); err != nil {
+			return fmt.Errorf("error when cleaning up snapshots: %v", err)
+		}
+	}
+
+	if o.classicAdministrators {
+		c, err := getClassicAdministratorsClient(cred, subscriptionID)
+		if err != nil {
+			return fmt.Errorf("error when obtaining classic administrators client: %v", err)
+		}
+		if err := runClassicAdministratorCleanup(ctx, c, newGraphClient(cred), o); err != nil {
+			return fmt.Errorf("error when cleaning up classic administrators: %v", err)
+		}
+	}
+	switch {
+	case deletionsErr != nil && identitiesErr != nil:
+		return errors.Join(deletionsErr, identitiesErr)
+	case deletionsErr != nil:
+		return deletionsErr
+	case identitiesErr != nil:
+		return identitiesErr
+	}
+	return nil
+}
+
+// runResult holds what runResourceGroupCleanup found in a subscription.
+type runResult struct {
+	upcoming       []upcomingDeletion
+	notifications  []upcomingDeletion
+	resourceGroups []resourceGroupRecord
+
+	scanned int
+	// eligible counts the resource groups that are stale, whether or not
+	// their deletion was attempted.
+	eligible int
+	deleted  int
+	failed   int
+	// skipped counts the resource groups that were not deleted, by reason.
+	skipped map[string]int
+	// subscriptionErrors holds the error of each subscription that could
+	// not be cleaned up.
+	subscriptionErrors map[string]string
+}
+
+// addResourceGroup records what happened to a resource group.
+func (r *runResult) addResourceGroup(record resourceGroupRecord) {
+	r.resourceGroups = append(r.resourceGroups, record)
+	r.scanned++
+	if record.Decision == decisionDelete {
+		r.eligible++
+	}
+	switch record.Outcome {
+	case outcomeSkipped:
+		r.skipped[record.Reason]++
+	case outcomeDeletionStarted:
+		r.deleted++
+	case outcomeFailed:
+		r.failed++
+	}
+}
+
+// add adds the counts and upcoming deletions of other to r.
+func (r *runResult) add(other *runResult) {
+	r.upcoming = append(r.upcoming, other.upcoming...)
+	r.notifications = append(r.notifications, other.notifications...)
+	r.resourceGroups = append(r.resourceGroups, other.resourceGroups...)
+	r.scanned += other.scanned
+	r.eligible += other.eligible
+	r.deleted += other.deleted
+	r.failed += other.failed
+	for reason, count := range other.skipped {
+		r.skipped[reason] += count
+	}
+}
+
+// Reasons reported in the "reason" log field and in runResult.skipped.
+const (
+	reasonProtected           = cleanup.ReasonProtected
+	reasonRegexMismatch       = cleanup.ReasonRegexMismatch
+	reasonRegexError          = cleanup.ReasonRegexError
+	reasonTagKeyMismatch      = cleanup.ReasonTagKeyMismatch
+	reasonTagKeyError         = cleanup.ReasonTagKeyError
+	reasonMissingTag          = cleanup.ReasonMissingTag
+	reasonHasTag              = cleanup.ReasonHasTag
+	reasonNotCreatedBy        = cleanup.ReasonNotCreatedBy
+	reasonInvalidTimestamp    = cleanup.ReasonInvalidTimestamp
+	reasonTTLNotElapsed       = cleanup.ReasonTTLNotElapsed
+	reasonTTLElapsed          = cleanup.ReasonTTLElapsed
+	reasonNoCreationTimestamp = cleanup.ReasonNoCreationTimestamp
+	reasonMinResourceCount    = "min_resource_count"
+	reasonResourceCountError  = "resource_count_error"
+	reasonResourceType        = "resource_type"
+	reasonResourceTypeError   = "resource_type_error"
+	reasonCostAboveThreshold  = cleanup.ReasonCostAboveThreshold
+	reasonInvalidCostTag      = cleanup.ReasonInvalidCostTag
+	reasonAuditError          = "audit_error"
+	reasonGracePeriod         = "grace_period"
+	reasonDeletionBudget      = "deletion_budget"
+	reasonPreDeleteHook       = "pre_delete_hook"
+	reasonProvisioningState   = "provisioning_state"
+)
+
+func runResourceGroupCleanup(ctx context.Context, subscriptionID string, lister resourceGroupLister, r resourceGroupDeleter, resources resourcesClient, o *options) (*runResult, error) {
+	logger := loggerFrom(ctx).With("subscription_id", subscriptionID, "dry_run", o.dryRun)
+	eventStreamFrom(ctx).send(newStreamEvent(streamEventScanStarted, subscriptionID))
+
+	now := time.Now()
+	result := &runResult{skipped: map[string]int{}}
+	progress := newProgressReporter(ctx, logger, subscriptionID, o)
+	// seen holds the lowercase names of the resource groups listed, to find
+	// those of --apply that no longer exist.
+	seen := map[string]bool{}
+	c := &cleanup.Cleaner{
+		Lister:            lister,
+		Deleter:           r,
+		Resources:         resources,
+		Options:           o.cleanupOptions(),
+		Logger:            logger,
+		PrincipalObjectID: principalObjectIDFrom(ctx),
+		Hooks: cleanup.Hooks{
+			Listed: func(ctx context.Context, logger *slog.Logger, rg *armresources.ResourceGroup) (resourceGroupRecord, bool) {
+				if o.applyPlan != nil {
+					seen[strings.ToLower(*rg.Name)] = true
+					if record, ok := o.applyPlan.check(logger, subscriptionID, rg); !ok {
+						return record, false
+					}
+				}
+				return cleanup.NewRecord(subscriptionID, rg), true
+			},
+			Evaluated: func(ctx context.Context, logger *slog.Logger, rg *armresources.ResourceGroup, record resourceGroupRecord) {
+				eventStreamFrom(ctx).send(newStreamEvent(streamEventRGEvaluated, subscriptionID).withResourceGroup(record))
+				// This comes after the plan check, which the notified tag
+				// would fail, and before the deletion.
+				addUpcomingDeletion(ctx, logger, subscriptionID, r, resources, rg, record, o, now, result)
+			},
+			BeforeDelete: func(ctx context.Context, logger *slog.Logger, rg *armresources.ResourceGroup, record resourceGroupRecord) (resourceGroupRecord, bool) {
+				return beforeDeleteResourceGroup(ctx, logger, subscriptionID, rg, record, o)
+			},
+			Deleted: func(ctx context.Context, logger *slog.Logger, rg *armresources.ResourceGroup, record resourceGroupRecord) {
+				afterDeleteResourceGroup(ctx, logger, subscriptionID, rg, record, o)
+			},
+			Recorded: func(ctx context.Context, record resourceGroupRecord) error {
+				result.addResourceGroup(record)
+				statsdClientFrom(ctx).observeResourceGroup(record, o.dryRun)
+				return auditLogFrom(ctx).err()
+			},
+			Page: func(context.Context, *cleanup.Result) {
+				progress.page(result)
+			},
+		},
+	}
+	// Return what was done so far, even on error, so that it can still be
+	// reported.
+	if _, err := c.Run(ctx, subscriptionID); err != nil {
+		return result, err
+	}
+	if o.applyPlan != nil {
+		for _, record := range o.applyPlan.missing(logger, subscriptionID, seen) {
+			result.addResourceGroup(record)
 		}
-		if !match {
-			log.Printf("RG '%s' did not match regex", *rg.Name)
-			return "", false
+	}
+
+	if !o.ignoreDeletionErrors {
+		if err := newDeletionsError(result.resourceGroups); err != nil {
+			return result, err
 		}
-		log.Printf("RG '%s' matched regex '%s'", *rg.Name, regex)
 	}
+	return result, nil
+}
 
-	creationTimestamp, ok := rg.Tags[creationTimestampTag]
+// addUpcomingDeletion adds rg to the --export-ical calendar of result, and
+// notifies its owner with --notify-before-deletion, if it will be deleted
+// within their lookahead. Both reuse record, the decision of this run on rg,
+// and share the evaluation of rg at the longest lookahead, if one is needed.
+func addUpcomingDeletion(ctx context.Context, logger *slog.Logger, subscriptionID string, r resourceGroupDeleter, resources resourcesClient, rg *armresources.ResourceGroup, record resourceGroupRecord, o *options, now time.Time, result *runResult) {
+	notifyLookahead := time.Duration(o.notifyDaysBefore) * 24 * time.Hour
+	var lookahead time.Duration
+	switch {
+	case o.exportICal != "" && o.notifyBeforeDeletion != "":
+		lookahead = max(o.icalLookahead, notifyLookahead)
+	case o.exportICal != "":
+		lookahead = o.icalLookahead
+	case o.notifyBeforeDeletion != "":
+		lookahead = notifyLookahead
+	default:
+		return
+	}
+	d, ok := getUpcomingDeletion(ctx, subscriptionID, resources, rg, record, o, now, lookahead)
 	if !ok {
-		return fmt.Sprintf("probably a long time because it does not have a '%s' tag. Found tags: %v", creationTimestampTag, rg.Tags), true
+		return
+	}
+	// Resource groups deleted by this run are not upcoming.
+	if o.exportICal != "" && d.deletion.After(now) && !d.deletion.After(now.Add(o.icalLookahead)) {
+		result.upcoming = append(result.upcoming, d)
+	}
+	// Resource groups that are already eligible, e.g. on the first run, are
+	// notified right away, before they are deleted.
+	if o.notifyBeforeDeletion != "" && !d.deletion.After(now.Add(notifyLookahead)) && d.contact != "" && !isNotified(rg, d) {
+		result.notifications = append(result.notifications, d)
+		notifyDeletion(ctx, logger, mailerFrom(ctx), r, rg, d, o.dryRun)
 	}
+}
 
-	var t time.Time
-	var err error
-	for _, layout := range rfc3339Layouts {
-		t, err = time.Parse(layout, *creationTimestamp)
-		if err == nil {
-			break
+// beforeDeleteResourceGroup runs the --pre-delete-hook, waits for the
+// --deletion-budget-per-hour and writes the audit record of the deletion of
+// rg, which is about to start. It returns false with a skipped record if rg
+// must not be deleted.
+func beforeDeleteResourceGroup(ctx context.Context, logger *slog.Logger, subscriptionID string, rg *armresources.ResourceGroup, record resourceGroupRecord, o *options) (resourceGroupRecord, bool) {
+	rgName := *rg.Name
+	if o.preDeleteHook != "" {
+		if err := runDeleteHook(ctx, preDeleteHook, o.preDeleteHook, o.preDeleteHookTimeout, subscriptionID, rg, time.Now()); err != nil {
+			logger.Info(fmt.Sprintf("Skip deletion of resource group '%s' because the pre-delete hook rejected it (age: %s)", rgName, record.Age), "decision", decisionSkip, "reason", reasonPreDeleteHook, "error", err)
+			return record.Skip(reasonPreDeleteHook, err), false
 		}
 	}
 
-	if err != nil {
-		log.Printf("failed to parse timestamp: %s", err)
-		return "", false
+	if err := deletionBudgetFrom(ctx).take(ctx); err != nil {
+		logger.Error(fmt.Sprintf("Error when waiting for the deletion budget to delete %s", rgName), "decision", decisionSkip, "reason", reasonDeletionBudget, "error", err)
+		return record.Skip(reasonDeletionBudget, err), false
 	}
 
-	return fmt.Sprintf("%d days (%d hours)", int(time.Since(t).Hours()/24), int(time.Since(t).Hours())), time.Since(t) >= ttl
+	if err := auditLogFrom(ctx).write(ctx, auditPhaseAttempt, record, ""); err != nil {
+		logger.Error(fmt.Sprintf("Error when writing the audit record of %s", rgName), "error", err)
+		if o.auditRequired {
+			return record.Skip(reasonAuditError, err), false
+		}
+	}
+	return record, true
 }
 
-func regexMatchesResourceGroupName(regex string, rgName string) (bool, error) {
-	if regex != "" {
-		rgx, err := regexp.Compile(regex)
-		if err != nil {
-			return false, fmt.Errorf("failed to compile regex: %v", err)
+// afterDeleteResourceGroup writes the audit record, sends the events and runs
+// the --post-delete-hook of the deletion of rg, which was started or failed
+// as told by record.
+func afterDeleteResourceGroup(ctx context.Context, logger *slog.Logger, subscriptionID string, rg *armresources.ResourceGroup, record resourceGroupRecord, o *options) {
+	rgName := *rg.Name
+	if err := auditLogFrom(ctx).write(ctx, auditPhaseResult, record, record.RequestID); err != nil {
+		logger.Error(fmt.Sprintf("Error when writing the audit record of %s", rgName), "error", err)
+	}
+	if record.Outcome == outcomeFailed {
+		eventSenderFrom(ctx).send(deletionEvent{Type: eventDeletionFailed, Timestamp: time.Now().UTC(), resourceGroupRecord: record})
+		eventStreamFrom(ctx).send(newStreamEvent(streamEventRGDeleteFailed, subscriptionID).withResourceGroup(record))
+		return
+	}
+	eventSenderFrom(ctx).send(deletionEvent{Type: eventDeletionStarted, Timestamp: time.Now().UTC(), resourceGroupRecord: record})
+	if err := serviceBusPublisherFrom(ctx).publish(ctx, record, time.Now()); err != nil {
+		logger.Error(fmt.Sprintf("Error when publishing the deletion of %s to Service Bus", rgName), "error", err)
+	}
+	eventStreamFrom(ctx).send(newStreamEvent(streamEventRGDeleteStarted, subscriptionID).withResourceGroup(record))
+	if o.postDeleteHook != "" {
+		if err := runDeleteHook(ctx, postDeleteHook, o.postDeleteHook, o.postDeleteHookTimeout, subscriptionID, rg, time.Now()); err != nil {
+			logger.Error(fmt.Sprintf("Error when running the post-delete hook of %s", rgName), "error", err)
 		}
-		match := rgx.FindString(rgName)
-		if match != rgName {
-			return false, nil
+	}
+}
+
+// deletionsError holds the errors of the deletions that failed. Unlike other
+// errors, it does not stop the run.
+type deletionsError struct {
+	// resources names what failed to be deleted, e.g. "resource groups".
+	resources string
+	errs      []error
+}
+
+// newDeletionsError returns a *deletionsError for the failed deletions of
+// records, or nil if none failed.
+func newDeletionsError(records []resourceGroupRecord) error {
+	var errs []error
+	for _, record := range records {
+		if record.Outcome == outcomeFailed {
+			errs = append(errs, fmt.Errorf("%s: %s", record.Name, record.Error))
 		}
-		return true, nil
 	}
-	return false, nil
+	if len(errs) == 0 {
+		return nil
+	}
+	return &deletionsError{resources: "resource groups", errs: errs}
 }
 
-func getResourceGroupClient(clientID, clientSecret, tenantID, subscriptionID string, identity bool) (*armresources.ResourceGroupsClient, error) {
-	options := arm.ClientOptions{
-		ClientOptions: azcore.ClientOptions{
-			Cloud: cloud.AzurePublic,
-		},
+func (e *deletionsError) Error() string {
+	messages := make([]string, len(e.errs))
+	for i, err := range e.errs {
+		messages[i] = err.Error()
 	}
+	return fmt.Sprintf("failed to delete %d %s: %s", len(e.errs), e.resources, strings.Join(messages, "; "))
+}
+
+func (e *deletionsError) Unwrap() []error {
+	return e.errs
+}
+
+// onlyDeletionsErrors reports whether every error of errs is a
+// *deletionsError.
+func onlyDeletionsErrors(errs map[string]error) bool {
+	for _, err := range errs {
+		var deletionsErr *deletionsError
+		if !errors.As(err, &deletionsErr) {
+			return false
+		}
+	}
+	return true
+}
+
+// evaluateResourceGroup decides whether rg should be deleted and returns a
+// record with the decision. The record of a resource group to delete has no
+// outcome yet.
+func evaluateResourceGroup(ctx context.Context, logger *slog.Logger, subscriptionID string, resources resourcesClient, rg *armresources.ResourceGroup, o *options) resourceGroupRecord {
+	return cleanup.Evaluate(ctx, logger, resources, subscriptionID, rg, o.cleanupOptions())
+}
+
+// isEligibleForDeletion reports whether a run would delete rg,
+// without deleting it, by evaluating it the same way. Resource groups whose
+// resources cannot be listed are not eligible.
+func isEligibleForDeletion(ctx context.Context, resources resourcesClient, rg *armresources.ResourceGroup, o *options) bool {
+	return evaluateResourceGroup(ctx, loggerFrom(ctx), "", resources, rg, o).Decision == decisionDelete
+}
+
+// defaultProvisioningStates are the provisioning states of the resource
+// groups that may be deleted by default. Resource groups that are being
+// created, updated or deleted, or that failed to be, are left alone.
+var defaultProvisioningStates = []string{"Succeeded"}
+
+// shouldDeleteResourceGroup decides whether rg is eligible for deletion.
+func shouldDeleteResourceGroup(ctx context.Context, rg *armresources.ResourceGroup, o *options) cleanup.Decision {
+	return cleanup.Decide(loggerFrom(ctx), rg, o.cleanupOptions())
+}
+
+// cleanupOptions returns the deletion policy of o.
+func (o *options) cleanupOptions() *cleanup.Options {
+	return &cleanup.Options{
+		TTL:                   o.ttl,
+		Regex:                 o.regex,
+		DisableRegexFullMatch: o.disableRegexFullMatch,
+		ProtectTagValues:      o.protectTagValues,
+		TagKeyFilter:          o.tagKeyFilter,
+		HasTags:               o.hasTags,
+		MissingTags:           o.missingTags,
+		CostTag:               o.costTag,
+		CostThreshold:         o.costThreshold,
+		CreatedBySPs:          o.createdBySPs,
+		CreatedByTag:          o.createdByTag,
+		Now:                   o.evaluateAt,
+		ProvisioningStates:    o.provisioningStates,
+		MinResourceCount:      o.minResourceCount,
+		SkipResourceTypes:     o.skipResourceTypes,
+		MinimumRGsToKeep:      o.minimumRGsToKeep,
+		GracePeriod:           o.gracePeriod,
+		TagOnDelete:           o.tagOnDelete,
+		DryRun:                o.dryRun,
+		MaxTagLogLength:       o.maxTagLogLength,
+	}
+}
+
+// dedupe returns values without duplicates, keeping the first occurrence of
+// each value.
+func dedupe(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	var deduped []string
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			deduped = append(deduped, v)
+		}
+	}
+	return deduped
+}
+
+// readSubscriptionIDsFile reads one subscription ID per line from path,
+// ignoring blank lines and '#' comments.
+func readSubscriptionIDsFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read subscription IDs file: %v", err)
+	}
+	var ids []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			ids = append(ids, line)
+		}
+	}
+	return ids, nil
+}
+
+// splitCommaList splits a comma-separated list, trimming whitespace and
+// dropping empty elements.
+func splitCommaList(list string) []string {
+	var values []string
+	for _, v := range strings.Split(list, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// getCredential returns a managed identity credential with identity, a
+// client assertion credential using GitHub Actions ID tokens when githubOIDC
+// is set, a workload identity credential when federatedTokenFile is set, or a
+// client secret credential.
+func getCredential(clientID, clientSecret, tenantID string, identity bool, federatedTokenFile string, githubOIDC *githubOIDCTokenSource) (azcore.TokenCredential, error) {
 	possibleTokens := []azcore.TokenCredential{}
 	if identity {
 		micOptions := azidentity.ManagedIdentityCredentialOptions{
@@ -209,6 +1861,22 @@ func getResourceGroupClient(clientID, clientSecret, tenantID, subscriptionID str
 			return nil, err
 		}
 		possibleTokens = append(possibleTokens, miCred)
+	} else if githubOIDC != nil {
+		assertionCred, err := azidentity.NewClientAssertionCredential(tenantID, clientID, githubOIDC.getAssertion, nil)
+		if err != nil {
+			return nil, err
+		}
+		possibleTokens = append(possibleTokens, assertionCred)
+	} else if federatedTokenFile != "" {
+		wiCred, err := azidentity.NewWorkloadIdentityCredential(&azidentity.WorkloadIdentityCredentialOptions{
+			ClientID:      clientID,
+			TenantID:      tenantID,
+			TokenFilePath: federatedTokenFile,
+		})
+		if err != nil {
+			return nil, err
+		}
+		possibleTokens = append(possibleTokens, wiCred)
 	} else {
 		spCred, err := azidentity.NewClientSecretCredential(tenantID, clientID, clientSecret, nil)
 		if err != nil {
@@ -216,11 +1884,21 @@ func getResourceGroupClient(clientID, clientSecret, tenantID, subscriptionID str
 		}
 		possibleTokens = append(possibleTokens, spCred)
 	}
-	chain, err := azidentity.NewChainedTokenCredential(possibleTokens, nil)
-	if err != nil {
-		return nil, err
+	return azidentity.NewChainedTokenCredential(possibleTokens, nil)
+}
+
+func getClientOptions() *arm.ClientOptions {
+	return &arm.ClientOptions{
+		ClientOptions: azcore.ClientOptions{
+			Cloud:            cloud.AzurePublic,
+			PerRetryPolicies: []policy.Policy{tracingPolicy{}},
+			Telemetry:        policy.TelemetryOptions{ApplicationID: userAgent()},
+		},
 	}
-	resourceGroupClient, err := armresources.NewResourceGroupsClient(subscriptionID, chain, &options)
+}
+
+func getResourceGroupClient(cred azcore.TokenCredential, subscriptionID string) (*armresources.ResourceGroupsClient, error) {
+	resourceGroupClient, err := armresources.NewResourceGroupsClient(subscriptionID, cred, getClientOptions())
 	if err != nil {
 		return nil, err
 	}
@@ -1,11 +1,23 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	goruntime "runtime"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
 	"github.com/Azure/go-autorest/autorest/to"
+
+	"github.com/chewong/rg-cleanup/pkg/cleanup"
 )
 
 func TestShouldDeleteResourceGroup(t *testing.T) {
@@ -20,7 +32,14 @@ func TestShouldDeleteResourceGroup(t *testing.T) {
 		hasDoNotDelete      bool
 		expectedToBeDeleted bool
 		expectedAge         string
+		expectedReason      string
 		regex               string
+		doNotDeleteValue    string
+		protectTagValues    []string
+		ttlOverride         string
+		createdBy           string
+		createdBySPs        []string
+		substringRegex      bool
 	}{
 		{
 			desc:                "deletable resource group that has not lived for more than 3 days",
@@ -28,6 +47,7 @@ func TestShouldDeleteResourceGroup(t *testing.T) {
 			creationTimestamp:   oneDayAgo,
 			expectedToBeDeleted: false,
 			expectedAge:         oneDayAgeOutput,
+			expectedReason:      reasonTTLNotElapsed,
 			regex:               "",
 		},
 		{
@@ -36,6 +56,7 @@ func TestShouldDeleteResourceGroup(t *testing.T) {
 			creationTimestamp:   fourDaysAgo,
 			expectedToBeDeleted: true,
 			expectedAge:         fourDayAgeOutput,
+			expectedReason:      reasonTTLElapsed,
 			regex:               "",
 		},
 		{
@@ -44,6 +65,7 @@ func TestShouldDeleteResourceGroup(t *testing.T) {
 			creationTimestamp:   fourDaysAgo,
 			expectedToBeDeleted: true,
 			expectedAge:         fourDayAgeOutput,
+			expectedReason:      reasonTTLElapsed,
 			regex:               "",
 		},
 		{
@@ -52,6 +74,7 @@ func TestShouldDeleteResourceGroup(t *testing.T) {
 			creationTimestamp:   fourDaysAgo,
 			expectedToBeDeleted: true,
 			expectedAge:         fourDayAgeOutput,
+			expectedReason:      reasonTTLElapsed,
 			regex:               "",
 		},
 		{
@@ -60,6 +83,7 @@ func TestShouldDeleteResourceGroup(t *testing.T) {
 			creationTimestamp:   fourDaysAgo,
 			expectedToBeDeleted: true,
 			expectedAge:         fourDayAgeOutput,
+			expectedReason:      reasonTTLElapsed,
 			regex:               "",
 		},
 		{
@@ -68,6 +92,7 @@ func TestShouldDeleteResourceGroup(t *testing.T) {
 			creationTimestamp:   fourDaysAgo,
 			expectedToBeDeleted: true,
 			expectedAge:         fourDayAgeOutput,
+			expectedReason:      reasonTTLElapsed,
 			regex:               "",
 		},
 		{
@@ -76,6 +101,7 @@ func TestShouldDeleteResourceGroup(t *testing.T) {
 			creationTimestamp:   fourDaysAgo,
 			expectedToBeDeleted: true,
 			expectedAge:         fourDayAgeOutput,
+			expectedReason:      reasonTTLElapsed,
 			regex:               "",
 		},
 		{
@@ -84,6 +110,7 @@ func TestShouldDeleteResourceGroup(t *testing.T) {
 			creationTimestamp:   fourDaysAgo,
 			expectedToBeDeleted: true,
 			expectedAge:         fourDayAgeOutput,
+			expectedReason:      reasonTTLElapsed,
 			regex:               "",
 		},
 		{
@@ -92,6 +119,7 @@ func TestShouldDeleteResourceGroup(t *testing.T) {
 			creationTimestamp:   fourDaysAgo,
 			expectedToBeDeleted: true,
 			expectedAge:         fourDayAgeOutput,
+			expectedReason:      reasonTTLElapsed,
 			regex:               "",
 		},
 		{
@@ -100,6 +128,7 @@ func TestShouldDeleteResourceGroup(t *testing.T) {
 			creationTimestamp:   "",
 			expectedToBeDeleted: true,
 			expectedAge:         "probably a long time because it does not have a 'creationTimestamp' tag. Found tags: map[]",
+			expectedReason:      reasonNoCreationTimestamp,
 			regex:               "",
 		},
 		{
@@ -108,6 +137,7 @@ func TestShouldDeleteResourceGroup(t *testing.T) {
 			creationTimestamp:   "invalid creation timestamp",
 			expectedToBeDeleted: false,
 			expectedAge:         "",
+			expectedReason:      reasonInvalidTimestamp,
 			regex:               "",
 		},
 		{
@@ -117,6 +147,7 @@ func TestShouldDeleteResourceGroup(t *testing.T) {
 			hasDoNotDelete:      true,
 			expectedToBeDeleted: false,
 			expectedAge:         "",
+			expectedReason:      reasonProtected,
 			regex:               "",
 		},
 		{
@@ -126,6 +157,7 @@ func TestShouldDeleteResourceGroup(t *testing.T) {
 			hasDoNotDelete:      false,
 			expectedToBeDeleted: true,
 			expectedAge:         "probably a long time because it does not have a 'creationTimestamp' tag. Found tags: map[]",
+			expectedReason:      reasonNoCreationTimestamp,
 			regex:               "^kubetest.+$",
 		},
 		{
@@ -135,7 +167,28 @@ func TestShouldDeleteResourceGroup(t *testing.T) {
 			hasDoNotDelete:      false,
 			expectedToBeDeleted: false,
 			expectedAge:         "",
+			expectedReason:      reasonRegexMismatch,
+			regex:               "kubetest",
+		},
+		{
+			desc:                "deletable resource group with a substring match and --disable-regex-full-match",
+			rgName:              "kubetest-fake-123",
+			creationTimestamp:   fourDaysAgo,
+			expectedToBeDeleted: true,
+			expectedAge:         fourDayAgeOutput,
+			expectedReason:      reasonTTLElapsed,
+			regex:               "kubetest",
+			substringRegex:      true,
+		},
+		{
+			desc:                "resource group not deletable without a substring match and --disable-regex-full-match",
+			rgName:              "aks-fake-123",
+			creationTimestamp:   fourDaysAgo,
+			expectedToBeDeleted: false,
+			expectedAge:         "",
+			expectedReason:      reasonRegexMismatch,
 			regex:               "kubetest",
+			substringRegex:      true,
 		},
 		{
 			desc:                "resource group no deletable, matches regex but has DO-NOT-DELETE",
@@ -144,6 +197,7 @@ func TestShouldDeleteResourceGroup(t *testing.T) {
 			hasDoNotDelete:      true,
 			expectedToBeDeleted: false,
 			expectedAge:         "",
+			expectedReason:      reasonProtected,
 			regex:               "^.+$",
 		},
 		{
@@ -152,8 +206,87 @@ func TestShouldDeleteResourceGroup(t *testing.T) {
 			creationTimestamp:   oneDayAgo,
 			expectedToBeDeleted: false,
 			expectedAge:         oneDayAgeOutput,
+			expectedReason:      reasonTTLNotElapsed,
 			regex:               "^kube.+",
 		},
+		{
+			desc:                "DO-NOT-DELETE tag value matches one of the protected values",
+			rgName:              "kubetest-789",
+			creationTimestamp:   fourDaysAgo,
+			hasDoNotDelete:      true,
+			doNotDeleteValue:    "infra,compliance",
+			protectTagValues:    []string{"compliance"},
+			expectedToBeDeleted: false,
+			expectedAge:         "",
+			expectedReason:      reasonProtected,
+		},
+		{
+			desc:                "DO-NOT-DELETE tag value does not match any of the protected values",
+			rgName:              "kubetest-789",
+			creationTimestamp:   fourDaysAgo,
+			hasDoNotDelete:      true,
+			doNotDeleteValue:    "temporary",
+			protectTagValues:    []string{"infra", "compliance"},
+			expectedToBeDeleted: true,
+			expectedAge:         fourDayAgeOutput,
+			expectedReason:      reasonTTLElapsed,
+		},
+		{
+			desc:                "ttl-override tag extends the TTL of an old resource group",
+			rgName:              "kubetest-789",
+			creationTimestamp:   fourDaysAgo,
+			ttlOverride:         "168h",
+			expectedToBeDeleted: false,
+			expectedAge:         fourDayAgeOutput,
+			expectedReason:      reasonTTLNotElapsed,
+		},
+		{
+			desc:                "ttl-override tag shortens the TTL of a young resource group",
+			rgName:              "kubetest-789",
+			creationTimestamp:   oneDayAgo,
+			ttlOverride:         "12h",
+			expectedToBeDeleted: true,
+			expectedAge:         oneDayAgeOutput,
+			expectedReason:      reasonTTLElapsed,
+		},
+		{
+			desc:                "invalid ttl-override tag falls back to the global TTL",
+			rgName:              "kubetest-789",
+			creationTimestamp:   fourDaysAgo,
+			ttlOverride:         "one week",
+			expectedToBeDeleted: true,
+			expectedAge:         fourDayAgeOutput,
+			expectedReason:      reasonTTLElapsed,
+		},
+		{
+			desc:                "created by one of the given service principals",
+			rgName:              "kubetest-789",
+			creationTimestamp:   fourDaysAgo,
+			createdBy:           "22222222-2222-2222-2222-222222222222",
+			createdBySPs:        []string{"11111111-1111-1111-1111-111111111111", "22222222-2222-2222-2222-222222222222"},
+			expectedToBeDeleted: true,
+			expectedAge:         fourDayAgeOutput,
+			expectedReason:      reasonTTLElapsed,
+		},
+		{
+			desc:                "created by another principal",
+			rgName:              "kubetest-789",
+			creationTimestamp:   fourDaysAgo,
+			createdBy:           "33333333-3333-3333-3333-333333333333",
+			createdBySPs:        []string{"11111111-1111-1111-1111-111111111111"},
+			expectedToBeDeleted: false,
+			expectedAge:         "",
+			expectedReason:      reasonNotCreatedBy,
+		},
+		{
+			desc:                "no createdBy tag with --created-by-sp",
+			rgName:              "kubetest-789",
+			creationTimestamp:   fourDaysAgo,
+			createdBySPs:        []string{"11111111-1111-1111-1111-111111111111"},
+			expectedToBeDeleted: false,
+			expectedAge:         "",
+			expectedReason:      reasonNotCreatedBy,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -163,15 +296,220 @@ func TestShouldDeleteResourceGroup(t *testing.T) {
 				tags[creationTimestampTag] = to.StringPtr(tc.creationTimestamp)
 			}
 			if tc.hasDoNotDelete {
-				tags[doNotDeleteTag] = to.StringPtr("test")
+				value := "test"
+				if tc.doNotDeleteValue != "" {
+					value = tc.doNotDeleteValue
+				}
+				tags[doNotDeleteTag] = to.StringPtr(value)
+			}
+			if tc.ttlOverride != "" {
+				tags[ttlOverrideTag] = to.StringPtr(tc.ttlOverride)
+			}
+			if tc.createdBy != "" {
+				tags[defaultCreatedByTag] = to.StringPtr(tc.createdBy)
 			}
 			rg := getResourceGroup(tc.rgName, tags)
-			age, ok := shouldDeleteResourceGroup(&rg, defaultTTL, tc.regex)
-			if ok != tc.expectedToBeDeleted {
-				t.Fatalf("expected %t, but got %t", tc.expectedToBeDeleted, ok)
+			o := &options{
+				ttl:                   defaultTTL,
+				regex:                 tc.regex,
+				protectTagValues:      tc.protectTagValues,
+				createdBySPs:          tc.createdBySPs,
+				createdByTag:          defaultCreatedByTag,
+				disableRegexFullMatch: tc.substringRegex,
+			}
+			// The decision must not depend on what is logged.
+			for _, level := range []string{"debug", "error"} {
+				logger, err := newLogger(io.Discard, logFormatText, level)
+				if err != nil {
+					t.Fatal(err)
+				}
+				decision := shouldDeleteResourceGroup(withLogger(context.Background(), logger), &rg, o)
+				expected := cleanup.Decision{Delete: tc.expectedToBeDeleted, Reason: tc.expectedReason, Age: tc.expectedAge}
+				if decision != expected {
+					t.Fatalf("expected %+v at log level %s, but got %+v", expected, level, decision)
+				}
+			}
+		})
+	}
+}
+
+func TestCompleteSubscriptionIDs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "subscriptions.txt")
+	content := `# dev subscriptions
+sub-2
+
+sub-3 # shared
+  sub-1
+`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	o := &options{subscriptionIDs: []string{"sub-1", "sub-2"}, subscriptionIDsFile: path}
+	if err := o.complete(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{"sub-1", "sub-2", "sub-3"}
+	if fmt.Sprint(o.subscriptionIDs) != fmt.Sprint(expected) {
+		t.Fatalf("expected %v, but got %v", expected, o.subscriptionIDs)
+	}
+
+	o = &options{subscriptionIDsFile: filepath.Join(t.TempDir(), "missing.txt")}
+	if err := o.complete(); err == nil {
+		t.Fatal("expected an error for a missing subscription IDs file")
+	}
+}
+
+func TestShouldDeleteResourceGroupTagKeyFilter(t *testing.T) {
+	fourDaysAgo := time.Now().Add(-defaultTTL - 24*time.Hour).Format(time.RFC3339)
+	o := &options{ttl: defaultTTL, tagKeyFilter: "^ci-run-"}
+
+	rg := getResourceGroup("kubetest-1", map[string]*string{
+		creationTimestampTag: to.StringPtr(fourDaysAgo),
+		"ci-run-42":          to.StringPtr(""),
+	})
+	if !shouldDeleteResourceGroup(context.Background(), &rg, o).Delete {
+		t.Fatal("expected a resource group with a matching tag key to be deleted")
+	}
+
+	rg = getResourceGroup("kubetest-2", map[string]*string{
+		creationTimestampTag: to.StringPtr(fourDaysAgo),
+		"pipeline":           to.StringPtr("ci-run-42"),
+	})
+	if shouldDeleteResourceGroup(context.Background(), &rg, o).Delete {
+		t.Fatal("expected a resource group with only a matching tag value to be kept")
+	}
+}
+
+func TestShouldDeleteResourceGroupHasTag(t *testing.T) {
+	fourDaysAgo := to.StringPtr(time.Now().Add(-defaultTTL - 24*time.Hour).Format(time.RFC3339))
+	testCases := []struct {
+		desc                string
+		tags                map[string]*string
+		hasTags             []string
+		expectedToBeDeleted bool
+		expectedReason      string
+	}{
+		{
+			desc:                "tag with a value",
+			tags:                map[string]*string{creationTimestampTag: fourDaysAgo, "github-workflow": to.StringPtr("e2e")},
+			hasTags:             []string{"github-workflow"},
+			expectedToBeDeleted: true,
+			expectedReason:      reasonTTLElapsed,
+		},
+		{
+			desc:                "tag with an empty value",
+			tags:                map[string]*string{creationTimestampTag: fourDaysAgo, "github-workflow": to.StringPtr("")},
+			hasTags:             []string{"github-workflow"},
+			expectedToBeDeleted: true,
+			expectedReason:      reasonTTLElapsed,
+		},
+		{
+			desc:                "tag names are case-insensitive",
+			tags:                map[string]*string{creationTimestampTag: fourDaysAgo, "GitHub-Workflow": to.StringPtr("e2e")},
+			hasTags:             []string{"github-workflow"},
+			expectedToBeDeleted: true,
+			expectedReason:      reasonTTLElapsed,
+		},
+		{
+			desc:                "missing tag",
+			tags:                map[string]*string{creationTimestampTag: fourDaysAgo, "workflow": to.StringPtr("github-workflow")},
+			hasTags:             []string{"github-workflow"},
+			expectedToBeDeleted: false,
+			expectedReason:      reasonMissingTag,
+		},
+		{
+			desc:                "every tag is required",
+			tags:                map[string]*string{creationTimestampTag: fourDaysAgo, "github-workflow": to.StringPtr("e2e")},
+			hasTags:             []string{"github-workflow", "github-run-id"},
+			expectedToBeDeleted: false,
+			expectedReason:      reasonMissingTag,
+		},
+		{
+			desc:                "required tags do not bypass the TTL",
+			tags:                map[string]*string{creationTimestampTag: to.StringPtr(time.Now().Format(time.RFC3339)), "github-workflow": to.StringPtr("e2e")},
+			hasTags:             []string{"github-workflow"},
+			expectedToBeDeleted: false,
+			expectedReason:      reasonTTLNotElapsed,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			rg := getResourceGroup("rg", tc.tags)
+			decision := shouldDeleteResourceGroup(context.Background(), &rg, &options{ttl: defaultTTL, hasTags: tc.hasTags})
+			if decision.Delete != tc.expectedToBeDeleted {
+				t.Fatalf("expected %t, but got %t", tc.expectedToBeDeleted, decision.Delete)
 			}
-			if age != tc.expectedAge {
-				t.Fatalf("expected the resource group age to be '%s', but got '%s'", tc.expectedAge, age)
+			if decision.Reason != tc.expectedReason {
+				t.Fatalf("expected reason '%s', but got '%s'", tc.expectedReason, decision.Reason)
+			}
+		})
+	}
+}
+
+func TestShouldDeleteResourceGroupMissingTag(t *testing.T) {
+	oneDayAgo := to.StringPtr(time.Now().Add(-24 * time.Hour).Format(time.RFC3339))
+	fourDaysAgo := to.StringPtr(time.Now().Add(-defaultTTL - 24*time.Hour).Format(time.RFC3339))
+	testCases := []struct {
+		desc                string
+		tags                map[string]*string
+		missingTags         []string
+		expectedToBeDeleted bool
+		expectedReason      string
+	}{
+		{
+			desc:                "resource group without a creationTimestamp is assumed to be old",
+			tags:                map[string]*string{"owner": to.StringPtr("alice")},
+			missingTags:         []string{creationTimestampTag},
+			expectedToBeDeleted: true,
+			expectedReason:      reasonNoCreationTimestamp,
+		},
+		{
+			desc:                "resource group with a creationTimestamp",
+			tags:                map[string]*string{creationTimestampTag: fourDaysAgo},
+			missingTags:         []string{creationTimestampTag},
+			expectedToBeDeleted: false,
+			expectedReason:      reasonHasTag,
+		},
+		{
+			desc:                "missing tag does not bypass the TTL",
+			tags:                map[string]*string{creationTimestampTag: oneDayAgo},
+			missingTags:         []string{"owner"},
+			expectedToBeDeleted: false,
+			expectedReason:      reasonTTLNotElapsed,
+		},
+		{
+			desc:                "missing tag and TTL elapsed",
+			tags:                map[string]*string{creationTimestampTag: fourDaysAgo},
+			missingTags:         []string{"owner"},
+			expectedToBeDeleted: true,
+			expectedReason:      reasonTTLElapsed,
+		},
+		{
+			desc:                "every tag must be missing",
+			tags:                map[string]*string{"Owner": to.StringPtr("")},
+			missingTags:         []string{creationTimestampTag, "owner"},
+			expectedToBeDeleted: false,
+			expectedReason:      reasonHasTag,
+		},
+		{
+			desc:                "untagged resource group",
+			missingTags:         []string{creationTimestampTag, "owner"},
+			expectedToBeDeleted: true,
+			expectedReason:      reasonNoCreationTimestamp,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			rg := getResourceGroup("rg", tc.tags)
+			decision := shouldDeleteResourceGroup(context.Background(), &rg, &options{ttl: defaultTTL, missingTags: tc.missingTags})
+			if decision.Delete != tc.expectedToBeDeleted {
+				t.Fatalf("expected %t, but got %t", tc.expectedToBeDeleted, decision.Delete)
+			}
+			if decision.Reason != tc.expectedReason {
+				t.Fatalf("expected reason '%s', but got '%s'", tc.expectedReason, decision.Reason)
 			}
 		})
 	}
@@ -183,3 +521,843 @@ func getResourceGroup(name string, tags map[string]*string) armresources.Resourc
 		Tags: tags,
 	}
 }
+
+// newStaticPager returns a pager that serves the given pages in order.
+func newStaticPager[T any](pages ...T) *runtime.Pager[T] {
+	i := 0
+	return runtime.NewPager(runtime.PagingHandler[T]{
+		More: func(T) bool {
+			return i < len(pages)
+		},
+		Fetcher: func(context.Context, *T) (T, error) {
+			var page T
+			if i < len(pages) {
+				page = pages[i]
+				i++
+			}
+			return page, nil
+		},
+	})
+}
+
+func TestGracePeriod(t *testing.T) {
+	now := time.Now()
+	testCases := []struct {
+		desc           string
+		scheduled      *string
+		dryRun         bool
+		expectedCalls  []string
+		expectedReason string
+	}{
+		{
+			desc:           "the first eligible run schedules the deletion",
+			expectedCalls:  []string{"CreateOrUpdate old-rg"},
+			expectedReason: reasonGracePeriod,
+		},
+		{
+			desc:           "a dry run does not schedule the deletion",
+			dryRun:         true,
+			expectedReason: reasonGracePeriod,
+		},
+		{
+			desc:           "the deletion waits for the grace period",
+			scheduled:      to.StringPtr(now.Add(-23 * time.Hour).UTC().Format(time.RFC3339)),
+			expectedReason: reasonGracePeriod,
+		},
+		{
+			desc:           "the deletion proceeds once the grace period has elapsed",
+			scheduled:      to.StringPtr(now.Add(-25 * time.Hour).UTC().Format(time.RFC3339)),
+			expectedCalls:  []string{"BeginDelete old-rg"},
+			expectedReason: reasonTTLElapsed,
+		},
+		{
+			desc:           "an invalid tag is replaced",
+			scheduled:      to.StringPtr("tomorrow"),
+			expectedCalls:  []string{"CreateOrUpdate old-rg"},
+			expectedReason: reasonGracePeriod,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			tags := map[string]*string{creationTimestampTag: to.StringPtr(reportFourDaysAgo)}
+			if tc.scheduled != nil {
+				tags[scheduledDeletionTag] = tc.scheduled
+			}
+			rg := getResourceGroup("old-rg", tags)
+			c := &fakeResourceGroupsClient{pages: [][]*armresources.ResourceGroup{{&rg}}}
+			result, err := runResourceGroupCleanup(context.Background(), "sub", c, c, fakeResourcesClient{}, &options{ttl: defaultTTL, gracePeriod: 24 * time.Hour, dryRun: tc.dryRun})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(c.calls, tc.expectedCalls) {
+				t.Fatalf("expected calls %v, but got %v", tc.expectedCalls, c.calls)
+			}
+			if reason := result.resourceGroups[0].Reason; reason != tc.expectedReason {
+				t.Fatalf("expected reason %s, but got %s", tc.expectedReason, reason)
+			}
+			if len(c.updates) > 0 {
+				scheduled, err := time.Parse(time.RFC3339, *c.updates[0].Tags[scheduledDeletionTag])
+				if err != nil || *c.updates[0].Tags[creationTimestampTag] != reportFourDaysAgo {
+					t.Fatalf("expected the existing tags and a '%s' timestamp, but got %v", scheduledDeletionTag, c.updates[0].Tags)
+				}
+				if scheduled.Before(now.Add(-time.Minute)) {
+					t.Fatalf("expected the deletion to be scheduled now, but got %s", scheduled)
+				}
+			}
+		})
+	}
+}
+
+type fakeResourceGroupsClient struct {
+	pages     [][]*armresources.ResourceGroup
+	deleted   []string
+	deleteErr error
+	// deleteErrs holds the error of BeginDelete by resource group name,
+	// in addition to deleteErr.
+	deleteErrs map[string]error
+	// calls lists the BeginDelete and CreateOrUpdate calls in order, and
+	// updates the parameters of the CreateOrUpdate calls.
+	calls   []string
+	updates []armresources.ResourceGroup
+}
+
+func (c *fakeResourceGroupsClient) NewListPager(*armresources.ResourceGroupsClientListOptions) *runtime.Pager[armresources.ResourceGroupsClientListResponse] {
+	var pages []armresources.ResourceGroupsClientListResponse
+	for _, page := range c.pages {
+		pages = append(pages, armresources.ResourceGroupsClientListResponse{
+			ResourceGroupListResult: armresources.ResourceGroupListResult{Value: page},
+		})
+	}
+	return newStaticPager(pages...)
+}
+
+func (c *fakeResourceGroupsClient) BeginDelete(_ context.Context, name string, _ *armresources.ResourceGroupsClientBeginDeleteOptions) (*runtime.Poller[armresources.ResourceGroupsClientDeleteResponse], error) {
+	c.calls = append(c.calls, "BeginDelete "+name)
+	if c.deleteErr != nil {
+		return nil, c.deleteErr
+	}
+	if err := c.deleteErrs[name]; err != nil {
+		return nil, err
+	}
+	c.deleted = append(c.deleted, name)
+	return nil, nil
+}
+
+func (c *fakeResourceGroupsClient) CreateOrUpdate(_ context.Context, name string, parameters armresources.ResourceGroup, _ *armresources.ResourceGroupsClientCreateOrUpdateOptions) (armresources.ResourceGroupsClientCreateOrUpdateResponse, error) {
+	c.calls = append(c.calls, "CreateOrUpdate "+name)
+	c.updates = append(c.updates, parameters)
+	return armresources.ResourceGroupsClientCreateOrUpdateResponse{ResourceGroup: parameters}, nil
+}
+
+// fakeResourcesClient maps resource group names to the number of resources
+// they contain.
+type fakeResourcesClient map[string]int
+
+func (c fakeResourcesClient) NewListByResourceGroupPager(resourceGroupName string, _ *armresources.ClientListByResourceGroupOptions) *runtime.Pager[armresources.ClientListByResourceGroupResponse] {
+	var resources []*armresources.GenericResourceExpanded
+	for i := 0; i < c[resourceGroupName]; i++ {
+		resources = append(resources, &armresources.GenericResourceExpanded{Name: to.StringPtr(fmt.Sprintf("resource-%d", i))})
+	}
+	return newStaticPager(armresources.ClientListByResourceGroupResponse{
+		ResourceListResult: armresources.ResourceListResult{Value: resources},
+	})
+}
+
+func TestRunResourceGroupCleanup(t *testing.T) {
+	fourDaysAgo := time.Now().Add(-defaultTTL - 24*time.Hour).Format(time.RFC3339)
+	oneDayAgo := time.Now().Add(-24 * time.Hour).Format(time.RFC3339)
+	testCases := []struct {
+		desc                 string
+		dryRun               bool
+		ignoreDeletionErrors bool
+		expectedCalls        []string
+		expectedDeleted      int
+		expectedFailed       int
+		expectedErr          string
+	}{
+		{
+			desc:            "resource groups on every page are deleted",
+			expectedCalls:   []string{"BeginDelete old-1", "BeginDelete old-2", "BeginDelete locked"},
+			expectedDeleted: 2,
+			expectedFailed:  1,
+			expectedErr:     "failed to delete 1 resource groups: locked: scope locked",
+		},
+		{
+			desc:                 "failed deletions are ignored",
+			ignoreDeletionErrors: true,
+			expectedCalls:        []string{"BeginDelete old-1", "BeginDelete old-2", "BeginDelete locked"},
+			expectedDeleted:      2,
+			expectedFailed:       1,
+		},
+		{
+			desc:   "dry run",
+			dryRun: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			old1 := getResourceGroup("old-1", map[string]*string{creationTimestampTag: to.StringPtr(fourDaysAgo)})
+			young := getResourceGroup("young", map[string]*string{creationTimestampTag: to.StringPtr(oneDayAgo)})
+			protected := getResourceGroup("protected", map[string]*string{doNotDeleteTag: to.StringPtr("")})
+			old2 := getResourceGroup("old-2", map[string]*string{creationTimestampTag: to.StringPtr(fourDaysAgo)})
+			locked := getResourceGroup("locked", map[string]*string{creationTimestampTag: to.StringPtr(fourDaysAgo)})
+			c := &fakeResourceGroupsClient{
+				pages:      [][]*armresources.ResourceGroup{{&old1, &young}, {&protected}, {&old2, &locked}},
+				deleteErrs: map[string]error{"locked": errors.New("scope locked")},
+			}
+
+			result, err := runResourceGroupCleanup(context.Background(), "sub", c, c, fakeResourcesClient{}, &options{ttl: defaultTTL, dryRun: tc.dryRun, ignoreDeletionErrors: tc.ignoreDeletionErrors})
+			if tc.expectedErr == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tc.expectedErr != "" && (err == nil || err.Error() != tc.expectedErr) {
+				t.Fatalf("expected error %q, but got %v", tc.expectedErr, err)
+			}
+			if !reflect.DeepEqual(c.calls, tc.expectedCalls) {
+				t.Fatalf("expected calls %v, but got %v", tc.expectedCalls, c.calls)
+			}
+			if result.scanned != 5 || result.eligible != 3 {
+				t.Fatalf("expected 5 scanned and 3 eligible resource groups, but got %d and %d", result.scanned, result.eligible)
+			}
+			if result.deleted != tc.expectedDeleted || result.failed != tc.expectedFailed {
+				t.Fatalf("expected %d deleted and %d failed, but got %d and %d", tc.expectedDeleted, tc.expectedFailed, result.deleted, result.failed)
+			}
+			expectedSkipped := map[string]int{reasonTTLNotElapsed: 1, reasonProtected: 1}
+			if !reflect.DeepEqual(result.skipped, expectedSkipped) {
+				t.Fatalf("expected skipped %v, but got %v", expectedSkipped, result.skipped)
+			}
+		})
+	}
+}
+
+func TestRunResultAdd(t *testing.T) {
+	total := &runResult{skipped: map[string]int{reasonProtected: 1}}
+	total.add(&runResult{scanned: 3, eligible: 2, deleted: 1, failed: 1, skipped: map[string]int{reasonProtected: 1}})
+	total.add(&runResult{scanned: 2, eligible: 1, deleted: 1, skipped: map[string]int{reasonTTLNotElapsed: 1}})
+
+	expected := &runResult{scanned: 5, eligible: 3, deleted: 2, failed: 1, skipped: map[string]int{reasonProtected: 2, reasonTTLNotElapsed: 1}}
+	if !reflect.DeepEqual(total, expected) {
+		t.Fatalf("expected %+v, but got %+v", expected, total)
+	}
+}
+
+func TestCheckConfirmation(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		name        string
+		expected    string
+		value       string
+		dryRun      bool
+		expectedErr bool
+	}{
+		{
+			desc: "no confirmation required",
+		},
+		{
+			desc:     "variable matches",
+			name:     "RG_CLEANUP_ENVIRONMENT",
+			expected: "staging",
+			value:    "staging",
+		},
+		{
+			desc:        "variable does not match",
+			name:        "RG_CLEANUP_ENVIRONMENT",
+			expected:    "staging",
+			value:       "production",
+			expectedErr: true,
+		},
+		{
+			desc:        "variable is not set",
+			name:        "RG_CLEANUP_ENVIRONMENT",
+			expected:    "staging",
+			expectedErr: true,
+		},
+		{
+			desc:     "dry run does not need confirmation",
+			name:     "RG_CLEANUP_ENVIRONMENT",
+			expected: "staging",
+			value:    "production",
+			dryRun:   true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Setenv("RG_CLEANUP_ENVIRONMENT", tc.value)
+			o := &options{confirmationEnvName: tc.name, confirmationEnvValue: tc.expected, dryRun: tc.dryRun}
+			if err := o.checkConfirmation(); tc.expectedErr != (err != nil) {
+				t.Fatalf("expected error to be %v, but got %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestShouldDeleteResourceGroupCostTag(t *testing.T) {
+	fourDaysAgo := time.Now().Add(-defaultTTL - 24*time.Hour).Format(time.RFC3339)
+	testCases := []struct {
+		desc                string
+		cost                *string
+		expectedToBeDeleted bool
+		expectedReason      string
+	}{
+		{
+			desc:                "tag present above threshold",
+			cost:                to.StringPtr("750.50"),
+			expectedToBeDeleted: false,
+			expectedReason:      reasonCostAboveThreshold,
+		},
+		{
+			desc:                "tag present below threshold",
+			cost:                to.StringPtr("499.99"),
+			expectedToBeDeleted: true,
+			expectedReason:      reasonTTLElapsed,
+		},
+		{
+			desc:                "tag present at threshold",
+			cost:                to.StringPtr("500"),
+			expectedToBeDeleted: true,
+			expectedReason:      reasonTTLElapsed,
+		},
+		{
+			desc:                "tag absent",
+			expectedToBeDeleted: true,
+			expectedReason:      reasonTTLElapsed,
+		},
+		{
+			desc:                "tag not a number",
+			cost:                to.StringPtr("expensive"),
+			expectedToBeDeleted: false,
+			expectedReason:      reasonInvalidCostTag,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			tags := map[string]*string{creationTimestampTag: to.StringPtr(fourDaysAgo)}
+			if tc.cost != nil {
+				tags["estimated-monthly-cost"] = tc.cost
+			}
+			rg := getResourceGroup("kubetest-123", tags)
+			o := &options{ttl: defaultTTL, costTag: "estimated-monthly-cost", costThreshold: 500}
+			decision := shouldDeleteResourceGroup(context.Background(), &rg, o)
+			if decision.Delete != tc.expectedToBeDeleted || decision.Reason != tc.expectedReason {
+				t.Fatalf("expected %t (%s), but got %t (%s)", tc.expectedToBeDeleted, tc.expectedReason, decision.Delete, decision.Reason)
+			}
+		})
+	}
+}
+
+func TestLoadAzurePipelinesEnv(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		env      map[string]string
+		o        options
+		expected options
+	}{
+		{
+			desc: "Azure Pipelines variables override the usual ones",
+			env: map[string]string{
+				azureClientIDEnvVar:       "ado-client",
+				azureClientSecretEnvVar:   "ado-secret",
+				azureTenantIDEnvVar:       "ado-tenant",
+				azureSubscriptionIDEnvVar: "ado-sub",
+			},
+			o:        options{clientID: "client", clientSecret: "secret", tenantID: "tenant"},
+			expected: options{clientID: "ado-client", clientSecret: "ado-secret", tenantID: "ado-tenant", subscriptionIDs: []string{"ado-sub"}},
+		},
+		{
+			desc:     "absent Azure Pipelines variables fall back to the usual ones",
+			o:        options{clientID: "client", clientSecret: "secret", tenantID: "tenant"},
+			expected: options{clientID: "client", clientSecret: "secret", tenantID: "tenant"},
+		},
+		{
+			desc:     "--subscription-id wins over the Azure Pipelines variable",
+			env:      map[string]string{azureSubscriptionIDEnvVar: "ado-sub"},
+			o:        options{subscriptionIDs: []string{"flag-sub"}},
+			expected: options{subscriptionIDs: []string{"flag-sub"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			for _, name := range []string{azureClientIDEnvVar, azureClientSecretEnvVar, azureTenantIDEnvVar, azureSubscriptionIDEnvVar} {
+				t.Setenv(name, tc.env[name])
+			}
+			o := tc.o
+			o.loadAzurePipelinesEnv()
+			if !reflect.DeepEqual(o, tc.expected) {
+				t.Fatalf("expected %+v, but got %+v", tc.expected, o)
+			}
+		})
+	}
+}
+
+func TestLoadWorkloadIdentityEnv(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		env      map[string]string
+		o        options
+		expected options
+	}{
+		{
+			desc: "workload identity variables override the usual ones",
+			env: map[string]string{
+				workloadIdentityTokenFileEnvVar: "/var/run/secrets/azure/tokens/azure-identity-token",
+				azureClientIDEnvVar:             "wi-client",
+				azureTenantIDEnvVar:             "wi-tenant",
+			},
+			o:        options{clientID: "client", clientSecret: "secret", tenantID: "tenant"},
+			expected: options{clientID: "wi-client", clientSecret: "secret", tenantID: "wi-tenant", federatedTokenFile: "/var/run/secrets/azure/tokens/azure-identity-token"},
+		},
+		{
+			desc:     "absent workload identity variables fall back to the usual ones",
+			env:      map[string]string{workloadIdentityTokenFileEnvVar: "/token"},
+			o:        options{clientID: "client", tenantID: "tenant"},
+			expected: options{clientID: "client", tenantID: "tenant", federatedTokenFile: "/token"},
+		},
+		{
+			desc:     "standard variables are ignored without a federated token file",
+			env:      map[string]string{azureClientIDEnvVar: "wi-client", azureTenantIDEnvVar: "wi-tenant"},
+			o:        options{clientID: "client", clientSecret: "secret", tenantID: "tenant"},
+			expected: options{clientID: "client", clientSecret: "secret", tenantID: "tenant"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			for _, name := range []string{workloadIdentityTokenFileEnvVar, azureClientIDEnvVar, azureTenantIDEnvVar} {
+				t.Setenv(name, tc.env[name])
+			}
+			o := tc.o
+			o.loadWorkloadIdentityEnv()
+			if !reflect.DeepEqual(o, tc.expected) {
+				t.Fatalf("expected %+v, but got %+v", tc.expected, o)
+			}
+		})
+	}
+}
+
+func TestMinimumRGsToKeep(t *testing.T) {
+	fourDaysAgo := time.Now().Add(-defaultTTL - 24*time.Hour).Format(time.RFC3339)
+	newResourceGroup := func(name string, tags map[string]*string) *armresources.ResourceGroup {
+		rg := getResourceGroup(name, tags)
+		return &rg
+	}
+	testCases := []struct {
+		desc             string
+		minimumRGsToKeep int
+		expectedDeleted  []string
+		expectedErr      bool
+	}{
+		{
+			desc:            "disabled",
+			expectedDeleted: []string{"old-1", "old-2"},
+		},
+		{
+			desc:             "enough resource groups remain",
+			minimumRGsToKeep: 1,
+			expectedDeleted:  []string{"old-1", "old-2"},
+		},
+		{
+			desc:             "too few resource groups would remain",
+			minimumRGsToKeep: 2,
+			expectedErr:      true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			r := &fakeResourceGroupsClient{
+				pages: [][]*armresources.ResourceGroup{
+					{newResourceGroup("old-1", map[string]*string{creationTimestampTag: to.StringPtr(fourDaysAgo)})},
+					{newResourceGroup("old-2", map[string]*string{creationTimestampTag: to.StringPtr(fourDaysAgo)}), newResourceGroup("busy", map[string]*string{creationTimestampTag: to.StringPtr(fourDaysAgo)})},
+				},
+			}
+			// busy is kept by --min-resource-count, so it counts as remaining.
+			o := &options{ttl: defaultTTL, minResourceCount: 3, minimumRGsToKeep: tc.minimumRGsToKeep}
+			_, err := runResourceGroupCleanup(context.Background(), "sub", r, r, fakeResourcesClient{"busy": 3}, o)
+			if tc.expectedErr != (err != nil) {
+				t.Fatalf("expected error to be %v, but got %v", tc.expectedErr, err)
+			}
+			if fmt.Sprint(r.deleted) != fmt.Sprint(tc.expectedDeleted) {
+				t.Fatalf("expected %v to be deleted, but got %v", tc.expectedDeleted, r.deleted)
+			}
+		})
+	}
+}
+
+func TestMinimumRGsToKeepResourceType(t *testing.T) {
+	fourDaysAgo := time.Now().Add(-defaultTTL - 24*time.Hour).Format(time.RFC3339)
+	var rgs []*armresources.ResourceGroup
+	for _, name := range []string{"old-1", "old-2", "vault"} {
+		rg := getResourceGroup(name, map[string]*string{creationTimestampTag: to.StringPtr(fourDaysAgo)})
+		rgs = append(rgs, &rg)
+	}
+	r := &fakeResourceGroupsClient{pages: [][]*armresources.ResourceGroup{rgs}}
+	resources := fakeTypedResourcesClient{"vault": {"Microsoft.KeyVault/vaults"}}
+	// vault is kept by --skip-rg-with-resource-type, so it counts as
+	// remaining.
+	o := &options{ttl: defaultTTL, skipResourceTypes: []string{"Microsoft.KeyVault/vaults"}, minimumRGsToKeep: 1}
+	if _, err := runResourceGroupCleanup(context.Background(), "sub", r, r, resources, o); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{"old-1", "old-2"}
+	if fmt.Sprint(r.deleted) != fmt.Sprint(expected) {
+		t.Fatalf("expected %v to be deleted, but got %v", expected, r.deleted)
+	}
+}
+
+func TestValidateScopeLevel(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		o           options
+		expectedErr bool
+	}{
+		{
+			desc: "subscription",
+			o:    options{scopeLevel: scopeLevelSubscription, managedIdentities: true, classicAdministrators: true},
+		},
+		{
+			desc: "management group",
+			o:    options{scopeLevel: scopeLevelManagementGroup},
+		},
+		{
+			desc: "resource group with a regex",
+			o:    options{scopeLevel: scopeLevelResourceGroup, regex: "^ci-.+"},
+		},
+		{
+			desc:        "resource group without a regex",
+			o:           options{scopeLevel: scopeLevelResourceGroup},
+			expectedErr: true,
+		},
+		{
+			desc:        "resource group with subscription-scoped cleanups",
+			o:           options{scopeLevel: scopeLevelResourceGroup, regex: "^ci-.+", classicAdministrators: true},
+			expectedErr: true,
+		},
+		{
+			desc:        "unknown scope level",
+			o:           options{scopeLevel: "tenant"},
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := tc.o.validateScopeLevel()
+			if tc.expectedErr != (err != nil) {
+				t.Fatalf("expected error to be %v, but got %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateLease(t *testing.T) {
+	testCases := []struct {
+		desc          string
+		duration      time.Duration
+		renewInterval time.Duration
+		expectedErr   bool
+	}{
+		{
+			desc:          "defaults",
+			duration:      defaultLeaseDuration,
+			renewInterval: defaultLeaseRenewInterval,
+		},
+		{
+			desc:          "renew interval just under half of the duration",
+			duration:      30 * time.Second,
+			renewInterval: 15*time.Second - time.Millisecond,
+		},
+		{
+			desc:          "renew interval of half of the duration",
+			duration:      30 * time.Second,
+			renewInterval: 15 * time.Second,
+			expectedErr:   true,
+		},
+		{
+			desc:          "renew interval longer than the duration",
+			duration:      30 * time.Second,
+			renewInterval: 40 * time.Second,
+			expectedErr:   true,
+		},
+		{
+			desc:          "zero renew interval",
+			duration:      30 * time.Second,
+			renewInterval: 0,
+			expectedErr:   true,
+		},
+		{
+			desc:          "duration too short",
+			duration:      10 * time.Second,
+			renewInterval: time.Second,
+			expectedErr:   true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			o := options{leaseBlobURL: "https://account.blob.core.windows.net/leases/rg-cleanup", watch: true, leaseDuration: tc.duration, leaseRenewInterval: tc.renewInterval}
+			err := o.validateLease()
+			if tc.expectedErr != (err != nil) {
+				t.Fatalf("expected error to be %v, but got %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateDeleteHook(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		script      string
+		timeout     time.Duration
+		expectedErr bool
+	}{
+		{
+			desc: "no hook",
+		},
+		{
+			desc:    "executable",
+			script:  "/usr/local/bin/check-ticket",
+			timeout: defaultDeleteHookTimeout,
+		},
+		{
+			desc:        "executable with arguments",
+			script:      "/usr/local/bin/check-ticket --strict",
+			timeout:     defaultDeleteHookTimeout,
+			expectedErr: true,
+		},
+		{
+			desc:        "shell command",
+			script:      "sh -c 'exit 0'",
+			timeout:     defaultDeleteHookTimeout,
+			expectedErr: true,
+		},
+		{
+			desc:        "zero timeout",
+			script:      "/usr/local/bin/check-ticket",
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := validateDeleteHook("pre-delete-hook", tc.script, tc.timeout)
+			if tc.expectedErr != (err != nil) {
+				t.Fatalf("expected error to be %v, but got %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestForEachSubscription(t *testing.T) {
+	subscriptionIDs := []string{"sub-1", "sub-2", "sub-3", "sub-4", "sub-5"}
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	var called []string
+	errs := forEachSubscription(context.Background(), subscriptionIDs, 2, func(_ context.Context, subscriptionID string) error {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		called = append(called, subscriptionID)
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		if subscriptionID == "sub-2" || subscriptionID == "sub-4" {
+			return fmt.Errorf("failed to list %s", subscriptionID)
+		}
+		return nil
+	})
+
+	if len(called) != len(subscriptionIDs) {
+		t.Fatalf("expected every subscription to be cleaned up, but got %v", called)
+	}
+	if maxRunning != 2 {
+		t.Fatalf("expected 2 subscriptions to be cleaned up at a time, but got %d", maxRunning)
+	}
+	expected := "2 subscriptions failed: sub-2: failed to list sub-2; sub-4: failed to list sub-4"
+	if err := subscriptionsError(errs); err.Error() != expected {
+		t.Fatalf("expected error %q, but got %q", expected, err)
+	}
+}
+
+func TestForEachSubscriptionCancellation(t *testing.T) {
+	before := goruntime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{}, 10)
+	done := make(chan map[string]error)
+	go func() {
+		done <- forEachSubscription(ctx, []string{"sub-1", "sub-2", "sub-3", "sub-4", "sub-5"}, 2, func(ctx context.Context, _ string) error {
+			started <- struct{}{}
+			<-ctx.Done()
+			return ctx.Err()
+		})
+	}()
+	<-started
+	<-started
+	cancel()
+
+	var errs map[string]error
+	select {
+	case errs = <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("expected the workers to stop once the context is canceled")
+	}
+	if len(started) != 0 {
+		t.Fatalf("expected no subscription to be started after the cancellation")
+	}
+	if len(errs) != 5 {
+		t.Fatalf("expected every subscription to fail with the context error, but got %v", errs)
+	}
+	for subscriptionID, err := range errs {
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected %s to fail with %v, but got %v", subscriptionID, context.Canceled, err)
+		}
+	}
+	// Give the goroutine calling forEachSubscription time to exit.
+	deadline := time.Now().Add(5 * time.Second)
+	for goruntime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after := goruntime.NumGoroutine(); after > before {
+		t.Fatalf("expected no leaked goroutines, but got %d goroutines instead of %d", after, before)
+	}
+}
+
+func TestTagOnDelete(t *testing.T) {
+	testCases := []struct {
+		desc          string
+		deleteErr     error
+		expectedCalls []string
+	}{
+		{
+			desc:          "the tag is set before the deletion",
+			expectedCalls: []string{"CreateOrUpdate old-rg", "BeginDelete old-rg"},
+		},
+		{
+			desc:          "the tag is removed when the deletion fails",
+			deleteErr:     errors.New("scope locked"),
+			expectedCalls: []string{"CreateOrUpdate old-rg", "BeginDelete old-rg", "CreateOrUpdate old-rg"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			rg := getResourceGroup("old-rg", map[string]*string{creationTimestampTag: to.StringPtr(reportFourDaysAgo)})
+			rg.Location = to.StringPtr("westus2")
+			c := &fakeResourceGroupsClient{pages: [][]*armresources.ResourceGroup{{&rg}}, deleteErr: tc.deleteErr}
+			if _, err := runResourceGroupCleanup(context.Background(), "sub", c, c, fakeResourcesClient{}, &options{ttl: defaultTTL, tagOnDelete: true, ignoreDeletionErrors: true}); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(c.calls, tc.expectedCalls) {
+				t.Fatalf("expected calls %v, but got %v", tc.expectedCalls, c.calls)
+			}
+			tagged := c.updates[0]
+			if *tagged.Location != "westus2" || tagged.Tags[deletionInProgressTag] == nil || *tagged.Tags[creationTimestampTag] != reportFourDaysAgo {
+				t.Fatalf("expected the existing tags and a '%s' tag, but got %+v", deletionInProgressTag, tagged)
+			}
+			if _, err := time.Parse(time.RFC3339, *tagged.Tags[deletionInProgressTag]); err != nil {
+				t.Fatalf("expected the '%s' tag to be a timestamp: %v", deletionInProgressTag, err)
+			}
+			if tc.deleteErr != nil {
+				if restored := c.updates[1]; restored.Tags[deletionInProgressTag] != nil || len(restored.Tags) != 1 {
+					t.Fatalf("expected the '%s' tag to be removed, but got %v", deletionInProgressTag, restored.Tags)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateSettingsDryRunCount(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		o           options
+		expectedErr bool
+	}{
+		{
+			desc: "alone",
+			o:    options{dryRunCount: true},
+		},
+		{
+			desc:        "with --output",
+			o:           options{dryRunCount: true, output: outputJSON},
+			expectedErr: true,
+		},
+		{
+			desc:        "with --interval",
+			o:           options{dryRunCount: true, interval: time.Hour},
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			o := tc.o
+			o.command, o.scopeLevel, o.subscriptionIDs = commandAll, scopeLevelSubscription, []string{"sub"}
+			o.policyEffect, o.concurrentSubscriptions, o.stuckAfter = policyEffectAudit, 1, 1
+			if err := o.complete(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !o.dryRun {
+				t.Fatal("expected --dry-run-count to imply --dry-run")
+			}
+			if err := o.validateSettings(); (err != nil) != tc.expectedErr {
+				t.Fatalf("expected error to be %t, but got %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestFilterProvisioningState(t *testing.T) {
+	fourDaysAgo := time.Now().Add(-defaultTTL - 24*time.Hour).Format(time.RFC3339)
+	newResourceGroup := func(name, state string) *armresources.ResourceGroup {
+		rg := getResourceGroup(name, map[string]*string{creationTimestampTag: to.StringPtr(fourDaysAgo)})
+		rg.Properties = &armresources.ResourceGroupProperties{ProvisioningState: to.StringPtr(state)}
+		return &rg
+	}
+	testCases := []struct {
+		desc            string
+		states          []string
+		expectedDeleted []string
+	}{
+		{
+			desc:            "default",
+			states:          defaultProvisioningStates,
+			expectedDeleted: []string{"ready"},
+		},
+		{
+			desc:            "failed included",
+			states:          []string{"succeeded", "failed"},
+			expectedDeleted: []string{"ready", "failed"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			r := &fakeResourceGroupsClient{
+				pages: [][]*armresources.ResourceGroup{{newResourceGroup("ready", "Succeeded"), newResourceGroup("failed", "Failed"), newResourceGroup("updating", "Updating")}},
+			}
+			o := &options{ttl: defaultTTL, provisioningStates: tc.states}
+			result, err := runResourceGroupCleanup(context.Background(), "sub", r, r, fakeResourcesClient{}, o)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if fmt.Sprint(r.deleted) != fmt.Sprint(tc.expectedDeleted) {
+				t.Fatalf("expected %v to be deleted, but got %v", tc.expectedDeleted, r.deleted)
+			}
+			if skipped := 3 - len(tc.expectedDeleted); result.skipped[reasonProvisioningState] != skipped {
+				t.Fatalf("expected %d resource groups to be skipped for their provisioning state, but got %v", skipped, result.skipped)
+			}
+		})
+	}
+}
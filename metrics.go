@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+const (
+	metricsNamespace      = "rg_cleanup"
+	pushgatewayJob        = "rg_cleanup"
+	defaultMetricsAddress = ":8080"
+	// maxCycleAgeFactor is how many poll intervals may pass after the last
+	// completed cycle before /readyz fails in --watch mode, which leaves room
+	// for a slow or failed cycle.
+	maxCycleAgeFactor = 3
+)
+
+// runMetrics are the metrics describing the last run in a subscription. They
+// are partitioned by labels, which is empty when pushing to a Pushgateway
+// since the subscription is part of the grouping key there.
+type runMetrics struct {
+	labels               []string
+	runDurationSeconds   *prometheus.GaugeVec
+	rgsScanned           *prometheus.GaugeVec
+	rgsDeleted           *prometheus.GaugeVec
+	rgsFailed            *prometheus.GaugeVec
+	rgsSkipped           *prometheus.GaugeVec
+	lastSuccessTimestamp *prometheus.GaugeVec
+}
+
+func newRunMetrics(registry prometheus.Registerer, labels ...string) *runMetrics {
+	newGaugeVec := func(name, help string, labels ...string) *prometheus.GaugeVec {
+		g := prometheus.NewGaugeVec(prometheus.GaugeOpts{Namespace: metricsNamespace, Name: name, Help: help}, labels)
+		registry.MustRegister(g)
+		return g
+	}
+	return &runMetrics{
+		labels:               labels,
+		runDurationSeconds:   newGaugeVec("run_duration_seconds", "How long the last run took.", labels...),
+		rgsScanned:           newGaugeVec("rgs_scanned", "Resource groups scanned in the last run.", labels...),
+		rgsDeleted:           newGaugeVec("rgs_deleted", "Resource groups whose deletion was started in the last run.", labels...),
+		rgsFailed:            newGaugeVec("rgs_failed", "Resource groups that failed to be deleted in the last run.", labels...),
+		rgsSkipped:           newGaugeVec("rgs_skipped", "Resource groups that were not deleted in the last run, by reason.", append(labels, "reason")...),
+		lastSuccessTimestamp: newGaugeVec("last_success_timestamp", "Unix time of the last successful run.", labels...),
+	}
+}
+
+// observe records a run. result is nil when the run failed, in which case only
+// the duration is updated.
+func (m *runMetrics) observe(result *runResult, duration time.Duration, now time.Time, labelValues ...string) {
+	m.runDurationSeconds.WithLabelValues(labelValues...).Set(duration.Seconds())
+	if result == nil {
+		return
+	}
+	m.rgsScanned.WithLabelValues(labelValues...).Set(float64(result.scanned))
+	m.rgsDeleted.WithLabelValues(labelValues...).Set(float64(result.deleted))
+	m.rgsFailed.WithLabelValues(labelValues...).Set(float64(result.failed))
+	// Drop the reasons of the previous run that did not occur this time.
+	partial := prometheus.Labels{}
+	for i, label := range m.labels {
+		partial[label] = labelValues[i]
+	}
+	m.rgsSkipped.DeletePartialMatch(partial)
+	for reason, count := range result.skipped {
+		m.rgsSkipped.WithLabelValues(append(labelValues, reason)...).Set(float64(count))
+	}
+	m.lastSuccessTimestamp.WithLabelValues(labelValues...).Set(float64(now.Unix()))
+}
+
+// pushMetrics pushes the metrics of the run in subscriptionID to the
+// Prometheus Pushgateway at url, grouped by subscription. result is nil when
+// the run failed, in which case only the duration is pushed and the last
+// success timestamp from a previous run is left untouched.
+func pushMetrics(url, subscriptionID string, result *runResult, duration time.Duration, now time.Time) error {
+	registry := prometheus.NewRegistry()
+	newRunMetrics(registry).observe(result, duration, now)
+
+	// Add rather than Push, so that a failed run does not wipe the last
+	// success timestamp.
+	if err := push.New(url, pushgatewayJob).Grouping("subscription_id", subscriptionID).Gatherer(registry).Add(); err != nil {
+		return fmt.Errorf("failed to push metrics to %s: %v", url, err)
+	}
+	return nil
+}
+
+// metricsServer serves /metrics, /healthz, /readyz and /status while
+// rg-cleanup runs with --serve-metrics or --serve-addr.
+type metricsServer struct {
+	metrics  *runMetrics
+	progress *progressMetrics
+	handler  http.Handler
+	// ready is set once the credential has been checked and, in --watch
+	// mode, the first cleanup cycle has completed.
+	ready atomic.Bool
+	// standby is set while another replica holds the lease of
+	// --lease-blob-url.
+	standby atomic.Bool
+	// maxCycleAge is how long after the last completed cycle /readyz keeps
+	// returning 200. It is 0 outside of --watch mode.
+	maxCycleAge time.Duration
+	// lastCycle is the Unix time in nanoseconds at which the last cycle
+	// completed.
+	lastCycle atomic.Int64
+	// status is the summary of the last run, served on /status.
+	status atomic.Pointer[runSummary]
+}
+
+func newMetricsServer() *metricsServer {
+	registry := prometheus.NewRegistry()
+	s := &metricsServer{metrics: newRunMetrics(registry, "subscription_id"), progress: newProgressMetrics(registry)}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, _ *http.Request) {
+		if s.standby.Load() {
+			// A standby replica is healthy and ready to take over.
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("standby"))
+			return
+		}
+		if !s.ready.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if age := time.Since(time.Unix(0, s.lastCycle.Load())); s.maxCycleAge > 0 && age > s.maxCycleAge {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w, "the last cycle completed %s ago", age.Round(time.Second))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, _ *http.Request) {
+		summary := s.status.Load()
+		if summary == nil {
+			http.Error(w, "no run has completed yet", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(summary); err != nil {
+			slog.Error("Error when writing the run status", "error", err)
+		}
+	})
+	s.handler = mux
+	return s
+}
+
+// recordRun records the summary of a run that completed at now, which makes
+// the server ready.
+func (s *metricsServer) recordRun(summary *runSummary, now time.Time) {
+	s.status.Store(summary)
+	s.lastCycle.Store(now.UnixNano())
+	s.ready.Store(true)
+}
+
+// setStandby records whether this replica stands by for the lease of
+// --lease-blob-url. It does nothing on a nil *metricsServer.
+func (s *metricsServer) setStandby(standby bool) {
+	if s != nil {
+		s.standby.Store(standby)
+	}
+}
+
+// errServerFailed is the cause of the cancellation of the context returned by
+// serveInBackground when the server fails.
+var errServerFailed = errors.New("the metrics server failed")
+
+// serveInBackground serves s on address until ctx is done or stop is called,
+// which waits for the server to shut down. If the server fails, e.g. because
+// address is in use, the error is logged and the returned context is
+// canceled with errServerFailed, so that the run stops and exits through the
+// usual path, flushing its report and releasing its lease.
+func (s *metricsServer) serveInBackground(ctx context.Context, address string) (_ context.Context, stop func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		if err := s.serve(ctx, address); err != nil {
+			slog.Error("Error when serving metrics", "error", err)
+			cancel(errServerFailed)
+		}
+	}()
+	return ctx, func() {
+		cancel(nil)
+		<-served
+	}
+}
+
+// serve listens on address until ctx is done, then shuts the server down and
+// returns once the requests in flight have been served.
+func (s *metricsServer) serve(ctx context.Context, address string) error {
+	srv := &http.Server{Addr: address, Handler: s.handler}
+	shutDown := make(chan struct{})
+	go func() {
+		defer close(shutDown)
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			slog.Error("Error when shutting down the metrics server", "error", err)
+		}
+	}()
+
+	slog.Info(fmt.Sprintf("Serving metrics on %s", address))
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	<-shutDown
+	return nil
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+func TestPushMetrics(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	testCases := []struct {
+		desc            string
+		result          *runResult
+		expectedMetrics map[string]float64
+	}{
+		{
+			desc: "successful run",
+			result: &runResult{
+				scanned: 10,
+				deleted: 2,
+				failed:  1,
+				skipped: map[string]int{reasonProtected: 3, reasonTTLNotElapsed: 4},
+			},
+			expectedMetrics: map[string]float64{
+				"rg_cleanup_run_duration_seconds":   5,
+				"rg_cleanup_rgs_scanned":            10,
+				"rg_cleanup_rgs_deleted":            2,
+				"rg_cleanup_rgs_failed":             1,
+				"rg_cleanup_rgs_skipped":            7,
+				"rg_cleanup_last_success_timestamp": 1700000000,
+			},
+		},
+		{
+			desc: "failed run",
+			expectedMetrics: map[string]float64{
+				"rg_cleanup_run_duration_seconds": 5,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			var method, path string
+			got := map[string]float64{}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				method, path = r.Method, r.URL.Path
+				decoder := expfmt.NewDecoder(r.Body, expfmt.ResponseFormat(r.Header))
+				for {
+					var family dto.MetricFamily
+					if err := decoder.Decode(&family); err != nil {
+						break
+					}
+					for _, m := range family.Metric {
+						got[family.GetName()] += m.GetGauge().GetValue()
+					}
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer server.Close()
+
+			if err := pushMetrics(server.URL, "sub", tc.result, 5*time.Second, now); err != nil {
+				t.Fatal(err)
+			}
+			if method != http.MethodPost || path != "/metrics/job/rg_cleanup/subscription_id/sub" {
+				t.Fatalf("expected POST /metrics/job/rg_cleanup/subscription_id/sub, but got %s %s", method, path)
+			}
+			if len(got) != len(tc.expectedMetrics) {
+				t.Fatalf("expected metrics %v, but got %v", tc.expectedMetrics, got)
+			}
+			for name, value := range tc.expectedMetrics {
+				if got[name] != value {
+					t.Fatalf("expected %s to be %v, but got %v", name, value, got[name])
+				}
+			}
+		})
+	}
+}
+
+func TestPushMetricsError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	if err := pushMetrics(server.URL, "sub", &runResult{}, time.Second, time.Now()); err == nil {
+		t.Fatalf("expected an error, but got nil")
+	}
+}
+
+func TestMetricsServer(t *testing.T) {
+	s := newMetricsServer()
+	get := func(path string) (int, string) {
+		rec := httptest.NewRecorder()
+		s.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code, rec.Body.String()
+	}
+
+	if code, _ := get("/healthz"); code != http.StatusOK {
+		t.Fatalf("expected /healthz to return %d, but got %d", http.StatusOK, code)
+	}
+	if code, _ := get("/readyz"); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected /readyz to return %d before the first cycle, but got %d", http.StatusServiceUnavailable, code)
+	}
+
+	s.metrics.observe(&runResult{scanned: 3, skipped: map[string]int{reasonProtected: 1}}, time.Second, time.Now(), "sub")
+	s.metrics.observe(&runResult{scanned: 4, skipped: map[string]int{reasonTTLNotElapsed: 2}}, time.Second, time.Now(), "sub")
+	s.ready.Store(true)
+
+	if code, _ := get("/readyz"); code != http.StatusOK {
+		t.Fatalf("expected /readyz to return %d after the first cycle, but got %d", http.StatusOK, code)
+	}
+	_, body := get("/metrics")
+	for _, expected := range []string{
+		`rg_cleanup_rgs_scanned{subscription_id="sub"} 4`,
+		`rg_cleanup_rgs_skipped{reason="ttl_not_elapsed",subscription_id="sub"} 2`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Fatalf("expected /metrics to contain %q, but got:\n%s", expected, body)
+		}
+	}
+	if strings.Contains(body, `reason="protected"`) {
+		t.Fatalf("expected skip reasons of the previous run to be dropped, but got:\n%s", body)
+	}
+}
+
+func TestMetricsServerShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- newMetricsServer().serve(ctx, "127.0.0.1:0")
+	}()
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected a clean shutdown, but got %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("expected the server to shut down")
+	}
+}
+
+func TestReadyzStandby(t *testing.T) {
+	s := newMetricsServer()
+	s.setStandby(true)
+	rec := httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "standby" {
+		t.Fatalf("expected 200 standby, but got %d %s", rec.Code, rec.Body.String())
+	}
+
+	s.setStandby(false)
+	rec = httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 before the first cycle of the leader, but got %d", rec.Code)
+	}
+
+	var nilServer *metricsServer
+	nilServer.setStandby(true)
+}
+
+func TestMetricsServerStatus(t *testing.T) {
+	s := newMetricsServer()
+	s.maxCycleAge = time.Hour
+	get := func(path string) (int, string) {
+		rec := httptest.NewRecorder()
+		s.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code, rec.Body.String()
+	}
+
+	if code, _ := get("/status"); code != http.StatusNotFound {
+		t.Fatalf("expected /status to return %d before the first run, but got %d", http.StatusNotFound, code)
+	}
+
+	s.recordRun(&runSummary{SubscriptionIDs: []string{"sub"}, Scanned: 3, Deleted: 1}, time.Now())
+	code, body := get("/status")
+	if code != http.StatusOK {
+		t.Fatalf("expected /status to return %d, but got %d", http.StatusOK, code)
+	}
+	if !strings.Contains(body, `"scanned":3`) || !strings.Contains(body, `"deleted":1`) {
+		t.Fatalf("expected /status to return the summary of the last run, but got %s", body)
+	}
+	if code, _ := get("/readyz"); code != http.StatusOK {
+		t.Fatalf("expected /readyz to return %d after a recent cycle, but got %d", http.StatusOK, code)
+	}
+
+	s.recordRun(&runSummary{}, time.Now().Add(-2*time.Hour))
+	if code, _ := get("/readyz"); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected /readyz to return %d when the last cycle is too old, but got %d", http.StatusServiceUnavailable, code)
+	}
+}
+
+func TestServeInBackground(t *testing.T) {
+	// Serving on an address in use fails, which cancels the run.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	ctx, stop := newMetricsServer().serveInBackground(context.Background(), l.Addr().String())
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the context to be canceled when the server fails")
+	}
+	if cause := context.Cause(ctx); !errors.Is(cause, errServerFailed) {
+		t.Fatalf("expected the context to be canceled with %v, but got %v", errServerFailed, cause)
+	}
+	stop()
+
+	// Stopping a healthy server does not report a failure.
+	ctx, stop = newMetricsServer().serveInBackground(context.Background(), "127.0.0.1:0")
+	stop()
+	if cause := context.Cause(ctx); !errors.Is(cause, context.Canceled) {
+		t.Fatalf("expected the context to be canceled without a failure, but got %v", cause)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+)
+
+const (
+	monitorScope             = "https://monitor.azure.com/.default"
+	logsIngestionAPIVersion  = "2023-01-01"
+	maxLogsIngestionBodySize = 1 << 20
+
+	monitorRecordResourceGroup = "ResourceGroup"
+	monitorRecordSummary       = "Summary"
+)
+
+// monitorRecord is a row sent to the Logs Ingestion API. Every record has the
+// same columns so that a single stream can hold both the per-resource-group
+// decisions and the run summary, told apart by RecordType.
+type monitorRecord struct {
+	TimeGenerated   time.Time         `json:"TimeGenerated"`
+	RecordType      string            `json:"RecordType"`
+	DryRun          bool              `json:"DryRun"`
+	SubscriptionID  string            `json:"SubscriptionId,omitempty"`
+	ResourceGroup   string            `json:"ResourceGroup,omitempty"`
+	Location        string            `json:"Location,omitempty"`
+	Tags            map[string]string `json:"Tags,omitempty"`
+	Age             string            `json:"Age,omitempty"`
+	Decision        string            `json:"Decision,omitempty"`
+	Reason          string            `json:"Reason,omitempty"`
+	Outcome         string            `json:"Outcome,omitempty"`
+	Error           string            `json:"Error,omitempty"`
+	Scanned         int               `json:"Scanned,omitempty"`
+	Eligible        int               `json:"Eligible,omitempty"`
+	Deleted         int               `json:"Deleted,omitempty"`
+	Failed          int               `json:"Failed,omitempty"`
+	Skipped         int               `json:"Skipped,omitempty"`
+	SkippedByReason map[string]int    `json:"SkippedByReason,omitempty"`
+}
+
+// newMonitorRecords returns a record per resource group followed by a record
+// for the run summary.
+func newMonitorRecords(summary *runSummary, resourceGroups []resourceGroupRecord, now time.Time) []monitorRecord {
+	now = now.UTC()
+	records := make([]monitorRecord, 0, len(resourceGroups)+1)
+	for _, rg := range resourceGroups {
+		records = append(records, monitorRecord{
+			TimeGenerated:  now,
+			RecordType:     monitorRecordResourceGroup,
+			DryRun:         summary.DryRun,
+			SubscriptionID: rg.SubscriptionID,
+			ResourceGroup:  rg.Name,
+			Location:       rg.Location,
+			Tags:           rg.Tags,
+			Age:            rg.Age,
+			Decision:       rg.Decision,
+			Reason:         rg.Reason,
+			Outcome:        rg.Outcome,
+			Error:          rg.Error,
+		})
+	}
+	return append(records, monitorRecord{
+		TimeGenerated:   now,
+		RecordType:      monitorRecordSummary,
+		DryRun:          summary.DryRun,
+		SubscriptionID:  strings.Join(summary.SubscriptionIDs, ","),
+		Scanned:         summary.Scanned,
+		Eligible:        summary.Eligible,
+		Deleted:         summary.Deleted,
+		Failed:          summary.Failed,
+		Skipped:         summary.Skipped,
+		SkippedByReason: summary.SkippedByReason,
+	})
+}
+
+// batchMonitorRecords encodes records as JSON arrays of at most maxSize bytes
+// each.
+func batchMonitorRecords(records []monitorRecord, maxSize int) ([][]byte, error) {
+	var batches [][]byte
+	batch := []byte{'['}
+	for _, record := range records {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode record: %v", err)
+		}
+		if len(data)+2 > maxSize {
+			return nil, fmt.Errorf("record of resource group '%s' is larger than %d bytes", record.ResourceGroup, maxSize)
+		}
+		// The batch grows by the record plus a comma or the closing bracket.
+		if len(batch) > 1 && len(batch)+len(data)+1 > maxSize {
+			batches = append(batches, append(batch[:len(batch)-1], ']'))
+			batch = []byte{'['}
+		}
+		batch = append(append(batch, data...), ',')
+	}
+	if len(batch) > 1 {
+		batches = append(batches, append(batch[:len(batch)-1], ']'))
+	}
+	return batches, nil
+}
+
+// logsIngestionClient uploads records to a data collection rule stream
+// through the Azure Monitor Logs Ingestion API.
+type logsIngestionClient struct {
+	endpoint string
+	ruleID   string
+	stream   string
+	pipeline runtime.Pipeline
+}
+
+func newLogsIngestionClient(cred azcore.TokenCredential, endpoint, ruleID, stream string) *logsIngestionClient {
+	return &logsIngestionClient{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		ruleID:   ruleID,
+		stream:   stream,
+		pipeline: runtime.NewPipeline(moduleName, moduleVersion, runtime.PipelineOptions{
+			PerRetry: []policy.Policy{
+				runtime.NewBearerTokenPolicy(cred, []string{monitorScope}, nil),
+				tracingPolicy{},
+			},
+		}, nil),
+	}
+}
+
+// upload sends records in as many requests as needed to stay under the size
+// limit of the Logs Ingestion API.
+func (c *logsIngestionClient) upload(ctx context.Context, records []monitorRecord) error {
+	batches, err := batchMonitorRecords(records, maxLogsIngestionBodySize)
+	if err != nil {
+		return err
+	}
+	endpoint := fmt.Sprintf("%s/dataCollectionRules/%s/streams/%s?api-version=%s", c.endpoint, url.PathEscape(c.ruleID), url.PathEscape(c.stream), logsIngestionAPIVersion)
+	for _, batch := range batches {
+		if err := c.uploadBatch(ctx, endpoint, batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// uploadBatch sends one batch of records to endpoint.
+func (c *logsIngestionClient) uploadBatch(ctx context.Context, endpoint string, batch []byte) error {
+	req, err := runtime.NewRequest(ctx, http.MethodPost, endpoint)
+	if err != nil {
+		return err
+	}
+	if err := req.SetBody(streaming.NopCloser(bytes.NewReader(batch)), "application/json"); err != nil {
+		return err
+	}
+	resp, err := c.pipeline.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if !runtime.HasStatusCode(resp, http.StatusNoContent, http.StatusOK) {
+		return runtime.NewResponseError(resp)
+	}
+	// Drain the body so that the connection can be reused.
+	_, err = io.Copy(io.Discard, resp.Body)
+	return err
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+)
+
+func TestBatchMonitorRecords(t *testing.T) {
+	var records []monitorRecord
+	for i := 0; i < 10; i++ {
+		records = append(records, monitorRecord{RecordType: monitorRecordResourceGroup, ResourceGroup: strings.Repeat("x", 50)})
+	}
+	size := len(mustMarshal(t, records[0]))
+
+	testCases := []struct {
+		desc            string
+		maxSize         int
+		expectedBatches int
+		expectedErr     bool
+	}{
+		{desc: "everything fits", maxSize: maxLogsIngestionBodySize, expectedBatches: 1},
+		{desc: "exactly three records per batch", maxSize: 3*size + 4, expectedBatches: 4},
+		{desc: "one record per batch", maxSize: size + 2, expectedBatches: 10},
+		{desc: "a record is too large", maxSize: size + 1, expectedErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			batches, err := batchMonitorRecords(records, tc.maxSize)
+			if tc.expectedErr != (err != nil) {
+				t.Fatalf("expected error to be %v, but got %v", tc.expectedErr, err)
+			}
+			if len(batches) != tc.expectedBatches {
+				t.Fatalf("expected %d batches, but got %d", tc.expectedBatches, len(batches))
+			}
+			total := 0
+			for _, batch := range batches {
+				if len(batch) > tc.maxSize {
+					t.Fatalf("expected batches of at most %d bytes, but got %d", tc.maxSize, len(batch))
+				}
+				var decoded []monitorRecord
+				if err := json.Unmarshal(batch, &decoded); err != nil {
+					t.Fatalf("expected a JSON array, but got %s: %v", batch, err)
+				}
+				total += len(decoded)
+			}
+			if !tc.expectedErr && total != len(records) {
+				t.Fatalf("expected %d records, but got %d", len(records), total)
+			}
+		})
+	}
+}
+
+func mustMarshal(t *testing.T, v any) []byte {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestLogsIngestionUpload(t *testing.T) {
+	var gotPath, gotAPIVersion string
+	var got []monitorRecord
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotAPIVersion = r.URL.Path, r.URL.Query().Get("api-version")
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &got); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	c := &logsIngestionClient{
+		endpoint: srv.URL,
+		ruleID:   "dcr-123",
+		stream:   "Custom-RgCleanup_CL",
+		pipeline: runtime.NewPipeline(moduleName, moduleVersion, runtime.PipelineOptions{}, nil),
+	}
+	result := &runResult{skipped: map[string]int{}}
+	result.addResourceGroup(resourceGroupRecord{SubscriptionID: "sub", Name: "old-rg", Decision: decisionDelete, Reason: reasonTTLElapsed, Outcome: outcomeDeletionStarted})
+	result.addResourceGroup(resourceGroupRecord{SubscriptionID: "sub", Name: "young-rg", Decision: decisionSkip, Reason: reasonTTLNotElapsed, Outcome: outcomeSkipped})
+	records := newMonitorRecords(newRunSummary(result, []string{"sub"}, false), result.resourceGroups, time.Now())
+	if err := c.upload(context.Background(), records); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if gotPath != "/dataCollectionRules/dcr-123/streams/Custom-RgCleanup_CL" || gotAPIVersion != logsIngestionAPIVersion {
+		t.Fatalf("unexpected request to %s with api-version %s", gotPath, gotAPIVersion)
+	}
+	if len(got) != 3 {
+		t.Fatalf("expected 3 records, but got %d", len(got))
+	}
+	if got[0].ResourceGroup != "old-rg" || got[0].Outcome != outcomeDeletionStarted || got[1].Reason != reasonTTLNotElapsed {
+		t.Fatalf("unexpected resource group records %+v", got[:2])
+	}
+	if summary := got[2]; summary.RecordType != monitorRecordSummary || summary.Scanned != 2 || summary.Deleted != 1 || summary.SkippedByReason[reasonTTLNotElapsed] != 1 {
+		t.Fatalf("unexpected summary record %+v", summary)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+)
+
+const (
+	monitorMetricsScope     = "https://monitoring.azure.com/.default"
+	monitorMetricNamespace  = "rg-cleanup"
+	staleResourceGroupCount = "StaleResourceGroupCount"
+)
+
+// customMetric is the body of a request to the Azure Monitor custom metrics
+// API. Every series holds a single data point, so min, max and sum are the
+// value.
+type customMetric struct {
+	Time time.Time `json:"time"`
+	Data struct {
+		BaseData customMetricData `json:"baseData"`
+	} `json:"data"`
+}
+
+type customMetricData struct {
+	Metric    string               `json:"metric"`
+	Namespace string               `json:"namespace"`
+	DimNames  []string             `json:"dimNames"`
+	Series    []customMetricSeries `json:"series"`
+}
+
+type customMetricSeries struct {
+	DimValues []string `json:"dimValues"`
+	Min       float64  `json:"min"`
+	Max       float64  `json:"max"`
+	Sum       float64  `json:"sum"`
+	Count     int      `json:"count"`
+}
+
+// newStaleResourceGroupCountMetric returns the StaleResourceGroupCount
+// metric, the number of resource groups eligible for deletion, with a series
+// per subscription. Subscriptions without stale resource groups report 0.
+func newStaleResourceGroupCountMetric(subscriptionIDs []string, resourceGroups []resourceGroupRecord, now time.Time) *customMetric {
+	counts := map[string]int{}
+	for _, id := range subscriptionIDs {
+		counts[id] = 0
+	}
+	for _, rg := range resourceGroups {
+		if rg.Decision == decisionDelete {
+			counts[rg.SubscriptionID]++
+		}
+	}
+	ids := make([]string, 0, len(counts))
+	for id := range counts {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	m := &customMetric{Time: now.UTC()}
+	m.Data.BaseData = customMetricData{
+		Metric:    staleResourceGroupCount,
+		Namespace: monitorMetricNamespace,
+		DimNames:  []string{"SubscriptionId"},
+	}
+	for _, id := range ids {
+		count := float64(counts[id])
+		m.Data.BaseData.Series = append(m.Data.BaseData.Series, customMetricSeries{
+			DimValues: []string{id},
+			Min:       count,
+			Max:       count,
+			Sum:       count,
+			Count:     1,
+		})
+	}
+	return m
+}
+
+// customMetricsClient writes custom metrics for an Azure resource through
+// the Azure Monitor custom metrics API.
+type customMetricsClient struct {
+	// endpoint is the regional endpoint followed by the ID of the resource
+	// the metrics are written for.
+	endpoint string
+	pipeline runtime.Pipeline
+}
+
+func newCustomMetricsClient(cred azcore.TokenCredential, endpoint string) *customMetricsClient {
+	return &customMetricsClient{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		pipeline: runtime.NewPipeline(moduleName, moduleVersion, runtime.PipelineOptions{
+			PerRetry: []policy.Policy{
+				runtime.NewBearerTokenPolicy(cred, []string{monitorMetricsScope}, nil),
+				tracingPolicy{},
+			},
+		}, nil),
+	}
+}
+
+func (c *customMetricsClient) write(ctx context.Context, metric *customMetric) error {
+	body, err := json.Marshal(metric)
+	if err != nil {
+		return fmt.Errorf("failed to encode metric: %v", err)
+	}
+	req, err := runtime.NewRequest(ctx, http.MethodPost, c.endpoint+"/metrics")
+	if err != nil {
+		return err
+	}
+	if err := req.SetBody(streaming.NopCloser(bytes.NewReader(body)), "application/json"); err != nil {
+		return err
+	}
+	resp, err := c.pipeline.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if !runtime.HasStatusCode(resp, http.StatusOK, http.StatusNoContent) {
+		return runtime.NewResponseError(resp)
+	}
+	// Drain the body so that the connection can be reused.
+	_, err = io.Copy(io.Discard, resp.Body)
+	return err
+}
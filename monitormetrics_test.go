@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+)
+
+func TestStaleResourceGroupCountMetric(t *testing.T) {
+	var gotPath string
+	var got customMetric
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &got); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	c := &customMetricsClient{
+		endpoint: srv.URL + "/subscriptions/sub-1/resourceGroups/monitoring/providers/Microsoft.OperationalInsights/workspaces/logs",
+		pipeline: runtime.NewPipeline(moduleName, moduleVersion, runtime.PipelineOptions{}, nil),
+	}
+	resourceGroups := []resourceGroupRecord{
+		{SubscriptionID: "sub-1", Name: "old-1", Decision: decisionDelete, Outcome: outcomeDeletionStarted},
+		{SubscriptionID: "sub-1", Name: "old-2", Decision: decisionDelete, Outcome: outcomeFailed},
+		{SubscriptionID: "sub-1", Name: "young", Decision: decisionSkip, Reason: reasonTTLNotElapsed},
+		{SubscriptionID: "sub-2", Name: "protected", Decision: decisionSkip, Reason: reasonProtected},
+	}
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := c.write(context.Background(), newStaleResourceGroupCountMetric([]string{"sub-1", "sub-2"}, resourceGroups, now)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if expected := "/subscriptions/sub-1/resourceGroups/monitoring/providers/Microsoft.OperationalInsights/workspaces/logs/metrics"; gotPath != expected {
+		t.Fatalf("expected a request to %s, but got %s", expected, gotPath)
+	}
+	data := got.Data.BaseData
+	if !got.Time.Equal(now) || data.Metric != staleResourceGroupCount || data.Namespace != monitorMetricNamespace {
+		t.Fatalf("unexpected metric %+v", got)
+	}
+	expectedSeries := []customMetricSeries{
+		{DimValues: []string{"sub-1"}, Min: 2, Max: 2, Sum: 2, Count: 1},
+		{DimValues: []string{"sub-2"}, Min: 0, Max: 0, Sum: 0, Count: 1},
+	}
+	if !reflect.DeepEqual(data.Series, expectedSeries) {
+		t.Fatalf("expected series %+v, but got %+v", expectedSeries, data.Series)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+
+	"github.com/chewong/rg-cleanup/pkg/cleanup"
+)
+
+const (
+	notifyEmail             = "email"
+	defaultNotifyDaysBefore = 3
+	smtpUsernameEnvVar      = "SMTP_USERNAME"
+	smtpPasswordEnvVar      = "SMTP_PASSWORD"
+	// notifiedTag holds the date of the deletion the contact of a resource
+	// group was notified of.
+	notifiedTag = "rg-cleanup-notified"
+)
+
+// mailer sends emails through an SMTP server.
+type mailer struct {
+	address string
+	auth    smtp.Auth
+	from    string
+	// send is smtp.SendMail, replaced in tests.
+	send func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+// newMailer returns a mailer for the SMTP server of o. PLAIN authentication
+// is used when a username is set.
+func newMailer(o *options) *mailer {
+	m := &mailer{address: o.smtpAddress, from: o.smtpFrom, send: smtp.SendMail}
+	if o.smtpUsername != "" {
+		host, _, _ := net.SplitHostPort(o.smtpAddress)
+		m.auth = smtp.PlainAuth("", o.smtpUsername, o.smtpPassword, host)
+	}
+	return m
+}
+
+// newNotificationEmail returns the email warning to about the upcoming
+// deletion d.
+func newNotificationEmail(from, to string, d upcomingDeletion) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Subject: rg-cleanup: resource group '%s' will be deleted on %s\r\n", d.name, d.deletion.UTC().Format("2006-01-02"))
+	fmt.Fprintf(&b, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&b, "Content-Type: text/plain; charset=UTF-8\r\n")
+	fmt.Fprintf(&b, "\r\n")
+	fmt.Fprintf(&b, "The resource group '%s' in subscription %s is scheduled to be deleted by rg-cleanup.\r\n\r\n", d.name, d.subscriptionID)
+	fmt.Fprintf(&b, "Resource group: %s\r\n", d.name)
+	fmt.Fprintf(&b, "Subscription: %s\r\n", d.subscriptionID)
+	fmt.Fprintf(&b, "Creation date: %s\r\n", d.formatCreated())
+	fmt.Fprintf(&b, "Expected deletion date: %s\r\n\r\n", d.deletion.UTC().Format(time.RFC3339))
+	fmt.Fprintf(&b, "To keep the resource group, add a '%s' tag to it before then.\r\n", doNotDeleteTag)
+	return b.Bytes()
+}
+
+type mailerKey struct{}
+
+func withMailer(ctx context.Context, m *mailer) context.Context {
+	return context.WithValue(ctx, mailerKey{}, m)
+}
+
+// mailerFrom returns the mailer of ctx, or nil if there is none.
+func mailerFrom(ctx context.Context) *mailer {
+	m, _ := ctx.Value(mailerKey{}).(*mailer)
+	return m
+}
+
+// isNotified reports whether the contact of rg was already notified of the
+// deletion d, i.e. rg has a notifiedTag holding the date of d. A new
+// notification is sent when the date changes, e.g. because of a ttl-override
+// tag.
+func isNotified(rg *armresources.ResourceGroup, d upcomingDeletion) bool {
+	value, ok := rg.Tags[notifiedTag]
+	return ok && value != nil && *value == d.deletion.UTC().Format(time.DateOnly)
+}
+
+// notifyDeletion emails the contact of rg about its upcoming deletion d and
+// tags rg with notifiedTag so that later runs do not send the same email
+// again. Failures are logged and do not stop the cleanup. Nothing is sent in
+// a dry run, since nothing will be deleted.
+func notifyDeletion(ctx context.Context, logger *slog.Logger, m *mailer, r resourceGroupDeleter, rg *armresources.ResourceGroup, d upcomingDeletion, dryRun bool) {
+	// Parsing the address also keeps a malicious tag value from adding
+	// headers to the email.
+	to, err := mail.ParseAddress(d.contact)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error when parsing the contact '%s' of resource group '%s'", d.contact, d.name), "error", err)
+		return
+	}
+	if dryRun {
+		logger.Info(fmt.Sprintf("Dry-run: skip notifying %s that resource group '%s' will be deleted on %s", to.Address, d.name, d.deletion.UTC().Format(time.RFC3339)))
+		return
+	}
+	logger.Info(fmt.Sprintf("Notifying %s that resource group '%s' will be deleted on %s", to.Address, d.name, d.deletion.UTC().Format(time.RFC3339)))
+	if err := m.send(m.address, m.auth, m.from, []string{to.Address}, newNotificationEmail(m.from, to.String(), d)); err != nil {
+		logger.Error(fmt.Sprintf("Error when notifying %s", to.Address), "error", err)
+		return
+	}
+	tags := make(map[string]*string, len(rg.Tags)+1)
+	for k, v := range rg.Tags {
+		tags[k] = v
+	}
+	date := d.deletion.UTC().Format(time.DateOnly)
+	tags[notifiedTag] = &date
+	if err := cleanup.UpdateTags(ctx, r, rg, tags); err != nil {
+		logger.Error(fmt.Sprintf("Error when tagging %s with '%s', it will be notified again", d.name, notifiedTag), "error", err)
+		return
+	}
+	// Later tag updates of this run start from the tags of rg.
+	rg.Tags = tags
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+func TestRunResourceGroupCleanupNotifications(t *testing.T) {
+	now := time.Now()
+	newResourceGroup := func(name string, created time.Time, tags map[string]*string) *armresources.ResourceGroup {
+		tags[creationTimestampTag] = to.StringPtr(created.Format(time.RFC3339))
+		rg := getResourceGroup(name, tags)
+		return &rg
+	}
+	c := &fakeResourceGroupsClient{pages: [][]*armresources.ResourceGroup{{
+		newResourceGroup("soon", now.Add(-defaultTTL+24*time.Hour), map[string]*string{ownerEmailTag: to.StringPtr("alice@example.com"), contactTag: to.StringPtr("team@example.com")}),
+		newResourceGroup("soon-contact", now.Add(-defaultTTL+24*time.Hour), map[string]*string{contactTag: to.StringPtr("team@example.com")}),
+		newResourceGroup("soon-no-contact", now.Add(-defaultTTL+24*time.Hour), map[string]*string{}),
+		newResourceGroup("later", now, map[string]*string{ownerEmailTag: to.StringPtr("bob@example.com")}),
+		newResourceGroup("stale", now.Add(-defaultTTL-time.Hour), map[string]*string{ownerEmailTag: to.StringPtr("carol@example.com")}),
+	}}}
+	o := &options{ttl: defaultTTL, dryRun: true, notifyBeforeDeletion: notifyEmail, notifyDaysBefore: 2}
+	result, err := runResourceGroupCleanup(context.Background(), "sub", c, c, fakeResourcesClient{}, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var notified []string
+	for _, d := range result.notifications {
+		notified = append(notified, fmt.Sprintf("%s:%s", d.name, d.contact))
+	}
+	// The stale resource group is notified right away, as on a first run.
+	expected := []string{"soon:alice@example.com", "soon-contact:team@example.com", "stale:carol@example.com"}
+	if fmt.Sprint(notified) != fmt.Sprint(expected) {
+		t.Fatalf("expected %v to be notified, but got %v", expected, notified)
+	}
+	if len(c.updates) != 0 {
+		t.Fatalf("expected no tag update in a dry run, but got %d", len(c.updates))
+	}
+}
+
+func TestRunResourceGroupCleanupNotifiesOnce(t *testing.T) {
+	now := time.Now()
+	soon := getResourceGroup("soon", map[string]*string{
+		creationTimestampTag: to.StringPtr(now.Add(-defaultTTL + 24*time.Hour).Format(time.RFC3339)),
+		ownerEmailTag:        to.StringPtr("alice@example.com"),
+	})
+	stale := getResourceGroup("stale", map[string]*string{
+		creationTimestampTag: to.StringPtr(now.Add(-defaultTTL - time.Hour).Format(time.RFC3339)),
+		ownerEmailTag:        to.StringPtr("carol@example.com"),
+	})
+	c := &fakeResourceGroupsClient{pages: [][]*armresources.ResourceGroup{{&soon, &stale}}}
+	var sent []string
+	m := &mailer{address: "smtp.example.com:587", from: "rg-cleanup@example.com", send: func(_ string, _ smtp.Auth, _ string, to []string, _ []byte) error {
+		sent = append(sent, to...)
+		return nil
+	}}
+	ctx := withMailer(context.Background(), m)
+	o := &options{ttl: defaultTTL, notifyBeforeDeletion: notifyEmail, notifyDaysBefore: 2}
+
+	// The fake client lists the same resource groups, so the second run sees
+	// the tags of the first.
+	for i := 0; i < 2; i++ {
+		if _, err := runResourceGroupCleanup(ctx, "sub", c, c, fakeResourcesClient{}, o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := []string{"alice@example.com", "carol@example.com"}
+	if fmt.Sprint(sent) != fmt.Sprint(expected) {
+		t.Fatalf("expected %v to be notified once, but got %v", expected, sent)
+	}
+	// The stale resource group is notified before it is deleted.
+	expectedCalls := []string{"CreateOrUpdate soon", "CreateOrUpdate stale", "BeginDelete stale", "BeginDelete stale"}
+	if fmt.Sprint(c.calls) != fmt.Sprint(expectedCalls) {
+		t.Fatalf("expected calls %v, but got %v", expectedCalls, c.calls)
+	}
+	date := now.Add(24 * time.Hour).UTC().Format(time.DateOnly)
+	if tag := soon.Tags[notifiedTag]; tag == nil || *tag != date {
+		t.Fatalf("expected a '%s' tag set to %s, but got %v", notifiedTag, date, tag)
+	}
+}
+
+func TestNotifyDeletion(t *testing.T) {
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	deletions := []upcomingDeletion{
+		{subscriptionID: "sub", name: "soon", created: created, contact: "Alice <alice@example.com>", deletion: created.Add(defaultTTL)},
+		{subscriptionID: "sub", name: "bad", created: created, contact: "x@example.com\r\nBcc: everyone@example.com", deletion: created.Add(defaultTTL)},
+	}
+
+	for _, dryRun := range []bool{false, true} {
+		var sent []string
+		m := &mailer{address: "smtp.example.com:587", from: "rg-cleanup@example.com", send: func(addr string, _ smtp.Auth, from string, to []string, msg []byte) error {
+			sent = append(sent, string(msg))
+			if addr != "smtp.example.com:587" || from != "rg-cleanup@example.com" || fmt.Sprint(to) != "[alice@example.com]" {
+				t.Fatalf("unexpected email from %s to %v through %s", from, to, addr)
+			}
+			return nil
+		}}
+		c := &fakeResourceGroupsClient{}
+		for _, d := range deletions {
+			rg := getResourceGroup(d.name, map[string]*string{})
+			notifyDeletion(context.Background(), slog.Default(), m, c, &rg, d, dryRun)
+		}
+
+		if dryRun {
+			if len(sent) != 0 || len(c.updates) != 0 {
+				t.Fatalf("expected no email and no tag in a dry run, but got %v and %d tag updates", sent, len(c.updates))
+			}
+			continue
+		}
+		if len(sent) != 1 {
+			t.Fatalf("expected 1 email, but got %d", len(sent))
+		}
+		for _, expected := range []string{
+			"To: \"Alice\" <alice@example.com>\r\n",
+			"Subject: rg-cleanup: resource group 'soon' will be deleted on 2024-01-05\r\n",
+			"Creation date: 2024-01-02T03:04:05Z\r\n",
+			"Expected deletion date: 2024-01-05T03:04:05Z\r\n",
+			"add a 'DO-NOT-DELETE' tag",
+		} {
+			if !strings.Contains(sent[0], expected) {
+				t.Fatalf("expected the email to contain %q, but got:\n%s", expected, sent[0])
+			}
+		}
+		if len(c.updates) != 1 || *c.updates[0].Tags[notifiedTag] != "2024-01-05" {
+			t.Fatalf("expected 'soon' to be tagged as notified of 2024-01-05, but got %+v", c.updates)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+// eligibleResourceGroup is an entry of --output json. Its fields must stay
+// stable since scripts parse them.
+type eligibleResourceGroup struct {
+	SubscriptionID string `json:"subscriptionId"`
+	Name           string `json:"name"`
+	Location       string `json:"location"`
+	Age            string `json:"age"`
+	Owner          string `json:"owner"`
+	Outcome        string `json:"outcome"`
+}
+
+func newEligibleResourceGroups(resourceGroups []resourceGroupRecord) []eligibleResourceGroup {
+	eligible := []eligibleResourceGroup{}
+	for _, rg := range resourceGroups {
+		if rg.Decision != decisionDelete {
+			continue
+		}
+		age := rg.Age
+		if rg.Reason == reasonNoCreationTimestamp {
+			// The age is a sentence listing the tags in that case.
+			age = "unknown"
+		}
+		eligible = append(eligible, eligibleResourceGroup{
+			SubscriptionID: rg.SubscriptionID,
+			Name:           rg.Name,
+			Location:       rg.Location,
+			Age:            age,
+			Owner:          rg.Tags[ownerTag],
+			Outcome:        rg.Outcome,
+		})
+	}
+	return eligible
+}
+
+// writeOutput writes the resource groups that were deleted, or would have
+// been in a dry run, to w in format.
+func writeOutput(w io.Writer, format string, resourceGroups []resourceGroupRecord) error {
+	eligible := newEligibleResourceGroups(resourceGroups)
+	switch format {
+	case outputJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(eligible)
+	case outputTable:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "SUBSCRIPTION\tNAME\tLOCATION\tAGE\tOWNER\tOUTCOME")
+		for _, rg := range eligible {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", rg.SubscriptionID, rg.Name, rg.Location, rg.Age, rg.Owner, rg.Outcome)
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unknown output format '%s'", format)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+func TestWriteOutput(t *testing.T) {
+	c := &fakeResourceGroupsClient{pages: [][]*armresources.ResourceGroup{{
+		{
+			Name:     to.StringPtr("old-rg"),
+			Location: to.StringPtr("westus2"),
+			Tags:     map[string]*string{creationTimestampTag: to.StringPtr(reportFourDaysAgo), ownerTag: to.StringPtr("jane@example.com")},
+		},
+		{Name: to.StringPtr("untagged-rg"), Location: to.StringPtr("eastus")},
+		{Name: to.StringPtr("young-rg"), Tags: map[string]*string{creationTimestampTag: to.StringPtr(reportOneDayAgo)}},
+	}}}
+	result, err := runResourceGroupCleanup(context.Background(), "sub", c, c, fakeResourcesClient{}, &options{ttl: defaultTTL, dryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		format         string
+		resourceGroups []resourceGroupRecord
+		golden         string
+	}{
+		{format: outputTable, resourceGroups: result.resourceGroups, golden: "output.golden.txt"},
+		{format: outputJSON, resourceGroups: result.resourceGroups, golden: "output.golden.json"},
+		{format: outputJSON, golden: "output-empty.golden.json"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.golden, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "output")
+			f, err := os.Create(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := writeOutput(f, tc.format, tc.resourceGroups); err != nil {
+				t.Fatal(err)
+			}
+			if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+			compareGolden(t, path, filepath.Join("testdata", tc.golden))
+		})
+	}
+}
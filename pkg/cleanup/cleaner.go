@@ -0,0 +1,461 @@
+package cleanup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DeletePermission is the permission needed to delete resource groups.
+const DeletePermission = "Microsoft.Resources/subscriptions/resourcegroups/delete"
+
+// Headers of the ARM responses identifying the request.
+const (
+	requestIDHeader            = "x-ms-request-id"
+	correlationRequestIDHeader = "x-ms-correlation-request-id"
+)
+
+// ResourceGroupLister lists the resource groups of a subscription, like
+// *armresources.ResourceGroupsClient.
+type ResourceGroupLister interface {
+	NewListPager(options *armresources.ResourceGroupsClientListOptions) *runtime.Pager[armresources.ResourceGroupsClientListResponse]
+}
+
+// ResourceGroupDeleter deletes resource groups and updates the tags that
+// track their deletion, like *armresources.ResourceGroupsClient.
+type ResourceGroupDeleter interface {
+	BeginDelete(ctx context.Context, resourceGroupName string, options *armresources.ResourceGroupsClientBeginDeleteOptions) (*runtime.Poller[armresources.ResourceGroupsClientDeleteResponse], error)
+	CreateOrUpdate(ctx context.Context, resourceGroupName string, parameters armresources.ResourceGroup, options *armresources.ResourceGroupsClientCreateOrUpdateOptions) (armresources.ResourceGroupsClientCreateOrUpdateResponse, error)
+}
+
+// ResourcesClient lists the resources of a resource group, like
+// *armresources.Client. It is only used with Options.MinResourceCount and
+// Options.SkipResourceTypes.
+type ResourcesClient interface {
+	NewListByResourceGroupPager(resourceGroupName string, options *armresources.ClientListByResourceGroupOptions) *runtime.Pager[armresources.ClientListByResourceGroupResponse]
+}
+
+// Hooks let the caller of a Cleaner act on each resource group. They are all
+// optional. Each is given the logger of the resource group.
+type Hooks struct {
+	// Listed is called for each resource group as it is listed. When it
+	// returns false, the resource group is not evaluated and the record it
+	// returns is kept, e.g. to only delete the resource groups of a plan.
+	Listed func(ctx context.Context, logger *slog.Logger, rg *armresources.ResourceGroup) (Record, bool)
+	// Evaluated is called with the decision on each evaluated resource
+	// group, before its deletion is started. The decision of a resource
+	// group whose resources were listed tells whether they allow deleting
+	// it, which the caller can reuse instead of listing them again.
+	Evaluated func(ctx context.Context, logger *slog.Logger, rg *armresources.ResourceGroup, record Record)
+	// BeforeDelete is called before the deletion of each resource group is
+	// started, outside of dry runs. When it returns false, the resource
+	// group is not deleted and the record it returns is kept.
+	BeforeDelete func(ctx context.Context, logger *slog.Logger, rg *armresources.ResourceGroup, record Record) (Record, bool)
+	// Deleted is called once the deletion of a resource group was started,
+	// or failed to be, as told by the outcome of record.
+	Deleted func(ctx context.Context, logger *slog.Logger, rg *armresources.ResourceGroup, record Record)
+	// Recorded is called with the final record of each resource group. An
+	// error stops the run.
+	Recorded func(ctx context.Context, record Record) error
+	// Page is called after each page of resource groups with the result so
+	// far.
+	Page func(ctx context.Context, result *Result)
+}
+
+// Cleaner deletes the stale resource groups of a subscription.
+type Cleaner struct {
+	Lister    ResourceGroupLister
+	Deleter   ResourceGroupDeleter
+	Resources ResourcesClient
+	Options   *Options
+	// Logger receives the decisions. slog.Default() is used when it is nil.
+	Logger *slog.Logger
+	// PrincipalObjectID is the object ID of the principal the clients run
+	// as, logged when a deletion is forbidden.
+	PrincipalObjectID string
+	Hooks             Hooks
+}
+
+// Run lists the resource groups of subscriptionID, evaluates them and starts
+// the deletion of the eligible ones without waiting for it to complete.
+// Failed deletions are part of the result rather than errors. When listing
+// fails, the result so far is returned with the error.
+func (c *Cleaner) Run(ctx context.Context, subscriptionID string) (*Result, error) {
+	logger := c.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	o := c.Options
+	logger.Info(fmt.Sprintf("Scanning for stale resource groups in subscription %s", subscriptionID))
+
+	result := &Result{Skipped: map[string]int{}}
+	if o.MinimumRGsToKeep > 0 {
+		if err := c.checkMinimumResourceGroups(ctx); err != nil {
+			return result, err
+		}
+	}
+	pager := c.Lister.NewListPager(nil)
+	for pager.More() {
+		pageCtx, span := tracer().Start(ctx, "list resource groups page", trace.WithAttributes(attribute.String("subscription_id", subscriptionID)))
+		page, err := pager.NextPage(pageCtx)
+		if err != nil {
+			endSpan(span, err)
+			return result, fmt.Errorf("error when iterating resource groups: %v", err)
+		}
+		span.SetAttributes(attribute.Int("rgs", len(page.Value)))
+		endSpan(span, nil)
+		for _, rg := range page.Value {
+			rgLogger := resourceGroupLogger(logger, rg)
+			record, ok := NewRecord(subscriptionID, rg), true
+			if c.Hooks.Listed != nil {
+				record, ok = c.Hooks.Listed(ctx, rgLogger, rg)
+			}
+			if ok {
+				record = c.cleanupResourceGroup(ctx, rgLogger, subscriptionID, rg)
+			}
+			result.Add(record)
+			if c.Hooks.Recorded != nil {
+				if err := c.Hooks.Recorded(ctx, record); err != nil {
+					return result, err
+				}
+			}
+		}
+		if c.Hooks.Page != nil {
+			c.Hooks.Page(ctx, result)
+		}
+	}
+	return result, nil
+}
+
+// checkMinimumResourceGroups returns an error if deleting every resource group
+// that is eligible for deletion would leave fewer than
+// Options.MinimumRGsToKeep resource groups in the subscription. It lists the
+// resource groups on its own so that nothing is deleted when the check fails.
+func (c *Cleaner) checkMinimumResourceGroups(ctx context.Context) error {
+	// The decisions are logged again when the resource groups are cleaned up.
+	quiet := slog.New(slog.NewTextHandler(io.Discard, nil))
+	total, eligible := 0, 0
+	pager := c.Lister.NewListPager(nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("error when iterating resource groups: %v", err)
+		}
+		for _, rg := range page.Value {
+			total++
+			if Evaluate(ctx, quiet, c.Resources, "", rg, c.Options).Decision == DecisionDelete {
+				eligible++
+			}
+		}
+	}
+	if total-eligible < c.Options.MinimumRGsToKeep {
+		return fmt.Errorf("refusing to delete %d of %d resource groups: --minimum-rgs-to-keep requires at least %d to remain", eligible, total, c.Options.MinimumRGsToKeep)
+	}
+	return nil
+}
+
+// cleanupResourceGroup decides whether rg should be deleted, starts its
+// deletion if so, and returns a record of what happened.
+func (c *Cleaner) cleanupResourceGroup(ctx context.Context, logger *slog.Logger, subscriptionID string, rg *armresources.ResourceGroup) Record {
+	o := c.Options
+	rgName := *rg.Name
+	record := Evaluate(ctx, logger, c.Resources, subscriptionID, rg, o)
+	if c.Hooks.Evaluated != nil {
+		c.Hooks.Evaluated(ctx, logger, rg, record)
+	}
+	if record.Decision != DecisionDelete {
+		return record
+	}
+
+	if o.GracePeriod > 0 {
+		wait, err := c.waitForGracePeriod(ctx, logger, rg, time.Now())
+		if err != nil {
+			logger.Error(fmt.Sprintf("Error when scheduling the deletion of %s", rgName), "decision", DecisionSkip, "reason", ReasonGracePeriod, "error", err)
+			return record.Skip(ReasonGracePeriod, err)
+		}
+		if wait {
+			return record.Skip(ReasonGracePeriod, nil)
+		}
+	}
+
+	if o.DryRun {
+		logger.Info(fmt.Sprintf("Dry-run: skip deletion of eligible resource group '%s' in %s (age: %s, tags: %s)", rgName, location(rg), record.Age, formatTags(rg.Tags, o.MaxTagLogLength)), "decision", DecisionDelete, "reason", record.Reason)
+		record.Outcome = OutcomeDryRun
+		return record
+	}
+
+	if c.Hooks.BeforeDelete != nil {
+		var ok bool
+		if record, ok = c.Hooks.BeforeDelete(ctx, logger, rg, record); !ok {
+			return record
+		}
+	}
+
+	// Start the delete without waiting for it to complete.
+	logger.Info(fmt.Sprintf("Beginning to delete resource group '%s' in %s (age: %s, tags: %s)", rgName, location(rg), record.Age, formatTags(rg.Tags, o.MaxTagLogLength)), "decision", DecisionDelete, "reason", record.Reason)
+	tagged := false
+	if o.TagOnDelete {
+		if err := setTimestampTag(ctx, c.Deleter, rg, DeletionInProgressTag, time.Now()); err != nil {
+			logger.Error(fmt.Sprintf("Error when tagging %s with '%s', deleting it anyway", rgName, DeletionInProgressTag), "error", err)
+		} else {
+			tagged = true
+		}
+	}
+	deleteCtx, span := tracer().Start(ctx, "delete resource group", trace.WithAttributes(
+		attribute.String("subscription_id", subscriptionID),
+		attribute.String("rg_name", rgName),
+		attribute.String("reason", record.Reason),
+	))
+	var resp *http.Response
+	_, err := c.Deleter.BeginDelete(runtime.WithCaptureResponse(deleteCtx, &resp), rgName, nil)
+	record.Outcome = OutcomeDeletionStarted
+	requestID, correlationRequestID := requestIDs(resp, err)
+	if err != nil {
+		record.Outcome, record.Error = OutcomeFailed, err.Error()
+		record.RequestID, record.CorrelationRequestID = requestID, correlationRequestID
+	}
+	span.SetAttributes(attribute.String("outcome", record.Outcome))
+	endSpan(span, err)
+	if err != nil && tagged {
+		// Restore the tags rg had before DeletionInProgressTag was added.
+		tags := rg.Tags
+		if tags == nil {
+			// Send no tags rather than omitting them, so that the tag is
+			// removed.
+			tags = map[string]*string{}
+		}
+		if err := UpdateTags(ctx, c.Deleter, rg, tags); err != nil {
+			logger.Error(fmt.Sprintf("Error when removing the '%s' tag from %s", DeletionInProgressTag, rgName), "error", err)
+		}
+	}
+	if err != nil {
+		if isForbidden(err) {
+			logger.Error(fmt.Sprintf("Error when deleting %s: principal %s lacks the %s permission in subscription %s", rgName, c.PrincipalObjectID, DeletePermission, subscriptionID), "error", err, "principal_object_id", c.PrincipalObjectID, "request_id", requestID, "correlation_request_id", correlationRequestID)
+		} else {
+			logger.Error(fmt.Sprintf("Error when deleting %s", rgName), "error", err, "request_id", requestID, "correlation_request_id", correlationRequestID)
+		}
+	}
+	if c.Hooks.Deleted != nil {
+		c.Hooks.Deleted(ctx, logger, rg, record)
+	}
+	return record
+}
+
+// Evaluate decides whether rg should be deleted under o and returns a record
+// with the decision. Unlike Decide, it also applies the options that need the
+// resources of rg, listed with resources. The record of a resource group to
+// delete has no outcome yet. Resource groups whose resources cannot be listed
+// are skipped.
+func Evaluate(ctx context.Context, logger *slog.Logger, resources ResourcesClient, subscriptionID string, rg *armresources.ResourceGroup, o *Options) Record {
+	rgName := *rg.Name
+	record := NewRecord(subscriptionID, rg)
+
+	decision := Decide(logger, rg, o)
+	record.Age, record.Reason = decision.Age, decision.Reason
+	if !decision.Delete {
+		return record.Skip(decision.Reason, nil)
+	}
+	if state, ok := hasProvisioningState(rg, o.ProvisioningStates); !ok {
+		logger.Info(fmt.Sprintf("Skip deletion of resource group '%s' in %s because its provisioning state is %s (age: %s)", rgName, location(rg), state, decision.Age), "decision", DecisionSkip, "reason", ReasonProvisioningState)
+		return record.Skip(ReasonProvisioningState, nil)
+	}
+
+	if o.MinResourceCount > 0 {
+		inUse, err := hasAtLeastResources(ctx, resources, rgName, o.MinResourceCount)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Error when counting resources in %s, skipping deletion", rgName), "decision", DecisionSkip, "reason", ReasonResourceCountError, "error", err)
+			return record.Skip(ReasonResourceCountError, err)
+		}
+		if inUse {
+			logger.Info(fmt.Sprintf("Skip deletion of resource group '%s' in %s because it contains at least %d resources (age: %s)", rgName, location(rg), o.MinResourceCount, decision.Age), "decision", DecisionSkip, "reason", ReasonMinResourceCount)
+			return record.Skip(ReasonMinResourceCount, nil)
+		}
+	}
+
+	if len(o.SkipResourceTypes) > 0 {
+		resourceType, found, err := findResourceType(ctx, resources, rgName, o.SkipResourceTypes)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Error when listing the resources of %s, skipping deletion", rgName), "decision", DecisionSkip, "reason", ReasonResourceTypeError, "error", err)
+			return record.Skip(ReasonResourceTypeError, err)
+		}
+		if found {
+			logger.Info(fmt.Sprintf("Skip deletion of resource group '%s' in %s because it contains a resource of type %s (age: %s)", rgName, location(rg), resourceType, decision.Age), "decision", DecisionSkip, "reason", ReasonResourceType)
+			return record.Skip(ReasonResourceType, nil)
+		}
+	}
+
+	record.Decision = DecisionDelete
+	return record
+}
+
+// hasProvisioningState reports whether the provisioning state of rg is one of
+// states, ignoring case, and returns the state. Any state matches when
+// states is empty.
+func hasProvisioningState(rg *armresources.ResourceGroup, states []string) (string, bool) {
+	state := "unknown"
+	if rg.Properties != nil && rg.Properties.ProvisioningState != nil {
+		state = *rg.Properties.ProvisioningState
+	}
+	if len(states) == 0 {
+		return state, true
+	}
+	for _, s := range states {
+		if strings.EqualFold(s, state) {
+			return state, true
+		}
+	}
+	return state, false
+}
+
+// WaitsForGracePeriod reports whether rg, which is eligible for deletion, must
+// not be deleted yet because of gracePeriod: either it was not scheduled for
+// deletion with ScheduledDeletionTag, or less than gracePeriod before now.
+func WaitsForGracePeriod(rg *armresources.ResourceGroup, gracePeriod time.Duration, now time.Time) bool {
+	value, ok := rg.Tags[ScheduledDeletionTag]
+	if !ok || value == nil {
+		return true
+	}
+	scheduled, err := ParseCreationTimestamp(*value)
+	return err != nil || now.Before(scheduled.Add(gracePeriod))
+}
+
+// waitForGracePeriod implements Options.GracePeriod for rg, which is eligible
+// for deletion. It returns true if rg must not be deleted yet, either because
+// it was not scheduled for deletion, in which case it is tagged with
+// ScheduledDeletionTag unless this is a dry run, or because it was scheduled
+// less than Options.GracePeriod ago. An invalid tag is replaced.
+func (c *Cleaner) waitForGracePeriod(ctx context.Context, logger *slog.Logger, rg *armresources.ResourceGroup, now time.Time) (bool, error) {
+	o := c.Options
+	rgName := *rg.Name
+	if value, ok := rg.Tags[ScheduledDeletionTag]; ok && value != nil {
+		scheduled, err := ParseCreationTimestamp(*value)
+		if err == nil {
+			if deleteAt := scheduled.Add(o.GracePeriod); now.Before(deleteAt) {
+				logger.Info(fmt.Sprintf("Skip deletion of resource group '%s' in %s: it is scheduled for deletion after %s", rgName, location(rg), deleteAt.UTC().Format(time.RFC3339)), "decision", DecisionSkip, "reason", ReasonGracePeriod)
+				return true, nil
+			}
+			return false, nil
+		}
+		logger.Warn(fmt.Sprintf("Invalid '%s' tag on resource group '%s', scheduling it again", ScheduledDeletionTag, rgName), "error", err)
+	}
+	if o.DryRun {
+		logger.Info(fmt.Sprintf("Dry-run: skip scheduling the deletion of eligible resource group '%s' in %s after a grace period of %s", rgName, location(rg), o.GracePeriod), "decision", DecisionSkip, "reason", ReasonGracePeriod)
+		return true, nil
+	}
+	logger.Info(fmt.Sprintf("Scheduling the deletion of resource group '%s' in %s after a grace period of %s", rgName, location(rg), o.GracePeriod), "decision", DecisionSkip, "reason", ReasonGracePeriod)
+	return true, setTimestampTag(ctx, c.Deleter, rg, ScheduledDeletionTag, now)
+}
+
+// setTimestampTag adds a tag holding now to the tags of rg.
+func setTimestampTag(ctx context.Context, r ResourceGroupDeleter, rg *armresources.ResourceGroup, tag string, now time.Time) error {
+	tags := make(map[string]*string, len(rg.Tags)+1)
+	for k, v := range rg.Tags {
+		tags[k] = v
+	}
+	timestamp := now.UTC().Format(time.RFC3339)
+	tags[tag] = &timestamp
+	return UpdateTags(ctx, r, rg, tags)
+}
+
+// UpdateTags replaces the tags of rg with tags, keeping its location and
+// manager.
+func UpdateTags(ctx context.Context, r ResourceGroupDeleter, rg *armresources.ResourceGroup, tags map[string]*string) error {
+	_, err := r.CreateOrUpdate(ctx, *rg.Name, armresources.ResourceGroup{
+		Location:  rg.Location,
+		ManagedBy: rg.ManagedBy,
+		Tags:      tags,
+	}, nil)
+	return err
+}
+
+// requestIDs returns the request and correlation IDs of the ARM request that
+// returned resp or failed with err. Either may be empty, e.g. when the request
+// never reached ARM.
+func requestIDs(resp *http.Response, err error) (string, string) {
+	var respErr *azcore.ResponseError
+	if resp == nil && errors.As(err, &respErr) {
+		resp = respErr.RawResponse
+	}
+	if resp == nil {
+		return "", ""
+	}
+	return resp.Header.Get(requestIDHeader), resp.Header.Get(correlationRequestIDHeader)
+}
+
+// isForbidden reports whether err is an ARM response with status 403.
+func isForbidden(err error) bool {
+	var respErr *azcore.ResponseError
+	return errors.As(err, &respErr) && respErr.StatusCode == http.StatusForbidden
+}
+
+// resourceGroupLogger returns logger with the name, location and, when known,
+// the age in hours of rg attached.
+func resourceGroupLogger(logger *slog.Logger, rg *armresources.ResourceGroup) *slog.Logger {
+	logger = logger.With("rg_name", *rg.Name, "location", location(rg))
+	if creationTimestamp, ok := rg.Tags[CreationTimestampTag]; ok && creationTimestamp != nil {
+		if t, err := ParseCreationTimestamp(*creationTimestamp); err == nil {
+			logger = logger.With("age_hours", int(time.Since(t).Hours()))
+		}
+	}
+	return logger
+}
+
+// location returns the location of rg, or "unknown location" if the list
+// response did not include one.
+func location(rg *armresources.ResourceGroup) string {
+	if rg.Location == nil || *rg.Location == "" {
+		return "unknown location"
+	}
+	return *rg.Location
+}
+
+// formatTags formats tags as sorted key=value pairs, truncated to maxLength
+// characters unless it is 0.
+func formatTags(tags map[string]*string, maxLength int) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		v := ""
+		if tags[k] != nil {
+			v = *tags[k]
+		}
+		pairs = append(pairs, fmt.Sprintf("%s=%s", k, v))
+	}
+	formatted := "{" + strings.Join(pairs, ", ") + "}"
+	if maxLength > 0 && len(formatted) > maxLength {
+		return formatted[:maxLength] + "...(truncated)"
+	}
+	return formatted
+}
+
+func tracer() trace.Tracer {
+	return otel.Tracer("github.com/chewong/rg-cleanup/pkg/cleanup")
+}
+
+// endSpan records err, if any, on span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,282 @@
+package cleanup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+func newStaticPager[T any](pages ...T) *runtime.Pager[T] {
+	i := 0
+	return runtime.NewPager(runtime.PagingHandler[T]{
+		More: func(T) bool {
+			return i < len(pages)
+		},
+		Fetcher: func(context.Context, *T) (T, error) {
+			var page T
+			if i < len(pages) {
+				page = pages[i]
+				i++
+			}
+			return page, nil
+		},
+	})
+}
+
+type fakeResourceGroupsClient struct {
+	pages   [][]*armresources.ResourceGroup
+	deleted []string
+	// deleteErrs holds the error of BeginDelete by resource group name.
+	deleteErrs map[string]error
+	// calls lists the BeginDelete and CreateOrUpdate calls in order.
+	calls []string
+}
+
+func (c *fakeResourceGroupsClient) NewListPager(*armresources.ResourceGroupsClientListOptions) *runtime.Pager[armresources.ResourceGroupsClientListResponse] {
+	var pages []armresources.ResourceGroupsClientListResponse
+	for _, page := range c.pages {
+		pages = append(pages, armresources.ResourceGroupsClientListResponse{
+			ResourceGroupListResult: armresources.ResourceGroupListResult{Value: page},
+		})
+	}
+	return newStaticPager(pages...)
+}
+
+func (c *fakeResourceGroupsClient) BeginDelete(_ context.Context, name string, _ *armresources.ResourceGroupsClientBeginDeleteOptions) (*runtime.Poller[armresources.ResourceGroupsClientDeleteResponse], error) {
+	c.calls = append(c.calls, "BeginDelete "+name)
+	if err := c.deleteErrs[name]; err != nil {
+		return nil, err
+	}
+	c.deleted = append(c.deleted, name)
+	return nil, nil
+}
+
+func (c *fakeResourceGroupsClient) CreateOrUpdate(_ context.Context, name string, parameters armresources.ResourceGroup, _ *armresources.ResourceGroupsClientCreateOrUpdateOptions) (armresources.ResourceGroupsClientCreateOrUpdateResponse, error) {
+	c.calls = append(c.calls, "CreateOrUpdate "+name)
+	return armresources.ResourceGroupsClientCreateOrUpdateResponse{ResourceGroup: parameters}, nil
+}
+
+// fakeResourcesClient maps resource group names to the number of resources
+// they contain.
+type fakeResourcesClient map[string]int
+
+func (c fakeResourcesClient) NewListByResourceGroupPager(resourceGroupName string, _ *armresources.ClientListByResourceGroupOptions) *runtime.Pager[armresources.ClientListByResourceGroupResponse] {
+	var resources []*armresources.GenericResourceExpanded
+	for i := 0; i < c[resourceGroupName]; i++ {
+		resources = append(resources, &armresources.GenericResourceExpanded{Name: to.StringPtr(fmt.Sprintf("resource-%d", i))})
+	}
+	return newStaticPager(armresources.ClientListByResourceGroupResponse{
+		ResourceListResult: armresources.ResourceListResult{Value: resources},
+	})
+}
+
+func TestCleanerRun(t *testing.T) {
+	old := to.StringPtr(time.Now().Add(-48 * time.Hour).Format(time.RFC3339))
+	recent := to.StringPtr(time.Now().Format(time.RFC3339))
+	newClient := func() *fakeResourceGroupsClient {
+		return &fakeResourceGroupsClient{
+			pages: [][]*armresources.ResourceGroup{
+				{
+					getResourceGroup("stale", map[string]*string{CreationTimestampTag: old}),
+					getResourceGroup("recent", map[string]*string{CreationTimestampTag: recent}),
+				},
+				{
+					getResourceGroup("in-use", map[string]*string{CreationTimestampTag: old}),
+					getResourceGroup("failing", map[string]*string{CreationTimestampTag: old}),
+				},
+			},
+			deleteErrs: map[string]error{"failing": errors.New("boom")},
+		}
+	}
+	testCases := []struct {
+		desc             string
+		dryRun           bool
+		hooks            func(*[]string) Hooks
+		expectedDeleted  []string
+		expectedEligible int
+		expectedFailed   int
+		expectedSkipped  map[string]int
+		expectedHooks    []string
+	}{
+		{
+			desc:             "stale resource groups are deleted",
+			expectedDeleted:  []string{"stale"},
+			expectedEligible: 2,
+			expectedFailed:   1,
+			expectedSkipped:  map[string]int{ReasonTTLNotElapsed: 1, ReasonMinResourceCount: 1},
+		},
+		{
+			desc:             "dry run deletes nothing",
+			dryRun:           true,
+			expectedEligible: 2,
+			expectedSkipped:  map[string]int{ReasonTTLNotElapsed: 1, ReasonMinResourceCount: 1},
+		},
+		{
+			desc: "hooks are called in order",
+			hooks: func(calls *[]string) Hooks {
+				return Hooks{
+					Listed: func(_ context.Context, _ *slog.Logger, rg *armresources.ResourceGroup) (Record, bool) {
+						*calls = append(*calls, "Listed "+*rg.Name)
+						return NewRecord("sub", rg), true
+					},
+					BeforeDelete: func(_ context.Context, _ *slog.Logger, rg *armresources.ResourceGroup, record Record) (Record, bool) {
+						*calls = append(*calls, "BeforeDelete "+*rg.Name)
+						if *rg.Name == "failing" {
+							return record.Skip("rejected", nil), false
+						}
+						return record, true
+					},
+					Deleted: func(_ context.Context, _ *slog.Logger, rg *armresources.ResourceGroup, record Record) {
+						*calls = append(*calls, "Deleted "+*rg.Name+" "+record.Outcome)
+					},
+					Page: func(_ context.Context, result *Result) {
+						*calls = append(*calls, fmt.Sprintf("Page %d", result.Scanned))
+					},
+				}
+			},
+			expectedDeleted:  []string{"stale"},
+			expectedEligible: 1,
+			expectedSkipped:  map[string]int{ReasonTTLNotElapsed: 1, ReasonMinResourceCount: 1, "rejected": 1},
+			expectedHooks: []string{
+				"Listed stale", "BeforeDelete stale", "Deleted stale " + OutcomeDeletionStarted,
+				"Listed recent", "Page 2",
+				"Listed in-use", "Listed failing", "BeforeDelete failing", "Page 4",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			client := newClient()
+			var calls []string
+			c := &Cleaner{
+				Lister:    client,
+				Deleter:   client,
+				Resources: fakeResourcesClient{"in-use": 2},
+				Options:   &Options{TTL: 24 * time.Hour, MinResourceCount: 2, DryRun: tc.dryRun},
+				Logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+			}
+			if tc.hooks != nil {
+				c.Hooks = tc.hooks(&calls)
+			}
+			result, err := c.Run(context.Background(), "sub")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if fmt.Sprint(client.deleted) != fmt.Sprint(tc.expectedDeleted) {
+				t.Fatalf("expected %v to be deleted, but got %v", tc.expectedDeleted, client.deleted)
+			}
+			if result.Scanned != 4 || result.Eligible != tc.expectedEligible || result.Failed != tc.expectedFailed {
+				t.Fatalf("expected 4 scanned, %d eligible and %d failed, but got %+v", tc.expectedEligible, tc.expectedFailed, result)
+			}
+			if fmt.Sprint(result.Skipped) != fmt.Sprint(tc.expectedSkipped) {
+				t.Fatalf("expected %v to be skipped, but got %v", tc.expectedSkipped, result.Skipped)
+			}
+			if fmt.Sprint(calls) != fmt.Sprint(tc.expectedHooks) {
+				t.Fatalf("expected hooks %v, but got %v", tc.expectedHooks, calls)
+			}
+		})
+	}
+}
+
+func TestCleanerRunMinimumResourceGroups(t *testing.T) {
+	old := to.StringPtr(time.Now().Add(-48 * time.Hour).Format(time.RFC3339))
+	client := &fakeResourceGroupsClient{pages: [][]*armresources.ResourceGroup{{
+		getResourceGroup("a", map[string]*string{CreationTimestampTag: old}),
+		getResourceGroup("b", map[string]*string{CreationTimestampTag: old}),
+	}}}
+	c := &Cleaner{
+		Lister:  client,
+		Deleter: client,
+		Options: &Options{TTL: 24 * time.Hour, MinimumRGsToKeep: 1},
+		Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	if _, err := c.Run(context.Background(), "sub"); err == nil {
+		t.Fatalf("expected an error, but got nil")
+	}
+	if len(client.calls) != 0 {
+		t.Fatalf("expected no calls, but got %v", client.calls)
+	}
+}
+
+func TestTagOnDeleteFailure(t *testing.T) {
+	old := to.StringPtr(time.Now().Add(-48 * time.Hour).Format(time.RFC3339))
+	client := &fakeResourceGroupsClient{
+		pages:      [][]*armresources.ResourceGroup{{getResourceGroup("rg", map[string]*string{CreationTimestampTag: old})}},
+		deleteErrs: map[string]error{"rg": errors.New("boom")},
+	}
+	c := &Cleaner{
+		Lister:  client,
+		Deleter: client,
+		Options: &Options{TTL: 24 * time.Hour, TagOnDelete: true},
+		Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	result, err := c.Run(context.Background(), "sub")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectedCalls := []string{"CreateOrUpdate rg", "BeginDelete rg", "CreateOrUpdate rg"}
+	if fmt.Sprint(client.calls) != fmt.Sprint(expectedCalls) {
+		t.Fatalf("expected calls %v, but got %v", expectedCalls, client.calls)
+	}
+	if record := result.ResourceGroups[0]; record.Outcome != OutcomeFailed || record.Error != "boom" {
+		t.Fatalf("expected a failed record, but got %+v", record)
+	}
+}
+
+func TestHasAtLeastResources(t *testing.T) {
+	resources := fakeResourcesClient{"rg": 5}
+	for min, expected := range map[int]bool{1: true, 5: true, 6: false} {
+		ok, err := hasAtLeastResources(context.Background(), resources, "rg", min)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if ok != expected {
+			t.Fatalf("expected %t for a minimum of %d, but got %t", expected, min, ok)
+		}
+	}
+}
+
+func TestFormatTags(t *testing.T) {
+	tags := map[string]*string{
+		"owner":              to.StringPtr("alice"),
+		CreationTimestampTag: to.StringPtr("2023-01-01T00:00:00Z"),
+		"empty":              nil,
+	}
+	testCases := []struct {
+		desc      string
+		maxLength int
+		expected  string
+	}{
+		{
+			desc:     "no limit",
+			expected: "{creationTimestamp=2023-01-01T00:00:00Z, empty=, owner=alice}",
+		},
+		{
+			desc:      "limit larger than the tags",
+			maxLength: 100,
+			expected:  "{creationTimestamp=2023-01-01T00:00:00Z, empty=, owner=alice}",
+		},
+		{
+			desc:      "truncated",
+			maxLength: 18,
+			expected:  "{creationTimestamp...(truncated)",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if formatted := formatTags(tags, tc.maxLength); formatted != tc.expected {
+				t.Fatalf("expected '%s', but got '%s'", tc.expected, formatted)
+			}
+		})
+	}
+}
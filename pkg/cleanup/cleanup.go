@@ -0,0 +1,377 @@
+// Package cleanup finds and deletes stale Azure resource groups. It holds the
+// deletion policy and the cleanup run of rg-cleanup, so that other tools can
+// apply the same rules without shelling out to the binary.
+package cleanup
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+)
+
+// Tags read and written by the deletion policy.
+const (
+	// CreationTimestampTag holds the RFC 3339 creation time of a resource
+	// group. Resource groups without it are considered old.
+	CreationTimestampTag = "creationTimestamp"
+	// DoNotDeleteTag protects a resource group from deletion.
+	DoNotDeleteTag = "DO-NOT-DELETE"
+	// TTLOverrideTag holds a duration such as "168h" that replaces
+	// Options.TTL for a resource group.
+	TTLOverrideTag = "ttl-override"
+	// DefaultCreatedByTag is the tag holding the object ID of the creator of
+	// a resource group, unless Options.CreatedByTag is set.
+	DefaultCreatedByTag = "createdBy"
+	// ScheduledDeletionTag holds the time at which an eligible resource group
+	// was scheduled for deletion with Options.GracePeriod.
+	ScheduledDeletionTag = "scheduled-for-deletion"
+	// DeletionInProgressTag holds the time at which the deletion of a
+	// resource group was started with Options.TagOnDelete.
+	DeletionInProgressTag = "deletion-in-progress"
+)
+
+// Reasons returned in Decision.Reason.
+const (
+	ReasonProtected           = "protected"
+	ReasonRegexMismatch       = "regex_mismatch"
+	ReasonRegexError          = "regex_error"
+	ReasonTagKeyMismatch      = "tag_key_mismatch"
+	ReasonTagKeyError         = "tag_key_error"
+	ReasonMissingTag          = "missing_tag"
+	ReasonHasTag              = "has_tag"
+	ReasonNotCreatedBy        = "not_created_by"
+	ReasonInvalidTimestamp    = "invalid_timestamp"
+	ReasonTTLNotElapsed       = "ttl_not_elapsed"
+	ReasonTTLElapsed          = "ttl_elapsed"
+	ReasonNoCreationTimestamp = "no_creation_timestamp"
+	ReasonCostAboveThreshold  = "cost_above_threshold"
+	ReasonInvalidCostTag      = "invalid_cost_tag"
+	ReasonProvisioningState   = "provisioning_state"
+	ReasonMinResourceCount    = "min_resource_count"
+	ReasonResourceCountError  = "resource_count_error"
+	ReasonResourceType        = "resource_type"
+	ReasonResourceTypeError   = "resource_type_error"
+	ReasonGracePeriod         = "grace_period"
+)
+
+var rfc3339Layouts = []string{
+	time.RFC3339,
+	time.RFC3339Nano,
+	// The following two layouts are also acceptable
+	// RFC3339 layouts. See:
+	// https://github.com/golang/go/issues/20555#issuecomment-440348440
+	"2006-01-02T15:04:05+0000",
+	"2006-01-02T15:04:05-0000",
+	"2006-01-02T15:04:05-00:00",
+	"2006-01-02T15:04:05+00:00",
+}
+
+// Options are the rules deciding which resource groups are deleted. The zero
+// value deletes every unprotected resource group, as its TTL is zero.
+type Options struct {
+	// TTL is the age after which a resource group is deleted.
+	TTL time.Duration
+	// Regex, when set, only deletes resource groups whose name matches it.
+	// The match must cover the whole name unless DisableRegexFullMatch is
+	// set.
+	Regex                 string
+	DisableRegexFullMatch bool
+	// ProtectTagValues, when set, only lets a DO-NOT-DELETE tag protect a
+	// resource group if its comma-separated value contains one of them.
+	ProtectTagValues []string
+	// TagKeyFilter, when set, only deletes resource groups with a tag key
+	// matching it.
+	TagKeyFilter string
+	// HasTags and MissingTags only delete resource groups with all of, and
+	// none of, these tag keys.
+	HasTags     []string
+	MissingTags []string
+	// CostTag, when set, keeps resource groups whose CostTag tag holds an
+	// amount greater than CostThreshold.
+	CostTag       string
+	CostThreshold float64
+	// CreatedBySPs, when set, only deletes resource groups whose
+	// CreatedByTag tag is one of these service principal object IDs.
+	CreatedBySPs []string
+	CreatedByTag string
+	// Now is the time at which resource groups are evaluated, e.g. a later
+	// time to find those that will become eligible for deletion. The current
+	// time is used when it is zero.
+	Now time.Time
+
+	// The following options are only used by Evaluate and Cleaner, as they
+	// need more than the resource group itself.
+
+	// ProvisioningStates, when set, only deletes resource groups in one of
+	// these provisioning states, compared case-insensitively.
+	ProvisioningStates []string
+	// MinResourceCount, when set, keeps resource groups with at least this
+	// many resources.
+	MinResourceCount int
+	// SkipResourceTypes keeps resource groups with a resource of one of these
+	// types.
+	SkipResourceTypes []string
+	// MinimumRGsToKeep, when set, makes Cleaner.Run refuse to delete anything
+	// if fewer resource groups than this would be left.
+	MinimumRGsToKeep int
+	// GracePeriod, when set, tags eligible resource groups with
+	// ScheduledDeletionTag instead of deleting them, and only deletes them
+	// once GracePeriod has passed since.
+	GracePeriod time.Duration
+	// TagOnDelete tags resource groups with DeletionInProgressTag before
+	// deleting them. The tag is removed if the deletion cannot be started.
+	TagOnDelete bool
+	// DryRun logs what would be deleted without deleting or tagging
+	// anything.
+	DryRun bool
+	// MaxTagLogLength truncates the tags logged for each deleted resource
+	// group to this many characters. No limit when 0.
+	MaxTagLogLength int
+}
+
+// Decision is the outcome of Decide for a resource group.
+type Decision struct {
+	// Delete is set when the resource group is eligible for deletion.
+	Delete bool
+	// Reason is one of the Reason constants, explaining the decision.
+	Reason string
+	// Age describes the age of the resource group. It is empty when the
+	// decision was made before the age was known.
+	Age string
+}
+
+// Decide decides whether rg is eligible for deletion under o. It logs the
+// reason of skipped resource groups to logger.
+func Decide(logger *slog.Logger, rg *armresources.ResourceGroup, o *Options) Decision {
+	if IsProtected(rg.Tags, o.ProtectTagValues) {
+		logger.Debug(fmt.Sprintf("RG '%s' has a '%s' tag", *rg.Name, DoNotDeleteTag), "decision", DecisionSkip, "reason", ReasonProtected)
+		return Decision{Reason: ReasonProtected}
+	}
+
+	if o.Regex != "" {
+		match, err := RegexMatchesResourceGroupName(o.Regex, *rg.Name, !o.DisableRegexFullMatch)
+		if err != nil {
+			logger.Error("failed to regex Resource Group Name", "decision", DecisionSkip, "reason", ReasonRegexError, "error", err)
+			return Decision{Reason: ReasonRegexError}
+		}
+		if !match {
+			logger.Debug(fmt.Sprintf("RG '%s' did not match regex", *rg.Name), "decision", DecisionSkip, "reason", ReasonRegexMismatch)
+			return Decision{Reason: ReasonRegexMismatch}
+		}
+		logger.Debug(fmt.Sprintf("RG '%s' matched regex '%s'", *rg.Name, o.Regex))
+	}
+
+	if o.TagKeyFilter != "" {
+		match, err := RegexMatchesTagKey(o.TagKeyFilter, rg.Tags)
+		if err != nil {
+			logger.Error("failed to regex tag keys", "decision", DecisionSkip, "reason", ReasonTagKeyError, "error", err)
+			return Decision{Reason: ReasonTagKeyError}
+		}
+		if !match {
+			logger.Debug(fmt.Sprintf("RG '%s' has no tag key matching '%s'", *rg.Name, o.TagKeyFilter), "decision", DecisionSkip, "reason", ReasonTagKeyMismatch)
+			return Decision{Reason: ReasonTagKeyMismatch}
+		}
+	}
+
+	for _, key := range o.HasTags {
+		if !hasTagKey(rg.Tags, key) {
+			logger.Debug(fmt.Sprintf("RG '%s' does not have a '%s' tag", *rg.Name, key), "decision", DecisionSkip, "reason", ReasonMissingTag)
+			return Decision{Reason: ReasonMissingTag}
+		}
+	}
+	for _, key := range o.MissingTags {
+		if hasTagKey(rg.Tags, key) {
+			logger.Debug(fmt.Sprintf("RG '%s' has a '%s' tag", *rg.Name, key), "decision", DecisionSkip, "reason", ReasonHasTag)
+			return Decision{Reason: ReasonHasTag}
+		}
+	}
+
+	if o.CostTag != "" {
+		exceeds, err := exceedsCostThreshold(rg.Tags, o.CostTag, o.CostThreshold)
+		if err != nil {
+			logger.Warn(fmt.Sprintf("RG '%s' has an invalid '%s' tag, keeping it to be safe", *rg.Name, o.CostTag), "decision", DecisionSkip, "reason", ReasonInvalidCostTag, "error", err)
+			return Decision{Reason: ReasonInvalidCostTag}
+		}
+		if exceeds {
+			logger.Info(fmt.Sprintf("RG '%s' has a '%s' tag above %g USD", *rg.Name, o.CostTag, o.CostThreshold), "decision", DecisionSkip, "reason", ReasonCostAboveThreshold)
+			return Decision{Reason: ReasonCostAboveThreshold}
+		}
+	}
+
+	if len(o.CreatedBySPs) > 0 && !isCreatedBy(rg.Tags, o.CreatedByTag, o.CreatedBySPs) {
+		logger.Debug(fmt.Sprintf("RG '%s' was not created by any of the given service principals", *rg.Name), "decision", DecisionSkip, "reason", ReasonNotCreatedBy)
+		return Decision{Reason: ReasonNotCreatedBy}
+	}
+
+	creationTimestamp, ok := rg.Tags[CreationTimestampTag]
+	if !ok {
+		return Decision{Delete: true, Reason: ReasonNoCreationTimestamp, Age: fmt.Sprintf("probably a long time because it does not have a '%s' tag. Found tags: %v", CreationTimestampTag, rg.Tags)}
+	}
+
+	if creationTimestamp == nil {
+		logger.Error(fmt.Sprintf("failed to parse timestamp: the '%s' tag has no value", CreationTimestampTag), "decision", DecisionSkip, "reason", ReasonInvalidTimestamp)
+		return Decision{Reason: ReasonInvalidTimestamp}
+	}
+	t, err := ParseCreationTimestamp(*creationTimestamp)
+	if err != nil {
+		logger.Error("failed to parse timestamp", "decision", DecisionSkip, "reason", ReasonInvalidTimestamp, "error", err)
+		return Decision{Reason: ReasonInvalidTimestamp}
+	}
+
+	now := o.now()
+	if now.Sub(t) < TTL(logger, rg, o.TTL) {
+		logger.Debug(fmt.Sprintf("RG '%s' is younger than its TTL (age: %s)", *rg.Name, formatAge(t, now)), "decision", DecisionSkip, "reason", ReasonTTLNotElapsed)
+		return Decision{Reason: ReasonTTLNotElapsed, Age: formatAge(t, now)}
+	}
+	return Decision{Delete: true, Reason: ReasonTTLElapsed, Age: formatAge(t, now)}
+}
+
+func (o *Options) now() time.Time {
+	if o.Now.IsZero() {
+		return time.Now()
+	}
+	return o.Now
+}
+
+// TTL returns the TTL set by the resource group's ttl-override tag, or ttl if
+// the tag is missing or invalid. An invalid tag is logged to logger.
+func TTL(logger *slog.Logger, rg *armresources.ResourceGroup, ttl time.Duration) time.Duration {
+	override, ok := rg.Tags[TTLOverrideTag]
+	if !ok || override == nil {
+		return ttl
+	}
+	d, err := time.ParseDuration(*override)
+	if err != nil {
+		logger.Warn(fmt.Sprintf("RG '%s' has an invalid '%s' tag, using the default TTL of %s", *rg.Name, TTLOverrideTag, ttl), "error", err)
+		return ttl
+	}
+	return d
+}
+
+// hasTagKey reports whether tags has a tag named key, whatever its value.
+// Tag names are compared case-insensitively, like Azure does.
+func hasTagKey(tags map[string]*string, key string) bool {
+	for k := range tags {
+		if strings.EqualFold(k, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// exceedsCostThreshold reports whether the costTag tag holds an amount greater
+// than threshold. A missing tag never exceeds the threshold.
+func exceedsCostThreshold(tags map[string]*string, costTag string, threshold float64) (bool, error) {
+	value, ok := tags[costTag]
+	if !ok || value == nil {
+		return false, nil
+	}
+	cost, err := strconv.ParseFloat(strings.TrimSpace(*value), 64)
+	if err != nil {
+		return false, err
+	}
+	return cost > threshold, nil
+}
+
+// isCreatedBy reports whether the createdByTag tag matches one of the given
+// service principal object IDs.
+func isCreatedBy(tags map[string]*string, createdByTag string, objectIDs []string) bool {
+	createdBy, ok := tags[createdByTag]
+	if !ok || createdBy == nil {
+		return false
+	}
+	for _, id := range objectIDs {
+		if strings.EqualFold(strings.TrimSpace(*createdBy), id) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsProtected reports whether the tags contain a DO-NOT-DELETE tag. When
+// protectTagValues is not empty, the tag only protects if its comma-separated
+// value contains at least one of protectTagValues.
+func IsProtected(tags map[string]*string, protectTagValues []string) bool {
+	value, ok := tags[DoNotDeleteTag]
+	if !ok {
+		return false
+	}
+	if len(protectTagValues) == 0 {
+		return true
+	}
+	if value == nil {
+		return false
+	}
+	for _, v := range strings.Split(*value, ",") {
+		for _, protected := range protectTagValues {
+			if strings.EqualFold(strings.TrimSpace(v), protected) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ParseCreationTimestamp parses the value of a creationTimestamp tag.
+func ParseCreationTimestamp(creationTimestamp string) (time.Time, error) {
+	var t time.Time
+	var err error
+	for _, layout := range rfc3339Layouts {
+		t, err = time.Parse(layout, creationTimestamp)
+		if err == nil {
+			break
+		}
+	}
+	return t, err
+}
+
+// FormatAge describes the time elapsed since t, e.g. "4 days (96 hours)".
+func FormatAge(t time.Time) string {
+	return formatAge(t, time.Now())
+}
+
+func formatAge(t, now time.Time) string {
+	age := now.Sub(t)
+	return fmt.Sprintf("%d days (%d hours)", int(age.Hours()/24), int(age.Hours()))
+}
+
+// RegexMatchesResourceGroupName reports whether regex matches rgName. When
+// fullMatch is set, the match must cover the whole name.
+func RegexMatchesResourceGroupName(regex string, rgName string, fullMatch bool) (bool, error) {
+	if regex != "" {
+		rgx, err := regexp.Compile(regex)
+		if err != nil {
+			return false, fmt.Errorf("failed to compile regex: %v", err)
+		}
+		if !fullMatch {
+			return rgx.MatchString(rgName), nil
+		}
+		match := rgx.FindString(rgName)
+		if match != rgName {
+			return false, nil
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// RegexMatchesTagKey reports whether at least one tag key matches regex.
+// Unlike the resource group name regex, the match does not have to cover the
+// whole key.
+func RegexMatchesTagKey(regex string, tags map[string]*string) (bool, error) {
+	rgx, err := regexp.Compile(regex)
+	if err != nil {
+		return false, fmt.Errorf("failed to compile regex: %v", err)
+	}
+	for key := range tags {
+		if rgx.MatchString(key) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
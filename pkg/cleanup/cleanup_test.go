@@ -0,0 +1,169 @@
+package cleanup
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+func getResourceGroup(name string, tags map[string]*string) *armresources.ResourceGroup {
+	return &armresources.ResourceGroup{Name: to.StringPtr(name), Tags: tags}
+}
+
+func TestDecide(t *testing.T) {
+	now := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+	fourDaysAgo := now.Add(-4 * 24 * time.Hour).Format(time.RFC3339)
+	oneDayAgo := now.Add(-24 * time.Hour).Format(time.RFC3339)
+	testCases := []struct {
+		desc             string
+		rg               *armresources.ResourceGroup
+		now              time.Time
+		expectedDelete   bool
+		expectedReason   string
+		expectedWarnings int
+	}{
+		{
+			desc:           "TTL elapsed",
+			rg:             getResourceGroup("old", map[string]*string{CreationTimestampTag: to.StringPtr(fourDaysAgo)}),
+			expectedDelete: true,
+			expectedReason: ReasonTTLElapsed,
+		},
+		{
+			desc:           "TTL not elapsed",
+			rg:             getResourceGroup("young", map[string]*string{CreationTimestampTag: to.StringPtr(oneDayAgo)}),
+			expectedReason: ReasonTTLNotElapsed,
+		},
+		{
+			desc:           "TTL elapsed at a later time",
+			rg:             getResourceGroup("young", map[string]*string{CreationTimestampTag: to.StringPtr(oneDayAgo)}),
+			now:            now.Add(2 * 24 * time.Hour),
+			expectedDelete: true,
+			expectedReason: ReasonTTLElapsed,
+		},
+		{
+			desc:           "protected",
+			rg:             getResourceGroup("protected", map[string]*string{DoNotDeleteTag: to.StringPtr("")}),
+			expectedReason: ReasonProtected,
+		},
+		{
+			desc:           "no creation timestamp",
+			rg:             getResourceGroup("untagged", nil),
+			expectedDelete: true,
+			expectedReason: ReasonNoCreationTimestamp,
+		},
+		{
+			desc:           "creation timestamp without a value",
+			rg:             getResourceGroup("nil", map[string]*string{CreationTimestampTag: nil}),
+			expectedReason: ReasonInvalidTimestamp,
+		},
+		{
+			desc:             "invalid ttl-override is logged",
+			rg:               getResourceGroup("override", map[string]*string{CreationTimestampTag: to.StringPtr(fourDaysAgo), TTLOverrideTag: to.StringPtr("forever")}),
+			expectedDelete:   true,
+			expectedReason:   ReasonTTLElapsed,
+			expectedWarnings: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelWarn}))
+			o := &Options{TTL: 3 * 24 * time.Hour, Now: now}
+			if !tc.now.IsZero() {
+				o.Now = tc.now
+			}
+			decision := Decide(logger, tc.rg, o)
+			if decision.Delete != tc.expectedDelete || decision.Reason != tc.expectedReason {
+				t.Fatalf("expected delete %t because of %s, but got %t because of %s", tc.expectedDelete, tc.expectedReason, decision.Delete, decision.Reason)
+			}
+			if warnings := strings.Count(buf.String(), "level=WARN"); warnings != tc.expectedWarnings {
+				t.Fatalf("expected %d warnings to be logged, but got %q", tc.expectedWarnings, buf.String())
+			}
+		})
+	}
+}
+
+func TestIsProtected(t *testing.T) {
+	testCases := []struct {
+		desc             string
+		tags             map[string]*string
+		protectTagValues []string
+		expected         bool
+	}{
+		{
+			desc:     "no DO-NOT-DELETE tag",
+			tags:     map[string]*string{"foo": to.StringPtr("bar")},
+			expected: false,
+		},
+		{
+			desc:     "any DO-NOT-DELETE value protects without protected values",
+			tags:     map[string]*string{DoNotDeleteTag: to.StringPtr("")},
+			expected: true,
+		},
+		{
+			desc:             "nil DO-NOT-DELETE value with protected values",
+			tags:             map[string]*string{DoNotDeleteTag: nil},
+			protectTagValues: []string{"infra"},
+			expected:         false,
+		},
+		{
+			desc:             "protected values are matched case-insensitively and ignore spaces",
+			tags:             map[string]*string{DoNotDeleteTag: to.StringPtr("infra, Audit")},
+			protectTagValues: []string{"audit"},
+			expected:         true,
+		},
+		{
+			desc:             "value is not one of the protected values",
+			tags:             map[string]*string{DoNotDeleteTag: to.StringPtr("temporary")},
+			protectTagValues: []string{"infra"},
+			expected:         false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if protected := IsProtected(tc.tags, tc.protectTagValues); protected != tc.expected {
+				t.Fatalf("expected %t, but got %t", tc.expected, protected)
+			}
+		})
+	}
+}
+
+func TestRegexMatchesTagKey(t *testing.T) {
+	tags := map[string]*string{
+		"ci-run-1234": to.StringPtr("true"),
+		"owner":       to.StringPtr("ci-run-5678"),
+	}
+	testCases := []struct {
+		regex    string
+		expected bool
+	}{
+		{regex: "^ci-run-", expected: true},
+		{regex: "^owner$", expected: true},
+		// Tag values are not matched.
+		{regex: "^ci-run-5678$", expected: false},
+		{regex: "^env$", expected: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.regex, func(t *testing.T) {
+			match, err := RegexMatchesTagKey(tc.regex, tags)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if match != tc.expected {
+				t.Fatalf("expected %t, but got %t", tc.expected, match)
+			}
+		})
+	}
+
+	if _, err := RegexMatchesTagKey("(", tags); err == nil {
+		t.Fatal("expected an error for an invalid regex")
+	}
+}
@@ -0,0 +1,103 @@
+package cleanup
+
+import "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+
+// Decisions reported in the "decision" log field and in Record.Decision.
+const (
+	DecisionSkip   = "skip"
+	DecisionDelete = "delete"
+)
+
+// Outcomes of a resource group in Record.Outcome.
+const (
+	OutcomeSkipped         = "skipped"
+	OutcomeDryRun          = "dry_run"
+	OutcomeDeletionStarted = "deletion_started"
+	OutcomeFailed          = "failed"
+)
+
+// Record describes what a Cleaner did with a resource group.
+type Record struct {
+	SubscriptionID string            `json:"subscriptionId"`
+	Name           string            `json:"name"`
+	Location       string            `json:"location,omitempty"`
+	Tags           map[string]string `json:"tags,omitempty"`
+	Age            string            `json:"age,omitempty"`
+	Decision       string            `json:"decision"`
+	Reason         string            `json:"reason"`
+	Outcome        string            `json:"outcome"`
+	Error          string            `json:"error,omitempty"`
+	// RequestID and CorrelationRequestID identify the failed ARM request of
+	// the deletion, as asked for by Azure support.
+	RequestID            string `json:"requestId,omitempty"`
+	CorrelationRequestID string `json:"correlationRequestId,omitempty"`
+	// EstimatedCost is the cost of the resource group over the last 30 days,
+	// when the caller looked it up.
+	EstimatedCost *float64 `json:"estimatedCost,omitempty"`
+	CostCurrency  string   `json:"costCurrency,omitempty"`
+}
+
+// NewRecord returns the record of rg in subscriptionID, with no decision yet.
+func NewRecord(subscriptionID string, rg *armresources.ResourceGroup) Record {
+	record := Record{
+		SubscriptionID: subscriptionID,
+		Name:           *rg.Name,
+	}
+	if rg.Location != nil {
+		record.Location = *rg.Location
+	}
+	if len(rg.Tags) > 0 {
+		record.Tags = make(map[string]string, len(rg.Tags))
+		for k, v := range rg.Tags {
+			if v != nil {
+				record.Tags[k] = *v
+			} else {
+				record.Tags[k] = ""
+			}
+		}
+	}
+	return record
+}
+
+// Skip marks the resource group as skipped for reason.
+func (r Record) Skip(reason string, err error) Record {
+	r.Decision, r.Reason, r.Outcome = DecisionSkip, reason, OutcomeSkipped
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
+
+// Result holds what a Cleaner did in a subscription.
+type Result struct {
+	ResourceGroups []Record
+
+	Scanned int
+	// Eligible counts the resource groups that are stale, whether or not
+	// their deletion was attempted.
+	Eligible int
+	Deleted  int
+	Failed   int
+	// Skipped counts the resource groups that were not deleted, by reason.
+	Skipped map[string]int
+}
+
+// Add records what happened to a resource group.
+func (r *Result) Add(record Record) {
+	if r.Skipped == nil {
+		r.Skipped = map[string]int{}
+	}
+	r.ResourceGroups = append(r.ResourceGroups, record)
+	r.Scanned++
+	if record.Decision == DecisionDelete {
+		r.Eligible++
+	}
+	switch record.Outcome {
+	case OutcomeSkipped:
+		r.Skipped[record.Reason]++
+	case OutcomeDeletionStarted:
+		r.Deleted++
+	case OutcomeFailed:
+		r.Failed++
+	}
+}
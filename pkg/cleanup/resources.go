@@ -0,0 +1,48 @@
+package cleanup
+
+import (
+	"context"
+	"strings"
+)
+
+// hasAtLeastResources reports whether the resource group contains at least
+// min resources. It stops paging as soon as min resources have been seen.
+func hasAtLeastResources(ctx context.Context, c ResourcesClient, rgName string, min int) (bool, error) {
+	count := 0
+	pager := c.NewListByResourceGroupPager(rgName, nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return false, err
+		}
+		count += len(page.Value)
+		if count >= min {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// findResourceType returns the type of the first resource of the resource
+// group whose type is one of types, compared case-insensitively like ARM
+// does, or false if there is none.
+func findResourceType(ctx context.Context, c ResourcesClient, rgName string, types []string) (string, bool, error) {
+	pager := c.NewListByResourceGroupPager(rgName, nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return "", false, err
+		}
+		for _, resource := range page.Value {
+			if resource.Type == nil {
+				continue
+			}
+			for _, t := range types {
+				if strings.EqualFold(*resource.Type, t) {
+					return *resource.Type, true, nil
+				}
+			}
+		}
+	}
+	return "", false, nil
+}
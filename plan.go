@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+
+	"github.com/chewong/rg-cleanup/pkg/cleanup"
+)
+
+const (
+	planVersion = 1
+
+	reasonNotInPlan = "not_in_plan"
+	reasonPlanDrift = "plan_drift"
+)
+
+// plan is the document written by --plan-out in a dry run, and executed by
+// --apply: the resource groups that were eligible for deletion, with the
+// tags they had when the plan was made.
+type plan struct {
+	Version        int         `json:"version"`
+	CreatedAt      time.Time   `json:"createdAt"`
+	ResourceGroups []planEntry `json:"resourceGroups"`
+}
+
+// planEntry is a resource group to delete. Resource groups have no ETag, so
+// their tags, which include the creation timestamp, tell whether they
+// changed since the plan was made.
+type planEntry struct {
+	SubscriptionID string            `json:"subscriptionId"`
+	Name           string            `json:"name"`
+	Tags           map[string]string `json:"tags,omitempty"`
+}
+
+// newPlan returns the plan to delete the resource groups of records that are
+// eligible for deletion.
+func newPlan(records []resourceGroupRecord, now time.Time) *plan {
+	p := &plan{Version: planVersion, CreatedAt: now.UTC(), ResourceGroups: []planEntry{}}
+	for _, record := range records {
+		if record.Decision == decisionDelete {
+			p.ResourceGroups = append(p.ResourceGroups, planEntry{SubscriptionID: record.SubscriptionID, Name: record.Name, Tags: record.Tags})
+		}
+	}
+	sort.Slice(p.ResourceGroups, func(i, j int) bool {
+		a, b := p.ResourceGroups[i], p.ResourceGroups[j]
+		if a.SubscriptionID != b.SubscriptionID {
+			return a.SubscriptionID < b.SubscriptionID
+		}
+		return a.Name < b.Name
+	})
+	return p
+}
+
+func writePlan(path string, p *plan) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode plan: %v", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write plan: %v", err)
+	}
+	return nil
+}
+
+func readPlan(path string) (*plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plan: %v", err)
+	}
+	var p plan
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("failed to decode plan '%s': %v", path, err)
+	}
+	if p.Version != planVersion {
+		return nil, fmt.Errorf("unsupported version %d of plan '%s', expected %d", p.Version, path, planVersion)
+	}
+	return &p, nil
+}
+
+// subscriptionIDs returns the subscriptions of the resource groups of p.
+func (p *plan) subscriptionIDs() []string {
+	var ids []string
+	seen := map[string]bool{}
+	for _, entry := range p.ResourceGroups {
+		if id := strings.ToLower(entry.SubscriptionID); !seen[id] {
+			seen[id] = true
+			ids = append(ids, entry.SubscriptionID)
+		}
+	}
+	return ids
+}
+
+// entry returns the entry of p for the resource group name in
+// subscriptionID, if any. Names are case-insensitive, like in Azure.
+func (p *plan) entry(subscriptionID, name string) (planEntry, bool) {
+	for _, entry := range p.ResourceGroups {
+		if strings.EqualFold(entry.SubscriptionID, subscriptionID) && strings.EqualFold(entry.Name, name) {
+			return entry, true
+		}
+	}
+	return planEntry{}, false
+}
+
+// check returns a skipped record if rg must not be deleted by p: either it
+// is not part of the plan, or its tags changed since the plan was made. The
+// resource groups that pass are still evaluated as usual, so that one that
+// is no longer eligible is not deleted either.
+func (p *plan) check(logger *slog.Logger, subscriptionID string, rg *armresources.ResourceGroup) (resourceGroupRecord, bool) {
+	record := cleanup.NewRecord(subscriptionID, rg)
+	entry, ok := p.entry(subscriptionID, record.Name)
+	if !ok {
+		logger.Debug(fmt.Sprintf("Skip resource group '%s' because it is not part of the plan", record.Name), "decision", decisionSkip, "reason", reasonNotInPlan)
+		return record.Skip(reasonNotInPlan, nil), false
+	}
+	if !reflect.DeepEqual(record.Tags, entry.Tags) && (len(record.Tags) > 0 || len(entry.Tags) > 0) {
+		err := fmt.Errorf("tags changed since the plan was made: %s, expected %s", formatTagMap(record.Tags), formatTagMap(entry.Tags))
+		logger.Info(fmt.Sprintf("Skip deletion of resource group '%s' because it changed since the plan was made", record.Name), "decision", decisionSkip, "reason", reasonPlanDrift, "error", err)
+		return record.Skip(reasonPlanDrift, err), false
+	}
+	return record, true
+}
+
+// missing returns skipped records for the resource groups of p in
+// subscriptionID that were not seen, i.e. that no longer exist.
+func (p *plan) missing(logger *slog.Logger, subscriptionID string, seen map[string]bool) []resourceGroupRecord {
+	var records []resourceGroupRecord
+	for _, entry := range p.ResourceGroups {
+		if !strings.EqualFold(entry.SubscriptionID, subscriptionID) || seen[strings.ToLower(entry.Name)] {
+			continue
+		}
+		logger.Info(fmt.Sprintf("Skip deletion of resource group '%s' because it no longer exists", entry.Name), "decision", decisionSkip, "reason", reasonPlanDrift)
+		record := resourceGroupRecord{SubscriptionID: subscriptionID, Name: entry.Name, Tags: entry.Tags}
+		records = append(records, record.Skip(reasonPlanDrift, fmt.Errorf("the resource group no longer exists")))
+	}
+	return records
+}
+
+// formatTagMap formats tags as sorted key=value pairs.
+func formatTagMap(tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for k, v := range tags {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+func TestPlanRoundTrip(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	p := newPlan([]resourceGroupRecord{
+		{SubscriptionID: "sub-2", Name: "old", Decision: decisionDelete, Outcome: outcomeDryRun, Tags: map[string]string{creationTimestampTag: "2024-01-01T00:00:00Z"}},
+		{SubscriptionID: "sub-1", Name: "young", Decision: decisionSkip, Reason: reasonTTLNotElapsed},
+		{SubscriptionID: "sub-1", Name: "untagged", Decision: decisionDelete, Outcome: outcomeDryRun},
+	}, now)
+	expected := []planEntry{
+		{SubscriptionID: "sub-1", Name: "untagged"},
+		{SubscriptionID: "sub-2", Name: "old", Tags: map[string]string{creationTimestampTag: "2024-01-01T00:00:00Z"}},
+	}
+	if !reflect.DeepEqual(p.ResourceGroups, expected) {
+		t.Fatalf("expected the plan to hold %+v, but got %+v", expected, p.ResourceGroups)
+	}
+
+	path := filepath.Join(t.TempDir(), "plan.json")
+	if err := writePlan(path, p); err != nil {
+		t.Fatal(err)
+	}
+	read, err := readPlan(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, p) {
+		t.Fatalf("expected the plan to round-trip, but got %+v", read)
+	}
+	if ids := read.subscriptionIDs(); !reflect.DeepEqual(ids, []string{"sub-1", "sub-2"}) {
+		t.Fatalf("expected the subscriptions of the plan to be [sub-1 sub-2], but got %v", ids)
+	}
+}
+
+func TestApplyPlan(t *testing.T) {
+	fourDaysAgo := time.Now().Add(-defaultTTL - 24*time.Hour).Format(time.RFC3339)
+	newResourceGroup := func(name string, tags map[string]*string) *armresources.ResourceGroup {
+		rg := getResourceGroup(name, tags)
+		return &rg
+	}
+	r := &fakeResourceGroupsClient{
+		pages: [][]*armresources.ResourceGroup{{
+			newResourceGroup("planned", map[string]*string{creationTimestampTag: to.StringPtr(fourDaysAgo)}),
+			newResourceGroup("Protected-Since", map[string]*string{creationTimestampTag: to.StringPtr(fourDaysAgo), doNotDeleteTag: to.StringPtr("")}),
+			newResourceGroup("unplanned", map[string]*string{creationTimestampTag: to.StringPtr(fourDaysAgo)}),
+		}},
+	}
+	o := &options{ttl: defaultTTL, applyPlan: &plan{Version: planVersion, ResourceGroups: []planEntry{
+		{SubscriptionID: "sub", Name: "planned", Tags: map[string]string{creationTimestampTag: fourDaysAgo}},
+		{SubscriptionID: "sub", Name: "protected-since", Tags: map[string]string{creationTimestampTag: fourDaysAgo}},
+		{SubscriptionID: "sub", Name: "gone", Tags: map[string]string{creationTimestampTag: fourDaysAgo}},
+		{SubscriptionID: "other-sub", Name: "elsewhere"},
+	}}}
+	result, err := runResourceGroupCleanup(context.Background(), "sub", r, r, fakeResourcesClient{}, o)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fmt.Sprint(r.deleted) != "[planned]" {
+		t.Fatalf("expected only the planned resource group to be deleted, but got %v", r.deleted)
+	}
+	expectedSkipped := map[string]int{reasonPlanDrift: 2, reasonNotInPlan: 1}
+	if !reflect.DeepEqual(result.skipped, expectedSkipped) {
+		t.Fatalf("expected skipped %v, but got %v", expectedSkipped, result.skipped)
+	}
+}
+
+func TestValidatePlan(t *testing.T) {
+	nonEmpty := &plan{Version: planVersion, ResourceGroups: []planEntry{{SubscriptionID: "sub", Name: "rg"}}}
+	testCases := []struct {
+		desc        string
+		o           options
+		expectedErr bool
+	}{
+		{
+			desc: "plan in a dry run",
+			o:    options{planOut: "plan.json", dryRun: true},
+		},
+		{
+			desc:        "plan in a live run",
+			o:           options{planOut: "plan.json"},
+			expectedErr: true,
+		},
+		{
+			desc: "apply",
+			o:    options{applyFile: "plan.json", applyPlan: nonEmpty},
+		},
+		{
+			desc:        "apply in a dry run",
+			o:           options{applyFile: "plan.json", applyPlan: nonEmpty, dryRun: true},
+			expectedErr: true,
+		},
+		{
+			desc:        "apply with --watch",
+			o:           options{applyFile: "plan.json", applyPlan: nonEmpty, watch: true},
+			expectedErr: true,
+		},
+		{
+			desc:        "empty plan",
+			o:           options{applyFile: "plan.json", applyPlan: &plan{Version: planVersion}},
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if err := tc.o.validatePlan(); (err != nil) != tc.expectedErr {
+				t.Fatalf("expected error to be %t, but got %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
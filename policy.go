@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armpolicy"
+)
+
+const (
+	cleanupTagPolicyName = "rg-cleanup-require-creation-timestamp"
+	policyEffectAudit    = "Audit"
+	policyEffectDeny     = "Deny"
+)
+
+// policyDefinitionsClient is the subset of *armpolicy.DefinitionsClient used
+// by rg-cleanup.
+type policyDefinitionsClient interface {
+	CreateOrUpdate(ctx context.Context, policyDefinitionName string, parameters armpolicy.Definition, options *armpolicy.DefinitionsClientCreateOrUpdateOptions) (armpolicy.DefinitionsClientCreateOrUpdateResponse, error)
+}
+
+// policyAssignmentsClient is the subset of *armpolicy.AssignmentsClient used
+// by rg-cleanup.
+type policyAssignmentsClient interface {
+	Create(ctx context.Context, scope string, policyAssignmentName string, parameters armpolicy.Assignment, options *armpolicy.AssignmentsClientCreateOptions) (armpolicy.AssignmentsClientCreateResponse, error)
+}
+
+func getPolicyClients(cred azcore.TokenCredential, subscriptionID string) (*armpolicy.DefinitionsClient, *armpolicy.AssignmentsClient, error) {
+	definitions, err := armpolicy.NewDefinitionsClient(subscriptionID, cred, getClientOptions())
+	if err != nil {
+		return nil, nil, err
+	}
+	assignments, err := armpolicy.NewAssignmentsClient(subscriptionID, cred, getClientOptions())
+	if err != nil {
+		return nil, nil, err
+	}
+	return definitions, assignments, nil
+}
+
+// parsePolicyEffect returns the canonical spelling of effect, which must be
+// Audit or Deny.
+func parsePolicyEffect(effect string) (string, error) {
+	for _, e := range []string{policyEffectAudit, policyEffectDeny} {
+		if strings.EqualFold(effect, e) {
+			return e, nil
+		}
+	}
+	return "", fmt.Errorf("unknown policy effect '%s', must be '%s' or '%s'", effect, policyEffectAudit, policyEffectDeny)
+}
+
+// cleanupTagPolicyDefinition returns a policy definition that applies its
+// effect parameter to resource groups without a creationTimestamp tag.
+func cleanupTagPolicyDefinition() armpolicy.Definition {
+	return armpolicy.Definition{
+		Properties: &armpolicy.DefinitionProperties{
+			DisplayName: to.Ptr(fmt.Sprintf("Require a '%s' tag on resource groups", creationTimestampTag)),
+			Description: to.Ptr(fmt.Sprintf("rg-cleanup uses the '%s' tag to find stale resource groups.", creationTimestampTag)),
+			PolicyType:  to.Ptr(armpolicy.PolicyTypeCustom),
+			Mode:        to.Ptr("All"),
+			Parameters: map[string]*armpolicy.ParameterDefinitionsValue{
+				"effect": {
+					Type:          to.Ptr(armpolicy.ParameterTypeString),
+					AllowedValues: []any{policyEffectAudit, policyEffectDeny, "Disabled"},
+					DefaultValue:  policyEffectAudit,
+				},
+			},
+			PolicyRule: map[string]any{
+				"if": map[string]any{
+					"allOf": []any{
+						map[string]any{"field": "type", "equals": "Microsoft.Resources/subscriptions/resourceGroups"},
+						map[string]any{"field": fmt.Sprintf("tags['%s']", creationTimestampTag), "exists": "false"},
+					},
+				},
+				"then": map[string]any{"effect": "[parameters('effect')]"},
+			},
+		},
+	}
+}
+
+// createCleanupTagPolicy creates or updates the policy definition requiring
+// the creationTimestamp tag in the subscription and assigns it to the
+// subscription with the given effect.
+func createCleanupTagPolicy(ctx context.Context, definitions policyDefinitionsClient, assignments policyAssignmentsClient, subscriptionID, effect string) error {
+	definition, err := definitions.CreateOrUpdate(ctx, cleanupTagPolicyName, cleanupTagPolicyDefinition(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create policy definition: %v", err)
+	}
+
+	scope := fmt.Sprintf("/subscriptions/%s", subscriptionID)
+	_, err = assignments.Create(ctx, scope, cleanupTagPolicyName, armpolicy.Assignment{
+		Properties: &armpolicy.AssignmentProperties{
+			DisplayName:        definition.Properties.DisplayName,
+			PolicyDefinitionID: definition.ID,
+			Parameters: map[string]*armpolicy.ParameterValuesValue{
+				"effect": {Value: effect},
+			},
+			NonComplianceMessages: []*armpolicy.NonComplianceMessage{{
+				Message: to.Ptr(fmt.Sprintf("Resource groups must have a '%s' tag so that rg-cleanup can tell when they become stale.", creationTimestampTag)),
+			}},
+		},
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create policy assignment: %v", err)
+	}
+	return nil
+}
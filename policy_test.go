@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armpolicy"
+)
+
+type fakePolicyDefinitionsClient struct {
+	created map[string]armpolicy.Definition
+	err     error
+}
+
+func (c *fakePolicyDefinitionsClient) CreateOrUpdate(_ context.Context, name string, definition armpolicy.Definition, _ *armpolicy.DefinitionsClientCreateOrUpdateOptions) (armpolicy.DefinitionsClientCreateOrUpdateResponse, error) {
+	if c.err != nil {
+		return armpolicy.DefinitionsClientCreateOrUpdateResponse{}, c.err
+	}
+	c.created[name] = definition
+	definition.ID = to.Ptr("/subscriptions/sub/providers/Microsoft.Authorization/policyDefinitions/" + name)
+	return armpolicy.DefinitionsClientCreateOrUpdateResponse{Definition: definition}, nil
+}
+
+type fakePolicyAssignmentsClient struct {
+	created map[string]armpolicy.Assignment
+}
+
+func (c *fakePolicyAssignmentsClient) Create(_ context.Context, scope string, name string, assignment armpolicy.Assignment, _ *armpolicy.AssignmentsClientCreateOptions) (armpolicy.AssignmentsClientCreateResponse, error) {
+	c.created[scope+"/"+name] = assignment
+	return armpolicy.AssignmentsClientCreateResponse{Assignment: assignment}, nil
+}
+
+func TestCreateCleanupTagPolicy(t *testing.T) {
+	definitions := &fakePolicyDefinitionsClient{created: map[string]armpolicy.Definition{}}
+	assignments := &fakePolicyAssignmentsClient{created: map[string]armpolicy.Assignment{}}
+
+	if err := createCleanupTagPolicy(context.Background(), definitions, assignments, "sub", policyEffectDeny); err != nil {
+		t.Fatal(err)
+	}
+
+	definition, ok := definitions.created[cleanupTagPolicyName]
+	if !ok {
+		t.Fatalf("expected the policy definition %s to be created, but got %v", cleanupTagPolicyName, definitions.created)
+	}
+	rule, err := json.Marshal(definition.Properties.PolicyRule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(rule), `"field":"tags['creationTimestamp']"`) || !strings.Contains(string(rule), "Microsoft.Resources/subscriptions/resourceGroups") {
+		t.Fatalf("expected the policy rule to require a creationTimestamp tag on resource groups, but got %s", rule)
+	}
+
+	assignment, ok := assignments.created["/subscriptions/sub/"+cleanupTagPolicyName]
+	if !ok {
+		t.Fatalf("expected the policy to be assigned to the subscription, but got %v", assignments.created)
+	}
+	if got := *assignment.Properties.PolicyDefinitionID; !strings.HasSuffix(got, "/policyDefinitions/"+cleanupTagPolicyName) {
+		t.Fatalf("expected the assignment to reference the definition, but got %s", got)
+	}
+	if got := assignment.Properties.Parameters["effect"].Value; got != policyEffectDeny {
+		t.Fatalf("expected effect %s, but got %v", policyEffectDeny, got)
+	}
+}
+
+func TestCreateCleanupTagPolicyError(t *testing.T) {
+	definitions := &fakePolicyDefinitionsClient{err: errors.New("forbidden")}
+	assignments := &fakePolicyAssignmentsClient{created: map[string]armpolicy.Assignment{}}
+
+	if err := createCleanupTagPolicy(context.Background(), definitions, assignments, "sub", policyEffectAudit); err == nil {
+		t.Fatalf("expected an error, but got nil")
+	}
+	if len(assignments.created) != 0 {
+		t.Fatalf("expected no assignment without a definition, but got %v", assignments.created)
+	}
+}
+
+func TestParsePolicyEffect(t *testing.T) {
+	testCases := []struct {
+		effect      string
+		expected    string
+		expectedErr bool
+	}{
+		{effect: "Audit", expected: policyEffectAudit},
+		{effect: "deny", expected: policyEffectDeny},
+		{effect: "Disabled", expectedErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.effect, func(t *testing.T) {
+			got, err := parsePolicyEffect(tc.effect)
+			if tc.expectedErr != (err != nil) {
+				t.Fatalf("expected error to be %v, but got %v", tc.expectedErr, err)
+			}
+			if got != tc.expected {
+				t.Fatalf("expected %s, but got %s", tc.expected, got)
+			}
+		})
+	}
+}
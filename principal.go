@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// principalObjectID returns the object ID of the principal cred
+// authenticates as, read from the oid claim of an ARM access token.
+func principalObjectID(ctx context.Context, cred azcore.TokenCredential) (string, error) {
+	token, err := cred.GetToken(ctx, policy.TokenRequestOptions{
+		Scopes: []string{cloud.AzurePublic.Services[cloud.ResourceManager].Audience + "/.default"},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get an access token: %v", err)
+	}
+	parts := strings.Split(token.Token, ".")
+	if len(parts) != 3 {
+		return "", errors.New("the access token is not a JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("failed to decode the access token: %v", err)
+	}
+	var claims struct {
+		ObjectID string `json:"oid"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", fmt.Errorf("failed to decode the access token: %v", err)
+	}
+	if claims.ObjectID == "" {
+		return "", errors.New("the access token has no oid claim")
+	}
+	return claims.ObjectID, nil
+}
+
+type principalObjectIDKey struct{}
+
+// withPrincipalObjectID returns a copy of ctx carrying the object ID of the
+// principal rg-cleanup runs as.
+func withPrincipalObjectID(ctx context.Context, objectID string) context.Context {
+	return context.WithValue(ctx, principalObjectIDKey{}, objectID)
+}
+
+// principalObjectIDFrom returns the object ID stored in ctx by
+// withPrincipalObjectID, or "unknown".
+func principalObjectIDFrom(ctx context.Context) string {
+	if objectID, ok := ctx.Value(principalObjectIDKey{}).(string); ok {
+		return objectID
+	}
+	return "unknown"
+}
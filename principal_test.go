@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+// fakeCredential returns token for every request.
+type fakeCredential struct {
+	token string
+}
+
+func (c fakeCredential) GetToken(context.Context, policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: c.token}, nil
+}
+
+func TestPrincipalObjectID(t *testing.T) {
+	jwt := func(claims string) string {
+		return "header." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".signature"
+	}
+	testCases := []struct {
+		desc        string
+		token       string
+		expected    string
+		expectedErr bool
+	}{
+		{
+			desc:     "oid claim",
+			token:    jwt(`{"oid":"00000000-0000-0000-0000-000000000001","tid":"tenant"}`),
+			expected: "00000000-0000-0000-0000-000000000001",
+		},
+		{
+			desc:        "no oid claim",
+			token:       jwt(`{"tid":"tenant"}`),
+			expectedErr: true,
+		},
+		{
+			desc:        "not a JWT",
+			token:       "opaque",
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			objectID, err := principalObjectID(context.Background(), fakeCredential{token: tc.token})
+			if tc.expectedErr != (err != nil) {
+				t.Fatalf("expected error to be %v, but got %v", tc.expectedErr, err)
+			}
+			if objectID != tc.expected {
+				t.Fatalf("expected object ID %q, but got %q", tc.expected, objectID)
+			}
+		})
+	}
+}
+
+func TestForbiddenDeletionLog(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, logFormatText, "info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &fakeResourceGroupsClient{
+		pages: [][]*armresources.ResourceGroup{{
+			{Name: to.StringPtr("old-rg"), Tags: map[string]*string{creationTimestampTag: to.StringPtr(reportFourDaysAgo)}},
+		}},
+		deleteErr: runtime.NewResponseError(&http.Response{
+			StatusCode: http.StatusForbidden,
+			Status:     http.StatusText(http.StatusForbidden),
+			Header:     http.Header{},
+			Body:       http.NoBody,
+			Request:    httptest.NewRequest(http.MethodDelete, "https://management.azure.com/subscriptions/sub/resourcegroups/old-rg", nil),
+		}),
+	}
+	ctx := withPrincipalObjectID(withLogger(context.Background(), logger), "object-id")
+
+	if _, err := runResourceGroupCleanup(ctx, "sub", c, c, fakeResourcesClient{}, &options{ttl: defaultTTL, ignoreDeletionErrors: true}); err != nil {
+		t.Fatal(err)
+	}
+	expected := "Error when deleting old-rg: principal object-id lacks the Microsoft.Resources/subscriptions/resourcegroups/delete permission in subscription sub"
+	if !strings.Contains(buf.String(), expected) || !strings.Contains(buf.String(), "principal_object_id=object-id") {
+		t.Fatalf("expected the log to contain %q, but got %q", expected, buf.String())
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	defaultProgressPages = 10
+	progressInterval     = 30 * time.Second
+)
+
+// progressMetrics are gauges following a scan in progress, partitioned by
+// subscription.
+type progressMetrics struct {
+	pages            *prometheus.GaugeVec
+	rgsScanned       *prometheus.GaugeVec
+	rgsEligible      *prometheus.GaugeVec
+	deletionsStarted *prometheus.GaugeVec
+}
+
+func newProgressMetrics(registry prometheus.Registerer) *progressMetrics {
+	newGaugeVec := func(name, help string) *prometheus.GaugeVec {
+		g := prometheus.NewGaugeVec(prometheus.GaugeOpts{Namespace: metricsNamespace, Subsystem: "progress", Name: name, Help: help}, []string{"subscription_id"})
+		registry.MustRegister(g)
+		return g
+	}
+	return &progressMetrics{
+		pages:            newGaugeVec("pages", "Pages of resource groups fetched so far in the current run."),
+		rgsScanned:       newGaugeVec("rgs_scanned", "Resource groups scanned so far in the current run."),
+		rgsEligible:      newGaugeVec("rgs_eligible", "Resource groups found eligible for deletion so far in the current run."),
+		deletionsStarted: newGaugeVec("deletions_started", "Deletions started so far in the current run."),
+	}
+}
+
+type progressMetricsKey struct{}
+
+// withProgressMetrics returns a copy of ctx carrying m.
+func withProgressMetrics(ctx context.Context, m *progressMetrics) context.Context {
+	return context.WithValue(ctx, progressMetricsKey{}, m)
+}
+
+// progressMetricsFrom returns the metrics stored in ctx by
+// withProgressMetrics, or nil.
+func progressMetricsFrom(ctx context.Context) *progressMetrics {
+	m, _ := ctx.Value(progressMetricsKey{}).(*progressMetrics)
+	return m
+}
+
+// progressReporter logs the progress of the scan of a subscription every
+// everyPages pages, and after a page once interval has passed since the last
+// line. It also updates metrics after every page when they are not nil.
+type progressReporter struct {
+	logger         *slog.Logger
+	metrics        *progressMetrics
+	subscriptionID string
+	everyPages     int
+	interval       time.Duration
+
+	pages      int
+	lastReport time.Time
+}
+
+func newProgressReporter(ctx context.Context, logger *slog.Logger, subscriptionID string, o *options) *progressReporter {
+	return &progressReporter{
+		logger:         logger,
+		metrics:        progressMetricsFrom(ctx),
+		subscriptionID: subscriptionID,
+		everyPages:     o.progressPages,
+		interval:       progressInterval,
+		lastReport:     time.Now(),
+	}
+}
+
+// page records that a page was processed, leaving result with the
+// cumulative counts of the scan.
+func (p *progressReporter) page(result *runResult) {
+	p.pages++
+	if p.metrics != nil {
+		p.metrics.pages.WithLabelValues(p.subscriptionID).Set(float64(p.pages))
+		p.metrics.rgsScanned.WithLabelValues(p.subscriptionID).Set(float64(result.scanned))
+		p.metrics.rgsEligible.WithLabelValues(p.subscriptionID).Set(float64(result.eligible))
+		p.metrics.deletionsStarted.WithLabelValues(p.subscriptionID).Set(float64(result.deleted))
+	}
+	if p.everyPages <= 0 || (p.pages%p.everyPages != 0 && time.Since(p.lastReport) < p.interval) {
+		return
+	}
+	p.lastReport = time.Now()
+	p.logger.Info(fmt.Sprintf("Progress in subscription %s: %d pages fetched, %d resource groups scanned, %d eligible, %d deletions started", p.subscriptionID, p.pages, result.scanned, result.eligible, result.deleted),
+		"pages", p.pages, "rgs_scanned", result.scanned, "rgs_eligible", result.eligible, "deletions_started", result.deleted)
+}
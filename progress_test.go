@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+func TestProgressReporting(t *testing.T) {
+	var pages [][]*armresources.ResourceGroup
+	for i := 0; i < 5; i++ {
+		pages = append(pages, []*armresources.ResourceGroup{
+			{Name: to.StringPtr(fmt.Sprintf("old-%d", i)), Tags: map[string]*string{creationTimestampTag: to.StringPtr(reportFourDaysAgo)}},
+			{Name: to.StringPtr(fmt.Sprintf("young-%d", i)), Tags: map[string]*string{creationTimestampTag: to.StringPtr(reportOneDayAgo)}},
+		})
+	}
+	testCases := []struct {
+		desc          string
+		progressPages int
+		expected      []string
+	}{
+		{
+			desc:          "every 2 pages",
+			progressPages: 2,
+			expected: []string{
+				"Progress in subscription sub: 2 pages fetched, 4 resource groups scanned, 2 eligible, 2 deletions started",
+				"Progress in subscription sub: 4 pages fetched, 8 resource groups scanned, 4 eligible, 4 deletions started",
+			},
+		},
+		{
+			desc: "disabled",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger, err := newLogger(&buf, logFormatText, "info")
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := newMetricsServer()
+			ctx := withProgressMetrics(withLogger(context.Background(), logger), s.progress)
+			c := &fakeResourceGroupsClient{pages: pages}
+			if _, err := runResourceGroupCleanup(ctx, "sub", c, c, fakeResourcesClient{}, &options{ttl: defaultTTL, progressPages: tc.progressPages}); err != nil {
+				t.Fatal(err)
+			}
+
+			var lines []string
+			for _, line := range strings.Split(buf.String(), "\n") {
+				if strings.Contains(line, "Progress in subscription") {
+					lines = append(lines, line)
+				}
+			}
+			if len(lines) != len(tc.expected) {
+				t.Fatalf("expected %d progress lines, but got %q", len(tc.expected), lines)
+			}
+			for i, expected := range tc.expected {
+				if !strings.Contains(lines[i], expected) {
+					t.Fatalf("expected %q, but got %q", expected, lines[i])
+				}
+			}
+
+			rec := httptest.NewRecorder()
+			s.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			for _, expected := range []string{
+				`rg_cleanup_progress_pages{subscription_id="sub"} 5`,
+				`rg_cleanup_progress_rgs_scanned{subscription_id="sub"} 10`,
+				`rg_cleanup_progress_rgs_eligible{subscription_id="sub"} 5`,
+				`rg_cleanup_progress_deletions_started{subscription_id="sub"} 5`,
+			} {
+				if !strings.Contains(rec.Body.String(), expected) {
+					t.Fatalf("expected /metrics to contain %q, but got:\n%s", expected, rec.Body.String())
+				}
+			}
+		})
+	}
+}
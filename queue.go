@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azqueue"
+)
+
+const (
+	// maxQueueMessageSize is the largest message Azure Queue Storage accepts.
+	maxQueueMessageSize = 64 * 1024
+
+	queueMessageDeletions = "deletions"
+	queueMessageSummary   = "summary"
+)
+
+// queueClient is the subset of *azqueue.QueueClient used by rg-cleanup.
+type queueClient interface {
+	EnqueueMessage(ctx context.Context, content string, options *azqueue.EnqueueMessageOptions) (azqueue.EnqueueMessagesResponse, error)
+}
+
+// getResultsQueueClient returns a client for the queue at queueURL. The URL
+// may carry a SAS token; otherwise cred is used. Throttling and server errors
+// are retried with a backoff.
+func getResultsQueueClient(cred azcore.TokenCredential, queueURL string) (*azqueue.QueueClient, error) {
+	parts, err := azqueue.ParseURL(queueURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid results queue URL: %v", err)
+	}
+	options := &azqueue.ClientOptions{ClientOptions: getClientOptions().ClientOptions}
+	options.Retry = policy.RetryOptions{MaxRetries: 5, RetryDelay: time.Second, MaxRetryDelay: 30 * time.Second}
+	if parts.SAS.Signature() != "" {
+		return azqueue.NewQueueClientWithNoCredential(queueURL, options)
+	}
+	return azqueue.NewQueueClient(queueURL, cred, options)
+}
+
+// queueMessage is the JSON content of a message enqueued to
+// --results-queue-url. A run enqueues messages with the deletions it started
+// or failed to start, followed by a message with its summary.
+type queueMessage struct {
+	Type      string                `json:"type"`
+	DryRun    bool                  `json:"dryRun"`
+	Deletions []resourceGroupRecord `json:"deletions,omitempty"`
+	Summary   *runSummary           `json:"summary,omitempty"`
+}
+
+// newQueueMessages returns the messages about the deletions of records,
+// batched in as few messages of at most maxSize bytes as possible, followed by
+// the message about summary.
+func newQueueMessages(summary *runSummary, records []resourceGroupRecord, maxSize int) ([]string, error) {
+	var messages []string
+	var batch []resourceGroupRecord
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		data, err := json.Marshal(queueMessage{Type: queueMessageDeletions, DryRun: summary.DryRun, Deletions: batch})
+		if err != nil {
+			return fmt.Errorf("failed to encode message: %v", err)
+		}
+		messages = append(messages, string(data))
+		batch = nil
+		return nil
+	}
+
+	// The size of a batch is the size of its records, separated by commas,
+	// plus the size of an empty message.
+	empty, err := json.Marshal(queueMessage{Type: queueMessageDeletions, DryRun: summary.DryRun, Deletions: []resourceGroupRecord{{}}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode message: %v", err)
+	}
+	emptyRecord, _ := json.Marshal(resourceGroupRecord{})
+	overhead := len(empty) - len(emptyRecord)
+	size := overhead
+	for _, record := range records {
+		if record.Outcome != outcomeDeletionStarted && record.Outcome != outcomeFailed {
+			continue
+		}
+		data, err := json.Marshal(record)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode record: %v", err)
+		}
+		if overhead+len(data) > maxSize {
+			return nil, fmt.Errorf("record of resource group '%s' is larger than %d bytes", record.Name, maxSize)
+		}
+		if len(batch) > 0 && size+1+len(data) > maxSize {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			size = overhead
+		}
+		if len(batch) > 0 {
+			size++
+		}
+		size += len(data)
+		batch = append(batch, record)
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(queueMessage{Type: queueMessageSummary, DryRun: summary.DryRun, Summary: summary})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode message: %v", err)
+	}
+	if len(data) > maxSize {
+		return nil, fmt.Errorf("summary is larger than %d bytes", maxSize)
+	}
+	return append(messages, string(data)), nil
+}
+
+// enqueueResults enqueues the deletions of records and summary to c. It stops
+// at the first message that cannot be enqueued once retries are exhausted.
+func enqueueResults(ctx context.Context, c queueClient, summary *runSummary, records []resourceGroupRecord) error {
+	messages, err := newQueueMessages(summary, records, maxQueueMessageSize)
+	if err != nil {
+		return err
+	}
+	for i, message := range messages {
+		if _, err := c.EnqueueMessage(ctx, message, nil); err != nil {
+			return fmt.Errorf("failed to enqueue message %d of %d: %v", i+1, len(messages), err)
+		}
+	}
+	return nil
+}
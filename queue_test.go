@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azqueue"
+)
+
+// fakeQueueClient records the messages enqueued to it.
+type fakeQueueClient struct {
+	messages   []string
+	enqueueErr error
+}
+
+func (c *fakeQueueClient) EnqueueMessage(_ context.Context, content string, _ *azqueue.EnqueueMessageOptions) (azqueue.EnqueueMessagesResponse, error) {
+	if c.enqueueErr != nil {
+		return azqueue.EnqueueMessagesResponse{}, c.enqueueErr
+	}
+	c.messages = append(c.messages, content)
+	return azqueue.EnqueueMessagesResponse{}, nil
+}
+
+func TestNewQueueMessages(t *testing.T) {
+	var records []resourceGroupRecord
+	for i := 0; i < 10; i++ {
+		records = append(records, resourceGroupRecord{SubscriptionID: "sub", Name: fmt.Sprintf("rg-%d", i), Decision: decisionDelete, Outcome: outcomeDeletionStarted})
+	}
+	records = append(records,
+		resourceGroupRecord{SubscriptionID: "sub", Name: "failed", Decision: decisionDelete, Outcome: outcomeFailed, Error: "conflict"},
+		resourceGroupRecord{SubscriptionID: "sub", Name: "young", Decision: decisionSkip, Outcome: outcomeSkipped},
+	)
+	summary := &runSummary{SubscriptionIDs: []string{"sub"}, Scanned: 12, Deleted: 10, Failed: 1, Skipped: 1}
+
+	testCases := []struct {
+		desc             string
+		maxSize          int
+		expectedMessages int
+		expectedErr      bool
+	}{
+		{
+			desc:             "all deletions fit in a message",
+			maxSize:          maxQueueMessageSize,
+			expectedMessages: 2,
+		},
+		{
+			desc:             "deletions are split across messages",
+			maxSize:          400,
+			expectedMessages: 5,
+		},
+		{
+			desc:        "record larger than a message",
+			maxSize:     100,
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			messages, err := newQueueMessages(summary, records, tc.maxSize)
+			if tc.expectedErr != (err != nil) {
+				t.Fatalf("expected error to be %v, but got %v", tc.expectedErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if len(messages) != tc.expectedMessages {
+				t.Fatalf("expected %d messages, but got %d: %v", tc.expectedMessages, len(messages), messages)
+			}
+			var deletions []string
+			for i, message := range messages {
+				if len(message) > tc.maxSize {
+					t.Fatalf("expected messages of at most %d bytes, but got %d", tc.maxSize, len(message))
+				}
+				var m queueMessage
+				if err := json.Unmarshal([]byte(message), &m); err != nil {
+					t.Fatal(err)
+				}
+				if i == len(messages)-1 {
+					if m.Type != queueMessageSummary || m.Summary == nil || m.Summary.Deleted != 10 {
+						t.Fatalf("expected the last message to be the summary, but got %s", message)
+					}
+					continue
+				}
+				if m.Type != queueMessageDeletions {
+					t.Fatalf("expected a %s message, but got %s", queueMessageDeletions, message)
+				}
+				for _, record := range m.Deletions {
+					deletions = append(deletions, record.Name)
+				}
+			}
+			if len(deletions) != 11 || deletions[10] != "failed" {
+				t.Fatalf("expected the 11 deletions to be enqueued in order, but got %v", deletions)
+			}
+		})
+	}
+}
+
+func TestEnqueueResults(t *testing.T) {
+	summary := &runSummary{SubscriptionIDs: []string{"sub"}, DryRun: true}
+	c := &fakeQueueClient{}
+	if err := enqueueResults(context.Background(), c, summary, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.messages) != 1 {
+		t.Fatalf("expected only the summary to be enqueued, but got %v", c.messages)
+	}
+
+	c = &fakeQueueClient{enqueueErr: errors.New("forbidden")}
+	if err := enqueueResults(context.Background(), c, summary, nil); err == nil {
+		t.Fatal("expected an error, but got nil")
+	}
+}
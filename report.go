@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/chewong/rg-cleanup/pkg/cleanup"
+)
+
+// Decisions reported in the "decision" log field and in the run report.
+const (
+	decisionSkip   = cleanup.DecisionSkip
+	decisionDelete = cleanup.DecisionDelete
+)
+
+// Outcomes of a resource group in the run report.
+const (
+	outcomeSkipped         = cleanup.OutcomeSkipped
+	outcomeDryRun          = cleanup.OutcomeDryRun
+	outcomeDeletionStarted = cleanup.OutcomeDeletionStarted
+	outcomeFailed          = cleanup.OutcomeFailed
+)
+
+// resourceGroupRecord describes what rg-cleanup did with a resource group.
+type resourceGroupRecord = cleanup.Record
+
+// report is the document written by --report-file.
+type report struct {
+	// Version is the version of rg-cleanup that wrote the report.
+	Version        string                `json:"version"`
+	Start          time.Time             `json:"start"`
+	End            time.Time             `json:"end"`
+	Options        reportOptions         `json:"options"`
+	Subscriptions  []string              `json:"subscriptions"`
+	Summary        *runSummary           `json:"summary"`
+	ResourceGroups []resourceGroupRecord `json:"resourceGroups"`
+	// RoleAssignments is always empty: rg-cleanup does not clean up role
+	// assignments. It is kept so that consumers can rely on the field.
+	RoleAssignments []struct{} `json:"roleAssignments"`
+	Error           string     `json:"error,omitempty"`
+}
+
+// reportOptions are the options of a run that affect its decisions. Secrets
+// are deliberately left out.
+type reportOptions struct {
+	DryRun           bool     `json:"dryRun"`
+	TTL              string   `json:"ttl"`
+	Regex            string   `json:"regex,omitempty"`
+	TagKeyFilter     string   `json:"tagKeyFilter,omitempty"`
+	ProtectTagValues []string `json:"protectTagValues,omitempty"`
+	CreatedBySPs     []string `json:"createdBySPs,omitempty"`
+	MinResourceCount int      `json:"minResourceCount,omitempty"`
+}
+
+func newReport(o *options, result *runResult, start, end time.Time, err error) *report {
+	r := &report{
+		Version: moduleVersion,
+		Start:   start.UTC(),
+		End:     end.UTC(),
+		Options: reportOptions{
+			DryRun:           o.dryRun,
+			TTL:              o.ttl.String(),
+			Regex:            o.regex,
+			TagKeyFilter:     o.tagKeyFilter,
+			ProtectTagValues: o.protectTagValues,
+			CreatedBySPs:     o.createdBySPs,
+			MinResourceCount: o.minResourceCount,
+		},
+		Subscriptions:   o.subscriptionIDs,
+		Summary:         newRunSummary(result, o.subscriptionIDs, o.dryRun),
+		ResourceGroups:  result.resourceGroups,
+		RoleAssignments: []struct{}{},
+	}
+	if r.Subscriptions == nil {
+		r.Subscriptions = []string{}
+	}
+	if r.ResourceGroups == nil {
+		r.ResourceGroups = []resourceGroupRecord{}
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
+
+// writeReport writes r to path as indented JSON.
+func writeReport(path string, r *report) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %v", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write report: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+var (
+	reportFourDaysAgo = time.Now().Add(-96 * time.Hour).Format(time.RFC3339)
+	reportOneDayAgo   = time.Now().Add(-24 * time.Hour).Format(time.RFC3339)
+)
+
+// reportResourceGroups returns resource groups covering each outcome.
+func reportResourceGroups() []*armresources.ResourceGroup {
+	fourDaysAgo := to.StringPtr(reportFourDaysAgo)
+	oneDayAgo := to.StringPtr(reportOneDayAgo)
+	return []*armresources.ResourceGroup{
+		{Name: to.StringPtr("old-rg"), Location: to.StringPtr("westus2"), Tags: map[string]*string{creationTimestampTag: fourDaysAgo, "owner": to.StringPtr("alice")}},
+		{Name: to.StringPtr("young-rg"), Location: to.StringPtr("eastus"), Tags: map[string]*string{creationTimestampTag: oneDayAgo}},
+		{Name: to.StringPtr("kept-rg"), Location: to.StringPtr("eastus"), Tags: map[string]*string{creationTimestampTag: fourDaysAgo, doNotDeleteTag: to.StringPtr("")}},
+		{Name: to.StringPtr("busy-rg"), Location: to.StringPtr("westeurope"), Tags: map[string]*string{creationTimestampTag: fourDaysAgo}},
+	}
+}
+
+func TestReportGolden(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	end := start.Add(90 * time.Second)
+
+	testCases := []struct {
+		desc      string
+		golden    string
+		dryRun    bool
+		deleteErr error
+	}{
+		{
+			desc:   "dry run",
+			golden: "report-dry-run.golden.json",
+			dryRun: true,
+		},
+		{
+			desc:      "failed deletion",
+			golden:    "report-delete-failed.golden.json",
+			deleteErr: errors.New("authorization failed"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			o := &options{
+				ttl:                  defaultTTL,
+				dryRun:               tc.dryRun,
+				minResourceCount:     2,
+				subscriptionIDs:      []string{"sub"},
+				ignoreDeletionErrors: true,
+			}
+			c := &fakeResourceGroupsClient{pages: [][]*armresources.ResourceGroup{reportResourceGroups()}, deleteErr: tc.deleteErr}
+			result, err := runResourceGroupCleanup(context.Background(), "sub", c, c, fakeResourcesClient{"busy-rg": 5}, o)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			path := filepath.Join(t.TempDir(), "report.json")
+			if err := writeReport(path, newReport(o, result, start, end, nil)); err != nil {
+				t.Fatal(err)
+			}
+			compareGolden(t, path, filepath.Join("testdata", tc.golden))
+		})
+	}
+}
+
+func TestReportPartialFailure(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	o := &options{ttl: defaultTTL, dryRun: true, subscriptionIDs: []string{"sub"}}
+
+	// The first page is served, then listing fails, e.g. because the run was
+	// interrupted.
+	pages := 0
+	c := &failingResourceGroupsClient{pager: runtime.NewPager(runtime.PagingHandler[armresources.ResourceGroupsClientListResponse]{
+		More: func(armresources.ResourceGroupsClientListResponse) bool { return true },
+		Fetcher: func(context.Context, *armresources.ResourceGroupsClientListResponse) (armresources.ResourceGroupsClientListResponse, error) {
+			pages++
+			if pages > 1 {
+				return armresources.ResourceGroupsClientListResponse{}, context.Canceled
+			}
+			return armresources.ResourceGroupsClientListResponse{
+				ResourceGroupListResult: armresources.ResourceGroupListResult{Value: reportResourceGroups()[:2]},
+			}, nil
+		},
+	})}
+	result, err := runResourceGroupCleanup(context.Background(), "sub", c, c, fakeResourcesClient{}, o)
+	if err == nil {
+		t.Fatalf("expected an error, but got nil")
+	}
+
+	path := filepath.Join(t.TempDir(), "report.json")
+	if err := writeReport(path, newReport(o, result, start, start.Add(time.Second), err)); err != nil {
+		t.Fatal(err)
+	}
+	compareGolden(t, path, filepath.Join("testdata", "report-partial.golden.json"))
+}
+
+type failingResourceGroupsClient struct {
+	fakeResourceGroupsClient
+	pager *runtime.Pager[armresources.ResourceGroupsClientListResponse]
+}
+
+func (c *failingResourceGroupsClient) NewListPager(*armresources.ResourceGroupsClientListOptions) *runtime.Pager[armresources.ResourceGroupsClientListResponse] {
+	return c.pager
+}
+
+// compareGolden compares the file at path with the golden file, or updates
+// the golden file when -update is set. The creation timestamps, which depend
+// on when the test runs, are replaced by placeholders first.
+func compareGolden(t *testing.T, path, golden string) {
+	t.Helper()
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = bytes.ReplaceAll(got, []byte(reportFourDaysAgo), []byte("<four days ago>"))
+	got = bytes.ReplaceAll(got, []byte(reportOneDayAgo), []byte("<one day ago>"))
+	if *update {
+		if err := os.WriteFile(golden, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	expected, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, expected) {
+		t.Fatalf("expected %s to match %s, but got:\n%s", path, golden, got)
+	}
+}
+
+func TestDeletionFailureRequestIDs(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, logFormatText, "info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := http.Header{}
+	header.Set(armRequestIDHeader, "request-id")
+	header.Set(armCorrelationRequestIDHeader, "correlation-id")
+	c := &fakeResourceGroupsClient{
+		pages: [][]*armresources.ResourceGroup{{
+			{Name: to.StringPtr("old-rg"), Tags: map[string]*string{creationTimestampTag: to.StringPtr(reportFourDaysAgo)}},
+		}},
+		deleteErr: runtime.NewResponseError(&http.Response{
+			StatusCode: http.StatusConflict,
+			Status:     http.StatusText(http.StatusConflict),
+			Header:     header,
+			Body:       http.NoBody,
+			Request:    httptest.NewRequest(http.MethodDelete, "https://management.azure.com/subscriptions/sub/resourcegroups/old-rg", nil),
+		}),
+	}
+
+	result, err := runResourceGroupCleanup(withLogger(context.Background(), logger), "sub", c, c, fakeResourcesClient{}, &options{ttl: defaultTTL, ignoreDeletionErrors: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := result.resourceGroups[0]
+	if record.Outcome != outcomeFailed || record.RequestID != "request-id" || record.CorrelationRequestID != "correlation-id" {
+		t.Fatalf("expected a failed deletion with the request IDs, but got %+v", record)
+	}
+	if !strings.Contains(buf.String(), "request_id=request-id correlation_request_id=correlation-id") {
+		t.Fatalf("expected the error to be logged with the request IDs, but got %q", buf.String())
+	}
+}
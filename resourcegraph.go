@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resourcegraph/armresourcegraph"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+)
+
+// resourceGroupsQuery returns the resource groups of the queried
+// subscriptions with the fields that ARM returns when listing them. Resource
+// groups are in the resourcecontainers table rather than resources.
+const resourceGroupsQuery = "resourcecontainers | where type == 'microsoft.resources/subscriptions/resourcegroups' | project id, name, type, location, managedBy, tags, properties"
+
+// resourceGraphClient is the subset of *armresourcegraph.Client used by
+// rg-cleanup.
+type resourceGraphClient interface {
+	Resources(ctx context.Context, query armresourcegraph.QueryRequest, options *armresourcegraph.ClientResourcesOptions) (armresourcegraph.ClientResourcesResponse, error)
+}
+
+func getResourceGraphClient(cred azcore.TokenCredential) (*armresourcegraph.Client, error) {
+	return armresourcegraph.NewClient(cred, getClientOptions())
+}
+
+// resourceGraphResourceGroups lists the resource groups of a subscription
+// with Azure Resource Graph, which returns up to 1000 of them with their tags
+// per request, and passes the other calls to the ARM client it wraps.
+type resourceGraphResourceGroups struct {
+	resourceGroupsClient
+	graph          resourceGraphClient
+	subscriptionID string
+}
+
+func (c *resourceGraphResourceGroups) NewListPager(*armresources.ResourceGroupsClientListOptions) *runtime.Pager[armresources.ResourceGroupsClientListResponse] {
+	return runtime.NewPager(runtime.PagingHandler[armresources.ResourceGroupsClientListResponse]{
+		More: func(page armresources.ResourceGroupsClientListResponse) bool {
+			return page.NextLink != nil && *page.NextLink != ""
+		},
+		Fetcher: func(ctx context.Context, page *armresources.ResourceGroupsClientListResponse) (armresources.ResourceGroupsClientListResponse, error) {
+			query := armresourcegraph.QueryRequest{
+				Query:         to.Ptr(resourceGroupsQuery),
+				Subscriptions: []*string{to.Ptr(c.subscriptionID)},
+				Options:       &armresourcegraph.QueryRequestOptions{ResultFormat: to.Ptr(armresourcegraph.ResultFormatObjectArray)},
+			}
+			if page != nil {
+				// The skip token of the previous page is kept as its next
+				// link.
+				query.Options.SkipToken = page.NextLink
+			}
+			resp, err := c.graph.Resources(ctx, query, nil)
+			if err != nil {
+				return armresources.ResourceGroupsClientListResponse{}, fmt.Errorf("failed to query Azure Resource Graph: %v", err)
+			}
+			rgs, err := decodeResourceGraphResourceGroups(resp.Data)
+			if err != nil {
+				return armresources.ResourceGroupsClientListResponse{}, err
+			}
+			return armresources.ResourceGroupsClientListResponse{
+				ResourceGroupListResult: armresources.ResourceGroupListResult{Value: rgs, NextLink: resp.SkipToken},
+			}, nil
+		},
+	})
+}
+
+// decodeResourceGraphResourceGroups decodes the rows of a query in the
+// object array format into resource groups.
+func decodeResourceGraphResourceGroups(data any) ([]*armresources.ResourceGroup, error) {
+	// The rows are decoded as maps, so encode them again to decode them
+	// like ARM responses.
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode Azure Resource Graph rows: %v", err)
+	}
+	var rgs []*armresources.ResourceGroup
+	if err := json.Unmarshal(b, &rgs); err != nil {
+		return nil, fmt.Errorf("failed to decode Azure Resource Graph rows as resource groups: %v", err)
+	}
+	return rgs, nil
+}
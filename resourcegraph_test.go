@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resourcegraph/armresourcegraph"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+// fakeResourceGraphClient returns the pages of rows in order, chained by skip
+// tokens, and records the queries.
+type fakeResourceGraphClient struct {
+	pages   [][]any
+	queries []armresourcegraph.QueryRequest
+}
+
+func (c *fakeResourceGraphClient) Resources(_ context.Context, query armresourcegraph.QueryRequest, _ *armresourcegraph.ClientResourcesOptions) (armresourcegraph.ClientResourcesResponse, error) {
+	c.queries = append(c.queries, query)
+	i := len(c.queries) - 1
+	resp := armresourcegraph.ClientResourcesResponse{QueryResponse: armresourcegraph.QueryResponse{Data: c.pages[i]}}
+	if i+1 < len(c.pages) {
+		resp.SkipToken = to.StringPtr("page-2")
+	}
+	return resp, nil
+}
+
+func TestResourceGraphResourceGroups(t *testing.T) {
+	graph := &fakeResourceGraphClient{pages: [][]any{
+		{
+			map[string]any{
+				"id":         "/subscriptions/sub/resourceGroups/old",
+				"name":       "old",
+				"location":   "westus2",
+				"tags":       map[string]any{creationTimestampTag: "2024-01-01T00:00:00Z"},
+				"properties": map[string]any{"provisioningState": "Succeeded"},
+			},
+		},
+		{
+			map[string]any{"id": "/subscriptions/sub/resourceGroups/untagged", "name": "untagged", "location": "eastus", "tags": nil},
+		},
+	}}
+	c := &resourceGraphResourceGroups{resourceGroupsClient: &fakeResourceGroupsClient{}, graph: graph, subscriptionID: "sub"}
+
+	var names []string
+	pager := c.NewListPager(nil)
+	for pager.More() {
+		page, err := pager.NextPage(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, rg := range page.Value {
+			names = append(names, *rg.Name)
+			if *rg.Name == "old" {
+				if *rg.Tags[creationTimestampTag] != "2024-01-01T00:00:00Z" || *rg.Properties.ProvisioningState != "Succeeded" || *rg.Location != "westus2" {
+					t.Fatalf("expected the tags, provisioning state and location to be decoded, but got %+v", rg)
+				}
+			}
+		}
+	}
+	if expected := []string{"old", "untagged"}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected %v, but got %v", expected, names)
+	}
+	if len(graph.queries) != 2 {
+		t.Fatalf("expected 2 queries, but got %d", len(graph.queries))
+	}
+	if first := graph.queries[0]; len(first.Subscriptions) != 1 || *first.Subscriptions[0] != "sub" || first.Options.SkipToken != nil {
+		t.Fatalf("expected the first query to be scoped to the subscription without a skip token, but got %+v", first)
+	}
+	if token := graph.queries[1].Options.SkipToken; token == nil || *token != "page-2" {
+		t.Fatalf("expected the second query to pass the skip token, but got %v", token)
+	}
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/chewong/rg-cleanup/pkg/cleanup"
+)
+
+// resourcesClient is the subset of *armresources.Client used by rg-cleanup.
+type resourcesClient = cleanup.ResourcesClient
+
+func getResourcesClient(cred azcore.TokenCredential, subscriptionID string) (*armresources.Client, error) {
+	return armresources.NewClient(subscriptionID, cred, getClientOptions())
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+func TestRunMinResourceCount(t *testing.T) {
+	fourDaysAgo := time.Now().Add(-defaultTTL - 24*time.Hour).Format(time.RFC3339)
+	oneDayAgo := time.Now().Add(-24 * time.Hour).Format(time.RFC3339)
+	newResourceGroup := func(name, creationTimestamp string) *armresources.ResourceGroup {
+		rg := getResourceGroup(name, map[string]*string{creationTimestampTag: to.StringPtr(creationTimestamp)})
+		return &rg
+	}
+	resources := fakeResourcesClient{
+		"hub":      10,
+		"at-limit": 3,
+		"small":    2,
+		"young":    0,
+	}
+	testCases := []struct {
+		desc             string
+		minResourceCount int
+		expectedDeleted  []string
+	}{
+		{
+			desc:             "disabled",
+			minResourceCount: 0,
+			expectedDeleted:  []string{"hub", "at-limit", "small"},
+		},
+		{
+			desc:             "resource groups with at least 3 resources are kept",
+			minResourceCount: 3,
+			expectedDeleted:  []string{"small"},
+		},
+		{
+			desc:             "resource groups with at least 1 resource are kept",
+			minResourceCount: 1,
+			expectedDeleted:  nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			r := &fakeResourceGroupsClient{
+				pages: [][]*armresources.ResourceGroup{
+					{newResourceGroup("hub", fourDaysAgo), newResourceGroup("at-limit", fourDaysAgo)},
+					{newResourceGroup("small", fourDaysAgo), newResourceGroup("young", oneDayAgo)},
+				},
+			}
+			o := &options{ttl: defaultTTL, minResourceCount: tc.minResourceCount}
+			if _, err := runResourceGroupCleanup(context.Background(), "sub", r, r, resources, o); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if fmt.Sprint(r.deleted) != fmt.Sprint(tc.expectedDeleted) {
+				t.Fatalf("expected %v to be deleted, but got %v", tc.expectedDeleted, r.deleted)
+			}
+		})
+	}
+}
+
+// fakeTypedResourcesClient maps resource group names to the types of the
+// resources they contain, one page per type.
+type fakeTypedResourcesClient map[string][]string
+
+func (c fakeTypedResourcesClient) NewListByResourceGroupPager(resourceGroupName string, _ *armresources.ClientListByResourceGroupOptions) *runtime.Pager[armresources.ClientListByResourceGroupResponse] {
+	var pages []armresources.ClientListByResourceGroupResponse
+	for i, resourceType := range c[resourceGroupName] {
+		pages = append(pages, armresources.ClientListByResourceGroupResponse{
+			ResourceListResult: armresources.ResourceListResult{Value: []*armresources.GenericResourceExpanded{
+				{Name: to.StringPtr(fmt.Sprintf("resource-%d", i)), Type: to.StringPtr(resourceType)},
+			}},
+		})
+	}
+	return newStaticPager(pages...)
+}
+
+func TestRunSkipResourceTypes(t *testing.T) {
+	fourDaysAgo := time.Now().Add(-defaultTTL - 24*time.Hour).Format(time.RFC3339)
+	resources := fakeTypedResourcesClient{
+		"vault":   {"Microsoft.Network/networkInterfaces", "Microsoft.KeyVault/vaults"},
+		"network": {"Microsoft.Network/virtualNetworks"},
+		"compute": {"Microsoft.Compute/virtualMachines", "Microsoft.Compute/disks"},
+	}
+	testCases := []struct {
+		desc              string
+		skipResourceTypes []string
+		expectedDeleted   []string
+	}{
+		{
+			desc:            "disabled",
+			expectedDeleted: []string{"compute", "empty", "network", "vault"},
+		},
+		{
+			desc:              "the type is present on a later page",
+			skipResourceTypes: []string{"Microsoft.KeyVault/vaults"},
+			expectedDeleted:   []string{"compute", "empty", "network"},
+		},
+		{
+			desc:              "types are compared case-insensitively",
+			skipResourceTypes: []string{"microsoft.keyvault/vaults", "Microsoft.Network/VirtualNetworks"},
+			expectedDeleted:   []string{"compute", "empty"},
+		},
+		{
+			desc:              "the type is absent",
+			skipResourceTypes: []string{"Microsoft.Storage/storageAccounts"},
+			expectedDeleted:   []string{"compute", "empty", "network", "vault"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			var rgs []*armresources.ResourceGroup
+			for _, name := range []string{"compute", "empty", "network", "vault"} {
+				rg := getResourceGroup(name, map[string]*string{creationTimestampTag: to.StringPtr(fourDaysAgo)})
+				rgs = append(rgs, &rg)
+			}
+			r := &fakeResourceGroupsClient{pages: [][]*armresources.ResourceGroup{rgs}}
+			o := &options{ttl: defaultTTL, skipResourceTypes: tc.skipResourceTypes}
+			result, err := runResourceGroupCleanup(context.Background(), "sub", r, r, resources, o)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if fmt.Sprint(r.deleted) != fmt.Sprint(tc.expectedDeleted) {
+				t.Fatalf("expected %v to be deleted, but got %v", tc.expectedDeleted, r.deleted)
+			}
+			if skipped := 4 - len(tc.expectedDeleted); result.skipped[reasonResourceType] != skipped {
+				t.Fatalf("expected %d resource groups to be skipped with reason %s, but got %v", skipped, reasonResourceType, result.skipped)
+			}
+		})
+	}
+}
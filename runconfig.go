@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// RunConfig is the serializable form of the options deciding what a run
+// cleans up, for callers that build a run programmatically or round-trip it
+// through JSON or YAML. The flag tag of each field names the flag it stands
+// for, which is also its key in the config file, so that its YAML form can be
+// read with --config. The command is an argument rather than a flag, so it is
+// only part of the JSON form. Like the config file, it holds no credentials
+// or other secrets.
+type RunConfig struct {
+	Command                      string   `json:"command,omitempty" yaml:"-" flag:"-"`
+	DryRun                       bool     `json:"dryRun" yaml:"dry-run" flag:"dry-run"`
+	TTLString                    string   `json:"ttl" yaml:"ttl" flag:"ttl"`
+	Regex                        string   `json:"regex,omitempty" yaml:"regex,omitempty" flag:"regex"`
+	DisableRegexFullMatch        bool     `json:"disableRegexFullMatch,omitempty" yaml:"disable-regex-full-match,omitempty" flag:"disable-regex-full-match"`
+	SubscriptionIDs              []string `json:"subscriptionIds,omitempty" yaml:"subscription-id,omitempty" flag:"subscription-id"`
+	SubscriptionNameFilter       string   `json:"subscriptionNameFilter,omitempty" yaml:"subscription-name-filter,omitempty" flag:"subscription-name-filter"`
+	ScopeLevel                   string   `json:"scopeLevel,omitempty" yaml:"scope-level,omitempty" flag:"scope-level"`
+	ProtectTagValues             []string `json:"protectTagValues,omitempty" yaml:"protect-tag-values,omitempty" flag:"protect-tag-values"`
+	CreatedBySPs                 []string `json:"createdBySps,omitempty" yaml:"created-by-sp,omitempty" flag:"created-by-sp"`
+	CreatedByTag                 string   `json:"createdByTag,omitempty" yaml:"created-by-tag,omitempty" flag:"created-by-tag"`
+	TagKeyFilter                 string   `json:"tagKeyFilter,omitempty" yaml:"tag-key-filter,omitempty" flag:"tag-key-filter"`
+	HasTags                      []string `json:"hasTags,omitempty" yaml:"has-tag,omitempty" flag:"has-tag"`
+	MissingTags                  []string `json:"missingTags,omitempty" yaml:"missing-tag,omitempty" flag:"missing-tag"`
+	SkipIfCostTagExceeds         string   `json:"skipIfCostTagExceeds,omitempty" yaml:"skip-if-cost-tag-exceeds,omitempty" flag:"skip-if-cost-tag-exceeds"`
+	MinResourceCount             int      `json:"minResourceCount,omitempty" yaml:"min-resource-count,omitempty" flag:"min-resource-count"`
+	SkipResourceTypes            []string `json:"skipResourceTypes,omitempty" yaml:"skip-rg-with-resource-type,omitempty" flag:"skip-rg-with-resource-type"`
+	MinimumRGsToKeep             int      `json:"minimumRgsToKeep,omitempty" yaml:"minimum-rgs-to-keep,omitempty" flag:"minimum-rgs-to-keep"`
+	ManagedIdentities            bool     `json:"managedIdentities,omitempty" yaml:"managed-identities,omitempty" flag:"managed-identities"`
+	ManagedIdentityResourceGroup string   `json:"managedIdentityResourceGroup,omitempty" yaml:"managed-identity-resource-group,omitempty" flag:"managed-identity-resource-group"`
+	DeleteOrphanedSnapshots      bool     `json:"deleteOrphanedSnapshots,omitempty" yaml:"delete-orphaned-snapshots,omitempty" flag:"delete-orphaned-snapshots"`
+	IncludeIncrementalSnapshots  bool     `json:"includeIncrementalSnapshots,omitempty" yaml:"include-incremental-snapshots,omitempty" flag:"include-incremental-snapshots"`
+	ClassicAdministrators        bool     `json:"classicAdministrators,omitempty" yaml:"classic-administrators,omitempty" flag:"classic-administrators"`
+	ClassicAdministratorExcludes []string `json:"classicAdministratorExcludes,omitempty" yaml:"classic-administrator-exclude,omitempty" flag:"classic-administrator-exclude"`
+}
+
+// newRunConfig returns the RunConfig of o.
+func newRunConfig(o *options) *RunConfig {
+	return &RunConfig{
+		Command:                      o.command,
+		DryRun:                       o.dryRun,
+		TTLString:                    formatDuration(o.ttl),
+		Regex:                        o.regex,
+		DisableRegexFullMatch:        o.disableRegexFullMatch,
+		SubscriptionIDs:              o.subscriptionIDs,
+		SubscriptionNameFilter:       o.subscriptionNameFilter,
+		ScopeLevel:                   o.scopeLevel,
+		ProtectTagValues:             o.protectTagValues,
+		CreatedBySPs:                 o.createdBySPs,
+		CreatedByTag:                 o.createdByTag,
+		TagKeyFilter:                 o.tagKeyFilter,
+		HasTags:                      o.hasTags,
+		MissingTags:                  o.missingTags,
+		SkipIfCostTagExceeds:         formatCostThreshold(o.costTag, o.costThreshold),
+		MinResourceCount:             o.minResourceCount,
+		SkipResourceTypes:            o.skipResourceTypes,
+		MinimumRGsToKeep:             o.minimumRGsToKeep,
+		ManagedIdentities:            o.managedIdentities,
+		ManagedIdentityResourceGroup: o.managedIdentityResourceGroup,
+		DeleteOrphanedSnapshots:      o.deleteOrphanedSnapshots,
+		IncludeIncrementalSnapshots:  o.includeIncrementalSnapshots,
+		ClassicAdministrators:        o.classicAdministrators,
+		ClassicAdministratorExcludes: o.classicAdministratorExcludes,
+	}
+}
+
+// options returns the options of c. Those that are not part of RunConfig,
+// and the command and scope level when empty, have their default values.
+func (c *RunConfig) options() (*options, error) {
+	ttl, err := time.ParseDuration(c.TTLString)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ttl '%s': %v", c.TTLString, err)
+	}
+	o := &options{
+		command:                      c.Command,
+		dryRun:                       c.DryRun,
+		ttl:                          ttl,
+		regex:                        c.Regex,
+		disableRegexFullMatch:        c.DisableRegexFullMatch,
+		subscriptionIDs:              c.SubscriptionIDs,
+		subscriptionNameFilter:       c.SubscriptionNameFilter,
+		scopeLevel:                   c.ScopeLevel,
+		protectTagValues:             c.ProtectTagValues,
+		createdBySPs:                 c.CreatedBySPs,
+		createdByTag:                 c.CreatedByTag,
+		tagKeyFilter:                 c.TagKeyFilter,
+		hasTags:                      c.HasTags,
+		missingTags:                  c.MissingTags,
+		minResourceCount:             c.MinResourceCount,
+		skipResourceTypes:            c.SkipResourceTypes,
+		minimumRGsToKeep:             c.MinimumRGsToKeep,
+		managedIdentities:            c.ManagedIdentities,
+		managedIdentityResourceGroup: c.ManagedIdentityResourceGroup,
+		deleteOrphanedSnapshots:      c.DeleteOrphanedSnapshots,
+		includeIncrementalSnapshots:  c.IncludeIncrementalSnapshots,
+		classicAdministrators:        c.ClassicAdministrators,
+		classicAdministratorExcludes: c.ClassicAdministratorExcludes,
+
+		policyEffect:            policyEffectAudit,
+		notifyDaysBefore:        defaultNotifyDaysBefore,
+		stuckAfter:              defaultStuckAfter,
+		githubTokenEnv:          defaultGitHubTokenEnv,
+		concurrentSubscriptions: 1,
+		pollInterval:            defaultPollInterval,
+		metricsAddress:          defaultMetricsAddress,
+		leaseDuration:           defaultLeaseDuration,
+		leaseRenewInterval:      defaultLeaseRenewInterval,
+		provisioningStates:      defaultProvisioningStates,
+	}
+	if c.SkipIfCostTagExceeds != "" {
+		if o.costTag, o.costThreshold, err = parseCostThreshold(c.SkipIfCostTagExceeds); err != nil {
+			return nil, fmt.Errorf("invalid skip-if-cost-tag-exceeds: %v", err)
+		}
+	}
+	if o.command == "" {
+		o.command = commandAll
+	}
+	if o.scopeLevel == "" {
+		o.scopeLevel = scopeLevelSubscription
+	}
+	return o, nil
+}
+
+// Validate checks c like the flags are checked, apart from the credentials.
+func (c *RunConfig) Validate() error {
+	o, err := c.options()
+	if err != nil {
+		return err
+	}
+	return o.validateSettings()
+}
+
+// printRunConfig writes the RunConfig of o as JSON to w, as printed by
+// --validate-config --output json.
+func printRunConfig(w io.Writer, o *options) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(newRunConfig(o)); err != nil {
+		return fmt.Errorf("failed to encode the run configuration: %v", err)
+	}
+	return nil
+}
+
+// formatDuration formats d like time.Duration.String, without the zero
+// minutes and seconds, e.g. "72h" rather than "72h0m0s".
+func formatDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestRunConfigRoundTrip(t *testing.T) {
+	o := &options{
+		command:         commandResourceGroups,
+		ttl:             72 * time.Hour,
+		regex:           "^ci-",
+		subscriptionIDs: []string{"sub-1", "sub-2"},
+		scopeLevel:      scopeLevelSubscription,
+		hasTags:         []string{"owner"},
+		costTag:         "estimated-monthly-cost",
+		costThreshold:   12.5,
+		smtpPassword:    "secret",
+	}
+	c := newRunConfig(o)
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"ttl":"72h"`) {
+		t.Fatalf("expected the TTL to be written as \"72h\", but got %s", b)
+	}
+	if strings.Contains(string(b), "secret") {
+		t.Fatalf("expected the run configuration to leave out secrets, but got %s", b)
+	}
+	var fromJSON RunConfig
+	if err := json.Unmarshal(b, &fromJSON); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&fromJSON, c) {
+		t.Fatalf("expected %+v to round-trip through JSON, but got %+v", c, fromJSON)
+	}
+
+	y, err := yaml.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fromYAML RunConfig
+	if err := yaml.Unmarshal(y, &fromYAML); err != nil {
+		t.Fatal(err)
+	}
+	// The command is only part of the JSON form.
+	withoutCommand := *c
+	withoutCommand.Command = ""
+	if !reflect.DeepEqual(fromYAML, withoutCommand) {
+		t.Fatalf("expected %+v to round-trip through YAML, but got %+v", withoutCommand, fromYAML)
+	}
+
+	back, err := fromYAML.options()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if back.ttl != o.ttl || back.regex != o.regex || !reflect.DeepEqual(back.subscriptionIDs, o.subscriptionIDs) || back.costTag != o.costTag || back.costThreshold != o.costThreshold {
+		t.Fatalf("expected the options to round-trip, but got %+v", back)
+	}
+}
+
+// defineFlags defines the flags of rg-cleanup on a new command line and
+// returns it.
+func defineFlags(t *testing.T) *flag.FlagSet {
+	commandLine, usage, args := flag.CommandLine, flag.Usage, os.Args
+	t.Cleanup(func() { flag.CommandLine, flag.Usage, os.Args = commandLine, usage, args })
+	flag.CommandLine = flag.NewFlagSet("rg-cleanup", flag.ContinueOnError)
+	os.Args = []string{"rg-cleanup"}
+	defineOptions()
+	return flag.CommandLine
+}
+
+func TestRunConfigTags(t *testing.T) {
+	// The YAML keys are the flag names, as in the config file.
+	fs := defineFlags(t)
+	typ := reflect.TypeOf(RunConfig{})
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		flagName := field.Tag.Get("flag")
+		if flagName == "" {
+			t.Fatalf("expected field %s to have a flag tag", field.Name)
+		}
+		if flagName == "-" {
+			continue
+		}
+		if fs.Lookup(flagName) == nil {
+			t.Fatalf("expected the flag tag of field %s to name a flag, but there is no --%s", field.Name, flagName)
+		}
+		if key, _, _ := strings.Cut(field.Tag.Get("yaml"), ","); key != flagName {
+			t.Fatalf("expected the YAML key of field %s to be '%s', but got '%s'", field.Name, flagName, key)
+		}
+	}
+}
+
+func TestRunConfigConfigFile(t *testing.T) {
+	c := &RunConfig{
+		DryRun:                       true,
+		TTLString:                    "72h",
+		Regex:                        "^ci-",
+		DisableRegexFullMatch:        true,
+		SubscriptionIDs:              []string{"sub-1", "sub-2"},
+		SubscriptionNameFilter:       "^dev-",
+		ScopeLevel:                   scopeLevelSubscription,
+		ProtectTagValues:             []string{"prod"},
+		CreatedBySPs:                 []string{"sp"},
+		CreatedByTag:                 "created-by",
+		TagKeyFilter:                 "^ci-run-",
+		HasTags:                      []string{"owner"},
+		MissingTags:                  []string{"keep"},
+		SkipIfCostTagExceeds:         "estimated-monthly-cost=12.5",
+		MinResourceCount:             3,
+		SkipResourceTypes:            []string{"Microsoft.KeyVault/vaults"},
+		MinimumRGsToKeep:             2,
+		ManagedIdentities:            true,
+		ManagedIdentityResourceGroup: "identities",
+		DeleteOrphanedSnapshots:      true,
+		IncludeIncrementalSnapshots:  true,
+		ClassicAdministrators:        true,
+		ClassicAdministratorExcludes: []string{"admin@contoso.com"},
+	}
+	y, err := yaml.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := readConfig(writeConfig(t, string(y)))
+	if err != nil {
+		t.Fatalf("expected the YAML form to be a valid config file, but got %v", err)
+	}
+	o := &options{explicit: map[string]bool{}}
+	o.applyConfig(config)
+	if back := newRunConfig(o); !reflect.DeepEqual(back, c) {
+		t.Fatalf("expected %+v to round-trip through the config file, but got %+v", c, back)
+	}
+}
+
+func TestRunConfigValidate(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		c           RunConfig
+		expectedErr string
+	}{
+		{
+			desc: "valid",
+			c:    RunConfig{TTLString: "72h", SubscriptionIDs: []string{"sub"}},
+		},
+		{
+			desc:        "invalid TTL",
+			c:           RunConfig{TTLString: "3 days", SubscriptionIDs: []string{"sub"}},
+			expectedErr: "invalid ttl",
+		},
+		{
+			desc:        "no subscriptions",
+			c:           RunConfig{TTLString: "72h"},
+			expectedErr: "no subscription IDs",
+		},
+		{
+			desc:        "resource groups command with managed identities",
+			c:           RunConfig{Command: commandResourceGroups, TTLString: "72h", SubscriptionIDs: []string{"sub"}, ManagedIdentities: true},
+			expectedErr: "only cleans up resource groups",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := tc.c.Validate()
+			if tc.expectedErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+				t.Fatalf("expected an error containing '%s', but got %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	for d, expected := range map[time.Duration]string{
+		72 * time.Hour:             "72h",
+		90 * time.Minute:           "1h30m",
+		time.Hour + 30*time.Second: "1h0m30s",
+		45 * time.Second:           "45s",
+		0:                          "0s",
+	} {
+		if got := formatDuration(d); got != expected {
+			t.Fatalf("expected %s to be formatted as '%s', but got '%s'", d, expected, got)
+		}
+	}
+}
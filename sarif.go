@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+const (
+	sarifVersion = "2.1.0"
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+	helpURI      = "https://github.com/Azure/rg-cleanup#usage"
+
+	staleResourceGroupRule    = "stale-resource-group"
+	untaggedResourceGroupRule = "untagged-resource-group"
+)
+
+// The following types are the subset of the SARIF 2.1.0 schema written by
+// rg-cleanup.
+
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	Version        string      `json:"version"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+	HelpURI          string       `json:"helpUri"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID     string            `json:"ruleId"`
+	Level      string            `json:"level"`
+	Message    sarifMessage      `json:"message"`
+	Locations  []sarifLocation   `json:"locations"`
+	Properties map[string]string `json:"properties"`
+}
+
+type sarifLocation struct {
+	LogicalLocations []sarifLogicalLocation `json:"logicalLocations"`
+}
+
+type sarifLogicalLocation struct {
+	Name               string `json:"name"`
+	FullyQualifiedName string `json:"fullyQualifiedName"`
+	Kind               string `json:"kind"`
+}
+
+// newSARIFLog returns a SARIF log with one result per resource group that was
+// found to be stale. Resource groups without a creationTimestamp tag are
+// reported as errors, the others as warnings.
+func newSARIFLog(resourceGroups []resourceGroupRecord) *sarifLog {
+	results := []sarifResult{}
+	for _, rg := range resourceGroups {
+		if rg.Decision != decisionDelete {
+			continue
+		}
+		ruleID, level := staleResourceGroupRule, "warning"
+		message := fmt.Sprintf("Resource group '%s' is stale (age: %s)", rg.Name, rg.Age)
+		if rg.Reason == reasonNoCreationTimestamp {
+			ruleID, level = untaggedResourceGroupRule, "error"
+			message = fmt.Sprintf("Resource group '%s' has no '%s' tag", rg.Name, creationTimestampTag)
+		}
+		results = append(results, sarifResult{
+			RuleID:  ruleID,
+			Level:   level,
+			Message: sarifMessage{Text: message},
+			Locations: []sarifLocation{{
+				LogicalLocations: []sarifLogicalLocation{{
+					Name:               rg.Name,
+					FullyQualifiedName: fmt.Sprintf("/subscriptions/%s/resourceGroups/%s", rg.SubscriptionID, rg.Name),
+					Kind:               "resourceGroup",
+				}},
+			}},
+			Properties: map[string]string{
+				"subscriptionId": rg.SubscriptionID,
+				"resourceGroup":  rg.Name,
+				"age":            rg.Age,
+			},
+		})
+	}
+
+	return &sarifLog{
+		Version: sarifVersion,
+		Schema:  sarifSchema,
+		Runs: []sarifRun{{
+			Tool: sarifTool{Driver: sarifDriver{
+				Name:           moduleName,
+				Version:        moduleVersion,
+				InformationURI: "https://github.com/Azure/rg-cleanup",
+				Rules: []sarifRule{
+					{
+						ID:               staleResourceGroupRule,
+						ShortDescription: sarifMessage{Text: "Resource group is older than its TTL"},
+						HelpURI:          helpURI,
+					},
+					{
+						ID:               untaggedResourceGroupRule,
+						ShortDescription: sarifMessage{Text: fmt.Sprintf("Resource group has no '%s' tag", creationTimestampTag)},
+						HelpURI:          helpURI,
+					},
+				},
+			}},
+			Results: results,
+		}},
+	}
+}
+
+// writeSARIF writes a SARIF file listing the stale resource groups to path.
+func writeSARIF(path string, resourceGroups []resourceGroupRecord) error {
+	data, err := json.MarshalIndent(newSARIFLog(resourceGroups), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode SARIF file: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write SARIF file: %v", err)
+	}
+	return nil
+}
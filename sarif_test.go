@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteSARIF(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rg-cleanup.sarif")
+	stale := []resourceGroupRecord{
+		{SubscriptionID: "sub", Name: "old-rg", Age: "4 days (96 hours)", Decision: decisionDelete, Reason: reasonTTLElapsed},
+		{SubscriptionID: "sub", Name: "untagged-rg", Age: "probably a long time", Decision: decisionDelete, Reason: reasonNoCreationTimestamp},
+	}
+	resourceGroups := append(stale, resourceGroupRecord{SubscriptionID: "sub", Name: "young-rg", Decision: decisionSkip, Reason: reasonTTLNotElapsed})
+	if err := writeSARIF(path, resourceGroups); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var log sarifLog
+	if err := json.Unmarshal(data, &log); err != nil {
+		t.Fatalf("expected valid JSON, but got %v", err)
+	}
+	if log.Version != sarifVersion || len(log.Runs) != 1 {
+		t.Fatalf("expected a SARIF %s log with one run, but got %+v", sarifVersion, log)
+	}
+
+	results := log.Runs[0].Results
+	if len(results) != len(stale) {
+		t.Fatalf("expected %d results, but got %d", len(stale), len(results))
+	}
+	for i, tc := range []struct {
+		ruleID string
+		level  string
+	}{
+		{ruleID: staleResourceGroupRule, level: "warning"},
+		{ruleID: untaggedResourceGroupRule, level: "error"},
+	} {
+		r := results[i]
+		if r.RuleID != tc.ruleID || r.Level != tc.level {
+			t.Fatalf("expected result %d to be %s/%s, but got %s/%s", i, tc.ruleID, tc.level, r.RuleID, r.Level)
+		}
+		if r.Properties["subscriptionId"] != "sub" || r.Properties["resourceGroup"] != stale[i].Name || r.Properties["age"] != stale[i].Age {
+			t.Fatalf("expected the result properties to describe %s, but got %v", stale[i].Name, r.Properties)
+		}
+		expectedFQN := "/subscriptions/sub/resourceGroups/" + stale[i].Name
+		if got := r.Locations[0].LogicalLocations[0].FullyQualifiedName; got != expectedFQN {
+			t.Fatalf("expected location %s, but got %s", expectedFQN, got)
+		}
+	}
+}
+
+func TestWriteSARIFNoResults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rg-cleanup.sarif")
+	if err := writeSARIF(path, nil); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw struct {
+		Runs []map[string]json.RawMessage `json:"runs"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		t.Fatal(err)
+	}
+	// SARIF requires results to be an array, not null, when the tool ran.
+	if got := string(raw.Runs[0]["results"]); got != "[]" {
+		t.Fatalf("expected an empty results array, but got %s", got)
+	}
+}
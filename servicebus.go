@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+)
+
+// serviceBusSender is the subset of *azservicebus.Sender used by rg-cleanup.
+type serviceBusSender interface {
+	SendMessage(ctx context.Context, message *azservicebus.Message, options *azservicebus.SendMessageOptions) error
+}
+
+// serviceBusDeletionEvent is the body of the message published to
+// --servicebus-topic for each deleted resource group.
+type serviceBusDeletionEvent struct {
+	RGName         string            `json:"rgName"`
+	SubscriptionID string            `json:"subscriptionId"`
+	Age            string            `json:"age,omitempty"`
+	Tags           map[string]string `json:"tags,omitempty"`
+	Timestamp      time.Time         `json:"timestamp"`
+}
+
+// serviceBusPublisher publishes deletions to a Service Bus topic. A nil
+// *serviceBusPublisher discards them.
+type serviceBusPublisher struct {
+	sender serviceBusSender
+}
+
+// newServiceBusPublisher connects to the topic of the Service Bus namespace of
+// connectionString. The returned function closes the connection.
+func newServiceBusPublisher(connectionString, topic string) (*serviceBusPublisher, func(), error) {
+	client, err := azservicebus.NewClientFromConnectionString(connectionString, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create Service Bus client: %v", err)
+	}
+	sender, err := client.NewSender(topic, nil)
+	if err != nil {
+		client.Close(context.Background())
+		return nil, nil, fmt.Errorf("failed to create Service Bus sender for topic '%s': %v", topic, err)
+	}
+	closeFunc := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+		defer cancel()
+		sender.Close(ctx)
+		client.Close(ctx)
+	}
+	return &serviceBusPublisher{sender: sender}, closeFunc, nil
+}
+
+// publish sends a message for record, a resource group whose deletion was
+// started at now.
+func (p *serviceBusPublisher) publish(ctx context.Context, record resourceGroupRecord, now time.Time) error {
+	if p == nil {
+		return nil
+	}
+	body, err := json.Marshal(serviceBusDeletionEvent{
+		RGName:         record.Name,
+		SubscriptionID: record.SubscriptionID,
+		Age:            record.Age,
+		Tags:           record.Tags,
+		Timestamp:      now.UTC(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+	return p.sender.SendMessage(ctx, &azservicebus.Message{Body: body, ContentType: to.Ptr("application/json")}, nil)
+}
+
+type serviceBusPublisherKey struct{}
+
+func withServiceBusPublisher(ctx context.Context, p *serviceBusPublisher) context.Context {
+	return context.WithValue(ctx, serviceBusPublisherKey{}, p)
+}
+
+// serviceBusPublisherFrom returns the publisher of ctx, or nil if there is
+// none.
+func serviceBusPublisherFrom(ctx context.Context) *serviceBusPublisher {
+	p, _ := ctx.Value(serviceBusPublisherKey{}).(*serviceBusPublisher)
+	return p
+}
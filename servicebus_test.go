@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+type fakeServiceBusSender struct {
+	messages []*azservicebus.Message
+	err      error
+}
+
+func (s *fakeServiceBusSender) SendMessage(_ context.Context, message *azservicebus.Message, _ *azservicebus.SendMessageOptions) error {
+	s.messages = append(s.messages, message)
+	return s.err
+}
+
+func TestServiceBusPublisher(t *testing.T) {
+	sender := &fakeServiceBusSender{}
+	ctx := withServiceBusPublisher(context.Background(), &serviceBusPublisher{sender: sender})
+	old := getResourceGroup("old-rg", map[string]*string{creationTimestampTag: to.StringPtr(reportFourDaysAgo), "owner": to.StringPtr("alice")})
+	recent := getResourceGroup("recent-rg", map[string]*string{creationTimestampTag: to.StringPtr(reportOneDayAgo)})
+	c := &fakeResourceGroupsClient{pages: [][]*armresources.ResourceGroup{{&old, &recent}}}
+	if _, err := runResourceGroupCleanup(ctx, "sub", c, c, fakeResourcesClient{}, &options{ttl: defaultTTL}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sender.messages) != 1 {
+		t.Fatalf("expected a message for the deleted resource group, but got %d", len(sender.messages))
+	}
+	if *sender.messages[0].ContentType != "application/json" {
+		t.Fatalf("expected a JSON message, but got %s", *sender.messages[0].ContentType)
+	}
+	var event serviceBusDeletionEvent
+	if err := json.Unmarshal(sender.messages[0].Body, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.RGName != "old-rg" || event.SubscriptionID != "sub" || event.Tags["owner"] != "alice" || event.Age == "" || time.Since(event.Timestamp) > time.Minute {
+		t.Fatalf("unexpected event %+v", event)
+	}
+}
+
+func TestServiceBusPublisherError(t *testing.T) {
+	p := &serviceBusPublisher{sender: &fakeServiceBusSender{err: errors.New("unauthorized")}}
+	if err := p.publish(context.Background(), resourceGroupRecord{Name: "rg"}, time.Now()); err == nil {
+		t.Fatal("expected an error, but got nil")
+	}
+	var nilPublisher *serviceBusPublisher
+	if err := nilPublisher.publish(context.Background(), resourceGroupRecord{Name: "rg"}, time.Now()); err != nil {
+		t.Fatalf("expected a nil publisher to discard the event, but got %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	slackWebhookURLEnvVar = "SLACK_WEBHOOK_URL"
+
+	slackColorClean  = "#2eb886"
+	slackColorFailed = "#e01e5a"
+	slackColorDryRun = "#439fe0"
+)
+
+type slackMessage struct {
+	Text        string            `json:"text"`
+	Attachments []slackAttachment `json:"attachments"`
+}
+
+type slackAttachment struct {
+	Color  string       `json:"color"`
+	Blocks []slackBlock `json:"blocks"`
+}
+
+type slackBlock struct {
+	Type string     `json:"type"`
+	Text *slackText `json:"text,omitempty"`
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+func newSlackSection(text string) slackBlock {
+	return slackBlock{Type: "section", Text: &slackText{Type: "mrkdwn", Text: text}}
+}
+
+// newSlackMessage returns a Block Kit message summarizing a run. The color
+// tells clean runs, runs with failures and dry runs apart.
+func newSlackMessage(summary *runSummary) *slackMessage {
+	color := slackColorClean
+	switch {
+	case summary.DryRun:
+		color = slackColorDryRun
+	case summary.Failed > 0:
+		color = slackColorFailed
+	}
+
+	text := summary.headline()
+	blocks := []slackBlock{
+		newSlackSection(fmt.Sprintf("*%s*", text)),
+		newSlackSection(fmt.Sprintf("Scanned: %d, skipped: %d", summary.Scanned, summary.Skipped)),
+	}
+	if len(summary.Oldest) > 0 {
+		var b strings.Builder
+		fmt.Fprintf(&b, "*Oldest %d:*", len(summary.Oldest))
+		for _, rg := range summary.Oldest {
+			fmt.Fprintf(&b, "\n• `%s` (%s, age: %s, %s)", rg.Name, rg.SubscriptionID, rg.Age, rg.Outcome)
+		}
+		blocks = append(blocks, newSlackSection(b.String()))
+	}
+
+	return &slackMessage{
+		Text:        text,
+		Attachments: []slackAttachment{{Color: color, Blocks: blocks}},
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestNewSlackMessage(t *testing.T) {
+	newResult := func(records ...resourceGroupRecord) *runResult {
+		result := &runResult{skipped: map[string]int{}}
+		for _, record := range records {
+			result.addResourceGroup(record)
+		}
+		return result
+	}
+	deleted := func(name, created string) resourceGroupRecord {
+		return resourceGroupRecord{SubscriptionID: "sub", Name: name, Tags: map[string]string{creationTimestampTag: created}, Decision: decisionDelete, Outcome: outcomeDeletionStarted}
+	}
+
+	testCases := []struct {
+		desc          string
+		result        *runResult
+		dryRun        bool
+		expectedText  string
+		expectedColor string
+	}{
+		{
+			desc:          "clean run",
+			result:        newResult(deleted("rg-1", "2024-01-01T00:00:00Z"), resourceGroupRecord{Name: "rg-2", Decision: decisionSkip, Reason: reasonProtected, Outcome: outcomeSkipped}),
+			expectedText:  "rg-cleanup deleted 1 RGs in sub",
+			expectedColor: slackColorClean,
+		},
+		{
+			desc:          "run with failures",
+			result:        newResult(deleted("rg-1", "2024-01-01T00:00:00Z"), resourceGroupRecord{Name: "rg-2", Decision: decisionDelete, Outcome: outcomeFailed}),
+			expectedText:  "rg-cleanup deleted 1 RGs (1 failed) in sub",
+			expectedColor: slackColorFailed,
+		},
+		{
+			desc:          "dry run",
+			result:        newResult(resourceGroupRecord{Name: "rg-1", Decision: decisionDelete, Outcome: outcomeDryRun}),
+			dryRun:        true,
+			expectedText:  "rg-cleanup dry run: 1 RGs would be deleted in sub",
+			expectedColor: slackColorDryRun,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			msg := newSlackMessage(newRunSummary(tc.result, []string{"sub"}, tc.dryRun))
+			if msg.Text != tc.expectedText {
+				t.Fatalf("expected text %q, but got %q", tc.expectedText, msg.Text)
+			}
+			if msg.Attachments[0].Color != tc.expectedColor {
+				t.Fatalf("expected color %s, but got %s", tc.expectedColor, msg.Attachments[0].Color)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v5"
+	"github.com/chewong/rg-cleanup/pkg/cleanup"
+)
+
+// snapshotsClient is the subset of *armcompute.SnapshotsClient used by
+// rg-cleanup.
+type snapshotsClient interface {
+	NewListPager(options *armcompute.SnapshotsClientListOptions) *runtime.Pager[armcompute.SnapshotsClientListResponse]
+	BeginDelete(ctx context.Context, resourceGroupName string, snapshotName string, options *armcompute.SnapshotsClientBeginDeleteOptions) (*runtime.Poller[armcompute.SnapshotsClientDeleteResponse], error)
+}
+
+func getSnapshotsClient(cred azcore.TokenCredential, subscriptionID string) (*armcompute.SnapshotsClient, error) {
+	return armcompute.NewSnapshotsClient(subscriptionID, cred, getClientOptions())
+}
+
+// runSnapshotCleanup deletes the disk snapshots of the subscription that are
+// older than the TTL. Incremental snapshots are only deleted with
+// --include-incremental-snapshots: they only store the changes since the
+// previous snapshot, so they cost little and deleting one can break a backup
+// chain.
+func runSnapshotCleanup(ctx context.Context, c snapshotsClient, o *options) error {
+	slog.Info("Scanning for orphaned disk snapshots")
+
+	pager := c.NewListPager(nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("error when iterating snapshots: %v", err)
+		}
+
+		for _, snapshot := range page.Value {
+			name := *snapshot.Name
+			age, ok := shouldDeleteSnapshot(snapshot, o)
+			if !ok {
+				continue
+			}
+
+			id, err := arm.ParseResourceID(*snapshot.ID)
+			if err != nil {
+				slog.Error(fmt.Sprintf("Error when parsing the ID of snapshot '%s'", name), "error", err)
+				continue
+			}
+
+			logger := slog.With("rg_name", id.ResourceGroupName, "size_gb", snapshotSizeGB(snapshot))
+			if o.dryRun {
+				logger.Info(fmt.Sprintf("Dry-run: skip deletion of snapshot '%s' in resource group '%s' (age: %s, size: %d GB)", name, id.ResourceGroupName, age, snapshotSizeGB(snapshot)), "dry_run", true)
+				continue
+			}
+
+			logger.Info(fmt.Sprintf("Deleting snapshot '%s' in resource group '%s' (age: %s, size: %d GB)", name, id.ResourceGroupName, age, snapshotSizeGB(snapshot)))
+			if _, err := c.BeginDelete(ctx, id.ResourceGroupName, name, nil); err != nil {
+				logger.Error(fmt.Sprintf("Error when deleting snapshot %s", name), "error", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// shouldDeleteSnapshot reports whether snapshot is older than the TTL and is
+// neither protected nor, unless o.includeIncrementalSnapshots is set,
+// incremental. The age comes from the creation time of the snapshot.
+func shouldDeleteSnapshot(snapshot *armcompute.Snapshot, o *options) (string, bool) {
+	if cleanup.IsProtected(snapshot.Tags, o.protectTagValues) {
+		return "", false
+	}
+	if snapshot.Properties == nil || snapshot.Properties.TimeCreated == nil {
+		slog.Debug(fmt.Sprintf("Skipping snapshot '%s' without a creation time", *snapshot.Name))
+		return "", false
+	}
+	if snapshot.Properties.Incremental != nil && *snapshot.Properties.Incremental && !o.includeIncrementalSnapshots {
+		slog.Debug(fmt.Sprintf("Skipping incremental snapshot '%s'", *snapshot.Name))
+		return "", false
+	}
+
+	t := *snapshot.Properties.TimeCreated
+	return cleanup.FormatAge(t), time.Since(t) >= o.ttl
+}
+
+// snapshotSizeGB returns the size of snapshot, or 0 if it is unknown.
+func snapshotSizeGB(snapshot *armcompute.Snapshot) int32 {
+	if snapshot.Properties == nil || snapshot.Properties.DiskSizeGB == nil {
+		return 0
+	}
+	return *snapshot.Properties.DiskSizeGB
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v5"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+type fakeSnapshotsClient struct {
+	snapshots []*armcompute.Snapshot
+	deleted   []string
+	deleteErr error
+}
+
+func (c *fakeSnapshotsClient) NewListPager(*armcompute.SnapshotsClientListOptions) *runtime.Pager[armcompute.SnapshotsClientListResponse] {
+	return newStaticPager(armcompute.SnapshotsClientListResponse{
+		SnapshotList: armcompute.SnapshotList{Value: c.snapshots},
+	})
+}
+
+func (c *fakeSnapshotsClient) BeginDelete(_ context.Context, resourceGroupName string, snapshotName string, _ *armcompute.SnapshotsClientBeginDeleteOptions) (*runtime.Poller[armcompute.SnapshotsClientDeleteResponse], error) {
+	if c.deleteErr != nil {
+		return nil, c.deleteErr
+	}
+	c.deleted = append(c.deleted, resourceGroupName+"/"+snapshotName)
+	return nil, nil
+}
+
+func getSnapshot(resourceGroup, name string, incremental bool, createdAt time.Time, tags map[string]*string) *armcompute.Snapshot {
+	return &armcompute.Snapshot{
+		ID:   to.StringPtr(fmt.Sprintf("/subscriptions/sub/resourceGroups/%s/providers/Microsoft.Compute/snapshots/%s", resourceGroup, name)),
+		Name: to.StringPtr(name),
+		Tags: tags,
+		Properties: &armcompute.SnapshotProperties{
+			Incremental: &incremental,
+			TimeCreated: &createdAt,
+			DiskSizeGB:  to.Int32Ptr(128),
+		},
+	}
+}
+
+func TestShouldDeleteSnapshot(t *testing.T) {
+	oneDayAgo := time.Now().Add(-24 * time.Hour)
+	fourDaysAgo := time.Now().Add(-defaultTTL - 24*time.Hour)
+	testCases := []struct {
+		desc                string
+		snapshot            *armcompute.Snapshot
+		includeIncremental  bool
+		expectedToBeDeleted bool
+		expectedAge         string
+	}{
+		{
+			desc:                "snapshot created less than 3 days ago",
+			snapshot:            getSnapshot("rg", "snap", false, oneDayAgo, nil),
+			expectedToBeDeleted: false,
+			expectedAge:         "1 days (24 hours)",
+		},
+		{
+			desc:                "snapshot created more than 3 days ago",
+			snapshot:            getSnapshot("rg", "snap", false, fourDaysAgo, nil),
+			expectedToBeDeleted: true,
+			expectedAge:         "4 days (96 hours)",
+		},
+		{
+			desc:                "incremental snapshot",
+			snapshot:            getSnapshot("rg", "snap", true, fourDaysAgo, nil),
+			expectedToBeDeleted: false,
+		},
+		{
+			desc:                "incremental snapshot with --include-incremental-snapshots",
+			snapshot:            getSnapshot("rg", "snap", true, fourDaysAgo, nil),
+			includeIncremental:  true,
+			expectedToBeDeleted: true,
+			expectedAge:         "4 days (96 hours)",
+		},
+		{
+			desc:                "snapshot with a DO-NOT-DELETE tag",
+			snapshot:            getSnapshot("rg", "snap", false, fourDaysAgo, map[string]*string{doNotDeleteTag: to.StringPtr("")}),
+			expectedToBeDeleted: false,
+		},
+		{
+			desc:                "snapshot without a creation time",
+			snapshot:            &armcompute.Snapshot{Name: to.StringPtr("snap")},
+			expectedToBeDeleted: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			age, ok := shouldDeleteSnapshot(tc.snapshot, &options{ttl: defaultTTL, includeIncrementalSnapshots: tc.includeIncremental})
+			if ok != tc.expectedToBeDeleted {
+				t.Fatalf("expected %t, but got %t", tc.expectedToBeDeleted, ok)
+			}
+			if age != tc.expectedAge {
+				t.Fatalf("expected the snapshot age to be '%s', but got '%s'", tc.expectedAge, age)
+			}
+		})
+	}
+}
+
+func TestRunSnapshotCleanup(t *testing.T) {
+	fourDaysAgo := time.Now().Add(-defaultTTL - 24*time.Hour)
+	snapshots := []*armcompute.Snapshot{
+		getSnapshot("vms", "full", false, fourDaysAgo, nil),
+		getSnapshot("vms", "incremental", true, fourDaysAgo, nil),
+		getSnapshot("vms", "too-young", false, time.Now(), nil),
+		getSnapshot("other", "full-elsewhere", false, fourDaysAgo, nil),
+	}
+
+	testCases := []struct {
+		desc            string
+		dryRun          bool
+		deleteErr       error
+		expectedDeleted []string
+	}{
+		{
+			desc:            "full snapshots older than the TTL are deleted",
+			expectedDeleted: []string{"vms/full", "other/full-elsewhere"},
+		},
+		{
+			desc:   "dry-run",
+			dryRun: true,
+		},
+		{
+			desc:      "deletion failures are logged",
+			deleteErr: errors.New("conflict"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			c := &fakeSnapshotsClient{snapshots: snapshots, deleteErr: tc.deleteErr}
+			if err := runSnapshotCleanup(context.Background(), c, &options{ttl: defaultTTL, dryRun: tc.dryRun}); err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(c.deleted) != fmt.Sprint(tc.expectedDeleted) {
+				t.Fatalf("expected %v to be deleted, but got %v", tc.expectedDeleted, c.deleted)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statsdClient sends counters and timings in the DogStatsD format over UDP.
+// Metrics are fire and forget: a nil *statsdClient, or one whose address is
+// unreachable, sends nothing without slowing down the cleanup.
+type statsdClient struct {
+	conn net.Conn
+
+	// errOnce logs only the first send error, since an agent that is down
+	// would fail every metric.
+	errOnce sync.Once
+}
+
+// newStatsdClient returns a client sending to addr, or nil if addr is empty
+// or cannot be resolved.
+func newStatsdClient(addr string) *statsdClient {
+	if addr == "" {
+		return nil
+	}
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		slog.Warn("Not sending statsd metrics", "statsd_addr", addr, "error", err)
+		return nil
+	}
+	return &statsdClient{conn: conn}
+}
+
+// count adds value to the counter name.
+func (c *statsdClient) count(name string, value int, tags ...string) {
+	c.send(name, strconv.Itoa(value), "c", tags)
+}
+
+// timing records a duration, in milliseconds, in the timer name.
+func (c *statsdClient) timing(name string, d time.Duration, tags ...string) {
+	c.send(name, strconv.FormatInt(d.Milliseconds(), 10), "ms", tags)
+}
+
+// send writes a metric line, e.g. rg_cleanup.rg.skipped:1|c|#reason:protected.
+// tags are key:value pairs.
+func (c *statsdClient) send(name, value, metricType string, tags []string) {
+	if c == nil {
+		return
+	}
+	line := fmt.Sprintf("%s.%s:%s|%s", metricsNamespace, name, value, metricType)
+	if len(tags) > 0 {
+		line += "|#" + strings.Join(tags, ",")
+	}
+	if _, err := c.conn.Write([]byte(line)); err != nil {
+		c.errOnce.Do(func() {
+			slog.Warn("Error when sending statsd metrics", "error", err)
+		})
+	}
+}
+
+// observeResourceGroup counts what happened to the resource group of record.
+func (c *statsdClient) observeResourceGroup(record resourceGroupRecord, dryRun bool) {
+	tags := []string{"subscription_id:" + record.SubscriptionID, "dry_run:" + strconv.FormatBool(dryRun)}
+	switch record.Outcome {
+	case outcomeDeletionStarted:
+		c.count("rg.deleted", 1, tags...)
+	case outcomeFailed:
+		c.count("rg.failed", 1, tags...)
+	case outcomeSkipped:
+		c.count("rg.skipped", 1, append(tags, "reason:"+record.Reason)...)
+	}
+}
+
+func (c *statsdClient) close() {
+	if c == nil {
+		return
+	}
+	c.conn.Close()
+}
+
+type statsdClientKey struct{}
+
+// withStatsdClient returns a copy of ctx carrying c.
+func withStatsdClient(ctx context.Context, c *statsdClient) context.Context {
+	return context.WithValue(ctx, statsdClientKey{}, c)
+}
+
+// statsdClientFrom returns the client stored in ctx by withStatsdClient, or
+// nil.
+func statsdClientFrom(ctx context.Context) *statsdClient {
+	c, _ := ctx.Value(statsdClientKey{}).(*statsdClient)
+	return c
+}
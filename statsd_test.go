@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+// listenStatsd returns a UDP listener and a function reading the next n
+// packets sent to it.
+func listenStatsd(t *testing.T) (*net.UDPConn, func(n int) []string) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, func(n int) []string {
+		var packets []string
+		buf := make([]byte, 1024)
+		for len(packets) < n {
+			if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+				t.Fatal(err)
+			}
+			size, err := conn.Read(buf)
+			if err != nil {
+				t.Fatalf("expected %d packets, but got %v: %v", n, packets, err)
+			}
+			packets = append(packets, string(buf[:size]))
+		}
+		return packets
+	}
+}
+
+func TestStatsdResourceGroupMetrics(t *testing.T) {
+	conn, read := listenStatsd(t)
+	c := newStatsdClient(conn.LocalAddr().String())
+	if c == nil {
+		t.Fatal("expected a statsd client")
+	}
+	defer c.close()
+
+	fourDaysAgo := to.StringPtr(reportFourDaysAgo)
+	r := &fakeResourceGroupsClient{pages: [][]*armresources.ResourceGroup{{
+		{Name: to.StringPtr("old"), Tags: map[string]*string{creationTimestampTag: fourDaysAgo}},
+		{Name: to.StringPtr("new"), Tags: map[string]*string{creationTimestampTag: to.StringPtr(reportOneDayAgo)}},
+		{Name: to.StringPtr("protected"), Tags: map[string]*string{creationTimestampTag: fourDaysAgo, doNotDeleteTag: to.StringPtr("")}},
+	}}}
+	ctx := withStatsdClient(context.Background(), c)
+	if _, err := runResourceGroupCleanup(ctx, "sub", r, r, fakeResourcesClient{}, &options{ttl: defaultTTL}); err != nil {
+		t.Fatal(err)
+	}
+
+	packets := read(3)
+	sort.Strings(packets)
+	expected := []string{
+		"rg_cleanup.rg.deleted:1|c|#subscription_id:sub,dry_run:false",
+		"rg_cleanup.rg.skipped:1|c|#subscription_id:sub,dry_run:false,reason:protected",
+		"rg_cleanup.rg.skipped:1|c|#subscription_id:sub,dry_run:false,reason:ttl_not_elapsed",
+	}
+	if strings.Join(packets, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("expected packets %v, but got %v", expected, packets)
+	}
+}
+
+func TestStatsdTiming(t *testing.T) {
+	conn, read := listenStatsd(t)
+	c := newStatsdClient(conn.LocalAddr().String())
+	defer c.close()
+	c.timing("run.duration", 1500*time.Millisecond, "success:true")
+	if packets := read(1); packets[0] != "rg_cleanup.run.duration:1500|ms|#success:true" {
+		t.Fatalf("unexpected packet %q", packets[0])
+	}
+}
+
+func TestStatsdNoop(t *testing.T) {
+	if c := newStatsdClient(""); c != nil {
+		t.Fatal("expected no client without an address")
+	}
+	if c := newStatsdClient("not a valid address"); c != nil {
+		t.Fatal("expected no client with an invalid address")
+	}
+	var c *statsdClient
+	c.count("rg.deleted", 1)
+	c.close()
+}
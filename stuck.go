@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const defaultStuckAfter = 3
+
+// stuckResourceGroup is a resource group whose deletion failed in at least
+// one run and that still exists.
+type stuckResourceGroup struct {
+	SubscriptionID string            `json:"subscriptionId"`
+	Name           string            `json:"name"`
+	Tags           map[string]string `json:"tags,omitempty"`
+	// Failures is the number of runs in which the deletion failed.
+	Failures             int       `json:"failures"`
+	LastFailure          time.Time `json:"lastFailure"`
+	LastError            string    `json:"lastError,omitempty"`
+	RequestID            string    `json:"requestId,omitempty"`
+	CorrelationRequestID string    `json:"correlationRequestId,omitempty"`
+}
+
+// stuckState is the content of --stuck-state-file. It carries the failed
+// deletions over from one run to the next.
+type stuckState struct {
+	ResourceGroups map[string]*stuckResourceGroup `json:"resourceGroups"`
+}
+
+func stuckStateKey(subscriptionID, rgName string) string {
+	return strings.ToLower(subscriptionID + "/" + rgName)
+}
+
+// readStuckState reads the state at path. A missing file is an empty state,
+// as on the first run.
+func readStuckState(path string) (*stuckState, error) {
+	state := &stuckState{ResourceGroups: map[string]*stuckResourceGroup{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stuck state file: %v", err)
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to decode stuck state file '%s': %v", path, err)
+	}
+	if state.ResourceGroups == nil {
+		state.ResourceGroups = map[string]*stuckResourceGroup{}
+	}
+	return state, nil
+}
+
+// writeStuckState replaces the state at path. The state is written to a
+// temporary file first so that an interrupted run does not lose it.
+func writeStuckState(path string, state *stuckState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode stuck state: %v", err)
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write stuck state file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write stuck state file: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write stuck state file: %v", err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("failed to write stuck state file: %v", err)
+	}
+	return nil
+}
+
+// update records the failed deletions of records, a run over every one of
+// subscriptionIDs. It returns the resource groups that failed to be deleted
+// in this run and in at least after runs overall, and the tracked resource
+// groups that no longer exist, which it stops tracking. Both are sorted by
+// key.
+func (s *stuckState) update(records []resourceGroupRecord, subscriptionIDs []string, after int, now time.Time) (stuck, gone []*stuckResourceGroup) {
+	seen := map[string]bool{}
+	for _, record := range records {
+		key := stuckStateKey(record.SubscriptionID, record.Name)
+		seen[key] = true
+		if record.Outcome != outcomeFailed {
+			continue
+		}
+		rg, ok := s.ResourceGroups[key]
+		if !ok {
+			rg = &stuckResourceGroup{SubscriptionID: record.SubscriptionID, Name: record.Name}
+			s.ResourceGroups[key] = rg
+		}
+		rg.Tags = record.Tags
+		rg.Failures++
+		rg.LastFailure = now.UTC()
+		rg.LastError = record.Error
+		rg.RequestID, rg.CorrelationRequestID = record.RequestID, record.CorrelationRequestID
+		if rg.Failures >= after {
+			stuck = append(stuck, rg)
+		}
+	}
+
+	scanned := map[string]bool{}
+	for _, subscriptionID := range subscriptionIDs {
+		scanned[strings.ToLower(subscriptionID)] = true
+	}
+	for key, rg := range s.ResourceGroups {
+		// Resource groups of subscriptions that were not part of this run
+		// may still exist.
+		if !seen[key] && scanned[strings.ToLower(rg.SubscriptionID)] {
+			gone = append(gone, rg)
+			delete(s.ResourceGroups, key)
+		}
+	}
+
+	for _, rgs := range [][]*stuckResourceGroup{stuck, gone} {
+		sort.Slice(rgs, func(i, j int) bool {
+			return stuckStateKey(rgs[i].SubscriptionID, rgs[i].Name) < stuckStateKey(rgs[j].SubscriptionID, rgs[j].Name)
+		})
+	}
+	return stuck, gone
+}
+
+// trackStuckResourceGroups updates --stuck-state-file with the failed
+// deletions of records, warns about the stuck resource groups and, with
+// --github-repo, syncs their issues.
+func trackStuckResourceGroups(ctx context.Context, o *options, records []resourceGroupRecord, now time.Time) error {
+	state, err := readStuckState(o.stuckStateFile)
+	if err != nil {
+		return err
+	}
+	stuck, gone := state.update(records, o.subscriptionIDs, o.stuckAfter, now)
+	if err := writeStuckState(o.stuckStateFile, state); err != nil {
+		return err
+	}
+	for _, rg := range stuck {
+		slog.Warn(fmt.Sprintf("Resource group '%s' is stuck: its deletion failed in %d runs", rg.Name, rg.Failures), "subscription_id", rg.SubscriptionID, "rg_name", rg.Name, "failures", rg.Failures, "error", rg.LastError)
+	}
+	if o.githubRepo == "" {
+		return nil
+	}
+	return syncGitHubIssues(ctx, newGitHubIssuesClient(o.githubRepo, o.githubToken), stuck, gone)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStuckStateUpdate(t *testing.T) {
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	failed := func(subscriptionID, name string) resourceGroupRecord {
+		return resourceGroupRecord{SubscriptionID: subscriptionID, Name: name, Decision: decisionDelete, Outcome: outcomeFailed, Error: "locked", RequestID: "req"}
+	}
+	started := func(subscriptionID, name string) resourceGroupRecord {
+		return resourceGroupRecord{SubscriptionID: subscriptionID, Name: name, Decision: decisionDelete, Outcome: outcomeDeletionStarted}
+	}
+	names := func(rgs []*stuckResourceGroup) string {
+		var names []string
+		for _, rg := range rgs {
+			names = append(names, fmt.Sprintf("%s/%s:%d", rg.SubscriptionID, rg.Name, rg.Failures))
+		}
+		return fmt.Sprint(names)
+	}
+
+	state := &stuckState{ResourceGroups: map[string]*stuckResourceGroup{}}
+	runs := []struct {
+		records         []resourceGroupRecord
+		subscriptionIDs []string
+		expectedStuck   string
+		expectedGone    string
+	}{
+		{
+			records:         []resourceGroupRecord{failed("sub-1", "locked"), failed("sub-1", "flaky"), failed("sub-2", "other")},
+			subscriptionIDs: []string{"sub-1", "sub-2"},
+			expectedStuck:   "[]",
+			expectedGone:    "[]",
+		},
+		{
+			// sub-2 is not part of this run, so its resource groups are kept.
+			records:         []resourceGroupRecord{failed("sub-1", "locked"), started("sub-1", "flaky")},
+			subscriptionIDs: []string{"sub-1"},
+			expectedStuck:   "[sub-1/locked:2]",
+			expectedGone:    "[]",
+		},
+		{
+			// The deletion of flaky eventually succeeded. The case of a name
+			// may change, but the tracked name is kept.
+			records:         []resourceGroupRecord{failed("sub-1", "Locked"), failed("sub-2", "other")},
+			subscriptionIDs: []string{"sub-1", "sub-2"},
+			expectedStuck:   "[sub-1/locked:3 sub-2/other:2]",
+			expectedGone:    "[sub-1/flaky:1]",
+		},
+		{
+			records:         nil,
+			subscriptionIDs: []string{"sub-1", "sub-2"},
+			expectedStuck:   "[]",
+			expectedGone:    "[sub-1/locked:3 sub-2/other:2]",
+		},
+	}
+	for i, run := range runs {
+		stuck, gone := state.update(run.records, run.subscriptionIDs, 2, now)
+		if names(stuck) != run.expectedStuck {
+			t.Fatalf("run %d: expected %s to be stuck, but got %s", i, run.expectedStuck, names(stuck))
+		}
+		if names(gone) != run.expectedGone {
+			t.Fatalf("run %d: expected %s to be gone, but got %s", i, run.expectedGone, names(gone))
+		}
+	}
+	if len(state.ResourceGroups) != 0 {
+		t.Fatalf("expected no resource group to be tracked, but got %v", state.ResourceGroups)
+	}
+}
+
+func TestStuckStateFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stuck.json")
+	state, err := readStuckState(path)
+	if err != nil {
+		t.Fatalf("expected a missing file to be an empty state, but got %v", err)
+	}
+	state.update([]resourceGroupRecord{{SubscriptionID: "sub", Name: "rg", Outcome: outcomeFailed, Error: "locked"}}, []string{"sub"}, 1, time.Now())
+	if err := writeStuckState(path, state); err != nil {
+		t.Fatal(err)
+	}
+	state, err = readStuckState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rg := state.ResourceGroups[stuckStateKey("sub", "rg")]; rg == nil || rg.Failures != 1 || rg.LastError != "locked" {
+		t.Fatalf("unexpected state %+v", state.ResourceGroups)
+	}
+
+	if err := os.WriteFile(path, []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readStuckState(path); err == nil {
+		t.Fatal("expected an error for an invalid state file")
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armsubscriptions"
+	"github.com/chewong/rg-cleanup/pkg/cleanup"
+)
+
+// subscriptionsClient is the subset of *armsubscriptions.Client used by
+// rg-cleanup.
+type subscriptionsClient interface {
+	NewListPager(options *armsubscriptions.ClientListOptions) *runtime.Pager[armsubscriptions.ClientListResponse]
+	Get(ctx context.Context, subscriptionID string, options *armsubscriptions.ClientGetOptions) (armsubscriptions.ClientGetResponse, error)
+}
+
+func getSubscriptionsClient(cred azcore.TokenCredential) (*armsubscriptions.Client, error) {
+	return armsubscriptions.NewClient(cred, getClientOptions())
+}
+
+// subscriptionNames resolves subscription display names and caches them so
+// that each subscription is only looked up once.
+type subscriptionNames struct {
+	client subscriptionsClient
+	names  map[string]string
+}
+
+func newSubscriptionNames(client subscriptionsClient) *subscriptionNames {
+	return &subscriptionNames{
+		client: client,
+		names:  map[string]string{},
+	}
+}
+
+// list returns the IDs of all subscriptions the credential can access.
+func (n *subscriptionNames) list(ctx context.Context) ([]string, error) {
+	var ids []string
+	pager := n.client.NewListPager(nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list subscriptions: %v", err)
+		}
+		for _, sub := range page.Value {
+			if sub.SubscriptionID == nil {
+				continue
+			}
+			ids = append(ids, *sub.SubscriptionID)
+			if sub.DisplayName != nil {
+				n.names[*sub.SubscriptionID] = *sub.DisplayName
+			}
+		}
+	}
+	return ids, nil
+}
+
+// name returns the display name of the subscription.
+func (n *subscriptionNames) name(ctx context.Context, subscriptionID string) (string, error) {
+	if name, ok := n.names[subscriptionID]; ok {
+		return name, nil
+	}
+	resp, err := n.client.Get(ctx, subscriptionID, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to get subscription %s: %v", subscriptionID, err)
+	}
+	name := ""
+	if resp.DisplayName != nil {
+		name = *resp.DisplayName
+	}
+	n.names[subscriptionID] = name
+	return name, nil
+}
+
+// filterSubscriptionsByName returns the subscriptions whose display name
+// fully matches regex. When subscriptionIDs is empty, every subscription the
+// credential can access is considered.
+func filterSubscriptionsByName(ctx context.Context, n *subscriptionNames, subscriptionIDs []string, regex string) ([]string, error) {
+	if len(subscriptionIDs) == 0 {
+		var err error
+		subscriptionIDs, err = n.list(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var filtered []string
+	for _, id := range subscriptionIDs {
+		name, err := n.name(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		match, err := cleanup.RegexMatchesResourceGroupName(regex, name, true)
+		if err != nil {
+			return nil, err
+		}
+		if !match {
+			slog.Debug(fmt.Sprintf("Subscription %s ('%s') did not match the subscription name filter", id, name), "subscription_id", id)
+			continue
+		}
+		slog.Info(fmt.Sprintf("Subscription %s ('%s') matched the subscription name filter", id, name), "subscription_id", id)
+		filtered = append(filtered, id)
+	}
+	return filtered, nil
+}
+
+// resolve completes o with the settings that need Azure, and must run after
+// o is validated, both at startup and whenever --config is reloaded. It
+// resolves the subscriptions of --scope-level=management-group and
+// --subscription-name-filter, then checks --require-confirmation-env, since
+// a reloaded config file can turn --dry-run off.
+func (o *options) resolve(ctx context.Context, client subscriptionsClient) error {
+	if o.scopeLevel == scopeLevelManagementGroup || o.subscriptionNameFilter != "" {
+		names := newSubscriptionNames(client)
+		if o.scopeLevel == scopeLevelManagementGroup {
+			ids, err := names.list(ctx)
+			if err != nil {
+				return fmt.Errorf("error when listing subscriptions: %v", err)
+			}
+			o.subscriptionIDs = ids
+			slog.Info(fmt.Sprintf("Scope level %s: cleaning up all %d subscriptions the credential can access", scopeLevelManagementGroup, len(o.subscriptionIDs)))
+		}
+		if o.subscriptionNameFilter != "" {
+			ids, err := filterSubscriptionsByName(ctx, names, o.subscriptionIDs, o.subscriptionNameFilter)
+			if err != nil {
+				return fmt.Errorf("error when filtering subscriptions by name: %v", err)
+			}
+			o.subscriptionIDs = ids
+		}
+	}
+	return o.checkConfirmation()
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armsubscriptions"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+// fakeSubscriptionsClient maps subscription IDs to display names and counts
+// the calls made to it.
+type fakeSubscriptionsClient struct {
+	names    map[string]string
+	listed   int
+	getCalls int
+}
+
+func (c *fakeSubscriptionsClient) NewListPager(*armsubscriptions.ClientListOptions) *runtime.Pager[armsubscriptions.ClientListResponse] {
+	c.listed++
+	var subs []*armsubscriptions.Subscription
+	for id, name := range c.names {
+		subs = append(subs, &armsubscriptions.Subscription{SubscriptionID: to.StringPtr(id), DisplayName: to.StringPtr(name)})
+	}
+	return newStaticPager(armsubscriptions.ClientListResponse{
+		SubscriptionListResult: armsubscriptions.SubscriptionListResult{Value: subs},
+	})
+}
+
+func (c *fakeSubscriptionsClient) Get(_ context.Context, subscriptionID string, _ *armsubscriptions.ClientGetOptions) (armsubscriptions.ClientGetResponse, error) {
+	c.getCalls++
+	name, ok := c.names[subscriptionID]
+	if !ok {
+		return armsubscriptions.ClientGetResponse{}, fmt.Errorf("subscription %s not found", subscriptionID)
+	}
+	return armsubscriptions.ClientGetResponse{
+		Subscription: armsubscriptions.Subscription{SubscriptionID: to.StringPtr(subscriptionID), DisplayName: to.StringPtr(name)},
+	}, nil
+}
+
+func TestFilterSubscriptionsByName(t *testing.T) {
+	names := map[string]string{
+		"sub-1": "dev-aks",
+		"sub-2": "prod-aks",
+		"sub-3": "dev-capz",
+	}
+	testCases := []struct {
+		desc            string
+		subscriptionIDs []string
+		regex           string
+		expected        []string
+	}{
+		{
+			desc:            "filter the given subscriptions",
+			subscriptionIDs: []string{"sub-1", "sub-2"},
+			regex:           "dev-.+",
+			expected:        []string{"sub-1"},
+		},
+		{
+			desc:            "partial matches are not enough",
+			subscriptionIDs: []string{"sub-1", "sub-2", "sub-3"},
+			regex:           "aks",
+			expected:        nil,
+		},
+		{
+			desc:     "filter all accessible subscriptions",
+			regex:    "dev-.+",
+			expected: []string{"sub-1", "sub-3"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			n := newSubscriptionNames(&fakeSubscriptionsClient{names: names})
+			filtered, err := filterSubscriptionsByName(context.Background(), n, tc.subscriptionIDs, tc.regex)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			// Listed subscriptions come back in map order.
+			sort.Strings(filtered)
+			if fmt.Sprint(filtered) != fmt.Sprint(tc.expected) {
+				t.Fatalf("expected %v, but got %v", tc.expected, filtered)
+			}
+		})
+	}
+}
+
+func TestSubscriptionNamesAreCached(t *testing.T) {
+	c := &fakeSubscriptionsClient{names: map[string]string{"sub-1": "dev-aks"}}
+	n := newSubscriptionNames(c)
+	for i := 0; i < 3; i++ {
+		name, err := n.name(context.Background(), "sub-1")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if name != "dev-aks" {
+			t.Fatalf("expected 'dev-aks', but got '%s'", name)
+		}
+	}
+	if c.getCalls != 1 {
+		t.Fatalf("expected 1 call to Get, but got %d", c.getCalls)
+	}
+
+	if _, err := n.name(context.Background(), "missing"); err == nil {
+		t.Fatal("expected an error for a missing subscription")
+	}
+}
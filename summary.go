@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/chewong/rg-cleanup/pkg/cleanup"
+)
+
+const (
+	githubStepSummaryEnvVar = "GITHUB_STEP_SUMMARY"
+	// maxSummaryRows caps the resource groups listed in the GitHub step
+	// summary, which GitHub limits to 1 MiB per step.
+	maxSummaryRows = 100
+	// summaryOldestCount is how many of the oldest deleted resource groups
+	// the chat notifications list.
+	summaryOldestCount = 5
+)
+
+// runSummary holds the counts of a run shared by the notifications and the
+// run report, so that they all agree.
+type runSummary struct {
+	SubscriptionIDs []string       `json:"subscriptionIds"`
+	DryRun          bool           `json:"dryRun"`
+	Scanned         int            `json:"scanned"`
+	Eligible        int            `json:"eligible"`
+	Deleted         int            `json:"deleted"`
+	Failed          int            `json:"failed"`
+	Skipped         int            `json:"skipped"`
+	SkippedByReason map[string]int `json:"skippedByReason"`
+	// SubscriptionErrors holds the error of each subscription that could not
+	// be cleaned up.
+	SubscriptionErrors map[string]string `json:"subscriptionErrors,omitempty"`
+	// EstimatedCost is the total cost over the last 30 days of the resource
+	// groups that were deleted, or would have been, by currency. It is only
+	// set with --estimate-cost.
+	EstimatedCost map[string]float64 `json:"estimatedCost,omitempty"`
+	// Oldest are the oldest resource groups that were deleted, or would have
+	// been in a dry run.
+	Oldest []resourceGroupRecord `json:"-"`
+}
+
+func newRunSummary(result *runResult, subscriptionIDs []string, dryRun bool) *runSummary {
+	s := &runSummary{
+		SubscriptionIDs:    subscriptionIDs,
+		DryRun:             dryRun,
+		Scanned:            result.scanned,
+		Eligible:           result.eligible,
+		Deleted:            result.deleted,
+		Failed:             result.failed,
+		SkippedByReason:    map[string]int{},
+		SubscriptionErrors: result.subscriptionErrors,
+		Oldest:             oldestDeletedResourceGroups(result.resourceGroups, summaryOldestCount),
+	}
+	if s.SubscriptionIDs == nil {
+		s.SubscriptionIDs = []string{}
+	}
+	for reason, count := range result.skipped {
+		s.Skipped += count
+		s.SkippedByReason[reason] = count
+	}
+	for _, rg := range result.resourceGroups {
+		// Resource groups without any cost have no currency.
+		if rg.EstimatedCost == nil || rg.CostCurrency == "" {
+			continue
+		}
+		if s.EstimatedCost == nil {
+			s.EstimatedCost = map[string]float64{}
+		}
+		s.EstimatedCost[rg.CostCurrency] += *rg.EstimatedCost
+	}
+	return s
+}
+
+// headline returns a one-line description of the run.
+func (s *runSummary) headline() string {
+	subscriptions := strings.Join(s.SubscriptionIDs, ", ")
+	switch {
+	case s.DryRun:
+		return fmt.Sprintf("rg-cleanup dry run: %d RGs would be deleted in %s", s.Eligible, subscriptions)
+	case s.Failed > 0:
+		return fmt.Sprintf("rg-cleanup deleted %d RGs (%d failed) in %s", s.Deleted, s.Failed, subscriptions)
+	default:
+		return fmt.Sprintf("rg-cleanup deleted %d RGs in %s", s.Deleted, subscriptions)
+	}
+}
+
+// formatSkipBreakdown returns a table of the number of resource groups
+// skipped for each reason, most common first.
+func formatSkipBreakdown(skippedByReason map[string]int) string {
+	reasons := make([]string, 0, len(skippedByReason))
+	total := 0
+	for reason, count := range skippedByReason {
+		reasons = append(reasons, reason)
+		total += count
+	}
+	sort.Slice(reasons, func(i, j int) bool {
+		if skippedByReason[reasons[i]] != skippedByReason[reasons[j]] {
+			return skippedByReason[reasons[i]] > skippedByReason[reasons[j]]
+		}
+		return reasons[i] < reasons[j]
+	})
+
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Skip reason\tResource groups")
+	for _, reason := range reasons {
+		fmt.Fprintf(w, "%s\t%d\n", reason, skippedByReason[reason])
+	}
+	fmt.Fprintf(w, "total\t%d\n", total)
+	w.Flush()
+	return b.String()
+}
+
+// oldestDeletedResourceGroups returns up to n resource groups that were
+// deleted, or would have been, oldest first. Resource groups without a
+// creationTimestamp tag come first since their age is unknown.
+func oldestDeletedResourceGroups(resourceGroups []resourceGroupRecord, n int) []resourceGroupRecord {
+	var deleted []resourceGroupRecord
+	created := map[string]time.Time{}
+	for _, rg := range resourceGroups {
+		if rg.Decision != decisionDelete {
+			continue
+		}
+		deleted = append(deleted, rg)
+		if t, err := cleanup.ParseCreationTimestamp(rg.Tags[creationTimestampTag]); err == nil {
+			created[rg.SubscriptionID+"/"+rg.Name] = t
+		}
+	}
+	sort.SliceStable(deleted, func(i, j int) bool {
+		return created[deleted[i].SubscriptionID+"/"+deleted[i].Name].Before(created[deleted[j].SubscriptionID+"/"+deleted[j].Name])
+	})
+	if len(deleted) > n {
+		deleted = deleted[:n]
+	}
+	return deleted
+}
+
+// formatGitHubSummary returns a Markdown summary of a run for a GitHub Actions
+// step summary. Deleted and failed resource groups are listed, up to
+// maxRows of them; skipped resource groups are only counted by reason.
+func formatGitHubSummary(result *runResult, dryRun bool, maxRows int) string {
+	var b strings.Builder
+	b.WriteString("## rg-cleanup\n\n")
+	if dryRun {
+		b.WriteString("Dry run: no resource group was deleted.\n\n")
+	}
+
+	deletedLabel := "Deletion started"
+	if dryRun {
+		deletedLabel = "Would be deleted"
+	}
+	b.WriteString("| Result | Resource groups |\n|---|---:|\n")
+	fmt.Fprintf(&b, "| Scanned | %d |\n", result.scanned)
+	if dryRun {
+		fmt.Fprintf(&b, "| %s | %d |\n", deletedLabel, result.eligible)
+	} else {
+		fmt.Fprintf(&b, "| %s | %d |\n", deletedLabel, result.deleted)
+		fmt.Fprintf(&b, "| Failed | %d |\n", result.failed)
+	}
+	reasons := make([]string, 0, len(result.skipped))
+	for reason := range result.skipped {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Fprintf(&b, "| Skipped (`%s`) | %d |\n", reason, result.skipped[reason])
+	}
+
+	var listed []resourceGroupRecord
+	for _, rg := range result.resourceGroups {
+		if rg.Decision == decisionDelete {
+			listed = append(listed, rg)
+		}
+	}
+	if len(listed) == 0 {
+		return b.String()
+	}
+
+	b.WriteString("\n| Subscription | Resource group | Location | Age | Outcome |\n|---|---|---|---|---|\n")
+	for i, rg := range listed {
+		if i == maxRows {
+			fmt.Fprintf(&b, "\n... and %d more resource groups, see the logs or `--report-file` for the full list.\n", len(listed)-maxRows)
+			break
+		}
+		outcome := rg.Outcome
+		if rg.Error != "" {
+			outcome = fmt.Sprintf("%s: %s", outcome, rg.Error)
+		}
+		fmt.Fprintf(&b, "| %s | %s | %s | %s | %s |\n", rg.SubscriptionID, rg.Name, rg.Location, rg.Age, escapeMarkdownTableCell(outcome))
+	}
+	return b.String()
+}
+
+// escapeMarkdownTableCell keeps text on one line and from breaking the table.
+func escapeMarkdownTableCell(text string) string {
+	text = strings.ReplaceAll(text, "|", "\\|")
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// writeGitHubSummary appends the Markdown summary of a run to path, as GitHub
+// Actions expects for $GITHUB_STEP_SUMMARY.
+func writeGitHubSummary(path string, result *runResult, dryRun bool) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open GitHub step summary: %v", err)
+	}
+	if _, err := f.WriteString(formatGitHubSummary(result, dryRun, maxSummaryRows)); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write GitHub step summary: %v", err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFormatGitHubSummary(t *testing.T) {
+	result := &runResult{skipped: map[string]int{}}
+	result.addResourceGroup(resourceGroupRecord{SubscriptionID: "sub", Name: "old-rg", Location: "westus2", Age: "4 days (96 hours)", Decision: decisionDelete, Reason: reasonTTLElapsed, Outcome: outcomeDeletionStarted})
+	result.addResourceGroup(resourceGroupRecord{SubscriptionID: "sub", Name: "locked-rg", Decision: decisionDelete, Reason: reasonTTLElapsed, Outcome: outcomeFailed, Error: "scope is locked |\nread-only"})
+	result.addResourceGroup(resourceGroupRecord{SubscriptionID: "sub", Name: "young-rg", Decision: decisionSkip, Reason: reasonTTLNotElapsed, Outcome: outcomeSkipped})
+
+	summary := formatGitHubSummary(result, false, maxSummaryRows)
+	for _, expected := range []string{
+		"| Scanned | 3 |",
+		"| Deletion started | 1 |",
+		"| Failed | 1 |",
+		"| Skipped (`ttl_not_elapsed`) | 1 |",
+		"| sub | old-rg | westus2 | 4 days (96 hours) | deletion_started |",
+		"| sub | locked-rg |  |  | failed: scope is locked \\| read-only |",
+	} {
+		if !strings.Contains(summary, expected) {
+			t.Fatalf("expected the summary to contain %q, but got:\n%s", expected, summary)
+		}
+	}
+	if strings.Contains(summary, "young-rg") {
+		t.Fatalf("expected skipped resource groups to only be counted, but got:\n%s", summary)
+	}
+}
+
+func TestFormatGitHubSummaryTruncates(t *testing.T) {
+	result := &runResult{skipped: map[string]int{}}
+	for i := 0; i < 5; i++ {
+		result.addResourceGroup(resourceGroupRecord{SubscriptionID: "sub", Name: fmt.Sprintf("rg-%d", i), Decision: decisionDelete, Outcome: outcomeDryRun})
+	}
+
+	summary := formatGitHubSummary(result, true, 3)
+	if !strings.Contains(summary, "| Would be deleted | 5 |") {
+		t.Fatalf("expected the dry-run count, but got:\n%s", summary)
+	}
+	if !strings.Contains(summary, "rg-2") || strings.Contains(summary, "rg-3") {
+		t.Fatalf("expected only the first 3 resource groups to be listed, but got:\n%s", summary)
+	}
+	if !strings.Contains(summary, "and 2 more resource groups") {
+		t.Fatalf("expected a note about the 2 resource groups not listed, but got:\n%s", summary)
+	}
+}
+
+func TestWriteGitHubSummaryAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "summary.md")
+	if err := os.WriteFile(path, []byte("previous step\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeGitHubSummary(path, &runResult{skipped: map[string]int{}}, false); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "previous step\n## rg-cleanup") {
+		t.Fatalf("expected the summary to be appended, but got:\n%s", data)
+	}
+}
+
+func TestOldestDeletedResourceGroups(t *testing.T) {
+	records := []resourceGroupRecord{
+		{Name: "newer", Tags: map[string]string{creationTimestampTag: "2024-01-03T00:00:00Z"}, Decision: decisionDelete},
+		{Name: "skipped", Tags: map[string]string{creationTimestampTag: "2020-01-01T00:00:00Z"}, Decision: decisionSkip},
+		{Name: "oldest", Tags: map[string]string{creationTimestampTag: "2024-01-01T00:00:00Z"}, Decision: decisionDelete},
+		{Name: "untagged", Decision: decisionDelete},
+		{Name: "newest", Tags: map[string]string{creationTimestampTag: "2024-01-05T00:00:00Z"}, Decision: decisionDelete},
+	}
+
+	var got []string
+	for _, rg := range oldestDeletedResourceGroups(records, 3) {
+		got = append(got, rg.Name)
+	}
+	if expected := "untagged,oldest,newer"; strings.Join(got, ",") != expected {
+		t.Fatalf("expected %s, but got %s", expected, strings.Join(got, ","))
+	}
+}
+
+func TestFormatSkipBreakdown(t *testing.T) {
+	breakdown := formatSkipBreakdown(map[string]int{reasonTTLNotElapsed: 380, reasonProtected: 12, reasonRegexMismatch: 12})
+	expected := "Skip reason      Resource groups\n" +
+		"ttl_not_elapsed  380\n" +
+		"protected        12\n" +
+		"regex_mismatch   12\n" +
+		"total            404\n"
+	if breakdown != expected {
+		t.Fatalf("expected:\n%s\nbut got:\n%s", expected, breakdown)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/chewong/rg-cleanup/pkg/cleanup"
+)
+
+var tagReportHeader = []string{"subscription_id", "rg_name", "location", "creation_timestamp_tag", "do_not_delete_tag", "age_days", "would_be_deleted", "all_tags_json"}
+
+// tsvReplacer keeps tag values from breaking the rows and columns of the tag
+// report.
+var tsvReplacer = strings.NewReplacer("\t", " ", "\r", " ", "\n", " ")
+
+// writeTagReport scans the resource groups of every subscription of o and
+// writes a row for each of them to o.tagReportOutput. It runs before any
+// resource group is deleted, so the report holds the state of the
+// subscriptions at the start of the run.
+func writeTagReport(ctx context.Context, cred azcore.TokenCredential, o *options) error {
+	f, err := os.Create(o.tagReportOutput)
+	if err != nil {
+		return fmt.Errorf("failed to create tag report: %v", err)
+	}
+	if err := writeTSVRow(f, tagReportHeader); err != nil {
+		f.Close()
+		return err
+	}
+	now := time.Now()
+	for _, subscriptionID := range o.subscriptionIDs {
+		client, resources, err := getSubscriptionClients(cred, subscriptionID, o.useResourceGraph)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		slog.Info(fmt.Sprintf("Writing the tags of the resource groups in subscription %s to '%s'", subscriptionID, o.tagReportOutput), "subscription_id", subscriptionID)
+		if err := scanResourceGroupTags(ctx, f, subscriptionID, client, resources, o, now); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// scanResourceGroupTags writes a tag report row to w for each resource group
+// listed by r.
+func scanResourceGroupTags(ctx context.Context, w io.Writer, subscriptionID string, r resourceGroupLister, resources resourcesClient, o *options, now time.Time) error {
+	// The decisions are logged when the resource groups are cleaned up.
+	quietCtx := withLogger(ctx, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	pager := r.NewListPager(nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("error when iterating resource groups: %v", err)
+		}
+		for _, rg := range page.Value {
+			record := cleanup.NewRecord(subscriptionID, rg)
+			creationTimestamp := record.Tags[creationTimestampTag]
+			ageDays := ""
+			if t, err := cleanup.ParseCreationTimestamp(creationTimestamp); err == nil {
+				ageDays = strconv.Itoa(int(now.Sub(t).Hours() / 24))
+			}
+			tags := "{}"
+			if len(record.Tags) > 0 {
+				data, err := json.Marshal(record.Tags)
+				if err != nil {
+					return fmt.Errorf("failed to encode the tags of resource group '%s': %v", record.Name, err)
+				}
+				tags = string(data)
+			}
+			row := []string{
+				subscriptionID,
+				record.Name,
+				record.Location,
+				creationTimestamp,
+				record.Tags[doNotDeleteTag],
+				ageDays,
+				strconv.FormatBool(isEligibleForDeletion(quietCtx, resources, rg, o)),
+				tags,
+			}
+			if err := writeTSVRow(w, row); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func writeTSVRow(w io.Writer, row []string) error {
+	fields := make([]string, len(row))
+	for i, field := range row {
+		fields[i] = tsvReplacer.Replace(field)
+	}
+	if _, err := fmt.Fprintln(w, strings.Join(fields, "\t")); err != nil {
+		return fmt.Errorf("failed to write tag report: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+func TestScanResourceGroupTags(t *testing.T) {
+	now := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+	c := &fakeResourceGroupsClient{pages: [][]*armresources.ResourceGroup{
+		{
+			{
+				Name:     to.StringPtr("old-rg"),
+				Location: to.StringPtr("westus2"),
+				Tags:     map[string]*string{creationTimestampTag: to.StringPtr("2024-01-05T12:00:00Z"), "team": to.StringPtr("a\tb")},
+			},
+			{
+				Name: to.StringPtr("kept-rg"),
+				Tags: map[string]*string{creationTimestampTag: to.StringPtr("2024-01-05T12:00:00Z"), doNotDeleteTag: to.StringPtr("infra")},
+			},
+		},
+		{
+			{Name: to.StringPtr("busy-rg"), Tags: map[string]*string{creationTimestampTag: to.StringPtr("2024-01-05T12:00:00Z")}},
+			{Name: to.StringPtr("untagged-rg")},
+		},
+	}}
+	o := &options{ttl: defaultTTL, minResourceCount: 1}
+
+	var buf bytes.Buffer
+	if err := scanResourceGroupTags(context.Background(), &buf, "sub", c, fakeResourcesClient{"busy-rg": 1}, o, now); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.deleted) != 0 {
+		t.Fatalf("expected nothing to be deleted, but got %v", c.deleted)
+	}
+	expected := "sub\told-rg\twestus2\t2024-01-05T12:00:00Z\t\t4\ttrue\t{\"creationTimestamp\":\"2024-01-05T12:00:00Z\",\"team\":\"a\\tb\"}\n" +
+		"sub\tkept-rg\t\t2024-01-05T12:00:00Z\tinfra\t4\tfalse\t{\"DO-NOT-DELETE\":\"infra\",\"creationTimestamp\":\"2024-01-05T12:00:00Z\"}\n" +
+		"sub\tbusy-rg\t\t2024-01-05T12:00:00Z\t\t4\tfalse\t{\"creationTimestamp\":\"2024-01-05T12:00:00Z\"}\n" +
+		"sub\tuntagged-rg\t\t\t\t\ttrue\t{}\n"
+	if buf.String() != expected {
+		t.Fatalf("expected:\n%s\nbut got:\n%s", expected, buf.String())
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const teamsWebhookURLEnvVar = "TEAMS_WEBHOOK_URL"
+
+// teamsMessage is a Microsoft Teams incoming webhook message carrying an
+// Adaptive Card.
+type teamsMessage struct {
+	Type        string            `json:"type"`
+	Attachments []teamsAttachment `json:"attachments"`
+}
+
+type teamsAttachment struct {
+	ContentType string    `json:"contentType"`
+	Content     teamsCard `json:"content"`
+}
+
+type teamsCard struct {
+	Schema  string           `json:"$schema"`
+	Type    string           `json:"type"`
+	Version string           `json:"version"`
+	Body    []teamsCardBlock `json:"body"`
+}
+
+// teamsCardBlock is either a TextBlock or a FactSet.
+type teamsCardBlock struct {
+	Type   string      `json:"type"`
+	Text   string      `json:"text,omitempty"`
+	Weight string      `json:"weight,omitempty"`
+	Size   string      `json:"size,omitempty"`
+	Color  string      `json:"color,omitempty"`
+	Wrap   bool        `json:"wrap,omitempty"`
+	Facts  []teamsFact `json:"facts,omitempty"`
+}
+
+type teamsFact struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+}
+
+// newTeamsMessage returns an Adaptive Card summarizing a run, built from the
+// same summary as the Slack message.
+func newTeamsMessage(summary *runSummary) *teamsMessage {
+	color := "Good"
+	switch {
+	case summary.DryRun:
+		color = "Accent"
+	case summary.Failed > 0:
+		color = "Attention"
+	}
+
+	facts := []teamsFact{{Title: "Scanned", Value: fmt.Sprint(summary.Scanned)}}
+	if summary.DryRun {
+		facts = append(facts, teamsFact{Title: "Would be deleted", Value: fmt.Sprint(summary.Eligible)})
+	} else {
+		facts = append(facts,
+			teamsFact{Title: "Deleted", Value: fmt.Sprint(summary.Deleted)},
+			teamsFact{Title: "Failed", Value: fmt.Sprint(summary.Failed)},
+		)
+	}
+	reasons := make([]string, 0, len(summary.SkippedByReason))
+	for reason := range summary.SkippedByReason {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		facts = append(facts, teamsFact{Title: fmt.Sprintf("Skipped (%s)", reason), Value: fmt.Sprint(summary.SkippedByReason[reason])})
+	}
+
+	body := []teamsCardBlock{
+		{Type: "TextBlock", Text: summary.headline(), Weight: "Bolder", Size: "Medium", Color: color, Wrap: true},
+		{Type: "FactSet", Facts: facts},
+	}
+	if len(summary.Oldest) > 0 {
+		lines := []string{fmt.Sprintf("**Oldest %d:**", len(summary.Oldest))}
+		for _, rg := range summary.Oldest {
+			lines = append(lines, fmt.Sprintf("- %s (%s, age: %s, %s)", rg.Name, rg.SubscriptionID, rg.Age, rg.Outcome))
+		}
+		body = append(body, teamsCardBlock{Type: "TextBlock", Text: strings.Join(lines, "\n"), Wrap: true})
+	}
+
+	return &teamsMessage{
+		Type: "message",
+		Attachments: []teamsAttachment{{
+			ContentType: "application/vnd.microsoft.card.adaptive",
+			Content: teamsCard{
+				Schema:  "http://adaptivecards.io/schemas/adaptive-card.json",
+				Type:    "AdaptiveCard",
+				Version: "1.4",
+				Body:    body,
+			},
+		}},
+	}
+}
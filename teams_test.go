@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNewTeamsMessage(t *testing.T) {
+	result := &runResult{skipped: map[string]int{}}
+	result.addResourceGroup(resourceGroupRecord{SubscriptionID: "sub", Name: "old-rg", Age: "4 days (96 hours)", Decision: decisionDelete, Outcome: outcomeDeletionStarted})
+	result.addResourceGroup(resourceGroupRecord{SubscriptionID: "sub", Name: "locked-rg", Decision: decisionDelete, Outcome: outcomeFailed})
+	result.addResourceGroup(resourceGroupRecord{SubscriptionID: "sub", Name: "young-rg", Decision: decisionSkip, Reason: reasonTTLNotElapsed, Outcome: outcomeSkipped})
+	summary := newRunSummary(result, []string{"sub"}, false)
+
+	msg := newTeamsMessage(summary)
+	card := msg.Attachments[0].Content
+	if msg.Attachments[0].ContentType != "application/vnd.microsoft.card.adaptive" || card.Type != "AdaptiveCard" {
+		t.Fatalf("expected an Adaptive Card, but got %+v", msg.Attachments[0])
+	}
+	// The Teams card and the Slack message share the same headline.
+	if headline := card.Body[0]; headline.Text != newSlackMessage(summary).Text || headline.Color != "Attention" {
+		t.Fatalf("expected the failure headline %q, but got %+v", newSlackMessage(summary).Text, headline)
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`{"title":"Deleted","value":"1"}`,
+		`{"title":"Failed","value":"1"}`,
+		`{"title":"Skipped (ttl_not_elapsed)","value":"1"}`,
+		"old-rg (sub, age: 4 days (96 hours), deletion_started)",
+	} {
+		if !strings.Contains(string(data), expected) {
+			t.Fatalf("expected the card to contain %q, but got %s", expected, data)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+const (
+	// telemetryTimeout bounds how long sending telemetry may delay the end of
+	// a run.
+	telemetryTimeout       = 5 * time.Second
+	telemetrySchemaVersion = 1
+)
+
+// telemetryPayload is the anonymous usage data sent with --enable-telemetry.
+// It must not hold names, IDs, tags or anything else that identifies a
+// subscription or a resource group. It is documented in the README.
+type telemetryPayload struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Version       string `json:"version"`
+	DryRun        bool   `json:"dryRun"`
+	Scanned       int    `json:"scanned"`
+	Deleted       int    `json:"deleted"`
+	Failed        int    `json:"failed"`
+	// Regions counts the scanned resource groups by Azure region.
+	Regions map[string]int `json:"regions"`
+}
+
+func newTelemetryPayload(summary *runSummary, records []resourceGroupRecord) *telemetryPayload {
+	p := &telemetryPayload{
+		SchemaVersion: telemetrySchemaVersion,
+		Version:       moduleVersion,
+		DryRun:        summary.DryRun,
+		Scanned:       summary.Scanned,
+		Deleted:       summary.Deleted,
+		Failed:        summary.Failed,
+		Regions:       map[string]int{},
+	}
+	for _, record := range records {
+		region := strings.ToLower(strings.ReplaceAll(record.Location, " ", ""))
+		if region == "" {
+			region = "unknown"
+		}
+		p.Regions[region]++
+	}
+	return p
+}
+
+// sendTelemetry posts the telemetry of a run to endpoint, giving up after
+// telemetryTimeout.
+func sendTelemetry(ctx context.Context, endpoint string, payload *telemetryPayload) error {
+	ctx, cancel := context.WithTimeout(ctx, telemetryTimeout)
+	defer cancel()
+	return postJSON(ctx, endpoint, nil, payload)
+}
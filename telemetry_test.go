@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSendTelemetry(t *testing.T) {
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		body = string(b)
+	}))
+	defer server.Close()
+
+	summary := &runSummary{Scanned: 3, Deleted: 1, Failed: 1}
+	records := []resourceGroupRecord{
+		{SubscriptionID: "sub", Name: "secret-project-1", Location: "westus2", Tags: map[string]string{"owner": "alice"}},
+		{SubscriptionID: "sub", Name: "secret-project-2", Location: "West US 2"},
+		{SubscriptionID: "sub", Name: "secret-project-3"},
+	}
+	payload := newTelemetryPayload(summary, records)
+	expectedRegions := map[string]int{"westus2": 2, "unknown": 1}
+	if !reflect.DeepEqual(payload.Regions, expectedRegions) {
+		t.Fatalf("expected regions %v, but got %v", expectedRegions, payload.Regions)
+	}
+	if err := sendTelemetry(context.Background(), server.URL, payload); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"sub", "secret-project", "alice"} {
+		if strings.Contains(body, s) {
+			t.Fatalf("expected the telemetry not to contain %q, but got %s", s, body)
+		}
+	}
+	if !strings.Contains(body, `"scanned":3`) || !strings.Contains(body, `"deleted":1`) {
+		t.Fatalf("unexpected telemetry %s", body)
+	}
+}
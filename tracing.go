@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// otlpEndpointEnvVar enables tracing when set. The exporter reads it,
+	// along with the other standard OTEL_EXPORTER_OTLP_* variables, itself.
+	otlpEndpointEnvVar = "OTEL_EXPORTER_OTLP_ENDPOINT"
+
+	// Headers returned by Azure Resource Manager to identify a request.
+	armRequestIDHeader            = "x-ms-request-id"
+	armCorrelationRequestIDHeader = "x-ms-correlation-request-id"
+)
+
+// setupTracing installs a tracer provider exporting spans over OTLP/HTTP when
+// $OTEL_EXPORTER_OTLP_ENDPOINT is set. The returned function flushes the
+// spans that have not been exported yet. Without the variable, spans are
+// discarded.
+func setupTracing(ctx context.Context) (func(context.Context) error, error) {
+	if os.Getenv(otlpEndpointEnvVar) == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP trace exporter: %v", err)
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(moduleName),
+		semconv.ServiceVersion(moduleVersion),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace resource: %v", err)
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// tracer returns the tracer of rg-cleanup from the global tracer provider.
+func tracer() trace.Tracer {
+	return otel.Tracer(moduleName, trace.WithInstrumentationVersion(moduleVersion))
+}
+
+// endSpan records err, if any, on span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// tracingPolicy is a per-retry pipeline policy that creates a span for each
+// HTTP request, recording the Azure request IDs so that traces can be matched
+// with Azure-side throttling and failures.
+type tracingPolicy struct{}
+
+func (tracingPolicy) Do(req *policy.Request) (*http.Response, error) {
+	raw := req.Raw()
+	ctx, span := tracer().Start(raw.Context(), "HTTP "+raw.Method, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		semconv.HTTPMethod(raw.Method),
+		attribute.String("http.url", raw.URL.Scheme+"://"+raw.URL.Host+raw.URL.Path),
+	))
+	resp, err := req.Clone(ctx).Next()
+	if resp != nil {
+		span.SetAttributes(
+			semconv.HTTPStatusCode(resp.StatusCode),
+			attribute.String("azure.request_id", resp.Header.Get(armRequestIDHeader)),
+			attribute.String("azure.correlation_request_id", resp.Header.Get(armCorrelationRequestIDHeader)),
+		)
+		if resp.StatusCode >= http.StatusBadRequest {
+			span.SetStatus(codes.Error, resp.Status)
+		}
+	}
+	endSpan(span, err)
+	return resp, err
+}
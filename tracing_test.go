@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/Azure/go-autorest/autorest/to"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// recordSpans installs a tracer provider recording the spans ended during
+// the test.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+// spanAttributes returns the attributes of span as strings.
+func spanAttributes(span sdktrace.ReadOnlySpan) map[string]string {
+	attributes := map[string]string{}
+	for _, kv := range span.Attributes() {
+		attributes[string(kv.Key)] = kv.Value.Emit()
+	}
+	return attributes
+}
+
+func TestRunResourceGroupCleanupSpans(t *testing.T) {
+	recorder := recordSpans(t)
+
+	fourDaysAgo := to.StringPtr(reportFourDaysAgo)
+	c := &fakeResourceGroupsClient{
+		pages: [][]*armresources.ResourceGroup{
+			{{Name: to.StringPtr("old-rg"), Tags: map[string]*string{creationTimestampTag: fourDaysAgo}}},
+			{{Name: to.StringPtr("kept-rg"), Tags: map[string]*string{creationTimestampTag: fourDaysAgo, doNotDeleteTag: to.StringPtr("")}}},
+		},
+		deleteErr: errors.New("authorization failed"),
+	}
+	if _, err := runResourceGroupCleanup(context.Background(), "sub", c, c, fakeResourcesClient{}, &options{ttl: defaultTTL, ignoreDeletionErrors: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	pages, deletions := 0, 0
+	for _, span := range recorder.Ended() {
+		attributes := spanAttributes(span)
+		switch span.Name() {
+		case "list resource groups page":
+			pages++
+			if attributes["subscription_id"] != "sub" || attributes["rgs"] != "1" {
+				t.Fatalf("expected a page of 1 resource group in sub, but got %v", attributes)
+			}
+		case "delete resource group":
+			deletions++
+			if attributes["rg_name"] != "old-rg" || attributes["outcome"] != outcomeFailed || span.Status().Code != codes.Error {
+				t.Fatalf("expected a failed deletion of old-rg, but got %v with status %v", attributes, span.Status())
+			}
+		}
+	}
+	if pages != 2 || deletions != 1 {
+		t.Fatalf("expected 2 page spans and 1 deletion span, but got %d and %d", pages, deletions)
+	}
+}
+
+// fakeTransport responds to every request with status and an ARM request ID.
+type fakeTransport struct {
+	status int
+}
+
+func (f fakeTransport) Do(req *http.Request) (*http.Response, error) {
+	header := http.Header{}
+	header.Set(armRequestIDHeader, "request-id")
+	header.Set(armCorrelationRequestIDHeader, "correlation-id")
+	return &http.Response{StatusCode: f.status, Status: http.StatusText(f.status), Header: header, Body: http.NoBody, Request: req}, nil
+}
+
+func TestTracingPolicy(t *testing.T) {
+	testCases := []struct {
+		desc           string
+		status         int
+		expectedStatus codes.Code
+	}{
+		{desc: "ok", status: http.StatusOK, expectedStatus: codes.Unset},
+		{desc: "throttled", status: http.StatusTooManyRequests, expectedStatus: codes.Error},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			recorder := recordSpans(t)
+			pipeline := runtime.NewPipeline(moduleName, moduleVersion, runtime.PipelineOptions{PerRetry: []policy.Policy{tracingPolicy{}}}, &policy.ClientOptions{
+				Transport: fakeTransport{status: tc.status},
+				Retry:     policy.RetryOptions{MaxRetries: -1},
+			})
+			req, err := runtime.NewRequest(context.Background(), http.MethodGet, "https://management.azure.com/subscriptions/sub/resourcegroups?api-version=2021-04-01")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := pipeline.Do(req); err != nil {
+				t.Fatal(err)
+			}
+
+			spans := recorder.Ended()
+			if len(spans) != 1 {
+				t.Fatalf("expected 1 span, but got %d", len(spans))
+			}
+			attributes := spanAttributes(spans[0])
+			if attributes["azure.request_id"] != "request-id" || attributes["azure.correlation_request_id"] != "correlation-id" {
+				t.Fatalf("expected the span to record the request IDs, but got %v", attributes)
+			}
+			if attributes["http.url"] != "https://management.azure.com/subscriptions/sub/resourcegroups" {
+				t.Fatalf("expected the URL without its query, but got %s", attributes["http.url"])
+			}
+			if spans[0].Status().Code != tc.expectedStatus {
+				t.Fatalf("expected status %v, but got %v", tc.expectedStatus, spans[0].Status())
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+const moduleName = "rg-cleanup"
+
+// Build metadata, injected at build time by the Makefile with
+// -ldflags "-X main.moduleVersion=... -X main.gitCommit=... -X main.buildDate=...".
+var (
+	moduleVersion = "v0.2.0"
+	gitCommit     = ""
+	buildDate     = "unknown"
+)
+
+// buildInfo describes the running binary, as printed by --version.
+type buildInfo struct {
+	Version   string
+	GitCommit string
+	BuildDate string
+	GoVersion string
+}
+
+func currentBuildInfo() buildInfo {
+	info := buildInfo{
+		Version:   moduleVersion,
+		GitCommit: gitCommit,
+		BuildDate: buildDate,
+		GoVersion: runtime.Version(),
+	}
+	if info.GitCommit == "" {
+		// go build records the commit of a git checkout on its own.
+		info.GitCommit = "unknown"
+		if bi, ok := debug.ReadBuildInfo(); ok {
+			for _, setting := range bi.Settings {
+				if setting.Key == "vcs.revision" {
+					info.GitCommit = setting.Value
+				}
+			}
+		}
+	}
+	return info
+}
+
+func (b buildInfo) String() string {
+	return fmt.Sprintf("%s %s\ngit commit: %s\nbuild date: %s\ngo version: %s\n", moduleName, b.Version, b.GitCommit, b.BuildDate, b.GoVersion)
+}
+
+// userAgent is the User-Agent of the requests rg-cleanup sends outside of the
+// Azure SDK.
+func userAgent() string {
+	return moduleName + "/" + moduleVersion
+}
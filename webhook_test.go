@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPostJSON(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		status      int
+		expectedErr bool
+	}{
+		{desc: "ok", status: http.StatusOK},
+		{desc: "accepted", status: http.StatusAccepted},
+		{desc: "forbidden", status: http.StatusForbidden, expectedErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			var received map[string]string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Content-Type") != "application/json" || r.Header.Get("Authorization") != "Bearer token" || r.Header.Get("User-Agent") != "rg-cleanup/"+moduleVersion {
+					w.WriteHeader(http.StatusUnsupportedMediaType)
+					return
+				}
+				if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				w.WriteHeader(tc.status)
+			}))
+			defer server.Close()
+
+			err := postJSON(context.Background(), server.URL, http.Header{"Authorization": {"Bearer token"}}, map[string]string{"text": "hello"})
+			if tc.expectedErr != (err != nil) {
+				t.Fatalf("expected error to be %v, but got %v", tc.expectedErr, err)
+			}
+			if received["text"] != "hello" {
+				t.Fatalf("expected the payload to be posted, but got %v", received)
+			}
+		})
+	}
+}